@@ -0,0 +1,205 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// AuthenticatedUser is the name of user authenticated by BasicAuth middleware,
+// which is mapped as a service for handlers after it.
+type AuthenticatedUser string
+
+// BasicAuthOptions is a struct for specifying configuration options for the macaron.BasicAuth middleware.
+type BasicAuthOptions struct {
+	// Realm is the protection space reported to clients. Default is "Restricted".
+	Realm string
+}
+
+func prepareBasicAuthOptions(options []BasicAuthOptions) BasicAuthOptions {
+	var opt BasicAuthOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Realm) == 0 {
+		opt.Realm = "Restricted"
+	}
+	return opt
+}
+
+// SecureCompare returns true if given strings are equal, in constant time regardless of
+// their contents so that secrets cannot be guessed by timing the comparison.
+func SecureCompare(given, actual string) bool {
+	// Compare hashes so that length of actual is not revealed either.
+	g := sha256.Sum256([]byte(given))
+	a := sha256.Sum256([]byte(actual))
+	return subtle.ConstantTimeCompare(g[:], a[:]) == 1
+}
+
+// BasicAuth returns a middleware handler that requires HTTP basic authentication, and responds
+// with 401 Unauthorized unless the validator accepts user name and password of the request.
+// Name of the user is mapped as AuthenticatedUser. Validator should compare passwords with
+// SecureCompare, or use BasicAuthUsers for a fixed set of users.
+func BasicAuth(validator func(user, pass string) bool, options ...BasicAuthOptions) Handler {
+	opt := prepareBasicAuthOptions(options)
+	challenge := "Basic realm=" + strconv.Quote(opt.Realm)
+
+	return Provides(func(ctx *Context) {
+		user, pass, ok := ctx.Req.BasicAuth()
+		if !ok || !validator(user, pass) {
+			ctx.Resp.Header().Set("WWW-Authenticate", challenge)
+			http.Error(ctx.Resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ctx.Map(AuthenticatedUser(user))
+	}, AuthenticatedUser(""))
+}
+
+// BasicAuthUsers returns a validator for BasicAuth that accepts given users,
+// which is a map of user name to password.
+func BasicAuthUsers(users map[string]string) func(user, pass string) bool {
+	accounts := make(map[string]string, len(users))
+	for user, pass := range users {
+		accounts[user] = pass
+	}
+	return func(user, pass string) bool {
+		actual, ok := accounts[user]
+		// Compare anyway so that unknown users take the same time.
+		return SecureCompare(pass, actual) && ok
+	}
+}
+
+// Errors of APIKeyAuth passed to APIKeyOptions.Unauthorized.
+var (
+	ErrAPIKeyMissing = errors.New("API key is missing")
+	ErrAPIKeyInvalid = errors.New("API key is invalid")
+)
+
+// APIKey is the metadata of an API key, which is mapped as a service by APIKeyAuth.
+type APIKey struct {
+	// Name identifies the client the key is issued to.
+	Name   string
+	Scopes []string
+	// Metadata is any other information of the key, e.g. the account it belongs to.
+	Metadata map[string]string
+}
+
+// HasScope returns true if the key is granted given scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore looks up API keys for APIKeyAuth, e.g. from the database.
+type APIKeyStore interface {
+	// Lookup returns metadata of the key, or nil if the key is not valid.
+	// Error should be returned only when the lookup itself fails.
+	Lookup(ctx context.Context, key string) (*APIKey, error)
+}
+
+type memoryAPIKeyStore map[[sha256.Size]byte]*APIKey
+
+// Keys are looked up by their hashes so that they cannot be guessed by timing the lookup.
+func (s memoryAPIKeyStore) Lookup(_ context.Context, key string) (*APIKey, error) {
+	return s[sha256.Sum256([]byte(key))], nil
+}
+
+// APIKeys returns an APIKeyStore of a fixed set of keys, which is a map of key to its metadata.
+func APIKeys(keys map[string]*APIKey) APIKeyStore {
+	s := make(memoryAPIKeyStore, len(keys))
+	for key, meta := range keys {
+		s[sha256.Sum256([]byte(key))] = meta
+	}
+	return s
+}
+
+// APIKeyOptions is a struct for specifying configuration options for the macaron.APIKeyAuth middleware.
+type APIKeyOptions struct {
+	// Header is the request header that carries the key. Default is "X-API-Key".
+	Header string
+	// Query is the query parameter that carries the key when the header is absent.
+	// Default is empty, i.e. keys are only accepted by header since URLs tend to be logged.
+	Query string
+	// Unauthorized writes the response when the key is missing or invalid, the error is
+	// ErrAPIKeyMissing or ErrAPIKeyInvalid. Default responds with 401 Unauthorized.
+	Unauthorized func(ctx *Context, err error)
+}
+
+func prepareAPIKeyOptions(options []APIKeyOptions) APIKeyOptions {
+	var opt APIKeyOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Header) == 0 {
+		opt.Header = "X-API-Key"
+	}
+	if opt.Unauthorized == nil {
+		opt.Unauthorized = func(ctx *Context, err error) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusUnauthorized, "")
+		}
+	}
+	return opt
+}
+
+// APIKeyAuth returns a middleware handler that requires an API key in the header or query parameter,
+// which is looked up in the store. Metadata of the key is mapped as *APIKey for handlers after it,
+// and requests without a valid key are responded by APIKeyOptions.Unauthorized. Requests are responded
+// with 500 Internal Server Error if the store fails, e.g.
+//
+//	m.Group("/api", func() {
+//		m.Get("/orders", func(key *macaron.APIKey) string { return key.Name })
+//	}, macaron.APIKeyAuth(macaron.APIKeys(map[string]*macaron.APIKey{
+//		os.Getenv("BILLING_KEY"): {Name: "billing"},
+//	})))
+func APIKeyAuth(store APIKeyStore, options ...APIKeyOptions) Handler {
+	opt := prepareAPIKeyOptions(options)
+
+	return Provides(func(ctx *Context) {
+		key := ctx.Req.Header.Get(opt.Header)
+		if len(key) == 0 && len(opt.Query) > 0 {
+			key = ctx.Req.URL.Query().Get(opt.Query)
+		}
+		if len(key) == 0 {
+			opt.Unauthorized(ctx, ErrAPIKeyMissing)
+			return
+		}
+
+		meta, err := store.Lookup(ctx.Req.Context(), key)
+		if err != nil {
+			if ctx.Router != nil && ctx.m != nil {
+				ctx.m.ErrorLogger().Printf("%sAPIKeyAuth: fail to look up key: %v", requestTag(ctx), err)
+			}
+			writeErrorPage(ctx, ctx.Resp, http.StatusInternalServerError, "")
+			return
+		} else if meta == nil {
+			opt.Unauthorized(ctx, ErrAPIKeyInvalid)
+			return
+		}
+		ctx.Map(meta)
+	}, (*APIKey)(nil))
+}
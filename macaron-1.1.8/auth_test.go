@@ -0,0 +1,177 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SecureCompare(t *testing.T) {
+	Convey("Compare strings in constant time", t, func() {
+		So(SecureCompare("secret", "secret"), ShouldBeTrue)
+		So(SecureCompare("secret", "secreT"), ShouldBeFalse)
+		So(SecureCompare("secret", "secret!"), ShouldBeFalse)
+		So(SecureCompare("", ""), ShouldBeTrue)
+	})
+}
+
+func Test_BasicAuth(t *testing.T) {
+	Convey("Authenticate with basic auth", t, func() {
+		m := New()
+		m.Use(BasicAuth(BasicAuthUsers(map[string]string{"foo": "bar"}), BasicAuthOptions{Realm: "Admin"}))
+		m.Get("/", func(user AuthenticatedUser) string {
+			return "hello " + string(user)
+		})
+		So(m.Check(), ShouldBeEmpty)
+
+		for _, c := range []struct {
+			user, pass string
+			code       int
+		}{
+			{"foo", "bar", http.StatusOK},
+			{"foo", "baz", http.StatusUnauthorized},
+			{"bar", "bar", http.StatusUnauthorized},
+			{"", "", http.StatusUnauthorized},
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			if len(c.user) > 0 {
+				req.SetBasicAuth(c.user, c.pass)
+			}
+			m.ServeHTTP(resp, req)
+
+			So(resp.Code, ShouldEqual, c.code)
+			if c.code == http.StatusOK {
+				So(resp.Body.String(), ShouldEqual, "hello foo")
+			} else {
+				So(resp.Header().Get("WWW-Authenticate"), ShouldEqual, `Basic realm="Admin"`)
+			}
+		}
+	})
+
+	Convey("Authenticate with custom validator", t, func() {
+		m := New()
+		m.Get("/", BasicAuth(func(user, pass string) bool {
+			return SecureCompare(pass, user+"!")
+		}), func(user AuthenticatedUser) string {
+			return string(user)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.SetBasicAuth("admin", "admin!")
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "admin")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("WWW-Authenticate"), ShouldEqual, `Basic realm="Restricted"`)
+	})
+}
+
+// apiKeyStoreFunc is an APIKeyStore of a function.
+type apiKeyStoreFunc func(ctx context.Context, key string) (*APIKey, error)
+
+func (f apiKeyStoreFunc) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	return f(ctx, key)
+}
+
+func Test_APIKeyAuth(t *testing.T) {
+	Convey("Authenticate with API keys", t, func() {
+		m := New()
+		m.Use(APIKeyAuth(APIKeys(map[string]*APIKey{
+			"s3cr3t": {Name: "billing", Scopes: []string{"orders:read"}},
+		}), APIKeyOptions{Query: "api_key"}))
+		m.Get("/", func(key *APIKey) string {
+			if key.HasScope("orders:read") && !key.HasScope("orders:write") {
+				return "hello " + key.Name
+			}
+			return "wrong scopes"
+		})
+		So(m.Check(), ShouldBeEmpty)
+
+		for _, c := range []struct {
+			header, url string
+			code        int
+		}{
+			{"s3cr3t", "/", http.StatusOK},
+			{"", "/?api_key=s3cr3t", http.StatusOK},
+			{"s3cr3T", "/", http.StatusUnauthorized},
+			{"", "/?api_key=wrong", http.StatusUnauthorized},
+			{"", "/", http.StatusUnauthorized},
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", c.url, nil)
+			So(err, ShouldBeNil)
+			if len(c.header) > 0 {
+				req.Header.Set("X-API-Key", c.header)
+			}
+			m.ServeHTTP(resp, req)
+
+			So(resp.Code, ShouldEqual, c.code)
+			if c.code == http.StatusOK {
+				So(resp.Body.String(), ShouldEqual, "hello billing")
+			}
+		}
+	})
+
+	Convey("Authenticate with custom store and response", t, func() {
+		var reasons []error
+		m := New()
+		m.Get("/", APIKeyAuth(apiKeyStoreFunc(func(ctx context.Context, key string) (*APIKey, error) {
+			switch key {
+			case "valid":
+				return &APIKey{Name: "client"}, nil
+			case "broken":
+				return nil, errors.New("database is down")
+			}
+			return nil, nil
+		}), APIKeyOptions{
+			Header: "Authorization",
+			Unauthorized: func(ctx *Context, err error) {
+				reasons = append(reasons, err)
+				ctx.Resp.WriteHeader(http.StatusUnauthorized)
+				ctx.Resp.Write([]byte(err.Error()))
+			},
+		}), func(key *APIKey) string {
+			return key.Name
+		})
+
+		serve := func(key string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Authorization", key)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		So(serve("valid").Body.String(), ShouldEqual, "client")
+		So(serve("invalid").Body.String(), ShouldEqual, "API key is invalid")
+		So(serve("").Body.String(), ShouldEqual, "API key is missing")
+		So(reasons, ShouldResemble, []error{ErrAPIKeyInvalid, ErrAPIKeyMissing})
+		So(serve("broken").Code, ShouldEqual, http.StatusInternalServerError)
+	})
+}
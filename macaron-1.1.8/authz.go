@@ -0,0 +1,260 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Principal is the authenticated identity checked by RequireRole and RequirePermission,
+// which should be mapped by authentication middleware, e.g. ctx.MapTo(user, (*macaron.Principal)(nil)).
+type Principal interface {
+	HasRole(role string) bool
+	HasPermission(permission string) bool
+}
+
+// SimplePrincipal is a Principal of fixed roles and permissions. Permissions ending with "*"
+// grant all permissions of the prefix, e.g. "article:*" grants "article:edit".
+type SimplePrincipal struct {
+	Name        string
+	Roles       []string
+	Permissions []string
+}
+
+func (p *SimplePrincipal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SimplePrincipal) HasPermission(permission string) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission ||
+			(strings.HasSuffix(granted, "*") && strings.HasPrefix(permission, granted[:len(granted)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole returns a handler that allows the request only if the principal has any of given roles,
+// or responds with 403 Forbidden as HTML or JSON as the client prefers. It can be used for some routes
+// or groups after authentication middleware, e.g.
+//
+//	m.Group("/admin", func() { ... }, auth, macaron.RequireRole("admin"))
+func RequireRole(roles ...string) Handler {
+	return func(ctx *Context, p Principal) {
+		for _, role := range roles {
+			if p.HasRole(role) {
+				return
+			}
+		}
+		writeErrorPage(ctx, ctx.Resp, http.StatusForbidden, "")
+	}
+}
+
+// RequirePermission returns a handler that allows the request only if the principal has all of given
+// permissions, or responds with 403 Forbidden as HTML or JSON as the client prefers, e.g.
+//
+//	m.Post("/articles/:id", auth, macaron.RequirePermission("article:edit"), editArticle)
+func RequirePermission(permissions ...string) Handler {
+	return func(ctx *Context, p Principal) {
+		for _, permission := range permissions {
+			if !p.HasPermission(permission) {
+				writeErrorPage(ctx, ctx.Resp, http.StatusForbidden, "")
+				return
+			}
+		}
+	}
+}
+
+// Authorizer decides if the subject is allowed to perform the action on the object,
+// e.g. whether user "alice" can "GET" "/articles/1".
+type Authorizer interface {
+	Authorize(subject, action, object string) (bool, error)
+}
+
+// Enforcer is the interface of policy engines that enforce requests of variadic values,
+// e.g. *casbin.Enforcer.
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+type enforcerAuthorizer struct {
+	e Enforcer
+}
+
+func (a enforcerAuthorizer) Authorize(subject, action, object string) (bool, error) {
+	return a.e.Enforce(subject, object, action)
+}
+
+// EnforcerAuthorizer returns an Authorizer backed by the enforcer, which is called with
+// subject, object and action in the order of the usual Casbin model "sub, obj, act".
+func EnforcerAuthorizer(e Enforcer) Authorizer {
+	return enforcerAuthorizer{e}
+}
+
+// RuleAuthorizer is an Authorizer of simple rules, see ParseRules.
+type RuleAuthorizer struct {
+	// policies are rules of "p" lines, i.e. subject, object and action.
+	policies [][3]string
+	// roles maps subjects to roles they inherit from "g" lines.
+	roles map[string][]string
+}
+
+// ParseRules parses rules in the format of Casbin policy files with RBAC model, where "p" lines
+// allow a subject to perform an action on objects, and "g" lines grant a role to a subject.
+// Objects ending with "*" match all objects of the prefix, and "*" action matches all actions.
+// Blank lines and lines starting with "#" are ignored, e.g.
+//
+//	p, admin, /admin/*, *
+//	p, anonymous, /articles/*, GET
+//	g, alice, admin
+func ParseRules(r io.Reader) (*RuleAuthorizer, error) {
+	a := &RuleAuthorizer{roles: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		switch {
+		case fields[0] == "p" && len(fields) == 4:
+			a.policies = append(a.policies, [3]string{fields[1], fields[2], fields[3]})
+		case fields[0] == "g" && len(fields) == 3:
+			a.roles[fields[1]] = append(a.roles[fields[1]], fields[2])
+		default:
+			return nil, fmt.Errorf("line %d: invalid rule %q", n, line)
+		}
+	}
+	return a, scanner.Err()
+}
+
+// LoadRules loads rules from the file, see ParseRules.
+func LoadRules(filename string) (*RuleAuthorizer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRules(f)
+}
+
+// subjects returns the subject and all roles it inherits.
+func (a *RuleAuthorizer) subjects(subject string) map[string]bool {
+	subjects := map[string]bool{subject: true}
+	queue := []string{subject}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, role := range a.roles[s] {
+			if !subjects[role] {
+				subjects[role] = true
+				queue = append(queue, role)
+			}
+		}
+	}
+	return subjects
+}
+
+func (a *RuleAuthorizer) Authorize(subject, action, object string) (bool, error) {
+	subjects := a.subjects(subject)
+	for _, p := range a.policies {
+		if !subjects[p[0]] || (p[2] != "*" && p[2] != action) {
+			continue
+		}
+		if p[1] == object || (strings.HasSuffix(p[1], "*") && strings.HasPrefix(object, p[1][:len(p[1])-1])) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AuthorizeOptions is a struct for specifying configuration options for the macaron.Authorize middleware.
+type AuthorizeOptions struct {
+	// Subject returns the subject of the request. Default is AuthenticatedUser if it is mapped,
+	// or "anonymous" otherwise.
+	Subject func(ctx *Context) string
+	// Action returns the action of the request. Default is the request method.
+	Action func(ctx *Context) string
+	// Object returns the object of the request. Default is the request path.
+	Object func(ctx *Context) string
+}
+
+func prepareAuthorizeOptions(options []AuthorizeOptions) AuthorizeOptions {
+	var opt AuthorizeOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Subject == nil {
+		opt.Subject = func(ctx *Context) string {
+			if v := ctx.GetVal(reflect.TypeOf(AuthenticatedUser(""))); v.IsValid() {
+				return v.String()
+			}
+			return "anonymous"
+		}
+	}
+	if opt.Action == nil {
+		opt.Action = func(ctx *Context) string { return ctx.Req.Method }
+	}
+	if opt.Object == nil {
+		opt.Object = func(ctx *Context) string { return ctx.Req.URL.Path }
+	}
+	return opt
+}
+
+// Authorize returns a middleware handler that asks the authorizer whether the request is allowed,
+// before handlers of the matched route run, so permission models live outside of handlers.
+// Denied requests are responded with 403 Forbidden, and failures of the authorizer with
+// 500 Internal Server Error. Requests that match no route are left to NotFound handlers.
+// It should be used after authentication middleware, e.g.
+//
+//	rules, err := macaron.LoadRules("conf/rules.csv")
+//	m.Use(macaron.BasicAuth(validator))
+//	m.Use(macaron.Authorize(rules))
+func Authorize(authorizer Authorizer, options ...AuthorizeOptions) Handler {
+	opt := prepareAuthorizeOptions(options)
+
+	return func(ctx *Context) {
+		if len(ctx.RoutePattern()) == 0 {
+			return
+		}
+		ok, err := authorizer.Authorize(opt.Subject(ctx), opt.Action(ctx), opt.Object(ctx))
+		if err != nil {
+			if ctx.Router != nil && ctx.m != nil {
+				ctx.m.ErrorLogger().Printf("%sAuthorize: %v", requestTag(ctx), err)
+			}
+			writeErrorPage(ctx, ctx.Resp, http.StatusInternalServerError, "")
+			return
+		} else if !ok {
+			writeErrorPage(ctx, ctx.Resp, http.StatusForbidden, "")
+		}
+	}
+}
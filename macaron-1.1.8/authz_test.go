@@ -0,0 +1,171 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Authorization(t *testing.T) {
+	Convey("Authorize requests by roles and permissions", t, func() {
+		users := map[string]*SimplePrincipal{
+			"admin":  {Name: "admin", Roles: []string{"admin"}, Permissions: []string{"article:*"}},
+			"editor": {Name: "editor", Roles: []string{"editor"}, Permissions: []string{"article:edit"}},
+			"reader": {Name: "reader", Permissions: []string{"article:read"}},
+		}
+		auth := Provides(func(ctx *Context) {
+			ctx.MapTo(users[ctx.Query("user")], (*Principal)(nil))
+		}, (*Principal)(nil))
+
+		m := New()
+		m.Group("/admin", func() {
+			m.Get("", func() string { return "dashboard" })
+		}, auth, RequireRole("admin", "editor"))
+		m.Post("/articles", auth, RequirePermission("article:edit", "article:publish"), func() string {
+			return "published"
+		})
+		So(m.Check(), ShouldBeEmpty)
+
+		serve := func(method, url string, json bool) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, nil)
+			So(err, ShouldBeNil)
+			if json {
+				req.Header.Set("Accept", "application/json")
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		So(serve("GET", "/admin?user=admin", false).Body.String(), ShouldEqual, "dashboard")
+		So(serve("GET", "/admin?user=editor", false).Body.String(), ShouldEqual, "dashboard")
+		So(serve("GET", "/admin?user=reader", false).Code, ShouldEqual, http.StatusForbidden)
+
+		So(serve("POST", "/articles?user=admin", false).Body.String(), ShouldEqual, "published")
+		So(serve("POST", "/articles?user=editor", false).Code, ShouldEqual, http.StatusForbidden)
+
+		resp := serve("POST", "/articles?user=reader", true)
+		So(resp.Code, ShouldEqual, http.StatusForbidden)
+		So(resp.Body.String(), ShouldEqual, `{"status":403,"error":"Forbidden"}`)
+	})
+
+	Convey("Report guards without principal", t, func() {
+		m := New()
+		m.Get("/", RequireRole("admin"), func() {})
+		So(m.Check(), ShouldNotBeEmpty)
+	})
+
+	Convey("Match permissions by prefix", t, func() {
+		p := &SimplePrincipal{Permissions: []string{"article:*", "user:read"}}
+		So(p.HasPermission("article:edit"), ShouldBeTrue)
+		So(p.HasPermission("user:read"), ShouldBeTrue)
+		So(p.HasPermission("user:edit"), ShouldBeFalse)
+		So((&SimplePrincipal{Permissions: []string{"*"}}).HasPermission("anything"), ShouldBeTrue)
+	})
+}
+
+// enforcerFunc is an Enforcer of a function.
+type enforcerFunc func(rvals ...interface{}) (bool, error)
+
+func (f enforcerFunc) Enforce(rvals ...interface{}) (bool, error) {
+	return f(rvals...)
+}
+
+func Test_Authorize(t *testing.T) {
+	Convey("Authorize requests by rules", t, func() {
+		rules, err := ParseRules(strings.NewReader(`
+# Everyone can read articles.
+p, anonymous, /articles/*, GET
+p, editor, /articles/*, POST
+p, admin, /admin, *
+g, alice, editor
+g, editor, anonymous
+g, root, admin
+`))
+		So(err, ShouldBeNil)
+
+		m := New()
+		m.Use(func(ctx *Context) {
+			if user := ctx.Query("user"); len(user) > 0 {
+				ctx.Map(AuthenticatedUser(user))
+			}
+		})
+		m.Use(Authorize(rules))
+		m.Any("/articles/:id", func() string { return "article" })
+		m.Any("/admin", func() string { return "admin" })
+
+		for _, c := range []struct {
+			method, url string
+			code        int
+		}{
+			{"GET", "/articles/1", http.StatusOK},
+			{"POST", "/articles/1", http.StatusForbidden},
+			{"POST", "/articles/1?user=alice", http.StatusOK},
+			{"GET", "/articles/1?user=alice", http.StatusOK},
+			{"DELETE", "/articles/1?user=alice", http.StatusForbidden},
+			{"GET", "/admin?user=alice", http.StatusForbidden},
+			{"DELETE", "/admin?user=root", http.StatusOK},
+			{"GET", "/missing", http.StatusNotFound},
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(c.method, c.url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, c.code)
+		}
+	})
+
+	Convey("Reject invalid rules", t, func() {
+		_, err := ParseRules(strings.NewReader("p, admin, /admin"))
+		So(err, ShouldNotBeNil)
+		_, err = LoadRules("fixtures/missing.csv")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Authorize requests by enforcer", t, func() {
+		var args []interface{}
+		m := New()
+		m.Use(Authorize(EnforcerAuthorizer(enforcerFunc(func(rvals ...interface{}) (bool, error) {
+			args = rvals
+			if rvals[1] == "/broken" {
+				return false, errors.New("policy is broken")
+			}
+			return rvals[1] == "/", nil
+		})), AuthorizeOptions{
+			Subject: func(ctx *Context) string { return "bob" },
+		}))
+		m.Get("/", func() string { return "home" })
+		m.Get("/private", func() string { return "private" })
+		m.Get("/broken", func() string { return "broken" })
+
+		serve := func(url string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+		So(serve("/").Body.String(), ShouldEqual, "home")
+		So(args, ShouldResemble, []interface{}{"bob", "/", "GET"})
+		So(serve("/private").Code, ShouldEqual, http.StatusForbidden)
+		So(serve("/broken").Code, ShouldEqual, http.StatusInternalServerError)
+	})
+}
@@ -0,0 +1,452 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package binding binds request data of JSON, forms and queries into structs, and validates them.
+package binding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Rules of errors that are not caused by validation.
+const (
+	// ERR_DESERIALIZATION means the body cannot be decoded.
+	ERR_DESERIALIZATION = "deserialization"
+	// ERR_CONTENT_TYPE means the body has a content type that cannot be bound.
+	ERR_CONTENT_TYPE = "content_type"
+	// ERR_BODY_TOO_LARGE means the body is larger than the limit of macaron.MaxBody.
+	ERR_BODY_TOO_LARGE = "body_too_large"
+)
+
+// Error is an error of a field, or of the whole request if Field is empty.
+type Error struct {
+	// Field is the name of field, which is its JSON or form name, and is joined with names of
+	// parent fields by ".", e.g. "address.city", or indexed for elements, e.g. "items[0].name".
+	Field string `json:"field,omitempty"`
+	// Rule is the validation rule that fails, e.g. "required", or ERR_DESERIALIZATION.
+	Rule string `json:"rule"`
+	// Message describes the error for humans.
+	Message string `json:"message"`
+}
+
+// Errors is the list of errors of binding, which is mapped as a service by Bind and BindIgnErr.
+type Errors []Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Has returns true if any of errors is of given rule.
+func (errs Errors) Has(rule string) bool {
+	for _, err := range errs {
+		if err.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends an error of the field.
+func (errs *Errors) Add(field, rule, message string) {
+	*errs = append(*errs, Error{Field: field, Rule: rule, Message: message})
+}
+
+// fieldName returns the name of struct field in errors and forms, which is the name in its JSON tag
+// or form tag, or the name of field itself. It returns "-" for fields that are ignored.
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		if name := strings.Split(f.Tag.Get(key), ",")[0]; len(name) > 0 {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// formName returns the name of struct field in forms.
+func formName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("form"), ",")[0]; len(name) > 0 {
+		return name
+	}
+	return fieldName(f)
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	fileType      = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileSliceType = reflect.TypeOf([]*multipart.FileHeader(nil))
+)
+
+// setValue sets the value converted from the string.
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), s)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		// Checkboxes submit "on" when they are checked.
+		if s == "on" {
+			s = "true"
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// mapForm sets fields of the struct by values of the form, fields of embedded structs are
+// set as if they are fields of the outer one. Empty values leave fields unchanged.
+func mapForm(v reflect.Value, form url.Values, errs *Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			mapForm(fv, form, errs)
+			continue
+		}
+		name := formName(f)
+		if name == "-" || f.Type == fileType || f.Type == fileSliceType {
+			continue
+		}
+
+		vals, ok := form[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(f.Type, 0, len(vals))
+			for _, s := range vals {
+				if len(s) == 0 {
+					continue
+				}
+				elem := reflect.New(f.Type.Elem()).Elem()
+				if err := setValue(elem, s); err != nil {
+					errs.Add(name, ERR_DESERIALIZATION, fmt.Sprintf("%s is not a valid value: %v", name, err))
+					break
+				}
+				slice = reflect.Append(slice, elem)
+			}
+			fv.Set(slice)
+			continue
+		}
+		if len(vals[0]) == 0 {
+			continue
+		}
+		if err := setValue(fv, vals[0]); err != nil {
+			errs.Add(name, ERR_DESERIALIZATION, fmt.Sprintf("%s is not a valid value: %v", name, err))
+		}
+	}
+}
+
+// mapFiles sets fields of the struct of type *multipart.FileHeader or []*multipart.FileHeader
+// by uploaded files of their form names.
+func mapFiles(v reflect.Value, files map[string][]*multipart.FileHeader) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			mapFiles(fv, files)
+			continue
+		}
+		fhs := files[formName(f)]
+		if len(fhs) == 0 {
+			continue
+		}
+		switch f.Type {
+		case fileType:
+			fv.Set(reflect.ValueOf(fhs[0]))
+		case fileSliceType:
+			fv.Set(reflect.ValueOf(fhs))
+		}
+	}
+}
+
+// mapParams sets fields of the struct that have `param` tags by route parameters of given names,
+// e.g. `param:"id"` for ":id", fields of embedded structs are set as if they are fields of the outer one.
+func mapParams(ctx *macaron.Context, v reflect.Value, errs *Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			mapParams(ctx, fv, errs)
+			continue
+		}
+		name := f.Tag.Get("param")
+		if len(name) == 0 {
+			continue
+		}
+		val := ctx.Params(name)
+		if len(val) == 0 {
+			continue
+		}
+		if err := setValue(fv, val); err != nil {
+			errs.Add(strings.TrimPrefix(name, ":"), ERR_DESERIALIZATION,
+				fmt.Sprintf("%s is not a valid value: %v", strings.TrimPrefix(name, ":"), err))
+		}
+	}
+}
+
+// DecodeFunc decodes the body into the struct that v points to.
+type DecodeFunc func(body []byte, v interface{}) error
+
+// decoders decode bodies by content types, other than JSON and forms.
+var decoders = map[string]DecodeFunc{
+	"application/x-protobuf": decodeProtobuf,
+	"application/protobuf":   decodeProtobuf,
+	"application/msgpack":    decodeMsgPack,
+	"application/x-msgpack":  decodeMsgPack,
+}
+
+// RegisterDecoder registers the decoder of bodies of the content type, which replaces the built-in
+// one if there is, e.g. to decode protobuf by the official library:
+//
+//	binding.RegisterDecoder("application/x-protobuf", func(body []byte, v interface{}) error {
+//		return proto.Unmarshal(body, v.(proto.Message))
+//	})
+//
+// Decoders should be registered before serving.
+func RegisterDecoder(contentType string, fn DecodeFunc) {
+	decoders[strings.ToLower(contentType)] = fn
+}
+
+// protoUnmarshaler is implemented by protobuf messages generated by gogo/protobuf and others.
+type protoUnmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+// decodeProtobuf decodes protobuf by the Unmarshal method of messages, since the standard
+// library has no protobuf support. Other messages need a decoder set by RegisterDecoder.
+func decodeProtobuf(body []byte, v interface{}) error {
+	msg, ok := v.(protoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T is not a protobuf message with Unmarshal method", v)
+	}
+	return msg.Unmarshal(body)
+}
+
+// addBodyError adds the error of reading or decoding the body, which is ERR_BODY_TOO_LARGE
+// if it is larger than the limit of macaron.MaxBody.
+func addBodyError(errs *Errors, message string, err error) {
+	if macaron.IsBodyTooLarge(err) {
+		errs.Add("", ERR_BODY_TOO_LARGE, "Request body is too large")
+		return
+	}
+	errs.Add("", ERR_DESERIALIZATION, message+err.Error())
+}
+
+// bindBody decodes the request into v by its content type. Requests without body
+// are bound by their query.
+func bindBody(ctx *macaron.Context, v reflect.Value, errs *Errors) {
+	req := ctx.Req.Request
+	contentType := req.Header.Get("Content-Type")
+	typ, _, _ := mime.ParseMediaType(contentType)
+	hasBody := req.ContentLength > 0 || (req.ContentLength < 0 && req.Body != nil && req.Body != http.NoBody)
+
+	switch {
+	case !hasBody || req.Method == "GET" || req.Method == "HEAD":
+		mapForm(v, req.URL.Query(), errs)
+	case typ == "application/json" || strings.HasSuffix(typ, "+json"):
+		if err := json.NewDecoder(req.Body).Decode(v.Addr().Interface()); err != nil && err != io.EOF {
+			addBodyError(errs, "Request body is not valid JSON: ", err)
+		}
+	case typ == "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			addBodyError(errs, "Request body is not a valid form: ", err)
+			return
+		}
+		mapForm(v, req.Form, errs)
+	case typ == "multipart/form-data":
+		if err := req.ParseMultipartForm(macaron.MaxMemory); err != nil {
+			addBodyError(errs, "Request body is not a valid multipart form: ", err)
+			return
+		}
+		form := make(url.Values)
+		for k, vals := range req.URL.Query() {
+			form[k] = vals
+		}
+		for k, vals := range req.MultipartForm.Value {
+			form[k] = append(append([]string(nil), vals...), form[k]...)
+		}
+		mapForm(v, form, errs)
+		mapFiles(v, req.MultipartForm.File)
+	case decoders[typ] != nil:
+		body, err := ioutil.ReadAll(req.Body)
+		if err == nil {
+			err = decoders[typ](body, v.Addr().Interface())
+		}
+		if err != nil {
+			addBodyError(errs, "Request body cannot be decoded: ", err)
+		}
+	default:
+		errs.Add("", ERR_CONTENT_TYPE, fmt.Sprintf("Content type %q is not supported", contentType))
+	}
+}
+
+// bind returns a new value of the type bound from the request and validated, and errors if any.
+func bind(ctx *macaron.Context, typ reflect.Type) (reflect.Value, Errors) {
+	v := reflect.New(typ).Elem()
+	var errs Errors
+	bindBody(ctx, v, &errs)
+	// Route parameters take precedence over the body, as they identify the resource.
+	mapParams(ctx, v, &errs)
+	if len(errs) == 0 {
+		validateStruct(ctx, v, "", &errs)
+	}
+	return v, errs
+}
+
+func checkTarget(obj interface{}) reflect.Type {
+	t := reflect.TypeOf(obj)
+	if t == nil || t.Kind() != reflect.Struct {
+		panic("binding: target must be a struct value, e.g. binding.Bind(Form{})")
+	}
+	return t
+}
+
+// mapValue maps the value as a service of its own type, and of interface types that ifacePtrs point to.
+func mapValue(ctx *macaron.Context, v reflect.Value, errs Errors, ifacePtrs []interface{}) {
+	ctx.Map(v.Interface())
+	for _, ptr := range ifacePtrs {
+		ctx.MapTo(v.Interface(), ptr)
+	}
+	ctx.Map(errs)
+}
+
+// writeErrors responds with the errors, 413 Request Entity Too Large if the body is too large,
+// 415 Unsupported Media Type or 400 Bad Request if the body cannot be decoded, or 422 Unprocessable
+// Entity if it is invalid.
+func writeErrors(ctx *macaron.Context, errs Errors) {
+	status := http.StatusUnprocessableEntity
+	switch {
+	case errs.Has(ERR_BODY_TOO_LARGE):
+		status = http.StatusRequestEntityTooLarge
+	case errs.Has(ERR_CONTENT_TYPE):
+		status = http.StatusUnsupportedMediaType
+	case errs.Has(ERR_DESERIALIZATION):
+		status = http.StatusBadRequest
+	}
+	ctx.Problem(status, "", "", "", map[string]interface{}{"errors": errs})
+}
+
+// Bind returns a middleware handler that binds the request into a new value of the type of obj,
+// which must be a struct value, and validates it by `binding` tags of fields. The value is mapped
+// for handlers after it, and also mapped to interface types that ifacePtrs point to. Requests that
+// fail binding or validation are responded with their Errors as an RFC 7807 problem, e.g.
+//
+//	type SignUpForm struct {
+//		Name  string `json:"name" binding:"required,min=3"`
+//		Email string `json:"email" binding:"required,email"`
+//	}
+//
+//	m.Post("/signup", binding.Bind(SignUpForm{}), func(form SignUpForm) {
+//		...
+//	})
+//
+// JSON bodies are decoded by encoding/json, MessagePack bodies are decoded as if they are JSON,
+// and protobuf bodies by the Unmarshal method of the target or the decoder set by RegisterDecoder.
+// Forms are bound by `form` tags of fields, or their JSON names. Uploaded files of multipart forms are bound into fields of type
+// *multipart.FileHeader or []*multipart.FileHeader. Requests without body are bound by their query. Fields with `param` tags
+// are set by route parameters, e.g. `param:"id"` for ":id" of "/users/:id", whichever the body is.
+//
+// Messages of errors are in the language of the request if macaron.I18n is used, and catalogs have
+// messages of keys "binding." followed by rules, e.g. "binding.required = %s is required", where
+// fields can be named by keys in `label` tags, and keys can be overridden by `msg` tags.
+func Bind(obj interface{}, ifacePtrs ...interface{}) macaron.Handler {
+	t := checkTarget(obj)
+	return macaron.Provides(func(ctx *macaron.Context) {
+		v, errs := bind(ctx, t)
+		mapValue(ctx, v, errs, ifacePtrs)
+		if len(errs) > 0 {
+			writeErrors(ctx, errs)
+		}
+	}, append([]interface{}{obj, Errors(nil)}, ifacePtrs...)...)
+}
+
+// BindIgnErr is like Bind, but leaves errors to handlers after it, which take them as Errors.
+func BindIgnErr(obj interface{}, ifacePtrs ...interface{}) macaron.Handler {
+	t := checkTarget(obj)
+	return macaron.Provides(func(ctx *macaron.Context) {
+		v, errs := bind(ctx, t)
+		mapValue(ctx, v, errs, ifacePtrs)
+	}, append([]interface{}{obj, Errors(nil)}, ifacePtrs...)...)
+}
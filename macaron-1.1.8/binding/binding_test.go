@@ -0,0 +1,306 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package binding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+type Named interface {
+	GetName() string
+}
+
+type Base struct {
+	ID int64 `json:"id" form:"id" param:"id"`
+}
+
+type SignUpForm struct {
+	Base
+	Name     string   `json:"name" binding:"required,min=3"`
+	Email    string   `json:"email" binding:"required,email"`
+	Age      int      `json:"age" binding:"max=150"`
+	Agree    bool     `json:"agree" form:"agree"`
+	Tags     []string `json:"tags" form:"tag"`
+	Internal string   `json:"-"`
+}
+
+func (f SignUpForm) GetName() string { return f.Name }
+
+type UpdateForm struct {
+	Base
+	OrgID uint8  `param:"org"`
+	Name  string `json:"name" binding:"required"`
+}
+
+func Test_BindParams(t *testing.T) {
+	Convey("Bind route parameters into structs", t, func() {
+		m := macaron.New()
+		m.Put("/orgs/:org/users/:id", Bind(UpdateForm{}), func(form UpdateForm) string {
+			return fmt.Sprintf("%d %d %s", form.OrgID, form.ID, form.Name)
+		})
+
+		serve := func(url, body string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("PUT", url, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/json")
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("/orgs/3/users/42", `{"id":1,"name":"Joe"}`)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "3 42 Joe")
+
+		resp = serve("/orgs/300/users/x", `{"name":"Joe"}`)
+		So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		So(resp.Body.String(), ShouldContainSubstring, `"field":"org","rule":"deserialization"`)
+		So(resp.Body.String(), ShouldContainSubstring, `"field":"id","rule":"deserialization"`)
+	})
+}
+
+type UploadForm struct {
+	Title       string                  `form:"title"`
+	Avatar      *multipart.FileHeader   `form:"avatar" binding:"required,maxsize=1KB,mime=image/png image/gif"`
+	Attachments []*multipart.FileHeader `form:"attachment" binding:"max=2,mime=text/*"`
+}
+
+func Test_BindFiles(t *testing.T) {
+	Convey("Bind uploaded files into structs", t, func() {
+		m := macaron.New()
+		m.Post("/upload", Bind(UploadForm{}), func(form UploadForm) string {
+			names := []string{form.Title, form.Avatar.Filename}
+			for _, fh := range form.Attachments {
+				names = append(names, fh.Filename)
+			}
+			return strings.Join(names, " ")
+		})
+
+		png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+		upload := func(files map[string][]string) *httptest.ResponseRecorder {
+			buf := new(bytes.Buffer)
+			w := multipart.NewWriter(buf)
+			w.WriteField("title", "Me")
+			for field, contents := range files {
+				for i, content := range contents {
+					fw, err := w.CreateFormFile(field, fmt.Sprintf("%s%d", field, i))
+					So(err, ShouldBeNil)
+					fw.Write([]byte(content))
+				}
+			}
+			w.Close()
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/upload", buf)
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", w.FormDataContentType())
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := upload(map[string][]string{"avatar": {png}, "attachment": {"hello", "world"}})
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "Me avatar0 attachment0 attachment1")
+
+		resp = upload(map[string][]string{"avatar": {png}})
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "Me avatar0")
+
+		resp = upload(map[string][]string{"attachment": {"hello"}})
+		So(resp.Code, ShouldEqual, http.StatusUnprocessableEntity)
+		So(resp.Body.String(), ShouldContainSubstring, `"field":"avatar","rule":"required"`)
+
+		resp = upload(map[string][]string{"avatar": {png + strings.Repeat("\x00", 1024)}})
+		So(resp.Code, ShouldEqual, http.StatusUnprocessableEntity)
+		So(resp.Body.String(), ShouldContainSubstring, `"message":"avatar must not be larger than 1KB"`)
+
+		resp = upload(map[string][]string{"avatar": {"<html></html>"}, "attachment": {png, "a", "b"}})
+		So(resp.Code, ShouldEqual, http.StatusUnprocessableEntity)
+		var problem struct{ Errors Errors }
+		So(json.Unmarshal(resp.Body.Bytes(), &problem), ShouldBeNil)
+		So(problem.Errors, ShouldResemble, Errors{
+			{"avatar", "mime", "avatar must be of type image/png, image/gif"},
+			{"attachment", "max", "attachment must have at most 2 items"},
+			{"attachment", "mime", "attachment must be of type text/*"},
+		})
+	})
+
+	Convey("Parse sizes", t, func() {
+		So(parseByteSize("512"), ShouldEqual, 512)
+		So(parseByteSize("2kb"), ShouldEqual, 2048)
+		So(parseByteSize("10MB"), ShouldEqual, 10<<20)
+		So(parseByteSize("1GB"), ShouldEqual, 1<<30)
+		So(parseByteSize("100B"), ShouldEqual, 100)
+		So(func() { parseByteSize("big") }, ShouldPanic)
+	})
+}
+
+func Test_Bind(t *testing.T) {
+	Convey("Bind requests into structs", t, func() {
+		m := macaron.New()
+		m.Post("/signup", Bind(SignUpForm{}, (*Named)(nil)), func(form SignUpForm, named Named) string {
+			return fmt.Sprintf("%d %s %s %d %v %v %s", form.ID, form.Name, form.Email, form.Age,
+				form.Agree, form.Tags, named.GetName())
+		})
+		m.Get("/search", Bind(SignUpForm{}), func(form SignUpForm) string {
+			return form.Name
+		})
+
+		serve := func(req *http.Request) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		Convey("Bind JSON", func() {
+			req, err := http.NewRequest("POST", "/signup", strings.NewReader(
+				`{"id":7,"name":"Joe","email":"joe@example.com","age":30,"agree":true,"tags":["a","b"],"Internal":"x"}`))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			resp := serve(req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "7 Joe joe@example.com 30 true [a b] Joe")
+		})
+
+		Convey("Bind forms", func() {
+			form := url.Values{"id": {"8"}, "name": {"Ann"}, "email": {"ann@example.com"},
+				"agree": {"on"}, "tag": {"x", "", "y"}}
+			req, err := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp := serve(req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "8 Ann ann@example.com 0 true [x y] Ann")
+		})
+
+		Convey("Bind multipart forms", func() {
+			buf := new(bytes.Buffer)
+			w := multipart.NewWriter(buf)
+			w.WriteField("name", "Bob")
+			w.WriteField("email", "bob@example.com")
+			w.WriteField("age", "41")
+			w.Close()
+			req, err := http.NewRequest("POST", "/signup?id=9", buf)
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", w.FormDataContentType())
+			resp := serve(req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "9 Bob bob@example.com 41 false [] Bob")
+		})
+
+		Convey("Bind queries", func() {
+			req, err := http.NewRequest("GET", "/search?name=Kim&email=kim@example.com", nil)
+			So(err, ShouldBeNil)
+			resp := serve(req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "Kim")
+		})
+
+		Convey("Respond with errors", func() {
+			req, err := http.NewRequest("POST", "/signup", strings.NewReader(`{"name":"Jo","email":"joe","age":200}`))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/json")
+			resp := serve(req)
+			So(resp.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/problem+json")
+			var problem struct {
+				Status int
+				Errors Errors
+			}
+			So(json.Unmarshal(resp.Body.Bytes(), &problem), ShouldBeNil)
+			So(problem.Status, ShouldEqual, 422)
+			So(problem.Errors, ShouldResemble, Errors{
+				{"name", "min", "name must have at least 3 characters"},
+				{"email", "email", "email must be a valid email address"},
+				{"age", "max", "age must be at most 150"},
+			})
+
+			req, err = http.NewRequest("POST", "/signup", strings.NewReader(`{"name":`))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/json")
+			So(serve(req).Code, ShouldEqual, http.StatusBadRequest)
+
+			req, err = http.NewRequest("POST", "/signup", strings.NewReader(url.Values{"age": {"old"}}.Encode()))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp = serve(req)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldContainSubstring, `"field":"age","rule":"deserialization"`)
+
+			req, err = http.NewRequest("POST", "/signup", strings.NewReader("<xml/>"))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "text/xml")
+			So(serve(req).Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+	})
+
+	Convey("Respond to bodies larger than MaxBody", t, func() {
+		m := macaron.New()
+		m.Post("/signup", macaron.MaxBody(16), Bind(SignUpForm{}), func() {})
+
+		buf := new(bytes.Buffer)
+		w := multipart.NewWriter(buf)
+		w.WriteField("name", strings.Repeat("x", 32))
+		w.Close()
+		for contentType, body := range map[string]string{
+			"application/json":                  `{"name":"` + strings.Repeat("x", 32) + `"}`,
+			"application/x-www-form-urlencoded": "name=" + strings.Repeat("x", 32),
+			w.FormDataContentType():             buf.String(),
+		} {
+			// Chunked bodies are not rejected by Content-Length.
+			req, err := http.NewRequest("POST", "/signup", ioutil.NopCloser(strings.NewReader(body)))
+			So(err, ShouldBeNil)
+			req.ContentLength = -1
+			req.Header.Set("Content-Type", contentType)
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(resp.Body.String(), ShouldContainSubstring, `"rule":"body_too_large"`)
+		}
+	})
+
+	Convey("Leave errors to handlers", t, func() {
+		m := macaron.New()
+		m.Post("/signup", BindIgnErr(SignUpForm{}), func(form SignUpForm, errs Errors) string {
+			return fmt.Sprintf("%s|%v|%s", form.Name, errs.Has("required"), errs.Error())
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/signup", strings.NewReader(`{"name":"Joe"}`))
+		So(err, ShouldBeNil)
+		req.Header.Set("Content-Type", "application/json")
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "Joe|true|email is required")
+	})
+
+	Convey("Reject targets other than struct values", t, func() {
+		So(func() { Bind(&SignUpForm{}) }, ShouldPanic)
+		So(func() { BindIgnErr("form") }, ShouldPanic)
+	})
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package binding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+type Point struct {
+	X    int64   `json:"x"`
+	Y    float64 `json:"y"`
+	Tags []string
+	Raw  []byte `json:"raw"`
+	Ok   bool   `json:"ok"`
+	Name string `json:"name" binding:"required"`
+}
+
+// Unmarshal decodes "x,name" as a fake protobuf message.
+func (p *Point) Unmarshal(data []byte) error {
+	parts := strings.Split(string(data), ",")
+	if len(parts) != 2 {
+		return errors.New("invalid message")
+	}
+	_, err := fmt.Sscan(parts[0], &p.X)
+	p.Name = parts[1]
+	return err
+}
+
+func Test_MsgPack(t *testing.T) {
+	Convey("Decode MessagePack", t, func() {
+		// {"x": -3, "y": 1.5, "Tags": ["a", "bc"], "raw": bin(0x01 0x02), "ok": true, "name": "pt", 7: nil}
+		body := []byte{0x87,
+			0xa1, 'x', 0xfd,
+			0xa1, 'y', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+			0xa4, 'T', 'a', 'g', 's', 0x92, 0xa1, 'a', 0xd9, 0x02, 'b', 'c',
+			0xa3, 'r', 'a', 'w', 0xc4, 0x02, 0x01, 0x02,
+			0xa2, 'o', 'k', 0xc3,
+			0xa4, 'n', 'a', 'm', 'e', 0xa2, 'p', 't',
+			0x07, 0xc0,
+		}
+		var p Point
+		So(decodeMsgPack(body, &p), ShouldBeNil)
+		So(p, ShouldResemble, Point{X: -3, Y: 1.5, Tags: []string{"a", "bc"}, Raw: []byte{1, 2}, Ok: true, Name: "pt"})
+
+		d := &msgpackDecoder{data: []byte{0xd1, 0xff, 0x00, 0xcd, 0x01, 0x00, 0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0,
+			0xca, 0x3f, 0x80, 0x00, 0x00, 0xdc, 0x00, 0x01, 0xc2}}
+		for _, want := range []interface{}{int64(-256), uint64(256), int64(-1 << 63), float64(1), []interface{}{false}} {
+			v, err := d.decode(0)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, want)
+		}
+
+		Convey("Reject invalid data", func() {
+			for _, data := range [][]byte{
+				{},
+				{0xa3, 'a'},
+				{0xdd, 0xff, 0xff, 0xff, 0xff},
+				{0xc1},
+				{0x01, 0x02},
+				bytes.Repeat([]byte{0x91}, msgpackMaxDepth+2),
+			} {
+				var v interface{}
+				So(decodeMsgPack(data, &v), ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("Bind binary bodies", t, func() {
+		m := macaron.New()
+		m.Post("/points", Bind(Point{}), func(p Point) string {
+			return fmt.Sprintf("%d %s", p.X, p.Name)
+		})
+
+		serve := func(contentType string, body []byte) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/points", bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", contentType)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("application/msgpack", []byte{0x82, 0xa1, 'x', 0x05, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'p'})
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "5 p")
+
+		resp = serve("application/x-msgpack", []byte{0x81, 0xa1, 'x', 0x05})
+		So(resp.Code, ShouldEqual, http.StatusUnprocessableEntity)
+
+		resp = serve("application/x-protobuf", []byte("9,q"))
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "9 q")
+
+		resp = serve("application/x-protobuf", []byte("9"))
+		So(resp.Code, ShouldEqual, http.StatusBadRequest)
+
+		Convey("Register decoders", func() {
+			RegisterDecoder("Application/X-Point", func(body []byte, v interface{}) error {
+				v.(*Point).Name = string(body)
+				return nil
+			})
+			defer delete(decoders, "application/x-point")
+
+			resp := serve("application/x-point", []byte("custom"))
+			So(resp.Body.String(), ShouldEqual, "0 custom")
+		})
+	})
+}
@@ -0,0 +1,548 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package binding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+// openapiMaxDepth is the maximum nesting of schemas that are checked, to stop cyclic references.
+const openapiMaxDepth = 64
+
+var openapiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openapiPath is a path template of the document, e.g. "/users/{id}".
+type openapiPath struct {
+	segments []string
+	literals int
+	item     map[string]interface{}
+}
+
+// match returns values of path parameters if the path matches the template.
+func (p *openapiPath) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(p.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, s := range p.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if len(segments[i]) == 0 {
+				return nil, false
+			}
+			params[s[1:len(s)-1]] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// OpenAPI is an OpenAPI 3 document that requests are validated against.
+type OpenAPI struct {
+	doc   map[string]interface{}
+	paths []*openapiPath
+
+	lock     sync.RWMutex
+	patterns map[string]*regexp.Regexp
+}
+
+// ParseOpenAPI parses an OpenAPI 3 document in JSON.
+func ParseOpenAPI(data []byte) (*OpenAPI, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("openapi: %v", err)
+	}
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", v)
+	}
+
+	spec := &OpenAPI{doc: doc, patterns: make(map[string]*regexp.Regexp)}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for tpl, item := range paths {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("openapi: path %q is not an object", tpl)
+		}
+		p := &openapiPath{segments: strings.Split(strings.Trim(tpl, "/"), "/"), item: m}
+		for _, s := range p.segments {
+			if !strings.HasPrefix(s, "{") {
+				p.literals++
+			}
+		}
+		spec.paths = append(spec.paths, p)
+	}
+	// Concrete paths are matched before templated ones, e.g. "/users/me" before "/users/{id}".
+	sort.SliceStable(spec.paths, func(i, j int) bool {
+		return spec.paths[i].literals > spec.paths[j].literals
+	})
+	return spec, nil
+}
+
+// LoadOpenAPI loads an OpenAPI 3 document in JSON from the file.
+func LoadOpenAPI(filename string) (*OpenAPI, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOpenAPI(data)
+}
+
+// resolve follows $ref of the object, which must point into the document, e.g. "#/components/schemas/User".
+func (spec *OpenAPI) resolve(obj map[string]interface{}) map[string]interface{} {
+	for i := 0; i < openapiMaxDepth; i++ {
+		ref, ok := obj["$ref"].(string)
+		if !ok {
+			return obj
+		}
+		var cur interface{} = spec.doc
+		for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			m, _ := cur.(map[string]interface{})
+			cur = m[token]
+		}
+		if obj, ok = cur.(map[string]interface{}); !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (spec *OpenAPI) pattern(s string) *regexp.Regexp {
+	spec.lock.RLock()
+	re, ok := spec.patterns[s]
+	spec.lock.RUnlock()
+	if ok {
+		return re
+	}
+	re, _ = regexp.Compile(s)
+	spec.lock.Lock()
+	spec.patterns[s] = re
+	spec.lock.Unlock()
+	return re
+}
+
+// jsonType returns the JSON type of the value decoded with UseNumber.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+var formatCheckers = map[string]func(string) bool{
+	"email": emailPattern.MatchString,
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	},
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	},
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+}
+
+// validate validates the value against the schema, errors are reported of the field.
+func (spec *OpenAPI) validate(schema map[string]interface{}, v interface{}, field string, errs *Errors, depth int) {
+	schema = spec.resolve(schema)
+	if schema == nil || depth > openapiMaxDepth {
+		return
+	}
+	fail := func(rule, format string, args ...interface{}) {
+		errs.Add(field, rule, field+" "+fmt.Sprintf(format, args...))
+	}
+
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := schema[key].([]interface{})
+		if !ok {
+			continue
+		}
+		matched := 0
+		var first Errors
+		for _, sub := range subs {
+			s, _ := sub.(map[string]interface{})
+			var subErrs Errors
+			spec.validate(s, v, field, &subErrs, depth+1)
+			if len(subErrs) == 0 {
+				matched++
+			} else if first == nil {
+				first = subErrs
+			}
+		}
+		switch {
+		case key == "allOf" && matched < len(subs):
+			*errs = append(*errs, first...)
+		case key == "anyOf" && matched == 0, key == "oneOf" && matched != 1:
+			fail(key, "must match %s of schemas", map[string]string{"anyOf": "any", "oneOf": "exactly one"}[key])
+		}
+	}
+
+	typ := jsonType(v)
+	if typ == "null" {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			fail("type", "must not be null")
+		}
+		return
+	}
+	if want, ok := schema["type"].(string); ok && want != typ && !(want == "number" && typ == "integer") {
+		fail("type", "must be of type %s", want)
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "must be one of %v", enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		if min, ok := schemaNumber(schema, "minimum"); ok {
+			if exclusive, _ := schema["exclusiveMinimum"].(bool); f < min || (exclusive && f == min) {
+				fail("minimum", "must be greater than %s%v", map[bool]string{true: "", false: "or equal to "}[exclusive], min)
+			}
+		}
+		if max, ok := schemaNumber(schema, "maximum"); ok {
+			if exclusive, _ := schema["exclusiveMaximum"].(bool); f > max || (exclusive && f == max) {
+				fail("maximum", "must be less than %s%v", map[bool]string{true: "", false: "or equal to "}[exclusive], max)
+			}
+		}
+	case string:
+		n := float64(len([]rune(v)))
+		if min, ok := schemaNumber(schema, "minLength"); ok && n < min {
+			fail("minLength", "must have at least %v characters", min)
+		}
+		if max, ok := schemaNumber(schema, "maxLength"); ok && n > max {
+			fail("maxLength", "must have at most %v characters", max)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			if re := spec.pattern(p); re != nil && !re.MatchString(v) {
+				fail("pattern", "must match pattern %s", p)
+			}
+		}
+		if format, ok := schema["format"].(string); ok {
+			if check, ok := formatCheckers[format]; ok && !check(v) {
+				fail("format", "must be a valid %s", format)
+			}
+		}
+	case []interface{}:
+		n := float64(len(v))
+		if min, ok := schemaNumber(schema, "minItems"); ok && n < min {
+			fail("minItems", "must have at least %v items", min)
+		}
+		if max, ok := schemaNumber(schema, "maxItems"); ok && n > max {
+			fail("maxItems", "must have at most %v items", max)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				spec.validate(items, item, fmt.Sprintf("%s[%d]", field, i), errs, depth+1)
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, ok := v[name]; !ok {
+					errs.Add(field+"."+name, "required", field+"."+name+" is required")
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := props[name].(map[string]interface{}); ok {
+				spec.validate(prop, v[name], field+"."+name, errs, depth+1)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs.Add(field+"."+name, "additionalProperties", field+"."+name+" is not allowed")
+				}
+			case map[string]interface{}:
+				spec.validate(additional, v[name], field+"."+name, errs, depth+1)
+			}
+		}
+	}
+}
+
+// coerce converts the value of parameter to the type of its schema, so it can be validated.
+func (spec *OpenAPI) coerce(schema map[string]interface{}, vals []string) interface{} {
+	schema = spec.resolve(schema)
+	typ, _ := schema["type"].(string)
+	if typ == "array" {
+		if len(vals) == 1 {
+			vals = strings.Split(vals[0], ",")
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		arr := make([]interface{}, len(vals))
+		for i, s := range vals {
+			arr[i] = spec.coerce(items, []string{s})
+		}
+		return arr
+	}
+
+	s := vals[0]
+	switch typ {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(s)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+// operation returns the operation of the request and values of path parameters,
+// or nil if the document has none.
+func (spec *OpenAPI) operation(method, path string) (map[string]interface{}, []interface{}, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, p := range spec.paths {
+		params, ok := p.match(segments)
+		if !ok {
+			continue
+		}
+		op, ok := p.item[strings.ToLower(method)].(map[string]interface{})
+		if !ok {
+			return nil, nil, nil
+		}
+		common, _ := p.item["parameters"].([]interface{})
+		return op, common, params
+	}
+	return nil, nil, nil
+}
+
+// validateParams validates parameters of the operation, which override common ones of the path.
+func (spec *OpenAPI) validateParams(req *http.Request, op map[string]interface{}, common []interface{},
+	pathParams map[string]string, errs *Errors) {
+	own, _ := op["parameters"].([]interface{})
+	params := make(map[string]map[string]interface{})
+	var keys []string
+	for _, list := range [][]interface{}{common, own} {
+		for _, p := range list {
+			m, _ := p.(map[string]interface{})
+			if m = spec.resolve(m); m == nil {
+				continue
+			}
+			name, _ := m["name"].(string)
+			in, _ := m["in"].(string)
+			key := in + "." + name
+			if _, ok := params[key]; !ok {
+				keys = append(keys, key)
+			}
+			params[key] = m
+		}
+	}
+
+	query := req.URL.Query()
+	for _, key := range keys {
+		p := params[key]
+		name, _ := p["name"].(string)
+		var vals []string
+		switch p["in"] {
+		case "path":
+			if v, ok := pathParams[name]; ok {
+				vals = []string{v}
+			}
+		case "query":
+			vals = query[name]
+		case "header":
+			vals = req.Header[http.CanonicalHeaderKey(name)]
+		case "cookie":
+			if c, err := req.Cookie(name); err == nil {
+				vals = []string{c.Value}
+			}
+		}
+
+		if len(vals) == 0 {
+			if required, _ := p["required"].(bool); required || p["in"] == "path" {
+				errs.Add(key, "required", key+" is required")
+			}
+			continue
+		}
+		if schema, ok := p["schema"].(map[string]interface{}); ok {
+			spec.validate(schema, spec.coerce(schema, vals), key, errs, 0)
+		}
+	}
+}
+
+// validateBody validates the request body, only JSON bodies are checked against schemas.
+func (spec *OpenAPI) validateBody(req *http.Request, op map[string]interface{}, errs *Errors) {
+	rb, _ := op["requestBody"].(map[string]interface{})
+	if rb = spec.resolve(rb); rb == nil {
+		return
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			if macaron.IsBodyTooLarge(err) {
+				errs.Add("body", ERR_BODY_TOO_LARGE, "Request body is too large")
+			} else {
+				errs.Add("body", ERR_DESERIALIZATION, "Request body cannot be read: "+err.Error())
+			}
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if len(body) == 0 {
+		if required, _ := rb["required"].(bool); required {
+			errs.Add("body", "required", "body is required")
+		}
+		return
+	}
+
+	content, _ := rb["content"].(map[string]interface{})
+	typ, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	media, ok := content[typ].(map[string]interface{})
+	if !ok {
+		if i := strings.IndexByte(typ, '/'); i > -1 {
+			media, ok = content[typ[:i]+"/*"].(map[string]interface{})
+		}
+		if !ok {
+			media, ok = content["*/*"].(map[string]interface{})
+		}
+	}
+	if !ok {
+		errs.Add("body", ERR_CONTENT_TYPE, fmt.Sprintf("Content type %q is not supported", typ))
+		return
+	}
+
+	schema, ok := media["schema"].(map[string]interface{})
+	if !ok || (typ != "application/json" && !strings.HasSuffix(typ, "+json")) {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		errs.Add("body", ERR_DESERIALIZATION, "Request body is not valid JSON: "+err.Error())
+		return
+	}
+	spec.validate(schema, v, "body", errs, 0)
+}
+
+// Validate validates the request against the operation that matches it, and returns errors,
+// whose fields are parameters named by their locations, e.g. "query.limit", or fields of the body
+// prefixed by "body", e.g. "body.items[0].name". Requests that match no operation are valid.
+func (spec *OpenAPI) Validate(req *http.Request, path string) Errors {
+	op, common, pathParams := spec.operation(req.Method, path)
+	if op == nil {
+		return nil
+	}
+	var errs Errors
+	spec.validateParams(req, op, common, pathParams, &errs)
+	spec.validateBody(req, op, &errs)
+	return errs
+}
+
+// Handler returns a middleware handler that validates requests against operations of the document
+// before handlers run, and responds with 400 Bad Request and Errors as an RFC 7807 problem to those
+// that are invalid, or 415 Unsupported Media Type if the operation does not accept the body.
+// Paths of operations are relative to basePath if given, e.g. "/v1". Parameters of all locations
+// and JSON bodies are validated by their schemas, which support $ref into the document, types,
+// enum, ranges, lengths, patterns, common formats, required and additional properties, and composition.
+//
+//	spec, err := binding.LoadOpenAPI("openapi.json")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	m.Use(spec.Handler())
+func (spec *OpenAPI) Handler(basePath ...string) macaron.Handler {
+	prefix := ""
+	if len(basePath) > 0 {
+		prefix = strings.TrimSuffix(basePath[0], "/")
+	}
+
+	return func(ctx *macaron.Context) {
+		path := ctx.Req.URL.Path
+		if len(prefix) > 0 {
+			if !strings.HasPrefix(path, prefix+"/") && path != prefix {
+				return
+			}
+			path = strings.TrimPrefix(path, prefix)
+		}
+
+		errs := spec.Validate(ctx.Req.Request, path)
+		if len(errs) == 0 {
+			return
+		}
+		status := http.StatusBadRequest
+		switch {
+		case errs.Has(ERR_BODY_TOO_LARGE):
+			status = http.StatusRequestEntityTooLarge
+		case errs.Has(ERR_CONTENT_TYPE):
+			status = http.StatusUnsupportedMediaType
+		}
+		ctx.Problem(status, "", "", "", map[string]interface{}{"errors": errs})
+	}
+}
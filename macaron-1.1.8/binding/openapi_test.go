@@ -0,0 +1,186 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package binding
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+const petstore = `{
+  "openapi": "3.0.3",
+  "paths": {
+    "/pets": {
+      "get": {
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+          {"name": "tags", "in": "query", "schema": {"type": "array", "items": {"type": "string", "enum": ["cat", "dog"]}}},
+          {"$ref": "#/components/parameters/Tenant"}
+        ]
+      },
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}
+        }
+      }
+    },
+    "/pets/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "get": {}
+    },
+    "/pets/mine": {
+      "get": {}
+    }
+  },
+  "components": {
+    "parameters": {
+      "Tenant": {"name": "X-Tenant", "in": "header", "required": true, "schema": {"type": "string", "pattern": "^[a-z]+$"}}
+    },
+    "schemas": {
+      "Pet": {
+        "type": "object",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 2},
+          "email": {"type": "string", "format": "email"},
+          "age": {"type": "integer", "minimum": 0},
+          "toys": {"type": "array", "maxItems": 2, "items": {"$ref": "#/components/schemas/Toy"}}
+        }
+      },
+      "Toy": {"type": "object", "required": ["kind"], "properties": {"kind": {"type": "string"}}}
+    }
+  }
+}`
+
+func Test_OpenAPI(t *testing.T) {
+	Convey("Parse OpenAPI documents", t, func() {
+		_, err := ParseOpenAPI([]byte(`{"swagger": "2.0"}`))
+		So(err, ShouldNotBeNil)
+		_, err = ParseOpenAPI([]byte(`{`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Validate requests against OpenAPI documents", t, func() {
+		spec, err := ParseOpenAPI([]byte(petstore))
+		So(err, ShouldBeNil)
+
+		m := macaron.New()
+		m.Use(spec.Handler("/v1"))
+		handler := func(ctx *macaron.Context) string {
+			body, _ := ioutil.ReadAll(ctx.Req.Request.Body)
+			return "ok" + string(body)
+		}
+		m.Get("/v1/pets", handler)
+		m.Post("/v1/pets", handler)
+		m.Get("/v1/pets/:id", handler)
+		m.Get("/v1/other", handler)
+
+		serve := func(method, url, body string, header map[string]string) (*httptest.ResponseRecorder, []Error) {
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			var problem struct {
+				Errors []Error `json:"errors"`
+			}
+			json.Unmarshal(resp.Body.Bytes(), &problem)
+			return resp, problem.Errors
+		}
+		tenant := map[string]string{"X-Tenant": "acme"}
+		jsonType := map[string]string{"Content-Type": "application/json"}
+
+		Convey("Valid requests pass through", func() {
+			resp, _ := serve("GET", "/v1/pets?limit=10&tags=cat,dog", "", tenant)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			resp, _ = serve("GET", "/v1/pets/7", "", nil)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			resp, _ = serve("GET", "/v1/pets/mine", "", nil)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			resp, _ = serve("GET", "/v1/other", "", nil)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+
+			body := `{"name":"Rex","age":3,"toys":[{"kind":"ball"}]}`
+			resp, _ = serve("POST", "/v1/pets", body, jsonType)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "ok"+body)
+		})
+
+		Convey("Invalid parameters", func() {
+			resp, errs := serve("GET", "/v1/pets?limit=500&tags=cat&tags=fish", "", map[string]string{"X-Tenant": "ACME"})
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Header().Get("Content-Type"), ShouldStartWith, "application/problem+json")
+			So(errs, ShouldHaveLength, 3)
+			So(errs[0].Field, ShouldEqual, "query.limit")
+			So(errs[0].Rule, ShouldEqual, "maximum")
+			So(errs[1].Field, ShouldEqual, "query.tags[1]")
+			So(errs[1].Rule, ShouldEqual, "enum")
+			So(errs[2].Field, ShouldEqual, "header.X-Tenant")
+			So(errs[2].Rule, ShouldEqual, "pattern")
+
+			resp, errs = serve("GET", "/v1/pets?limit=ten", "", nil)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Rule, ShouldEqual, "type")
+			So(errs[1].Field, ShouldEqual, "header.X-Tenant")
+			So(errs[1].Rule, ShouldEqual, "required")
+
+			resp, errs = serve("GET", "/v1/pets/abc", "", nil)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(errs[0].Field, ShouldEqual, "path.id")
+		})
+
+		Convey("Invalid bodies", func() {
+			resp, errs := serve("POST", "/v1/pets", "", jsonType)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(errs[0].Field, ShouldEqual, "body")
+			So(errs[0].Rule, ShouldEqual, "required")
+
+			resp, errs = serve("POST", "/v1/pets", `{"name":"R","email":"no","age":1.5,"toys":[{},{"kind":1}],"color":"red"}`, jsonType)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			fields := make([]string, len(errs))
+			for i := range errs {
+				fields[i] = errs[i].Field + " " + errs[i].Rule
+			}
+			So(fields, ShouldResemble, []string{
+				"body.age type",
+				"body.color additionalProperties",
+				"body.email format",
+				"body.name minLength",
+				"body.toys[0].kind required",
+				"body.toys[1].kind type",
+			})
+
+			resp, errs = serve("POST", "/v1/pets", `{"name":`, jsonType)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(errs[0].Rule, ShouldEqual, ERR_DESERIALIZATION)
+
+			resp, _ = serve("POST", "/v1/pets", "name=Rex", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+			So(resp.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+	})
+}
@@ -0,0 +1,430 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package binding
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/macaron.v1"
+)
+
+var (
+	emailPattern    = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+$`)
+	alphaPattern    = regexp.MustCompile(`^[a-zA-Z]+$`)
+	alphaNumPattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	numericPattern  = regexp.MustCompile(`^[-+]?[0-9]+(?:\.[0-9]+)?$`)
+)
+
+// hasLength returns true if min, max and len rules of the value compare its length rather than itself.
+func hasLength(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// size returns the length of the value, or the value itself for numbers.
+func size(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return 0
+}
+
+func compareSize(cmp func(a, b float64) bool) func(reflect.Value, string) bool {
+	return func(v reflect.Value, param string) bool {
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("binding: invalid parameter %q of rule", param))
+		}
+		return cmp(size(v), n)
+	}
+}
+
+func matchString(pattern *regexp.Regexp) func(reflect.Value, string) bool {
+	return func(v reflect.Value, _ string) bool {
+		return v.Kind() == reflect.String && pattern.MatchString(v.String())
+	}
+}
+
+// fileHeaders returns uploaded files of the value, which is a *multipart.FileHeader
+// or []*multipart.FileHeader, or false if it is neither.
+func fileHeaders(v reflect.Value) ([]*multipart.FileHeader, bool) {
+	switch fhs := v.Interface().(type) {
+	case *multipart.FileHeader:
+		return []*multipart.FileHeader{fhs}, true
+	case []*multipart.FileHeader:
+		return fhs, true
+	}
+	return nil, false
+}
+
+// parseByteSize parses size in bytes with an optional unit, e.g. "512", "100KB" or "10MB".
+func parseByteSize(s string) int64 {
+	units := []struct {
+		suffix string
+		n      int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), 10, 64)
+			if err == nil {
+				return n * u.n
+			}
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("binding: invalid size %q", s))
+	}
+	return n
+}
+
+// sniffType returns the content type of the file detected by its content,
+// which unlike the one declared by the client cannot be forged easily.
+func sniffType(fh *multipart.FileHeader) string {
+	f, err := fh.Open()
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	typ := http.DetectContentType(buf[:n])
+	if i := strings.IndexByte(typ, ';'); i > -1 {
+		typ = typ[:i]
+	}
+	return typ
+}
+
+// matchType returns true if the content type matches the pattern, which can be a wildcard, e.g. "image/*".
+func matchType(pattern, typ string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(typ, pattern[:len(pattern)-1])
+	}
+	return pattern == typ
+}
+
+// rules are validation rules by names, which return true if the value is valid.
+// Rules are not applied to zero values other than "required".
+var rules = map[string]func(v reflect.Value, param string) bool{
+	"required": func(v reflect.Value, _ string) bool { return !isZero(v) },
+	"min":      compareSize(func(a, b float64) bool { return a >= b }),
+	"max":      compareSize(func(a, b float64) bool { return a <= b }),
+	"len":      compareSize(func(a, b float64) bool { return a == b }),
+	"email":    matchString(emailPattern),
+	"alpha":    matchString(alphaPattern),
+	"alphanum": matchString(alphaNumPattern),
+	"numeric":  matchString(numericPattern),
+	"url": func(v reflect.Value, _ string) bool {
+		if v.Kind() != reflect.String {
+			return false
+		}
+		u, err := url.Parse(v.String())
+		return err == nil && len(u.Scheme) > 0 && len(u.Host) > 0
+	},
+	"maxsize": func(v reflect.Value, param string) bool {
+		fhs, ok := fileHeaders(v)
+		limit := parseByteSize(param)
+		for _, fh := range fhs {
+			if fh.Size > limit {
+				return false
+			}
+		}
+		return ok
+	},
+	"mime": func(v reflect.Value, param string) bool {
+		fhs, ok := fileHeaders(v)
+	files:
+		for _, fh := range fhs {
+			typ := sniffType(fh)
+			for _, pattern := range strings.Fields(param) {
+				if matchType(strings.ToLower(pattern), typ) {
+					continue files
+				}
+			}
+			return false
+		}
+		return ok
+	},
+	"oneof": func(v reflect.Value, param string) bool {
+		s := fmt.Sprint(v.Interface())
+		for _, option := range strings.Fields(param) {
+			if s == option {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// RuleFunc returns true if the value of field is valid, param is the parameter of the rule
+// in the tag, e.g. "3" of "min=3", or empty if there is none.
+type RuleFunc func(value interface{}, param string) bool
+
+// RegisterRule registers a named validation rule, which can be used in `binding` tags
+// along with built-in ones, e.g.
+//
+//	binding.RegisterRule("phone", func(value interface{}, _ string) bool {
+//		s, ok := value.(string)
+//		return ok && phonePattern.MatchString(s)
+//	})
+//
+// Like built-in rules, it is not applied to zero values. Rules should be registered before
+// serving, and it panics if the name is taken.
+func RegisterRule(name string, fn RuleFunc) {
+	if len(name) == 0 || strings.ContainsAny(name, ",= ") {
+		panic(fmt.Sprintf("binding: invalid rule name %q", name))
+	} else if _, ok := rules[name]; ok {
+		panic(fmt.Sprintf("binding: rule %q already exists", name))
+	}
+	rules[name] = func(v reflect.Value, param string) bool {
+		return fn(v.Interface(), param)
+	}
+}
+
+// Validator is implemented by structs that validate themselves, e.g. for rules across fields
+// or that need services of the request. Validate is called after rules of tags are checked,
+// and errors it returns are reported along with theirs, fields of them are prefixed by the
+// name of the struct if it is nested in another.
+type Validator interface {
+	Validate(ctx *macaron.Context) Errors
+}
+
+// message returns the message of the error of the rule.
+func message(field, rule, param string, v reflect.Value) string {
+	switch rule {
+	case "required":
+		return field + " is required"
+	case "min", "max", "len":
+		what := map[string]string{"min": "at least", "max": "at most", "len": "exactly"}[rule]
+		if hasLength(v) {
+			unit := "items"
+			if v.Kind() == reflect.String {
+				unit = "characters"
+			}
+			return fmt.Sprintf("%s must have %s %s %s", field, what, param, unit)
+		}
+		return fmt.Sprintf("%s must be %s %s", field, what, param)
+	case "email":
+		return field + " must be a valid email address"
+	case "url":
+		return field + " must be a valid URL"
+	case "alpha":
+		return field + " must contain letters only"
+	case "alphanum":
+		return field + " must contain letters and digits only"
+	case "numeric":
+		return field + " must be a number"
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", field, strings.Join(strings.Fields(param), ", "))
+	case "maxsize":
+		return fmt.Sprintf("%s must not be larger than %s", field, param)
+	case "mime":
+		return fmt.Sprintf("%s must be of type %s", field, strings.Join(strings.Fields(param), ", "))
+	}
+	return field + " is invalid"
+}
+
+// messageKey returns the key of the message of the rule in message catalogs, which is "binding."
+// followed by the rule, and "_length" for rules that compare the length, e.g. "binding.min_length".
+// It can be overridden by `msg` tag of the field, either for all rules, e.g. `msg:"signup.name_invalid"`,
+// or per rule, e.g. `msg:"required=signup.name_required,min=signup.name_short"`.
+func messageKey(tag reflect.StructTag, rule string, v reflect.Value) string {
+	if msg := tag.Get("msg"); len(msg) > 0 {
+		if !strings.Contains(msg, "=") {
+			return msg
+		}
+		for _, r := range parseRules(msg) {
+			if r[0] == rule {
+				return r[1]
+			}
+		}
+	}
+	key := "binding." + rule
+	if (rule == "min" || rule == "max" || rule == "len") && hasLength(v) {
+		key += "_length"
+	}
+	return key
+}
+
+// localizedMessage returns the message of the error of the rule in the locale of the request,
+// which is formatted with the field and the parameter of the rule if any, e.g. "%s is required".
+// The field is named by the message of the key in `label` tag if it has one. The message
+// in English is returned if the request has no locale or the catalog has no such message.
+func localizedMessage(ctx *macaron.Context, tag reflect.StructTag, field, rule, param string, v reflect.Value) string {
+	if ctx == nil || ctx.Locale == nil {
+		return message(field, rule, param, v)
+	}
+	if key := tag.Get("label"); len(key) > 0 {
+		field = ctx.Locale.Tr(key)
+	}
+	key := messageKey(tag, rule, v)
+	format := ctx.Locale.Tr(key)
+	if format == key {
+		return message(field, rule, param, v)
+	}
+	args := []interface{}{field}
+	if len(param) > 0 {
+		args = append(args, param)
+	}
+	msg := fmt.Sprintf(format, args...)
+	// Messages do not have to mention the field or the parameter.
+	if i := strings.Index(msg, "%!(EXTRA "); i > -1 {
+		msg = msg[:i]
+	}
+	return msg
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// parseRules parses rules of `binding` tag, e.g. "required,min=3".
+func parseRules(tag string) [][2]string {
+	var list [][2]string
+	for _, r := range strings.Split(tag, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+		kv := strings.SplitN(r, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		list = append(list, [2]string{kv[0], kv[1]})
+	}
+	return list
+}
+
+// validateField validates the value by rules of the tag, and values in it if it is a struct
+// or contains structs.
+func validateField(ctx *macaron.Context, v reflect.Value, name string, tag reflect.StructTag, errs *Errors) {
+	zero := isZero(v)
+	for _, r := range parseRules(tag.Get("binding")) {
+		fn, ok := rules[r[0]]
+		if !ok {
+			panic(fmt.Sprintf("binding: unknown rule %q of field %s", r[0], name))
+		}
+		if (zero && r[0] != "required") || fn(v, r[1]) {
+			continue
+		}
+		errs.Add(name, r[0], localizedMessage(ctx, tag, name, r[0], r[1], v))
+		// Other rules of the field are meaningless if it is missing.
+		if r[0] == "required" {
+			return
+		}
+	}
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Struct && v.Type() != timeType && v.Type() != fileType.Elem():
+		validateStruct(ctx, v, name+".", errs)
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			for elem.Kind() == reflect.Ptr && !elem.IsNil() {
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Struct && elem.Type() != timeType && elem.Type() != fileType.Elem() {
+				validateStruct(ctx, elem, fmt.Sprintf("%s[%d].", name, i), errs)
+			}
+		}
+	}
+}
+
+// validateStruct validates fields of the struct and then the struct itself if it is a Validator,
+// names of fields in errors are prefixed.
+func validateStruct(ctx *macaron.Context, v reflect.Value, prefix string, errs *Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			validateStruct(ctx, v.Field(i), prefix, errs)
+			continue
+		}
+		name := fieldName(f)
+		if name == "-" {
+			continue
+		}
+		validateField(ctx, v.Field(i), prefix+name, f.Tag, errs)
+	}
+
+	var validator Validator
+	if v.CanAddr() && v.Addr().Type().Implements(reflect.TypeOf((*Validator)(nil)).Elem()) {
+		validator = v.Addr().Interface().(Validator)
+	} else if vv, ok := v.Interface().(Validator); ok {
+		validator = vv
+	}
+	if validator == nil {
+		return
+	}
+	for _, err := range validator.Validate(ctx) {
+		if len(err.Field) > 0 {
+			err.Field = prefix + err.Field
+		} else if len(prefix) > 0 {
+			err.Field = strings.TrimSuffix(prefix, ".")
+		}
+		*errs = append(*errs, err)
+	}
+}
+
+// Validate validates the struct, or the struct that obj points to, by `binding` tags of fields,
+// and by Validator with nil context.
+func Validate(obj interface{}) Errors {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		panic("binding: only structs can be validated")
+	}
+	var errs Errors
+	validateStruct(nil, v, "", &errs)
+	return errs
+}
@@ -0,0 +1,235 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package binding
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+type Address struct {
+	City string `json:"city" binding:"required"`
+	Zip  string `json:"zip" binding:"len=5,numeric"`
+}
+
+type Order struct {
+	Address  *Address  `json:"address" binding:"required"`
+	Items    []Address `json:"items" binding:"min=1,max=2"`
+	Website  string    `json:"website" binding:"url"`
+	Code     string    `json:"code" binding:"alphanum"`
+	Initials string    `json:"initials" binding:"alpha"`
+	Status   string    `json:"status" binding:"oneof=new paid"`
+	Count    int       `json:"count" binding:"min=1"`
+}
+
+func Test_Validate(t *testing.T) {
+	Convey("Validate structs", t, func() {
+		So(Validate(&Order{
+			Address: &Address{City: "Paris", Zip: "75001"},
+			Items:   []Address{{City: "Lyon"}},
+			Website: "https://example.com",
+			Code:    "A1",
+			Status:  "paid",
+		}), ShouldBeEmpty)
+
+		errs := Validate(Order{
+			Items:    []Address{{Zip: "123"}, {City: "Nice"}, {City: "Metz"}},
+			Website:  "example.com",
+			Code:     "A-1",
+			Initials: "J.D.",
+			Status:   "lost",
+			Count:    -1,
+		})
+		So(errs, ShouldResemble, Errors{
+			{"address", "required", "address is required"},
+			{"items", "max", "items must have at most 2 items"},
+			{"items[0].city", "required", "items[0].city is required"},
+			{"items[0].zip", "len", "items[0].zip must have exactly 5 characters"},
+			{"website", "url", "website must be a valid URL"},
+			{"code", "alphanum", "code must contain letters and digits only"},
+			{"initials", "alpha", "initials must contain letters only"},
+			{"status", "oneof", "status must be one of new, paid"},
+			{"count", "min", "count must be at least 1"},
+		})
+
+		errs = Validate(Order{Address: &Address{}, Items: []Address{{City: "Lyon"}}})
+		So(errs, ShouldResemble, Errors{{"address.city", "required", "address.city is required"}})
+	})
+
+	Convey("Panic on unknown rules", t, func() {
+		So(func() { RegisterRule("min", func(interface{}, string) bool { return true }) }, ShouldPanic)
+		So(func() { RegisterRule("a,b", func(interface{}, string) bool { return true }) }, ShouldPanic)
+		So(func() {
+			Validate(struct {
+				Name string `binding:"unknown"`
+			}{"x"})
+		}, ShouldPanic)
+	})
+}
+
+var phonePattern = regexp.MustCompile(`^\+[0-9]{8,15}$`)
+
+func init() {
+	RegisterRule("phone", func(value interface{}, _ string) bool {
+		s, ok := value.(string)
+		return ok && phonePattern.MatchString(s)
+	})
+	RegisterRule("prefix", func(value interface{}, param string) bool {
+		return strings.HasPrefix(value.(string), param)
+	})
+}
+
+type Contact struct {
+	Phone string `json:"phone" binding:"phone"`
+	Code  string `json:"code" binding:"prefix=C-"`
+}
+
+type Booking struct {
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Contact Contact   `json:"contact"`
+	Guests  []Contact `json:"guests"`
+}
+
+func (b *Booking) Validate(ctx *macaron.Context) Errors {
+	var errs Errors
+	if b.To < b.From {
+		errs.Add("to", "after", "to must be after from")
+	}
+	if ctx != nil && ctx.Req.Header.Get("X-Closed") == "1" {
+		errs.Add("", "closed", "bookings are closed")
+	}
+	return errs
+}
+
+func (c Contact) Validate(*macaron.Context) Errors {
+	if c.Phone == "+00000000" {
+		return Errors{{Rule: "blocked", Message: "contact is blocked"}}
+	}
+	return nil
+}
+
+func Test_CustomValidation(t *testing.T) {
+	Convey("Validate by custom rules and validators", t, func() {
+		errs := Validate(&Booking{
+			From:    2,
+			To:      1,
+			Contact: Contact{Phone: "12345", Code: "D-1"},
+			Guests:  []Contact{{Phone: "+00000000"}},
+		})
+		So(errs, ShouldResemble, Errors{
+			{"contact.phone", "phone", "contact.phone is invalid"},
+			{"contact.code", "prefix", "contact.code is invalid"},
+			{"guests[0]", "blocked", "contact is blocked"},
+			{"to", "after", "to must be after from"},
+		})
+		So(Validate(&Booking{Contact: Contact{Phone: "+3312345678", Code: "C-1"}}), ShouldBeEmpty)
+
+		Convey("Validate with context of the request", func() {
+			m := macaron.New()
+			m.Post("/bookings", BindIgnErr(Booking{}), func(errs Errors) string {
+				return errs.Error()
+			})
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/bookings", strings.NewReader(`{"from":1,"to":2}`))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Closed", "1")
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "bookings are closed")
+		})
+	})
+}
+
+type fakeLocale map[string]string
+
+func (l fakeLocale) Language() string { return "fr-FR" }
+
+func (l fakeLocale) Tr(key string, args ...interface{}) string {
+	msg, ok := l[key]
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+type ProfileForm struct {
+	Name  string   `json:"name" binding:"required,min=3" label:"field.name"`
+	Email string   `json:"email" binding:"required,email" msg:"required=profile.email_required"`
+	Bio   string   `json:"bio" binding:"max=5" msg:"profile.bio_invalid"`
+	Tags  []string `json:"tags" binding:"min=2"`
+	Phone string   `json:"phone" binding:"phone"`
+}
+
+func Test_LocalizedMessages(t *testing.T) {
+	Convey("Localize messages of errors", t, func() {
+		m := macaron.New()
+		m.Use(func(ctx *macaron.Context) {
+			if ctx.Req.Header.Get("Accept-Language") == "fr" {
+				ctx.Locale = fakeLocale{
+					"field.name":             "Le nom",
+					"binding.min_length":     "%s doit avoir au moins %s éléments",
+					"binding.email":          "%s n'est pas une adresse valide",
+					"profile.email_required": "L'adresse est obligatoire",
+					"profile.bio_invalid":    "La biographie est trop longue",
+				}
+			}
+		})
+		m.Post("/profile", BindIgnErr(ProfileForm{}), func(errs Errors) string {
+			msgs := make([]string, len(errs))
+			for i := range errs {
+				msgs[i] = errs[i].Message
+			}
+			return strings.Join(msgs, "\n")
+		})
+
+		serve := func(lang string) string {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/profile",
+				strings.NewReader(`{"name":"Al","bio":"Too long","tags":["a"],"phone":"1"}`))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Language", lang)
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+
+		So(serve("fr"), ShouldEqual, strings.Join([]string{
+			"Le nom doit avoir au moins 3 éléments",
+			"L'adresse est obligatoire",
+			"La biographie est trop longue",
+			"tags doit avoir au moins 2 éléments",
+			"phone is invalid",
+		}, "\n"))
+		So(serve("en"), ShouldEqual, strings.Join([]string{
+			"name must have at least 3 characters",
+			"email is required",
+			"bio must have at most 5 characters",
+			"tags must have at least 2 items",
+			"phone is invalid",
+		}, "\n"))
+	})
+}
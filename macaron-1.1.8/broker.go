@@ -0,0 +1,265 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEEvent is an event of Server-Sent Events published by Broker.
+type SSEEvent struct {
+	// ID is assigned by Broker when the event is published, and increases across all topics.
+	ID    string
+	Topic string
+	// Event is the type of event, clients receive events without type as "message".
+	Event string
+	Data  string
+	// Retry tells clients how long to wait before reconnecting, 0 leaves it unchanged.
+	Retry time.Duration
+
+	seq uint64
+}
+
+// writeTo writes the event in text/event-stream format.
+func (ev *SSEEvent) writeTo(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	if len(ev.ID) > 0 {
+		buf.WriteString("id: " + ev.ID + "\n")
+	}
+	if len(ev.Event) > 0 {
+		buf.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(int64(ev.Retry/time.Millisecond), 10) + "\n")
+	}
+	for _, line := range strings.Split(strings.Replace(ev.Data, "\r\n", "\n", -1), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// BrokerOptions is a struct for specifying configuration options for macaron.Broker.
+type BrokerOptions struct {
+	// Replay is the number of last events kept for every topic, which are sent to clients when they
+	// connect, or those after Last-Event-ID when they reconnect. Default is 0, i.e. nothing is kept.
+	Replay int
+	// Heartbeat is how often comments are sent to idle clients, so proxies do not close the connection.
+	// Default is 15 seconds.
+	Heartbeat time.Duration
+	// BufferSize is the number of events buffered for every client, a client that falls behind
+	// is disconnected, so it reconnects and catches up by replay. Default is 16.
+	BufferSize int
+}
+
+func prepareBrokerOptions(options []BrokerOptions) BrokerOptions {
+	var opt BrokerOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Heartbeat <= 0 {
+		opt.Heartbeat = 15 * time.Second
+	}
+	if opt.BufferSize <= 0 {
+		opt.BufferSize = 16
+	}
+	return opt
+}
+
+// Subscription receives events of topics from Broker.
+type Subscription struct {
+	// C receives published events, it is closed when the subscription ends.
+	C <-chan *SSEEvent
+
+	c      chan *SSEEvent
+	topics []string
+}
+
+// Broker fans out events published to topics to clients connected by Server-Sent Events.
+type Broker struct {
+	opt BrokerOptions
+
+	lock    sync.Mutex
+	seq     uint64
+	subs    map[string]map[*Subscription]bool
+	history map[string][]*SSEEvent
+}
+
+// NewBroker creates a new broker of Server-Sent Events, whose handler serves clients,
+// and which can be published to from anywhere, e.g.
+//
+//	broker := macaron.NewBroker(macaron.BrokerOptions{Replay: 10})
+//	m.Get("/events/:topic", broker.Handler())
+//	m.Post("/orders", func() {
+//		broker.Publish("orders", "created", `{"id":1}`)
+//	})
+func NewBroker(options ...BrokerOptions) *Broker {
+	return &Broker{
+		opt:     prepareBrokerOptions(options),
+		subs:    make(map[string]map[*Subscription]bool),
+		history: make(map[string][]*SSEEvent),
+	}
+}
+
+// Publish sends an event of given type and data to subscribers of the topic, and returns it.
+func (b *Broker) Publish(topic, event, data string) *SSEEvent {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.seq++
+	ev := &SSEEvent{ID: strconv.FormatUint(b.seq, 10), Topic: topic, Event: event, Data: data, seq: b.seq}
+	if b.opt.Replay > 0 {
+		h := append(b.history[topic], ev)
+		if len(h) > b.opt.Replay {
+			h = h[len(h)-b.opt.Replay:]
+		}
+		b.history[topic] = h
+	}
+
+	for sub := range b.subs[topic] {
+		select {
+		case sub.c <- ev:
+		default:
+			b.unsubscribe(sub)
+		}
+	}
+	return ev
+}
+
+// Subscribe subscribes to topics, and replays kept events with IDs greater than lastID if given,
+// or all of them otherwise.
+func (b *Broker) Subscribe(lastID string, topics ...string) *Subscription {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var replay []*SSEEvent
+	after, _ := strconv.ParseUint(lastID, 10, 64)
+	for _, topic := range topics {
+		for _, ev := range b.history[topic] {
+			if ev.seq > after {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	sort.Slice(replay, func(i, j int) bool { return replay[i].seq < replay[j].seq })
+
+	c := make(chan *SSEEvent, b.opt.BufferSize+len(replay))
+	for _, ev := range replay {
+		c <- ev
+	}
+	sub := &Subscription{C: c, c: c, topics: topics}
+	for _, topic := range topics {
+		if b.subs[topic] == nil {
+			b.subs[topic] = make(map[*Subscription]bool)
+		}
+		b.subs[topic][sub] = true
+	}
+	return sub
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	if len(sub.topics) == 0 {
+		return
+	}
+	for _, topic := range sub.topics {
+		delete(b.subs[topic], sub)
+		if len(b.subs[topic]) == 0 {
+			delete(b.subs, topic)
+		}
+	}
+	sub.topics = nil
+	close(sub.c)
+}
+
+// Unsubscribe ends the subscription, it is safe to be called more than once.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.lock.Lock()
+	b.unsubscribe(sub)
+	b.lock.Unlock()
+}
+
+// Clients returns the number of subscriptions to the topic.
+func (b *Broker) Clients(topic string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subs[topic])
+}
+
+// Handler returns a handler that streams events of topics to the client by Server-Sent Events.
+// Topics are given, or taken from the route param "topic", or query "topic" which can be repeated.
+// Clients that reconnect with Last-Event-ID header receive kept events they have missed.
+func (b *Broker) Handler(topics ...string) Handler {
+	return func(ctx *Context) {
+		subTopics := topics
+		if len(subTopics) == 0 {
+			if topic := ctx.Params("topic"); len(topic) > 0 {
+				subTopics = []string{topic}
+			} else {
+				subTopics = ctx.QueryStrings("topic")
+			}
+		}
+		if len(subTopics) == 0 {
+			writeErrorPage(ctx, ctx.Resp, http.StatusBadRequest, "")
+			return
+		}
+
+		lastID := ctx.Req.Header.Get("Last-Event-ID")
+		if len(lastID) == 0 {
+			lastID = ctx.Query("lastEventId")
+		}
+		sub := b.Subscribe(lastID, subTopics...)
+		defer b.Unsubscribe(sub)
+
+		header := ctx.Resp.Header()
+		header.Set(_CONTENT_TYPE, "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		ctx.Resp.WriteHeader(http.StatusOK)
+		ctx.Resp.Flush()
+
+		heartbeat := time.NewTicker(b.opt.Heartbeat)
+		defer heartbeat.Stop()
+		done := ctx.Req.Context().Done()
+		for {
+			var err error
+			select {
+			case <-done:
+				return
+			case ev, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				err = ev.writeTo(ctx.Resp)
+			case <-heartbeat.C:
+				_, err = io.WriteString(ctx.Resp, ": ping\n\n")
+			}
+			if err != nil {
+				return
+			}
+			ctx.Resp.Flush()
+		}
+	}
+}
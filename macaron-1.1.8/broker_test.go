@@ -0,0 +1,124 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readSSE reads n events, or comments, from the stream.
+func readSSE(br *bufio.Reader, n int) ([]string, error) {
+	var events []string
+	var cur []string
+	for len(events) < n {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return events, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if len(line) == 0 {
+			events = append(events, strings.Join(cur, "|"))
+			cur = nil
+			continue
+		}
+		cur = append(cur, line)
+	}
+	return events, nil
+}
+
+func Test_Broker(t *testing.T) {
+	Convey("Fan out events to subscribers", t, func() {
+		b := NewBroker(BrokerOptions{Replay: 2, BufferSize: 1})
+
+		sub := b.Subscribe("", "a", "b")
+		So(b.Clients("a"), ShouldEqual, 1)
+		b.Publish("a", "", "one")
+		So((<-sub.C).Data, ShouldEqual, "one")
+		b.Publish("c", "", "other")
+
+		// A subscriber that falls behind is dropped.
+		b.Publish("b", "", "two")
+		b.Publish("b", "", "three")
+		So((<-sub.C).Data, ShouldEqual, "two")
+		_, ok := <-sub.C
+		So(ok, ShouldBeFalse)
+		So(b.Clients("a"), ShouldEqual, 0)
+		b.Unsubscribe(sub)
+
+		// Kept events are replayed in order after the last ID.
+		sub = b.Subscribe("1", "a", "b")
+		defer b.Unsubscribe(sub)
+		So((<-sub.C).ID, ShouldEqual, "3")
+		So((<-sub.C).ID, ShouldEqual, "4")
+	})
+
+	Convey("Stream events by Server-Sent Events", t, func() {
+		b := NewBroker(BrokerOptions{Replay: 1, Heartbeat: 50 * time.Millisecond})
+		b.Publish("news", "", "old")
+		b.Publish("news", "headline", "line1\nline2")
+
+		m := New()
+		m.Get("/events/:topic", b.Handler())
+		m.Get("/events", b.Handler())
+		server := httptest.NewServer(m)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/events/news")
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+		br := bufio.NewReader(resp.Body)
+
+		events, err := readSSE(br, 1)
+		So(err, ShouldBeNil)
+		So(events[0], ShouldEqual, "id: 2|event: headline|data: line1|data: line2")
+
+		for b.Clients("news") == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		b.Publish("news", "", "fresh")
+		events, err = readSSE(br, 2)
+		So(err, ShouldBeNil)
+		So(events[0], ShouldEqual, "id: 3|data: fresh")
+		So(events[1], ShouldEqual, ": ping")
+
+		req, err := http.NewRequest("GET", server.URL+"/events?topic=news&topic=sports", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Last-Event-ID", "3")
+		resp2, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		defer resp2.Body.Close()
+		br2 := bufio.NewReader(resp2.Body)
+		for b.Clients("sports") == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		b.Publish("sports", "score", "1-0")
+		events, err = readSSE(br2, 1)
+		So(err, ShouldBeNil)
+		So(events[0], ShouldEqual, "id: 4|event: score|data: 1-0")
+
+		resp3, err := http.Get(server.URL + "/events")
+		So(err, ShouldBeNil)
+		resp3.Body.Close()
+		So(resp3.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+}
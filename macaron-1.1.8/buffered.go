@@ -0,0 +1,57 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+)
+
+// Buffered returns a middleware handler that holds the response in memory until the rest of
+// the handler chain has completed, then writes it to the client at once. It can be used globally
+// or only for some routes, e.g. m.Get("/", macaron.Buffered(), handler).
+//
+// While buffered, headers and status can still be changed after the body has been written,
+// and the body can be transformed through Context.ResponseBuffer. If a handler panics, the
+// partial response is discarded so Recovery is able to write a clean error page.
+func Buffered() Handler {
+	return func(ctx *Context) {
+		rw, ok := baseResponseWriter(ctx.Resp)
+		if !ok || rw.buffer != nil {
+			ctx.Next()
+			return
+		}
+
+		rw.startBuffer()
+		completed := false
+		defer func() {
+			if !completed {
+				rw.discardBuffer()
+			}
+		}()
+
+		ctx.Next()
+		completed = true
+		rw.flushBuffer()
+	}
+}
+
+// ResponseBuffer returns the response body written so far when the response is buffered,
+// see Buffered. It returns nil if the response is not buffered.
+func (ctx *Context) ResponseBuffer() *bytes.Buffer {
+	if rw, ok := baseResponseWriter(ctx.Resp); ok {
+		return rw.buffer
+	}
+	return nil
+}
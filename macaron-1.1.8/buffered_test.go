@@ -0,0 +1,113 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Buffered(t *testing.T) {
+	Convey("Change headers after body is written", t, func() {
+		m := New()
+		m.Use(Buffered())
+		m.Use(func(ctx *Context) {
+			ctx.Next()
+			ctx.Resp.Header().Set("X-Late", "true")
+			ctx.Resp.WriteHeader(http.StatusAccepted)
+		})
+		m.Get("/", func(ctx *Context) {
+			ctx.Resp.Write([]byte("Hello"))
+			ctx.Resp.Write([]byte(" world"))
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusAccepted)
+		So(resp.Header().Get("X-Late"), ShouldEqual, "true")
+		So(resp.Body.String(), ShouldEqual, "Hello world")
+	})
+
+	Convey("Transform buffered body", t, func() {
+		m := New()
+		m.Get("/", Buffered(), func(ctx *Context) {
+			ctx.Next()
+			buf := ctx.ResponseBuffer()
+			So(buf, ShouldNotBeNil)
+			body := bytes.ToUpper(buf.Bytes())
+			buf.Reset()
+			buf.Write(body)
+		}, func() string {
+			return "hello world"
+		})
+		m.Get("/unbuffered", func(ctx *Context) {
+			So(ctx.ResponseBuffer(), ShouldBeNil)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "HELLO WORLD")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/unbuffered", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+	})
+
+	Convey("Call before hooks when buffer is flushed", t, func() {
+		m := New()
+		m.Use(Buffered())
+		m.Get("/", func(ctx *Context) {
+			ctx.Resp.Before(func(rw ResponseWriter) {
+				rw.Header().Set("X-Size", ctx.Resp.Header().Get("X-Size")+"done")
+			})
+			ctx.Resp.Write([]byte("Hello world"))
+			So(ctx.Resp.Header().Get("X-Size"), ShouldBeBlank)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("X-Size"), ShouldEqual, "done")
+	})
+
+	Convey("Discard partial response on panic", t, func() {
+		m := New()
+		m.Use(Recovery())
+		m.Use(Buffered())
+		m.Get("/", func(ctx *Context) {
+			ctx.Resp.Write([]byte("partial"))
+			panic("here is a panic!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(strings.HasPrefix(resp.Body.String(), "partial"), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,364 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a response kept by ResponseCache.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Expires is when the response becomes stale.
+	Expires time.Time
+}
+
+// CacheStore stores cached responses for ResponseCache, so caches can be shared by multiple instances.
+type CacheStore interface {
+	// Get returns the response of given key, or false if there is none or it has expired.
+	Get(key string) (*CachedResponse, bool)
+	// Set stores the response for given key, which expires after ttl.
+	Set(key string, resp *CachedResponse, ttl time.Duration)
+	// Delete removes the response of given key.
+	Delete(key string)
+	// Keys returns keys of all responses that have not expired.
+	Keys() []string
+}
+
+type memoryCacheItem struct {
+	resp    *CachedResponse
+	expires time.Time
+}
+
+// memoryCacheStore is a CacheStore that keeps responses in memory.
+type memoryCacheStore struct {
+	lock    sync.RWMutex
+	items   map[string]memoryCacheItem
+	cleaned time.Time
+}
+
+// NewMemoryCacheStore returns a CacheStore that keeps responses in memory of current process.
+func NewMemoryCacheStore() CacheStore {
+	return &memoryCacheStore{items: make(map[string]memoryCacheItem)}
+}
+
+func (s *memoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.lock.RLock()
+	item, ok := s.items[key]
+	s.lock.RUnlock()
+	if !ok || time.Now().After(item.expires) {
+		return nil, false
+	}
+	return item.resp, true
+}
+
+func (s *memoryCacheStore) Set(key string, resp *CachedResponse, ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Clean up expired responses by the way, at most once a minute.
+	now := time.Now()
+	if now.Sub(s.cleaned) > time.Minute {
+		for k, item := range s.items {
+			if now.After(item.expires) {
+				delete(s.items, k)
+			}
+		}
+		s.cleaned = now
+	}
+	s.items[key] = memoryCacheItem{resp, now.Add(ttl)}
+}
+
+func (s *memoryCacheStore) Delete(key string) {
+	s.lock.Lock()
+	delete(s.items, key)
+	s.lock.Unlock()
+}
+
+func (s *memoryCacheStore) Keys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	now := time.Now()
+	keys := make([]string, 0, len(s.items))
+	for k, item := range s.items {
+		if !now.After(item.expires) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// ResponseCacheOptions is a struct for specifying configuration options for macaron.ResponseCache.
+type ResponseCacheOptions struct {
+	// Store keeps cached responses. Default is a store in memory.
+	Store CacheStore
+	// TTL is how long responses are fresh. Default is 1 minute.
+	TTL time.Duration
+	// StaleWhileRevalidate is how long stale responses can still be served after TTL,
+	// while they are refreshed in the background.
+	StaleWhileRevalidate time.Duration
+	// VaryHeaders is the list of request headers that responses differ by, in addition to path and query.
+	// Requests with Authorization are not cached unless it is listed, so responses of users are never shared.
+	VaryHeaders []string
+	// VaryCookies is the list of request cookies that responses differ by, in addition to path and query.
+	// Requests with cookies are not cached unless cookies that responses differ by are listed, or
+	// Cookie is listed by VaryHeaders.
+	VaryCookies []string
+}
+
+func prepareResponseCacheOptions(options []ResponseCacheOptions) ResponseCacheOptions {
+	var opt ResponseCacheOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Store == nil {
+		opt.Store = NewMemoryCacheStore()
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = time.Minute
+	}
+	return opt
+}
+
+// ResponseCache caches full responses of GET requests.
+type ResponseCache struct {
+	opt        ResponseCacheOptions
+	varyAuth   bool
+	varyCookie bool
+
+	lock         sync.Mutex
+	revalidating map[string]bool
+}
+
+// NewResponseCache creates a new response cache, whose handler can be used for some routes
+// or globally, and whose responses can be purged when the content changes, e.g.
+//
+//	cache := macaron.NewResponseCache(macaron.ResponseCacheOptions{TTL: 5 * time.Minute})
+//	m.Get("/posts/:id", cache.Handler(), showPost)
+//	m.Post("/posts/:id", func(ctx *macaron.Context) {
+//		updatePost(ctx)
+//		cache.Purge("/posts/" + ctx.Params(":id"))
+//	})
+func NewResponseCache(options ...ResponseCacheOptions) *ResponseCache {
+	c := &ResponseCache{
+		opt:          prepareResponseCacheOptions(options),
+		revalidating: make(map[string]bool),
+	}
+	c.varyCookie = len(c.opt.VaryCookies) > 0
+	for _, name := range c.opt.VaryHeaders {
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization":
+			c.varyAuth = true
+		case "Cookie":
+			c.varyCookie = true
+		}
+	}
+	return c
+}
+
+// shared returns true if the response of the request can be shared by requests of other users,
+// which is not the case for requests with credentials that the key does not vary on.
+func (c *ResponseCache) shared(req *http.Request) bool {
+	if !c.varyAuth && len(req.Header.Get("Authorization")) > 0 {
+		return false
+	}
+	return c.varyCookie || len(req.Header.Get("Cookie")) == 0
+}
+
+// key returns the key that response of the request is cached by.
+func (c *ResponseCache) key(req *http.Request) string {
+	return varyKey(req, c.opt.VaryHeaders, c.opt.VaryCookies)
+}
+
+// varyKey returns a key of the request URI, and values of given headers and cookies.
+func varyKey(req *http.Request, headers, cookies []string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(req.URL.RequestURI())
+	for _, name := range headers {
+		buf.WriteString("\n" + name + ": " + strings.Join(req.Header[http.CanonicalHeaderKey(name)], ", "))
+	}
+	for _, name := range cookies {
+		buf.WriteString("\n" + name + "=")
+		if cookie, err := req.Cookie(name); err == nil {
+			buf.WriteString(cookie.Value)
+		}
+	}
+	return buf.String()
+}
+
+// keyPath returns the path part of the key.
+func keyPath(key string) string {
+	if i := strings.IndexAny(key, "?\n"); i > -1 {
+		return key[:i]
+	}
+	return key
+}
+
+// Purge removes cached responses of paths that match the pattern, which has the syntax
+// of path.Match, e.g. "/posts/*". It returns the number of responses removed.
+func (c *ResponseCache) Purge(pattern string) int {
+	n := 0
+	for _, key := range c.opt.Store.Keys() {
+		if ok, _ := path.Match(pattern, keyPath(key)); ok {
+			c.opt.Store.Delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// cacheWriter records the response while writing it through.
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	buf    bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = cloneHeader(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.buf.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// cacheable returns true if the recorded response can be shared by other requests.
+func (w *cacheWriter) cacheable() bool {
+	if w.status != http.StatusOK || len(w.header.Get("Set-Cookie")) > 0 {
+		return false
+	}
+	cc := strings.ToLower(w.header.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+// discardWriter is a http.ResponseWriter that discards the response.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+type cacheRevalidateKey struct{}
+
+// revalidate refreshes the response of the key by serving a copy of the request in the background,
+// which goes through all handlers of the route including global middleware.
+func (c *ResponseCache) revalidate(ctx *Context, key string) {
+	c.lock.Lock()
+	if c.revalidating[key] {
+		c.lock.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.lock.Unlock()
+
+	req := ctx.Req.Clone(context.WithValue(context.Background(), cacheRevalidateKey{}, true))
+	if ctx.m.hasURLPrefix {
+		req.URL.Path = ctx.m.urlPrefix + req.URL.Path
+	}
+	m := ctx.m
+	tag := requestTag(ctx)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				m.ErrorLogger().Printf("%sPANIC in cache revalidation: %v", tag, err)
+			}
+			c.lock.Lock()
+			delete(c.revalidating, key)
+			c.lock.Unlock()
+		}()
+		m.ServeHTTP(&discardWriter{header: make(http.Header)}, req)
+	}()
+}
+
+// Handler returns a middleware handler that responds to GET requests by cached responses,
+// or caches responses of handlers after it. Requests with Authorization or cookies are passed
+// through, unless the key varies on them by options. Only responses with status 200 that do not set
+// cookies and are not marked as private or no-store by Cache-Control are cached.
+// Header X-Cache of responses is set to HIT, STALE or MISS accordingly.
+func (c *ResponseCache) Handler() Handler {
+	return func(ctx *Context) {
+		if ctx.Req.Method != "GET" || !c.shared(ctx.Req.Request) {
+			return
+		}
+
+		key := c.key(ctx.Req.Request)
+		if ctx.Req.Context().Value(cacheRevalidateKey{}) == nil {
+			if cached, ok := c.opt.Store.Get(key); ok {
+				status := "HIT"
+				if time.Now().After(cached.Expires) {
+					status = "STALE"
+					c.revalidate(ctx, key)
+				}
+
+				header := ctx.Resp.Header()
+				for k, v := range cached.Header {
+					header[k] = v
+				}
+				header.Set("X-Cache", status)
+				ctx.Resp.WriteHeader(cached.Status)
+				ctx.Resp.Write(cached.Body)
+				return
+			}
+		}
+
+		orig := ctx.Resp
+		orig.Header().Set("X-Cache", "MISS")
+		cw := &cacheWriter{ResponseWriter: orig}
+		ctx.setResponseWriter(NewResponseWriter(cw))
+		defer ctx.setResponseWriter(orig)
+
+		ctx.Next()
+
+		if !cw.cacheable() {
+			return
+		}
+		cw.header.Del("X-Cache")
+		c.opt.Store.Set(key, &CachedResponse{
+			Status:  cw.status,
+			Header:  cw.header,
+			Body:    cw.buf.Bytes(),
+			Expires: time.Now().Add(c.opt.TTL),
+		}, c.opt.TTL+c.opt.StaleWhileRevalidate)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ResponseCache(t *testing.T) {
+	serve := func(m *Macaron, url string, setup ...func(*http.Request)) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		So(err, ShouldBeNil)
+		for _, f := range setup {
+			f(req)
+		}
+		m.ServeHTTP(resp, req)
+		return resp
+	}
+
+	Convey("Cache responses", t, func() {
+		var hits int32
+		cache := NewResponseCache()
+		m := New()
+		m.Get("/posts/:id", cache.Handler(), func(ctx *Context) string {
+			n := atomic.AddInt32(&hits, 1)
+			ctx.Resp.Header().Set("X-Post", ctx.Params(":id"))
+			return fmt.Sprintf("post %s #%d", ctx.Params(":id"), n)
+		})
+		m.Get("/private", cache.Handler(), func(ctx *Context) string {
+			ctx.Resp.Header().Set("Cache-Control", "private")
+			return fmt.Sprint(atomic.AddInt32(&hits, 1))
+		})
+
+		resp := serve(m, "/posts/1")
+		So(resp.Body.String(), ShouldEqual, "post 1 #1")
+		So(resp.Header().Get("X-Cache"), ShouldEqual, "MISS")
+
+		resp = serve(m, "/posts/1")
+		So(resp.Body.String(), ShouldEqual, "post 1 #1")
+		So(resp.Header().Get("X-Cache"), ShouldEqual, "HIT")
+		So(resp.Header().Get("X-Post"), ShouldEqual, "1")
+
+		So(serve(m, "/posts/1?page=2").Body.String(), ShouldEqual, "post 1 #2")
+		So(serve(m, "/posts/2").Body.String(), ShouldEqual, "post 2 #3")
+
+		Convey("Purge responses", func() {
+			So(cache.Purge("/posts/1"), ShouldEqual, 2)
+			So(serve(m, "/posts/1").Body.String(), ShouldEqual, "post 1 #4")
+			So(serve(m, "/posts/2").Body.String(), ShouldEqual, "post 2 #3")
+
+			So(cache.Purge("/posts/*"), ShouldEqual, 2)
+			So(serve(m, "/posts/2").Body.String(), ShouldEqual, "post 2 #5")
+		})
+
+		Convey("Do not cache private responses", func() {
+			So(serve(m, "/private").Body.String(), ShouldEqual, "4")
+			So(serve(m, "/private").Body.String(), ShouldEqual, "5")
+		})
+
+		Convey("Do not cache requests with credentials", func() {
+			auth := func(req *http.Request) { req.SetBasicAuth("joe", "secret") }
+			session := func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "session", Value: "joe"}) }
+			for _, setup := range []func(*http.Request){auth, session} {
+				resp := serve(m, "/posts/1", setup)
+				So(resp.Body.String(), ShouldNotEqual, "post 1 #1")
+				So(resp.Header().Get("X-Cache"), ShouldBeBlank)
+			}
+			So(serve(m, "/posts/1").Body.String(), ShouldEqual, "post 1 #1")
+		})
+	})
+
+	Convey("Clean up expired responses at most once a minute", t, func() {
+		store := NewMemoryCacheStore().(*memoryCacheStore)
+		store.Set("/a", &CachedResponse{}, time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+		store.Set("/b", &CachedResponse{}, time.Minute)
+		So(store.items, ShouldHaveLength, 2)
+
+		store.cleaned = time.Now().Add(-2 * time.Minute)
+		store.Set("/c", &CachedResponse{}, time.Minute)
+		So(store.items, ShouldHaveLength, 2)
+		So(store.Keys(), ShouldHaveLength, 2)
+	})
+
+	Convey("Cache responses by vary keys", t, func() {
+		var hits int32
+		cache := NewResponseCache(ResponseCacheOptions{
+			VaryHeaders: []string{"Accept-Language"},
+			VaryCookies: []string{"theme"},
+		})
+		m := New()
+		m.Get("/", cache.Handler(), func() string {
+			return fmt.Sprint(atomic.AddInt32(&hits, 1))
+		})
+
+		lang := func(req *http.Request) { req.Header.Set("Accept-Language", "fr") }
+		theme := func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"}) }
+		So(serve(m, "/").Body.String(), ShouldEqual, "1")
+		So(serve(m, "/", lang).Body.String(), ShouldEqual, "2")
+		So(serve(m, "/", theme).Body.String(), ShouldEqual, "3")
+		So(serve(m, "/", lang, theme).Body.String(), ShouldEqual, "4")
+		So(serve(m, "/", lang).Body.String(), ShouldEqual, "2")
+		So(serve(m, "/").Body.String(), ShouldEqual, "1")
+	})
+
+	Convey("Serve stale responses while revalidating", t, func() {
+		var hits int32
+		revalidated := make(chan bool, 1)
+		cache := NewResponseCache(ResponseCacheOptions{
+			TTL:                  10 * time.Millisecond,
+			StaleWhileRevalidate: time.Minute,
+		})
+		m := New()
+		m.Get("/", cache.Handler(), func() string {
+			n := atomic.AddInt32(&hits, 1)
+			if n > 1 {
+				defer func() { revalidated <- true }()
+			}
+			return fmt.Sprint(n)
+		})
+
+		So(serve(m, "/").Body.String(), ShouldEqual, "1")
+		time.Sleep(20 * time.Millisecond)
+
+		resp := serve(m, "/")
+		So(resp.Body.String(), ShouldEqual, "1")
+		So(resp.Header().Get("X-Cache"), ShouldEqual, "STALE")
+
+		<-revalidated
+		for i := 0; i < 100; i++ {
+			if resp = serve(m, "/"); resp.Header().Get("X-Cache") == "HIT" {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		So(resp.Body.String(), ShouldEqual, "2")
+	})
+}
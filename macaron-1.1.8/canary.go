@@ -0,0 +1,100 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+)
+
+// CanaryOptions is a struct for specifying configuration options for the macaron.Canary middleware.
+type CanaryOptions struct {
+	// Percent is the percentage of clients routed to the canary, from 0 to 100.
+	Percent float64
+	// Header forces the request to the canary if its value is "1" or "true", or to the stable handlers
+	// if it is "0" or "false", e.g. for testing. Default is "X-Canary".
+	Header string
+	// Cookie keeps clients on the side they are assigned to. Default is "_canary".
+	Cookie string
+	// CookieMaxAge is how long the assignment lasts in seconds. Default is 1 day.
+	CookieMaxAge int
+}
+
+func prepareCanaryOptions(opt CanaryOptions) CanaryOptions {
+	// Defaults
+	if len(opt.Header) == 0 {
+		opt.Header = "X-Canary"
+	}
+	if len(opt.Cookie) == 0 {
+		opt.Cookie = "_canary"
+	}
+	if opt.CookieMaxAge <= 0 {
+		opt.CookieMaxAge = 86400
+	}
+	return opt
+}
+
+// parseCanary returns whether the value selects the canary, or false if it selects nothing.
+func parseCanary(value string) (canary, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true":
+		return true, true
+	case "0", "false":
+		return false, true
+	}
+	return false, false
+}
+
+// Canary returns a middleware handler that routes a percentage of clients to given handlers instead of
+// the handlers after it, for gradual rollouts. Clients are assigned by random at the first request and
+// stay on the same side by a cookie, unless the header forces a side. Handlers can be a mounted
+// sub-application as well, e.g.
+//
+//	v2 := macaron.New()
+//	...
+//	m.Get("/search", macaron.Canary(macaron.CanaryOptions{Percent: 5}, v2.ServeHTTP), searchV1)
+func Canary(opt CanaryOptions, handlers ...Handler) Handler {
+	opt = prepareCanaryOptions(opt)
+	validateHandlers(handlers)
+
+	return func(ctx *Context) {
+		canary, ok := parseCanary(ctx.Req.Header.Get(opt.Header))
+		if !ok {
+			if canary, ok = parseCanary(ctx.GetCookie(opt.Cookie)); !ok {
+				canary = rand.Float64()*100 < opt.Percent
+				value := "0"
+				if canary {
+					value = "1"
+				}
+				http.SetCookie(ctx.Resp, &http.Cookie{
+					Name:     opt.Cookie,
+					Value:    value,
+					Path:     "/",
+					MaxAge:   opt.CookieMaxAge,
+					HttpOnly: true,
+				})
+			}
+		}
+		if !canary {
+			return
+		}
+
+		// Replace the rest of the chain, which is owned by this request.
+		rest := make([]Handler, 0, ctx.index+1+len(handlers))
+		rest = append(rest, ctx.handlers[:ctx.index+1]...)
+		ctx.handlers = append(rest, handlers...)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Canary(t *testing.T) {
+	Convey("Route clients to canary", t, func() {
+		v2 := New()
+		v2.Get("/search", func() string { return "v2" })
+
+		serve := func(m *Macaron, header, cookie string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/search", nil)
+			So(err, ShouldBeNil)
+			if len(header) > 0 {
+				req.Header.Set("X-Canary", header)
+			}
+			if len(cookie) > 0 {
+				req.AddCookie(&http.Cookie{Name: "_canary", Value: cookie})
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		Convey("By percentage", func() {
+			for _, c := range []struct {
+				percent float64
+				body    string
+				cookie  string
+			}{
+				{0, "v1", "_canary=0"},
+				{100, "v2", "_canary=1"},
+			} {
+				m := New()
+				m.Get("/search", Canary(CanaryOptions{Percent: c.percent}, v2.ServeHTTP), func() string { return "v1" })
+				resp := serve(m, "", "")
+				So(resp.Body.String(), ShouldEqual, c.body)
+				So(resp.Header().Get("Set-Cookie"), ShouldStartWith, c.cookie+";")
+			}
+		})
+
+		Convey("By cookie and header", func() {
+			m := New()
+			m.Get("/search", Canary(CanaryOptions{Percent: 100}, func(ctx *Context) {
+				ctx.Data["Version"] = "v2"
+			}, func(ctx *Context) string {
+				return ctx.Data["Version"].(string)
+			}), func() string { return "v1" })
+
+			resp := serve(m, "", "0")
+			So(resp.Body.String(), ShouldEqual, "v1")
+			So(resp.Header().Get("Set-Cookie"), ShouldBeEmpty)
+
+			So(serve(m, "", "1").Body.String(), ShouldEqual, "v2")
+			So(serve(m, "false", "1").Body.String(), ShouldEqual, "v1")
+			resp = serve(m, "true", "0")
+			So(resp.Body.String(), ShouldEqual, "v2")
+			So(resp.Header().Get("Set-Cookie"), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,420 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/big"
+	mrand "math/rand"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of captcha challenges.
+const (
+	// CAPTCHA_DIGITS asks to type digits shown in the image.
+	CAPTCHA_DIGITS = "digits"
+	// CAPTCHA_MATH asks to solve the arithmetic shown in the image, e.g. "3+8=?".
+	CAPTCHA_MATH = "math"
+)
+
+// CaptchaStore keeps answers of captcha challenges, so they can be verified by any instance.
+type CaptchaStore interface {
+	// Set saves the answer of given challenge, which expires after ttl.
+	Set(id, answer string, ttl time.Duration) error
+	// Get returns the answer of given challenge, or false if there is none or it has expired.
+	Get(id string) (string, bool)
+	// Take returns and removes the answer of given challenge, or false if there is none or it has expired.
+	Take(id string) (string, bool)
+}
+
+type memoryCaptchaItem struct {
+	answer  string
+	expires time.Time
+}
+
+// maxMemoryCaptchas is the maximum number of answers kept by NewMemoryCaptchaStore.
+const maxMemoryCaptchas = 100000
+
+// memoryCaptchaStore is a CaptchaStore that keeps answers in memory.
+type memoryCaptchaStore struct {
+	lock    sync.Mutex
+	items   map[string]memoryCaptchaItem
+	cleaned time.Time
+	max     int
+}
+
+// NewMemoryCaptchaStore returns a CaptchaStore that keeps answers in memory of current process.
+// It keeps at most 100000 answers that have not expired, and fails to set more.
+func NewMemoryCaptchaStore() CaptchaStore {
+	return &memoryCaptchaStore{items: make(map[string]memoryCaptchaItem), max: maxMemoryCaptchas}
+}
+
+func (s *memoryCaptchaStore) Set(id, answer string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Clean up expired answers by the way, at most once a minute.
+	now := time.Now()
+	if now.Sub(s.cleaned) > time.Minute {
+		for k, item := range s.items {
+			if now.After(item.expires) {
+				delete(s.items, k)
+			}
+		}
+		s.cleaned = now
+	}
+	if _, ok := s.items[id]; !ok && len(s.items) >= s.max {
+		return errors.New("captcha: too many challenges")
+	}
+	s.items[id] = memoryCaptchaItem{answer, now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryCaptchaStore) Get(id string) (string, bool) {
+	s.lock.Lock()
+	item, ok := s.items[id]
+	s.lock.Unlock()
+	if !ok || time.Now().After(item.expires) {
+		return "", false
+	}
+	return item.answer, true
+}
+
+func (s *memoryCaptchaStore) Take(id string) (string, bool) {
+	s.lock.Lock()
+	item, ok := s.items[id]
+	delete(s.items, id)
+	s.lock.Unlock()
+	if !ok || time.Now().After(item.expires) {
+		return "", false
+	}
+	return item.answer, true
+}
+
+// CaptchaOptions is a struct for specifying configuration options for macaron.Captcha.
+type CaptchaOptions struct {
+	// Type is the type of challenges, CAPTCHA_DIGITS or CAPTCHA_MATH. Default is CAPTCHA_DIGITS.
+	Type string
+	// Store keeps answers of challenges. Default is a store in memory.
+	Store CaptchaStore
+	// URLPrefix is the path that images are served under. Default is "/captcha/".
+	URLPrefix string
+	// Length is the number of digits of CAPTCHA_DIGITS challenges. Default is 6.
+	Length int
+	// Width and Height of images. Default is 240 and 80.
+	Width  int
+	Height int
+	// TTL is how long challenges can be answered. Default is 10 minutes.
+	TTL time.Duration
+	// FieldID and FieldAnswer are names of form fields that submit the challenge and the answer.
+	// Default is "captcha_id" and "captcha".
+	FieldID     string
+	FieldAnswer string
+}
+
+func prepareCaptchaOptions(options []CaptchaOptions) CaptchaOptions {
+	var opt CaptchaOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Type) == 0 {
+		opt.Type = CAPTCHA_DIGITS
+	}
+	if opt.Store == nil {
+		opt.Store = NewMemoryCaptchaStore()
+	}
+	if len(opt.URLPrefix) == 0 {
+		opt.URLPrefix = "/captcha/"
+	}
+	if !strings.HasSuffix(opt.URLPrefix, "/") {
+		opt.URLPrefix += "/"
+	}
+	if opt.Length <= 0 {
+		opt.Length = 6
+	}
+	if opt.Width <= 0 {
+		opt.Width = 240
+	}
+	if opt.Height <= 0 {
+		opt.Height = 80
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = 10 * time.Minute
+	}
+	if len(opt.FieldID) == 0 {
+		opt.FieldID = "captcha_id"
+	}
+	if len(opt.FieldAnswer) == 0 {
+		opt.FieldAnswer = "captcha"
+	}
+	return opt
+}
+
+// captchaFont is a 5x7 bitmap font of characters in challenges.
+var captchaFont = map[byte][7]string{
+	'0': {"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	'1': {"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	'2': {"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	'3': {"11110", "00001", "00001", "01110", "00001", "00001", "11110"},
+	'4': {"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	'5': {"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	'6': {"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	'7': {"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	'8': {"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	'9': {"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+	'+': {"00000", "00100", "00100", "11111", "00100", "00100", "00000"},
+	'-': {"00000", "00000", "00000", "11111", "00000", "00000", "00000"},
+	'=': {"00000", "00000", "11111", "00000", "11111", "00000", "00000"},
+	'?': {"01110", "10001", "00001", "00010", "00100", "00000", "00100"},
+}
+
+// drawCaptcha draws the text with random jitter and noise, so it is hard for machines to read.
+func drawCaptcha(text string, width, height int, rnd *mrand.Rand) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	bg := color.NRGBA{uint8(220 + rnd.Intn(36)), uint8(220 + rnd.Intn(36)), uint8(220 + rnd.Intn(36)), 255}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+
+	// Each character takes a cell of 5x7 dots with a gap of one dot.
+	cell := width / (len(text)*6 + 2)
+	if max := height / 9; cell > max {
+		cell = max
+	}
+	if cell < 1 {
+		cell = 1
+	}
+	left := (width - len(text)*6*cell) / 2
+	for i := 0; i < len(text); i++ {
+		fg := color.NRGBA{uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), 255}
+		x0 := left + i*6*cell + rnd.Intn(cell+1) - cell/2
+		y0 := (height-7*cell)/2 + rnd.Intn(cell+1) - cell/2
+		for row, bits := range captchaFont[text[i]] {
+			// Shear rows of the character by a random slant.
+			shift := (3 - row) * rnd.Intn(cell/3+1) / 2
+			for col := 0; col < len(bits); col++ {
+				if bits[col] != '1' {
+					continue
+				}
+				for dy := 0; dy < cell; dy++ {
+					for dx := 0; dx < cell; dx++ {
+						img.Set(x0+col*cell+dx+shift, y0+row*cell+dy, fg)
+					}
+				}
+			}
+		}
+	}
+
+	// A sine curve across the text, and scattered dots.
+	fg := color.NRGBA{uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), 255}
+	amplitude, period, phase := float64(height)/5, float64(width)/(1+rnd.Float64()), rnd.Float64()*2*math.Pi
+	thickness := cell/3 + 1
+	for x := 0; x < width; x++ {
+		y := height/2 + int(amplitude*math.Sin(2*math.Pi*float64(x)/period+phase))
+		for dy := 0; dy < thickness; dy++ {
+			img.Set(x, y+dy, fg)
+		}
+	}
+	for i := 0; i < width*height/20; i++ {
+		img.Set(rnd.Intn(width), rnd.Intn(height),
+			color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255})
+	}
+	return img
+}
+
+func randomInt(n int64) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		panic("captcha: fail to generate challenge: " + err.Error())
+	}
+	return int(v.Int64())
+}
+
+// Captcha generates captcha challenges of images, and verifies answers of them,
+// to protect forms such as login and signup without external services.
+type Captcha struct {
+	opt CaptchaOptions
+
+	lock sync.Mutex
+	rnd  *mrand.Rand
+}
+
+// NewCaptcha creates a new captcha, whose handler should be used globally to serve images,
+// and whose template function puts a challenge in forms, e.g.
+//
+//	cpt := macaron.NewCaptcha()
+//	m.Use(macaron.Renderer(macaron.RenderOptions{Funcs: []template.FuncMap{cpt.FuncMap()}}))
+//	m.Use(cpt.Handler())
+//	m.Post("/signup", func(ctx *macaron.Context) {
+//		if !ctx.VerifyCaptcha(ctx.Query("captcha_id"), ctx.Query("captcha")) {
+//			...
+//		}
+//	})
+//
+// Templates put the challenge image and its form fields by {{captcha}}.
+func NewCaptcha(options ...CaptchaOptions) *Captcha {
+	opt := prepareCaptchaOptions(options)
+	if opt.Type != CAPTCHA_DIGITS && opt.Type != CAPTCHA_MATH {
+		panic("captcha: unknown type " + opt.Type)
+	}
+	return &Captcha{
+		opt: opt,
+		rnd: mrand.New(mrand.NewSource(int64(randomInt(math.MaxInt64)))),
+	}
+}
+
+// challenge returns the text shown in the image and its answer.
+func (c *Captcha) challenge() (string, string) {
+	if c.opt.Type == CAPTCHA_MATH {
+		a, b := randomInt(10), randomInt(10)
+		if randomInt(2) == 0 {
+			return fmt.Sprintf("%d+%d=?", a, b), strconv.Itoa(a + b)
+		}
+		if a < b {
+			a, b = b, a
+		}
+		return fmt.Sprintf("%d-%d=?", a, b), strconv.Itoa(a - b)
+	}
+
+	digits := make([]byte, c.opt.Length)
+	for i := range digits {
+		digits[i] = byte('0' + randomInt(10))
+	}
+	return string(digits), string(digits)
+}
+
+// text returns the text shown in the image of the answer.
+func (c *Captcha) text(answer string) string {
+	if c.opt.Type != CAPTCHA_MATH {
+		return answer
+	}
+	// Math challenges are kept with their question, e.g. "3+8=?|11".
+	return answer[:strings.IndexByte(answer, '|')]
+}
+
+// Create creates a new challenge and returns its ID.
+func (c *Captcha) Create() (string, error) {
+	id := randomHex(16)
+	text, answer := c.challenge()
+	if c.opt.Type == CAPTCHA_MATH {
+		answer = text + "|" + answer
+	}
+	return id, c.opt.Store.Set(id, answer, c.opt.TTL)
+}
+
+// URL returns the URL of the image of given challenge.
+func (c *Captcha) URL(id string) string {
+	return c.opt.URLPrefix + id + ".png"
+}
+
+// HTML creates a new challenge, and returns its image and hidden form field of its ID,
+// followed by the text field to input the answer.
+func (c *Captcha) HTML() (template.HTML, error) {
+	id, err := c.Create()
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`+
+		`<img class="captcha-img" src="%s" width="%d" height="%d" alt="captcha">`+
+		`<input class="captcha-input" type="text" name="%s" autocomplete="off" required>`,
+		template.HTMLEscapeString(c.opt.FieldID), id, template.HTMLEscapeString(c.URL(id)),
+		c.opt.Width, c.opt.Height, template.HTMLEscapeString(c.opt.FieldAnswer))), nil
+}
+
+// FuncMap returns template functions of the captcha, which includes "captcha" that is same as HTML.
+func (c *Captcha) FuncMap() template.FuncMap {
+	return template.FuncMap{"captcha": c.HTML}
+}
+
+// Verify returns true if the answer of given challenge is correct. Every challenge can be
+// verified only once, so it cannot be answered again with another guess.
+func (c *Captcha) Verify(id, answer string) bool {
+	expected, ok := c.opt.Store.Take(id)
+	if !ok {
+		return false
+	}
+	if c.opt.Type == CAPTCHA_MATH {
+		expected = expected[strings.IndexByte(expected, '|')+1:]
+	}
+	return SecureCompare(strings.TrimSpace(answer), expected)
+}
+
+// serveImage serves the image of the challenge, which is drawn anew every time
+// so that it can be refreshed until the challenge is answered.
+func (c *Captcha) serveImage(ctx *Context, id string) {
+	answer, ok := c.opt.Store.Get(id)
+	if !ok {
+		http.NotFound(ctx.Resp, ctx.Req.Request)
+		return
+	}
+
+	c.lock.Lock()
+	img := drawCaptcha(c.text(answer), c.opt.Width, c.opt.Height, c.rnd)
+	c.lock.Unlock()
+
+	buf := new(bytes.Buffer)
+	png.Encode(buf, img)
+	ctx.Resp.Header().Set(_CONTENT_TYPE, "image/png")
+	ctx.Resp.Header().Set("Cache-Control", "no-store")
+	ctx.Resp.Write(buf.Bytes())
+}
+
+// Handler returns a middleware handler that serves images of challenges under URLPrefix,
+// and maps the captcha as *Captcha for ctx.VerifyCaptcha and handlers after it.
+func (c *Captcha) Handler() Handler {
+	return Provides(func(ctx *Context) {
+		ctx.Map(c)
+		if ctx.Req.Method != "GET" && ctx.Req.Method != "HEAD" {
+			return
+		}
+		if id := strings.TrimPrefix(ctx.Req.URL.Path, c.opt.URLPrefix); len(id) < len(ctx.Req.URL.Path) &&
+			strings.HasSuffix(id, ".png") {
+			id = strings.TrimSuffix(id, ".png")
+			if _, err := hex.DecodeString(id); err == nil && len(id) > 0 {
+				c.serveImage(ctx, id)
+			} else {
+				http.NotFound(ctx.Resp, ctx.Req.Request)
+			}
+		}
+	}, (*Captcha)(nil))
+}
+
+// VerifyCaptcha returns true if the answer of given challenge is correct, by the captcha
+// whose handler serves current request. It returns false if no captcha is used.
+func (ctx *Context) VerifyCaptcha(id, answer string) bool {
+	v := ctx.GetVal(reflect.TypeOf((*Captcha)(nil)))
+	if !v.IsValid() || v.IsNil() {
+		return false
+	}
+	return v.Interface().(*Captcha).Verify(id, answer)
+}
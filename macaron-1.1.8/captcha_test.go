@@ -0,0 +1,149 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"html/template"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Captcha(t *testing.T) {
+	Convey("Protect forms by captcha", t, func() {
+		store := NewMemoryCaptchaStore()
+		cpt := NewCaptcha(CaptchaOptions{Store: store, Width: 120, Height: 40})
+		m := New()
+		m.Use(cpt.Handler())
+		m.Post("/signup", func(ctx *Context) string {
+			return strconv.FormatBool(ctx.VerifyCaptcha(ctx.Query("captcha_id"), ctx.Query("captcha")))
+		})
+
+		buf := new(bytes.Buffer)
+		tmpl := template.Must(template.New("form").Funcs(cpt.FuncMap()).Parse(`<form>{{captcha}}</form>`))
+		So(tmpl.Execute(buf, nil), ShouldBeNil)
+		match := regexp.MustCompile(`name="captcha_id" value="([0-9a-f]{32})"`).FindStringSubmatch(buf.String())
+		So(match, ShouldHaveLength, 2)
+		id := match[1]
+		So(buf.String(), ShouldContainSubstring, `src="/captcha/`+id+`.png" width="120" height="40"`)
+		So(buf.String(), ShouldContainSubstring, `name="captcha"`)
+
+		answer, ok := store.Get(id)
+		So(ok, ShouldBeTrue)
+		So(answer, ShouldHaveLength, 6)
+
+		Convey("Serve images of challenges", func() {
+			for i := 0; i < 2; i++ {
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest("GET", cpt.URL(id), nil)
+				So(err, ShouldBeNil)
+				m.ServeHTTP(resp, req)
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Header().Get("Content-Type"), ShouldEqual, "image/png")
+				img, err := png.Decode(resp.Body)
+				So(err, ShouldBeNil)
+				So(img.Bounds().Dx(), ShouldEqual, 120)
+				So(img.Bounds().Dy(), ShouldEqual, 40)
+			}
+
+			for _, path := range []string{"/captcha/ffff.png", "/captcha/nothex.png"} {
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest("GET", path, nil)
+				So(err, ShouldBeNil)
+				m.ServeHTTP(resp, req)
+				So(resp.Code, ShouldEqual, http.StatusNotFound)
+			}
+		})
+
+		Convey("Verify answers once", func() {
+			verify := func(answer string) string {
+				resp := httptest.NewRecorder()
+				form := url.Values{"captcha_id": {id}, "captcha": {answer}}
+				req, err := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+				So(err, ShouldBeNil)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				m.ServeHTTP(resp, req)
+				return resp.Body.String()
+			}
+
+			So(verify(" "+answer+" "), ShouldEqual, "true")
+			So(verify(answer), ShouldEqual, "false")
+		})
+
+		Convey("Reject wrong answers", func() {
+			So(cpt.Verify(id, "abc"), ShouldBeFalse)
+			So(cpt.Verify(id, answer), ShouldBeFalse)
+		})
+	})
+
+	Convey("Limit answers kept in memory", t, func() {
+		store := NewMemoryCaptchaStore().(*memoryCaptchaStore)
+		store.max = 2
+		So(store.Set("a", "1", time.Millisecond), ShouldBeNil)
+		So(store.Set("b", "2", time.Minute), ShouldBeNil)
+		So(store.Set("c", "3", time.Minute), ShouldNotBeNil)
+		So(store.Set("b", "4", time.Minute), ShouldBeNil)
+
+		// Expired answers are cleaned up at most once a minute.
+		time.Sleep(2 * time.Millisecond)
+		So(store.Set("c", "3", time.Minute), ShouldNotBeNil)
+		store.cleaned = time.Now().Add(-2 * time.Minute)
+		So(store.Set("c", "3", time.Minute), ShouldBeNil)
+		So(store.items, ShouldHaveLength, 2)
+	})
+
+	Convey("Create math challenges", t, func() {
+		store := NewMemoryCaptchaStore()
+		cpt := NewCaptcha(CaptchaOptions{Type: CAPTCHA_MATH, Store: store})
+		for i := 0; i < 20; i++ {
+			id, err := cpt.Create()
+			So(err, ShouldBeNil)
+			kept, ok := store.Get(id)
+			So(ok, ShouldBeTrue)
+			match := regexp.MustCompile(`^(\d)([+-])(\d)=\?\|(\d+)$`).FindStringSubmatch(kept)
+			So(match, ShouldHaveLength, 5)
+			a, _ := strconv.Atoi(match[1])
+			b, _ := strconv.Atoi(match[3])
+			want := a + b
+			if match[2] == "-" {
+				want = a - b
+			}
+			So(want, ShouldBeGreaterThanOrEqualTo, 0)
+			So(cpt.text(kept), ShouldEqual, match[1]+match[2]+match[3]+"=?")
+			So(cpt.Verify(id, strconv.Itoa(want)), ShouldBeTrue)
+		}
+	})
+
+	Convey("Verify without captcha", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) string {
+			return strconv.FormatBool(ctx.VerifyCaptcha("id", "answer"))
+		})
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "false")
+	})
+}
@@ -0,0 +1,207 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/go-macaron/inject"
+)
+
+// Types of services that are mapped for every request.
+var requestServiceTypes = []reflect.Type{
+	contextType,
+	reflect.TypeOf((*http.ResponseWriter)(nil)).Elem(),
+	reflect.TypeOf((*http.Request)(nil)),
+}
+
+var handlerProvides = struct {
+	sync.RWMutex
+	m map[uintptr][]reflect.Type
+}{m: make(map[uintptr][]reflect.Type)}
+
+// Provides annotates a middleware handler with services it maps for the request,
+// so Check knows they are available to handlers after it. Each service is given
+// as a value of its type, or a nil pointer to an interface type as MapTo does, e.g.
+//
+//	m.Use(macaron.Provides(auth.Middleware(), (*auth.User)(nil), (*auth.Store)(nil)))
+//
+// The handler itself is returned unchanged.
+func Provides(handler Handler, services ...interface{}) Handler {
+	validateHandler(handler)
+	types := make([]reflect.Type, len(services))
+	for i, s := range services {
+		types[i] = serviceTypeOf(s)
+	}
+
+	ptr := reflect.ValueOf(handler).Pointer()
+	handlerProvides.Lock()
+	handlerProvides.m[ptr] = append(handlerProvides.m[ptr], types...)
+	handlerProvides.Unlock()
+	return handler
+}
+
+// serviceTypeOf returns type of the service that the value is mapped as.
+func serviceTypeOf(val interface{}) reflect.Type {
+	t := reflect.TypeOf(val)
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		return inject.InterfaceOf(val)
+	}
+	return t
+}
+
+// providesOf returns types of services that the handler is annotated to map.
+func providesOf(h Handler) []reflect.Type {
+	handlerProvides.RLock()
+	defer handlerProvides.RUnlock()
+	return handlerProvides.m[reflect.ValueOf(h).Pointer()]
+}
+
+// handlerName returns the name of function of the handler.
+func handlerName(h Handler) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(h).Pointer()); f != nil {
+		return f.Name()
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// serviceSet is a set of types of available services.
+type serviceSet map[reflect.Type]bool
+
+func (s serviceSet) add(types ...reflect.Type) {
+	for _, t := range types {
+		s[t] = true
+	}
+}
+
+func (s serviceSet) copy() serviceSet {
+	c := make(serviceSet, len(s))
+	for t := range s {
+		c[t] = true
+	}
+	return c
+}
+
+// has returns true if a service of given type is available,
+// interface types are satisfied by any service that implements them.
+func (s serviceSet) has(t reflect.Type) bool {
+	if s[t] {
+		return true
+	}
+	if t.Kind() == reflect.Interface {
+		for st := range s {
+			if st.Implements(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// missingTypes returns types of arguments of the handler that are not available.
+func (s serviceSet) missingTypes(h Handler) []reflect.Type {
+	var missing []reflect.Type
+	plan := planOf(reflect.TypeOf(h))
+	for i, t := range plan.args {
+		if fields, ok := plan.paramFields[i]; ok {
+			for _, f := range fields {
+				// Named services are not checked as they are often mapped by middleware.
+				if !f.optional && len(f.name) == 0 && !s.has(f.typ) {
+					missing = append(missing, f.typ)
+				}
+			}
+			continue
+		}
+		if !s.has(t) {
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
+
+// globalServices returns the set of global services and services of every request.
+func (m *Macaron) globalServices() serviceSet {
+	services := make(serviceSet)
+	services.add(requestServiceTypes...)
+	services.add(m.mapped...)
+	for t := range m.providers {
+		services.add(t)
+	}
+	if m.constructors != nil {
+		for t := range m.constructors.funcs {
+			services.add(t)
+		}
+	}
+	return services
+}
+
+// checkChain checks arguments of handlers in the chain are satisfied by given services
+// and services annotated to be mapped by handlers before them.
+func checkChain(route string, services serviceSet, handlers []Handler) []error {
+	var errs []error
+	services = services.copy()
+	for _, h := range handlers {
+		for _, t := range services.missingTypes(h) {
+			errs = append(errs, fmt.Errorf("%s: handler %s requires service %v, which is not mapped", route, handlerName(h), t))
+		}
+		services.add(providesOf(h)...)
+	}
+	return errs
+}
+
+// Check verifies that arguments of every handler can be injected, by services mapped
+// globally, lazy providers and constructors, and services that middleware before the
+// handler is annotated to map, see Provides. It is meant to be called after all routes
+// have been registered, so that missing services can fail the startup with a clear
+// message instead of failing the first live request, e.g.
+//
+//	if errs := m.Check(); len(errs) > 0 {
+//		log.Fatal(errs)
+//	}
+func (m *Macaron) Check() []error {
+	services := m.globalServices()
+	var errs []error
+
+	if m.constructors != nil {
+		globals := make(serviceSet)
+		globals.add(m.mapped...)
+		for t := range m.constructors.funcs {
+			globals.add(t)
+		}
+		for t, constructor := range m.constructors.funcs {
+			for _, argType := range globals.missingTypes(constructor) {
+				errs = append(errs, fmt.Errorf("constructor of %v requires service %v, which is not mapped", t, argType))
+			}
+		}
+	}
+
+	for _, r := range m.routes {
+		handlers := make([]Handler, 0, len(m.handlers)+len(r.handlers))
+		handlers = append(handlers, m.handlers...)
+		handlers = append(handlers, r.handlers...)
+		errs = append(errs, checkChain(r.method+" "+r.pattern, services, handlers)...)
+	}
+	if len(m.notFoundHandlers) > 0 {
+		handlers := make([]Handler, 0, len(m.handlers)+len(m.notFoundHandlers))
+		handlers = append(handlers, m.handlers...)
+		handlers = append(handlers, m.notFoundHandlers...)
+		errs = append(errs, checkChain("NotFound", services, handlers)...)
+	}
+	return errs
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type checkUser struct{}
+
+type checkStore interface {
+	Get(string) string
+}
+
+type checkMemoryStore struct{}
+
+func (checkMemoryStore) Get(string) string { return "" }
+
+func checkAuth(ctx *Context) {
+	ctx.Map(&checkUser{})
+}
+
+func Test_Check(t *testing.T) {
+	Convey("Check satisfiable handlers", t, func() {
+		m := Classic()
+		m.Use(Renderer())
+		m.Use(Provides(checkAuth, &checkUser{}))
+		m.MapTo(checkMemoryStore{}, (*checkStore)(nil))
+		m.Provide(func() *provideSession { return nil })
+		m.Construct(func(*log.Logger) *constructDB { return nil })
+		m.Get("/", func(ctx *Context, rw http.ResponseWriter, req *http.Request, r Render, l *log.Logger) {})
+		m.Get("/user", func(*checkUser, checkStore, *provideSession, *constructDB) {})
+		m.Get("/params", func(p struct {
+			In
+			User   *checkUser
+			Tenant *provideTenant `inject:"optional"`
+			DB     *namedDB       `name:"replica"`
+		}) {
+		})
+		m.NotFound(func(Render) {})
+
+		So(m.Check(), ShouldBeEmpty)
+	})
+
+	Convey("Report unsatisfiable handlers", t, func() {
+		m := New()
+		m.Get("/", func(*checkUser) {}, Provides(checkAuth, &checkUser{}))
+		m.Group("/api", func() {
+			m.Post("/users", func(p struct {
+				In
+				Tenant *provideTenant
+			}) {
+			})
+		})
+		m.Construct(func(*Context) *constructDB { return nil })
+
+		errs := m.Check()
+		So(errs, ShouldHaveLength, 3)
+		msgs := make([]string, len(errs))
+		for i := range errs {
+			msgs[i] = errs[i].Error()
+		}
+		sort.Strings(msgs)
+		So(strings.HasPrefix(msgs[0], "GET /: handler "), ShouldBeTrue)
+		So(strings.HasSuffix(msgs[0], " requires service *macaron.checkUser, which is not mapped"), ShouldBeTrue)
+		So(strings.HasPrefix(msgs[1], "POST /api/users: handler "), ShouldBeTrue)
+		So(strings.HasSuffix(msgs[1], " requires service *macaron.provideTenant, which is not mapped"), ShouldBeTrue)
+		So(msgs[2], ShouldEqual, "constructor of *macaron.constructDB requires service *macaron.Context, which is not mapped")
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"sync"
+)
+
+// CoalesceOptions is a struct for specifying configuration options for the macaron.Coalesce middleware.
+type CoalesceOptions struct {
+	// VaryHeaders is the list of request headers that responses differ by, in addition to path and query.
+	// Default is Accept, Accept-Encoding, Accept-Language, Authorization and Cookie, so that responses
+	// of different users are never shared.
+	VaryHeaders []string
+}
+
+func prepareCoalesceOptions(options []CoalesceOptions) CoalesceOptions {
+	var opt CoalesceOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.VaryHeaders == nil {
+		opt.VaryHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+	}
+	return opt
+}
+
+// coalesceCall is a request being served, whose response is shared by identical requests.
+type coalesceCall struct {
+	done chan struct{}
+	// shared is true if the response has been recorded and can be shared.
+	shared bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Coalesce returns a middleware handler that merges concurrent identical GET requests, so only the first
+// one runs handlers after it, and the others wait and receive the same response. Responses that set
+// cookies are not shared, nor are those of handlers that panic or write nothing; waiting requests run
+// handlers by themselves in these cases. It can be used for some routes or globally.
+func Coalesce(options ...CoalesceOptions) Handler {
+	opt := prepareCoalesceOptions(options)
+	var lock sync.Mutex
+	calls := make(map[string]*coalesceCall)
+
+	return func(ctx *Context) {
+		if ctx.Req.Method != "GET" {
+			return
+		}
+
+		key := varyKey(ctx.Req.Request, opt.VaryHeaders, nil)
+		lock.Lock()
+		if c, ok := calls[key]; ok {
+			lock.Unlock()
+			select {
+			case <-c.done:
+			case <-ctx.Req.Context().Done():
+				return
+			}
+			if !c.shared {
+				ctx.Next()
+				return
+			}
+
+			header := ctx.Resp.Header()
+			for k, v := range c.header {
+				header[k] = append([]string(nil), v...)
+			}
+			ctx.Resp.WriteHeader(c.status)
+			ctx.Resp.Write(c.body)
+			return
+		}
+		c := &coalesceCall{done: make(chan struct{})}
+		calls[key] = c
+		lock.Unlock()
+
+		defer func() {
+			lock.Lock()
+			delete(calls, key)
+			lock.Unlock()
+			close(c.done)
+		}()
+
+		orig := ctx.Resp
+		cw := &cacheWriter{ResponseWriter: orig}
+		ctx.setResponseWriter(NewResponseWriter(cw))
+		defer ctx.setResponseWriter(orig)
+
+		ctx.Next()
+
+		if cw.status != 0 && len(cw.header.Get("Set-Cookie")) == 0 {
+			c.shared = true
+			c.status = cw.status
+			c.header = cw.header
+			c.body = cw.buf.Bytes()
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Coalesce(t *testing.T) {
+	Convey("Merge concurrent identical requests", t, func() {
+		var calls int32
+		started := make(chan bool, 10)
+		release := make(chan bool)
+		m := New()
+		m.Use(Coalesce())
+		m.Get("/report", func(ctx *Context) {
+			n := atomic.AddInt32(&calls, 1)
+			started <- true
+			<-release
+			if ctx.Query("cookie") == "1" {
+				ctx.SetCookie("seen", "1")
+			}
+			ctx.Resp.Header().Set("X-Call", strconv.Itoa(int(n)))
+			ctx.Resp.WriteHeader(http.StatusAccepted)
+			ctx.Resp.Write([]byte("report"))
+		})
+
+		// serve serves requests concurrently, and returns after the first one has started.
+		serve := func(urls ...string) []chan *httptest.ResponseRecorder {
+			results := make([]chan *httptest.ResponseRecorder, len(urls))
+			for i, url := range urls {
+				results[i] = make(chan *httptest.ResponseRecorder, 1)
+				go func(url string, result chan *httptest.ResponseRecorder) {
+					resp := httptest.NewRecorder()
+					req, _ := http.NewRequest("GET", url, nil)
+					m.ServeHTTP(resp, req)
+					result <- resp
+				}(url, results[i])
+				if i == 0 {
+					<-started
+				}
+			}
+			return results
+		}
+
+		Convey("Share the response", func() {
+			results := serve("/report", "/report", "/report")
+			// Give the others time to start waiting.
+			time.Sleep(20 * time.Millisecond)
+			release <- true
+
+			for _, result := range results {
+				resp := <-result
+				So(resp.Code, ShouldEqual, http.StatusAccepted)
+				So(resp.Body.String(), ShouldEqual, "report")
+				So(resp.Header().Get("X-Call"), ShouldEqual, "1")
+			}
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+
+		Convey("Do not merge different requests", func() {
+			results := serve("/report?page=1", "/report?page=2")
+			<-started
+			release <- true
+			release <- true
+			So((<-results[0]).Body.String(), ShouldEqual, "report")
+			So((<-results[1]).Body.String(), ShouldEqual, "report")
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+
+		Convey("Do not share responses that set cookies", func() {
+			results := serve("/report?cookie=1", "/report?cookie=1")
+			time.Sleep(20 * time.Millisecond)
+			release <- true
+			<-started
+			release <- true
+			So((<-results[0]).Header().Get("X-Call"), ShouldEqual, "1")
+			So((<-results[1]).Header().Get("X-Call"), ShouldEqual, "2")
+		})
+	})
+}
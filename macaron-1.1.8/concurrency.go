@@ -0,0 +1,99 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyLimitOptions is a struct for specifying configuration options for the macaron.ConcurrencyLimit middleware.
+type ConcurrencyLimitOptions struct {
+	// MaxQueue is the maximum number of requests waiting for others to finish,
+	// requests beyond it are rejected immediately. Default is 0, i.e. no waiting.
+	MaxQueue int
+	// QueueTimeout is how long a request can wait before it is rejected. Default is 1 second.
+	QueueTimeout time.Duration
+	// RetryAfter is the value of Retry-After header of rejected requests. Default is 1 second.
+	RetryAfter time.Duration
+}
+
+func prepareConcurrencyLimitOptions(options []ConcurrencyLimitOptions) ConcurrencyLimitOptions {
+	var opt ConcurrencyLimitOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.QueueTimeout <= 0 {
+		opt.QueueTimeout = time.Second
+	}
+	if opt.RetryAfter <= 0 {
+		opt.RetryAfter = time.Second
+	}
+	return opt
+}
+
+// ConcurrencyLimit returns a middleware handler that allows at most max requests to be served
+// by handlers after it at the same time. Excess requests wait in a queue if configured, and are
+// rejected with 503 Service Unavailable and Retry-After header when the queue is full or they
+// time out. Every call creates a separate limit, so it can be used globally and for some routes.
+func ConcurrencyLimit(max int, options ...ConcurrencyLimitOptions) Handler {
+	if max <= 0 {
+		panic("ConcurrencyLimit: max must be positive")
+	}
+	opt := prepareConcurrencyLimitOptions(options)
+	retryAfter := strconv.Itoa(int((opt.RetryAfter + time.Second - 1) / time.Second))
+	slots := make(chan struct{}, max)
+	var queued int64
+
+	shed := func(ctx *Context) {
+		ctx.Resp.Header().Set("Retry-After", retryAfter)
+		writeErrorPage(ctx, ctx.Resp, http.StatusServiceUnavailable, "")
+	}
+
+	return func(ctx *Context) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if atomic.AddInt64(&queued, 1) > int64(opt.MaxQueue) {
+				atomic.AddInt64(&queued, -1)
+				shed(ctx)
+				return
+			}
+
+			timer := time.NewTimer(opt.QueueTimeout)
+			select {
+			case slots <- struct{}{}:
+				timer.Stop()
+				atomic.AddInt64(&queued, -1)
+			case <-timer.C:
+				atomic.AddInt64(&queued, -1)
+				shed(ctx)
+				return
+			case <-ctx.Req.Context().Done():
+				timer.Stop()
+				atomic.AddInt64(&queued, -1)
+				shed(ctx)
+				return
+			}
+		}
+		defer func() { <-slots }()
+
+		ctx.Next()
+	}
+}
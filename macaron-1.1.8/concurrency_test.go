@@ -0,0 +1,125 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ConcurrencyLimit(t *testing.T) {
+	Convey("Limit concurrent requests", t, func() {
+		started := make(chan bool)
+		release := make(chan bool)
+		slow := func() string {
+			started <- true
+			<-release
+			return "done"
+		}
+		serve := func(m *Macaron) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+		// occupy serves a request in the background, and returns after the handler starts.
+		occupy := func(m *Macaron) <-chan *httptest.ResponseRecorder {
+			done := make(chan *httptest.ResponseRecorder, 1)
+			go func() {
+				resp := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/", nil)
+				m.ServeHTTP(resp, req)
+				done <- resp
+			}()
+			<-started
+			return done
+		}
+
+		Convey("Reject requests when there is no queue", func() {
+			m := New()
+			m.Get("/", ConcurrencyLimit(1, ConcurrencyLimitOptions{RetryAfter: 1500 * time.Millisecond}), slow)
+
+			done := occupy(m)
+			resp := serve(m)
+			So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Header().Get("Retry-After"), ShouldEqual, "2")
+
+			release <- true
+			So((<-done).Body.String(), ShouldEqual, "done")
+		})
+
+		Convey("Reject queued requests after timeout", func() {
+			m := New()
+			m.Get("/", ConcurrencyLimit(1, ConcurrencyLimitOptions{
+				MaxQueue:     1,
+				QueueTimeout: 10 * time.Millisecond,
+			}), slow)
+
+			done := occupy(m)
+			start := time.Now()
+			resp := serve(m)
+			So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Header().Get("Retry-After"), ShouldEqual, "1")
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+
+			release <- true
+			So((<-done).Body.String(), ShouldEqual, "done")
+		})
+
+		Convey("Serve queued requests when others finish", func() {
+			m := New()
+			m.Get("/", ConcurrencyLimit(1, ConcurrencyLimitOptions{
+				MaxQueue:     1,
+				QueueTimeout: time.Minute,
+			}), slow)
+
+			first := occupy(m)
+			second := make(chan *httptest.ResponseRecorder, 1)
+			go func() {
+				resp := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/", nil)
+				m.ServeHTTP(resp, req)
+				second <- resp
+			}()
+
+			release <- true
+			So((<-first).Body.String(), ShouldEqual, "done")
+			<-started
+			release <- true
+			So((<-second).Body.String(), ShouldEqual, "done")
+		})
+
+		Convey("Limit routes separately", func() {
+			m := New()
+			m.Get("/", ConcurrencyLimit(1), slow)
+			m.Get("/fast", ConcurrencyLimit(1), func() string { return "fast" })
+
+			done := occupy(m)
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/fast", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "fast")
+
+			release <- true
+			So((<-done).Body.String(), ShouldEqual, "done")
+		})
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetETag sets ETag header of the response, the value is quoted if it is not,
+// and weak tags should be given with prefix, e.g. `W/"v1"`.
+func (ctx *Context) SetETag(etag string) {
+	if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	ctx.Resp.Header().Set("ETag", etag)
+}
+
+// SetLastModified sets Last-Modified header of the response.
+func (ctx *Context) SetLastModified(t time.Time) {
+	ctx.Resp.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// parseHTTPTime returns the time of the header, or false if it is absent or invalid.
+func parseHTTPTime(value string) (time.Time, bool) {
+	if len(value) == 0 {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
+
+// CheckPreconditions evaluates conditional headers of the request, i.e. If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since, against ETag and Last-Modified headers of the response set by
+// SetETag and SetLastModified, in the order defined by RFC 7232. It responds with 304 Not Modified
+// to GET and HEAD requests whose cached representation is still valid, or 412 Precondition Failed
+// when a precondition fails, and returns false in both cases so the handler should return, e.g.
+//
+//	m.Put("/posts/:id", func(ctx *macaron.Context) {
+//		post := getPost(ctx.ParamsInt64(":id"))
+//		ctx.SetETag(post.Version)
+//		if !ctx.CheckPreconditions() {
+//			return
+//		}
+//		updatePost(post, ctx)
+//	})
+func (ctx *Context) CheckPreconditions() bool {
+	req := ctx.Req.Request
+	header := ctx.Resp.Header()
+	etag := header.Get("ETag")
+	lastModified, hasLastModified := parseHTTPTime(header.Get("Last-Modified"))
+	isRead := req.Method == "GET" || req.Method == "HEAD"
+
+	// If-Unmodified-Since is ignored when If-Match is present.
+	if ifMatch := req.Header.Get("If-Match"); len(ifMatch) > 0 {
+		if !matchETag(ifMatch, etag, true) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusPreconditionFailed, "")
+			return false
+		}
+	} else if t, ok := parseHTTPTime(req.Header.Get("If-Unmodified-Since")); ok && hasLastModified {
+		if lastModified.After(t) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusPreconditionFailed, "")
+			return false
+		}
+	}
+
+	// If-Modified-Since is ignored when If-None-Match is present.
+	if ifNoneMatch := req.Header.Get("If-None-Match"); len(ifNoneMatch) > 0 {
+		if matchETag(ifNoneMatch, etag, false) {
+			if isRead {
+				ctx.writeNotModified()
+			} else {
+				writeErrorPage(ctx, ctx.Resp, http.StatusPreconditionFailed, "")
+			}
+			return false
+		}
+	} else if t, ok := parseHTTPTime(req.Header.Get("If-Modified-Since")); ok && hasLastModified && isRead {
+		if !lastModified.After(t) {
+			ctx.writeNotModified()
+			return false
+		}
+	}
+	return true
+}
+
+func (ctx *Context) writeNotModified() {
+	header := ctx.Resp.Header()
+	header.Del(_CONTENT_TYPE)
+	header.Del("Content-Length")
+	ctx.Resp.WriteHeader(http.StatusNotModified)
+}
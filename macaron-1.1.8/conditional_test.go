@@ -0,0 +1,88 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Context_CheckPreconditions(t *testing.T) {
+	Convey("Check preconditions of requests", t, func() {
+		modified := time.Date(2016, 5, 1, 8, 0, 0, 0, time.UTC)
+		m := New()
+		m.Any("/", func(ctx *Context) {
+			ctx.SetETag("v2")
+			ctx.SetLastModified(modified)
+			ctx.Resp.Header().Set(_CONTENT_TYPE, "text/plain")
+			if !ctx.CheckPreconditions() {
+				return
+			}
+			ctx.Resp.Write([]byte("post"))
+		})
+
+		serve := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, "/", nil)
+			So(err, ShouldBeNil)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+		before := modified.Add(-time.Hour).Format(http.TimeFormat)
+		after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+		resp := serve("GET", nil)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("ETag"), ShouldEqual, `"v2"`)
+		So(resp.Header().Get("Last-Modified"), ShouldEqual, "Sun, 01 May 2016 08:00:00 GMT")
+
+		Convey("Respond with 304 to reads not modified", func() {
+			resp := serve("GET", map[string]string{"If-None-Match": `W/"v2"`})
+			So(resp.Code, ShouldEqual, http.StatusNotModified)
+			So(resp.Body.Len(), ShouldEqual, 0)
+			So(resp.Header().Get(_CONTENT_TYPE), ShouldBeEmpty)
+			So(resp.Header().Get("ETag"), ShouldEqual, `"v2"`)
+
+			So(serve("HEAD", map[string]string{"If-Modified-Since": after}).Code, ShouldEqual, http.StatusNotModified)
+			So(serve("GET", map[string]string{"If-Modified-Since": before}).Code, ShouldEqual, http.StatusOK)
+			// If-Modified-Since is ignored when If-None-Match is present.
+			So(serve("GET", map[string]string{
+				"If-None-Match":     `"v1"`,
+				"If-Modified-Since": after,
+			}).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Respond with 412 to writes that fail preconditions", func() {
+			So(serve("PUT", map[string]string{"If-Match": `"v1"`}).Code, ShouldEqual, http.StatusPreconditionFailed)
+			So(serve("PUT", map[string]string{"If-Match": `W/"v2"`}).Code, ShouldEqual, http.StatusPreconditionFailed)
+			So(serve("PUT", map[string]string{"If-Match": `"v1", "v2"`}).Code, ShouldEqual, http.StatusOK)
+			So(serve("PUT", map[string]string{"If-Match": `*`}).Code, ShouldEqual, http.StatusOK)
+
+			So(serve("PUT", map[string]string{"If-Unmodified-Since": before}).Code, ShouldEqual, http.StatusPreconditionFailed)
+			So(serve("PUT", map[string]string{"If-Unmodified-Since": after}).Code, ShouldEqual, http.StatusOK)
+
+			So(serve("PUT", map[string]string{"If-None-Match": `*`}).Code, ShouldEqual, http.StatusPreconditionFailed)
+			// If-Modified-Since only applies to reads.
+			So(serve("PUT", map[string]string{"If-Modified-Since": after}).Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
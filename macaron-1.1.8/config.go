@@ -0,0 +1,1359 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// configLoaders load configuration files of formats other than INI by their extensions.
+// Loaded values are strings, nil, and slices and maps of them.
+var configLoaders = map[string]func([]byte) (map[string]interface{}, error){
+	".json": loadJSONConfig,
+	".yaml": loadYAMLConfig,
+	".yml":  loadYAMLConfig,
+	".toml": loadTOMLConfig,
+	".env":  loadEnvConfig,
+}
+
+// configLoader returns the loader of the source if it is a file name with a known extension.
+func configLoader(source interface{}) func([]byte) (map[string]interface{}, error) {
+	name, ok := source.(string)
+	if !ok {
+		return nil
+	}
+	// Dotenv files of environments are named like ".env.production".
+	if base := filepath.Base(name); strings.HasPrefix(base, ".env.") {
+		return loadEnvConfig
+	}
+	return configLoaders[strings.ToLower(filepath.Ext(name))]
+}
+
+// configOverlay returns the name of file that overlays the configuration file in the environment,
+// e.g. "conf/app.production.ini" for "conf/app.ini", or ".env.production" for ".env".
+func configOverlay(name, env string) string {
+	ext := filepath.Ext(name)
+	if len(ext) == len(filepath.Base(name)) {
+		return name + "." + env
+	}
+	return strings.TrimSuffix(name, ext) + "." + env + ext
+}
+
+// withConfigOverlays returns sources with the overlay of every file in current environment inserted
+// after it, if the overlay exists and is not one of sources.
+func withConfigOverlays(sources []interface{}) []interface{} {
+	env := safeEnv()
+	given := make(map[string]bool)
+	for _, source := range sources {
+		if name, ok := source.(string); ok {
+			given[filepath.Clean(name)] = true
+		}
+	}
+
+	expanded := make([]interface{}, 0, len(sources))
+	for _, source := range sources {
+		expanded = append(expanded, source)
+		name, ok := source.(string)
+		if !ok || len(env) == 0 {
+			continue
+		}
+		overlay := configOverlay(name, env)
+		if _, err := os.Stat(overlay); err == nil && !given[filepath.Clean(overlay)] {
+			expanded = append(expanded, overlay)
+		}
+	}
+	return expanded
+}
+
+// ConfigEnvPrefix is the prefix of environment variables that override configuration values,
+// which are named by the section and key separated by "__", e.g. MACARON__DATABASE__HOST
+// overrides key "host" of section "database", MACARON__DATABASE__REPLICA__HOST overrides
+// that of section "database.replica", and MACARON__APP_NAME overrides that of the default section.
+var ConfigEnvPrefix = "MACARON__"
+
+// applyConfigEnv overrides values of the file by environment variables with ConfigEnvPrefix.
+// Sections and keys are matched case-insensitively, missing ones are created in lower case.
+func applyConfigEnv(f *ini.File, environ []string) error {
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, ConfigEnvPrefix) {
+			continue
+		}
+		kv = kv[len(ConfigEnvPrefix):]
+		i := strings.IndexByte(kv, '=')
+		if i == -1 {
+			continue
+		}
+		parts := strings.Split(kv[:i], "__")
+		valid := true
+		for _, part := range parts {
+			valid = valid && len(part) > 0
+		}
+		if !valid {
+			continue
+		}
+		section, key := ini.DEFAULT_SECTION, parts[len(parts)-1]
+		if len(parts) > 1 {
+			section = strings.ToLower(strings.Join(parts[:len(parts)-1], "."))
+		}
+
+		for _, sec := range f.Sections() {
+			if strings.EqualFold(sec.Name(), section) {
+				section = sec.Name()
+				break
+			}
+		}
+		key = strings.ToLower(key)
+		if sec, err := f.GetSection(section); err == nil {
+			for _, name := range sec.KeyStrings() {
+				if strings.EqualFold(name, key) {
+					key = name
+					break
+				}
+			}
+		}
+		if err := setConfigValue(f, section, key, kv[i+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadConfig loads configuration from sources along with their overlays of current environment.
+// Values are overridden in the order of: each file, its overlay, later sources, and environment
+// variables with ConfigEnvPrefix. Placeholders of secrets are resolved at last by secret sources.
+func loadConfig(sources []interface{}) (f *ini.File, err error) {
+	var secrets []SecretSource
+	defer func() {
+		if err == nil {
+			err = applyConfigEnv(f, os.Environ())
+		}
+		if err == nil && len(secrets) > 0 {
+			err = resolveConfigSecrets(f, secrets)
+		}
+	}()
+
+	configSources := make([]interface{}, 0, len(sources))
+	for _, source := range sources {
+		if secret, ok := source.(SecretSource); ok {
+			secrets = append(secrets, secret)
+		} else {
+			configSources = append(configSources, source)
+		}
+	}
+	sources = withConfigOverlays(configSources)
+	hasOthers := len(sources) == 0
+	for _, source := range sources {
+		if _, ok := source.(ConfigSource); ok || configLoader(source) != nil {
+			hasOthers = true
+			break
+		}
+	}
+	// Sources are loaded by ini.Load as they were if none of them has other formats.
+	if !hasOthers {
+		return ini.Load(sources[0], sources[1:]...)
+	}
+
+	f = ini.Empty()
+	for _, source := range sources {
+		if src, ok := source.(ConfigSource); ok {
+			tree, err := src.Load()
+			if err == nil {
+				err = flattenConfig(f, ini.DEFAULT_SECTION, tree)
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		load := configLoader(source)
+		if load == nil {
+			src, err := ini.Load(source)
+			if err != nil {
+				return nil, err
+			}
+			for _, sec := range src.Sections() {
+				for _, key := range sec.Keys() {
+					if err = setConfigValue(f, sec.Name(), key.Name(), key.Value()); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+
+		name := source.(string)
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := load(data)
+		if err == nil {
+			err = flattenConfig(f, ini.DEFAULT_SECTION, tree)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return f, nil
+}
+
+func setConfigValue(f *ini.File, section, key, value string) error {
+	sec, err := f.NewSection(section)
+	if err == nil {
+		_, err = sec.NewKey(key, value)
+	}
+	return err
+}
+
+// flattenConfig sets values of the tree into sections of the file. Keys of nested maps are sections
+// named by their paths joined with ".", e.g. "database.replica", arrays of scalars are joined with ",",
+// and arrays of maps are sections suffixed by their indexes, e.g. "servers.0".
+func flattenConfig(f *ini.File, section string, tree map[string]interface{}) error {
+	child := func(key string) string {
+		if section == ini.DEFAULT_SECTION {
+			return key
+		}
+		return section + "." + key
+	}
+
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := tree[k].(type) {
+		case nil:
+			if err := setConfigValue(f, section, k, ""); err != nil {
+				return err
+			}
+		case string:
+			if err := setConfigValue(f, section, k, v); err != nil {
+				return err
+			}
+		case map[string]interface{}:
+			if err := flattenConfig(f, child(k), v); err != nil {
+				return err
+			}
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for i, item := range v {
+				switch item := item.(type) {
+				case nil:
+					values = append(values, "")
+				case string:
+					values = append(values, item)
+				case map[string]interface{}:
+					if err := flattenConfig(f, child(k)+"."+strconv.Itoa(i), item); err != nil {
+						return err
+					}
+				default:
+					return fmt.Errorf("unsupported value in array %q", child(k))
+				}
+			}
+			if len(values) > 0 && len(values) < len(v) {
+				return fmt.Errorf("array %q mixes maps with other values", child(k))
+			}
+			if len(values) > 0 || len(v) == 0 {
+				if err := setConfigValue(f, section, k, strings.Join(values, ",")); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported value of %q", child(k))
+		}
+	}
+	return nil
+}
+
+// normalizeJSON converts numbers and booleans decoded from JSON into strings.
+func normalizeJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeJSON(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalizeJSON(v[k])
+		}
+	}
+	return v
+}
+
+func loadJSONConfig(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree map[string]interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return normalizeJSON(tree).(map[string]interface{}), nil
+}
+
+// loadEnvConfig loads KEY=VALUE lines of .env files into the default section. Lines can start with
+// "export", values can be quoted by single quotes as they are, or by double quotes with escapes.
+func loadEnvConfig(data []byte) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		i := strings.IndexByte(line, '=')
+		if i < 1 {
+			return nil, fmt.Errorf("line %d: invalid syntax", n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value, '"')
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated string", n)
+			}
+			s, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			value = s
+		case strings.HasPrefix(value, "'"):
+			end := closingQuote(value, '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated string", n)
+			}
+			value = value[1:end]
+		default:
+			if i := strings.Index(value, " #"); i > -1 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		tree[key] = value
+	}
+	return tree, scanner.Err()
+}
+
+// closingQuote returns the index of the quote that closes the string starting at s[0], skipping ones
+// escaped by backslashes in double-quoted strings, or doubled in single-quoted strings of YAML,
+// or -1 if there is none.
+func closingQuote(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlLine is a line of YAML document.
+type yamlLine struct {
+	n      int
+	indent int
+	// text is the content without indentation and comment.
+	text string
+	raw  string
+}
+
+// yamlParser parses the subset of YAML commonly used by configuration: block mappings and sequences,
+// flow sequences and mappings of scalars, quoted scalars and block scalars. Anchors, aliases, tags and
+// multiple documents are not supported. All scalars are kept as strings except null.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func loadYAMLConfig(data []byte) (map[string]interface{}, error) {
+	p := &yamlParser{}
+	for n, raw := range strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n") {
+		text := strings.TrimLeft(raw, " ")
+		line := yamlLine{n: n + 1, indent: len(raw) - len(text), text: yamlStripComment(text), raw: raw}
+		if line.text == "---" && line.indent == 0 && len(p.lines) == 0 {
+			continue
+		}
+		if (line.text == "---" || line.text == "...") && line.indent == 0 {
+			break
+		}
+		if strings.HasPrefix(text, "\t") && len(strings.TrimSpace(text)) > 0 {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", n+1)
+		}
+		p.lines = append(p.lines, line)
+	}
+
+	if p.next() == nil {
+		return map[string]interface{}{}, nil
+	}
+	v, err := p.parseNode(p.next().indent)
+	if err != nil {
+		return nil, err
+	}
+	if line := p.next(); line != nil {
+		return nil, fmt.Errorf("line %d: unexpected indentation", line.n)
+	}
+	tree, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("document is not a mapping")
+	}
+	return tree, nil
+}
+
+// yamlStripComment removes the comment and trailing spaces of the line.
+func yamlStripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' || c == '\'' && quote == c && i+1 < len(s) && s[i+1] == c {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " ")
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,", s[i-1]) > -1):
+			quote = c
+		}
+	}
+	return strings.TrimRight(s, " ")
+}
+
+// next returns the next line that is not empty, or nil if there is none.
+func (p *yamlParser) next() *yamlLine {
+	for p.pos < len(p.lines) && len(p.lines[p.pos].text) == 0 {
+		p.pos++
+	}
+	if p.pos == len(p.lines) {
+		return nil
+	}
+	return &p.lines[p.pos]
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseNode parses the block mapping or sequence whose lines have the indentation.
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isYAMLSequenceItem(p.next().text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+// yamlSplitKey returns the key and value of the mapping entry, or false if the text is not one.
+func yamlSplitKey(text string) (key, value string, ok bool) {
+	start := 0
+	if len(text) > 0 && (text[0] == '"' || text[0] == '\'') {
+		end := closingQuote(text, text[0])
+		if end == -1 {
+			return "", "", false
+		}
+		start = end + 1
+	} else if len(text) > 0 && strings.IndexByte("[{", text[0]) > -1 {
+		return "", "", false
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			key = strings.TrimSpace(text[:i])
+			if start > 0 {
+				key = yamlScalar(key).(string)
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for line := p.next(); line != nil && line.indent == indent && !isYAMLSequenceItem(line.text); line = p.next() {
+		key, value, ok := yamlSplitKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: mapping key expected", line.n)
+		}
+		if _, ok = m[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.n, key)
+		}
+		p.pos++
+
+		v, err := p.parseValue(line, indent, value)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for line := p.next(); line != nil && line.indent == indent && isYAMLSequenceItem(line.text); line = p.next() {
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if _, _, isMapping := yamlSplitKey(item); len(item) > 0 && (isMapping || isYAMLSequenceItem(item)) {
+			// The item is a nested block starting on the same line, e.g. "- name: a".
+			line.indent += len(line.text) - len(item)
+			line.text = item
+			v, err := p.parseNode(line.indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		p.pos++
+
+		v, err := p.parseValue(line, indent, item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// parseValue parses the value of a mapping entry or sequence item of the line, which is either
+// in the rest of line, or a block scalar or nested block on following lines.
+func (p *yamlParser) parseValue(line *yamlLine, indent int, value string) (interface{}, error) {
+	if len(value) > 0 && (value[0] == '|' || value[0] == '>') {
+		return p.parseBlockScalar(indent, value)
+	}
+	if len(value) > 0 {
+		return yamlFlow(value, line.n)
+	}
+
+	next := p.next()
+	switch {
+	case next == nil:
+		return nil, nil
+	case next.indent > indent:
+		return p.parseNode(next.indent)
+	// Sequences can be at the same indentation as the key they belong to.
+	case next.indent == indent && isYAMLSequenceItem(next.text) && !isYAMLSequenceItem(line.text):
+		return p.parseSequence(indent)
+	}
+	return nil, nil
+}
+
+// parseBlockScalar parses the literal (|) or folded (>) block scalar on lines indented more than the parent.
+func (p *yamlParser) parseBlockScalar(indent int, header string) (interface{}, error) {
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos].raw
+		text := strings.TrimLeft(raw, " ")
+		if len(text) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		lineIndent := len(raw) - len(text)
+		if lineIndent <= indent || (blockIndent > -1 && lineIndent < blockIndent) {
+			break
+		}
+		if blockIndent == -1 {
+			blockIndent = lineIndent
+		}
+		lines = append(lines, strings.TrimRight(raw[blockIndent:], " "))
+	}
+	// Trailing empty lines are part of the block only by keep chomping.
+	trailing := 0
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var s string
+	if header[0] == '|' {
+		s = strings.Join(lines, "\n")
+	} else {
+		for i, l := range lines {
+			switch {
+			case i == 0:
+			case len(l) == 0 || len(lines[i-1]) == 0 || l[0] == ' ':
+				s += "\n"
+			default:
+				s += " "
+			}
+			s += l
+		}
+	}
+	switch {
+	case len(lines) == 0:
+	case strings.Contains(header, "-"):
+	case strings.Contains(header, "+"):
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// yamlSplitFlow splits items of flow collection by commas that are not in quotes or nested collections.
+func yamlSplitFlow(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			if end := closingQuote(s[i:], s[i]); end > -1 {
+				i += end
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); len(last) > 0 {
+		items = append(items, last)
+	}
+	return items
+}
+
+// yamlFlow parses the value in flow style, i.e. a scalar, or a flow sequence or mapping.
+func yamlFlow(s string, n int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %d: unterminated flow sequence", n)
+		}
+		list := []interface{}{}
+		for _, item := range yamlSplitFlow(s[1 : len(s)-1]) {
+			v, err := yamlFlow(item, n)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("line %d: unterminated flow mapping", n)
+		}
+		m := make(map[string]interface{})
+		for _, item := range yamlSplitFlow(s[1 : len(s)-1]) {
+			key, value, ok := yamlSplitKey(item)
+			if !ok {
+				return nil, fmt.Errorf("line %d: mapping key expected", n)
+			}
+			v, err := yamlFlow(value, n)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'"):
+		if closingQuote(s, s[0]) != len(s)-1 {
+			return nil, fmt.Errorf("line %d: invalid quoted scalar", n)
+		}
+	}
+	return yamlScalar(s), nil
+}
+
+// yamlScalar returns the value of plain or quoted scalar.
+func yamlScalar(s string) interface{} {
+	switch {
+	case len(s) >= 2 && s[0] == '"':
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+		return s[1 : len(s)-1]
+	case len(s) >= 2 && s[0] == '\'':
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+	case s == "~" || s == "null" || s == "Null" || s == "NULL" || len(s) == 0:
+		return nil
+	}
+	return s
+}
+
+// tomlParser parses TOML documents. Values are kept as strings, integers are converted to decimal.
+type tomlParser struct {
+	s    string
+	pos  int
+	root map[string]interface{}
+}
+
+func loadTOMLConfig(data []byte) (map[string]interface{}, error) {
+	p := &tomlParser{s: strings.Replace(string(data), "\r\n", "\n", -1), root: make(map[string]interface{})}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %v", strings.Count(p.s[:p.pos], "\n")+1, err)
+	}
+	return p.root, nil
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+func (p *tomlParser) skipSpaces() {
+	for !p.eof() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips spaces, comments and newlines.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\n':
+			p.pos++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if i := strings.IndexByte(p.s[p.pos:], '\n'); i > -1 {
+		p.pos += i
+	} else {
+		p.pos = len(p.s)
+	}
+}
+
+// expectLineEnd skips the rest of line, which can only contain a comment.
+func (p *tomlParser) expectLineEnd() error {
+	p.skipSpaces()
+	if p.peek("#") {
+		p.skipComment()
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.s[p.pos] != '\n' {
+		return fmt.Errorf("unexpected %q", p.s[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseKey parses a dotted key, whose parts are bare or quoted.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			return nil, errors.New("key expected")
+		}
+		switch p.s[p.pos] {
+		case '"', '\'':
+			v, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, v)
+		default:
+			start := p.pos
+			for !p.eof() && isTOMLBareKey(p.s[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, errors.New("key expected")
+			}
+			parts = append(parts, p.s[start:p.pos])
+		}
+		p.skipSpaces()
+		if !p.peek(".") {
+			return parts, nil
+		}
+		p.pos++
+	}
+}
+
+// table returns the table of the path from the root, creating missing ones.
+// The last table of arrays is used for paths through them.
+func (p *tomlParser) table(path []string) (map[string]interface{}, error) {
+	t := p.root
+	for _, k := range path {
+		switch v := t[k].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			t[k] = child
+			t = child
+		case map[string]interface{}:
+			t = v
+		case []interface{}:
+			last, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %q is not a table", k)
+			}
+			t = last
+		default:
+			return nil, fmt.Errorf("key %q is not a table", k)
+		}
+	}
+	return t, nil
+}
+
+func (p *tomlParser) parse() error {
+	current := p.root
+	for p.skipBlank(); !p.eof(); p.skipBlank() {
+		if !p.peek("[") {
+			if err := p.parseKeyValue(current); err != nil {
+				return err
+			}
+			if err := p.expectLineEnd(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		isArray := p.peek("[[")
+		if isArray {
+			p.pos += 2
+		} else {
+			p.pos++
+		}
+		path, err := p.parseKey()
+		if err != nil {
+			return err
+		}
+		if isArray && !p.peek("]]") || !isArray && !p.peek("]") {
+			return errors.New("unterminated table header")
+		}
+		p.pos++
+		if isArray {
+			p.pos++
+		}
+		if err = p.expectLineEnd(); err != nil {
+			return err
+		}
+
+		if !isArray {
+			if current, err = p.table(path); err != nil {
+				return err
+			}
+			continue
+		}
+		parent, err := p.table(path[:len(path)-1])
+		if err != nil {
+			return err
+		}
+		key := path[len(path)-1]
+		list, ok := parent[key].([]interface{})
+		if parent[key] != nil && !ok {
+			return fmt.Errorf("key %q is not an array of tables", key)
+		}
+		current = make(map[string]interface{})
+		parent[key] = append(list, current)
+	}
+	return nil
+}
+
+// parseKeyValue parses "key = value" into the table.
+func (p *tomlParser) parseKeyValue(t map[string]interface{}) error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if !p.peek("=") {
+		return errors.New("'=' expected after key")
+	}
+	p.pos++
+	p.skipSpaces()
+	v, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range path[:len(path)-1] {
+		child, ok := t[k].(map[string]interface{})
+		if !ok {
+			if t[k] != nil {
+				return fmt.Errorf("key %q is not a table", k)
+			}
+			child = make(map[string]interface{})
+			t[k] = child
+		}
+		t = child
+	}
+	key := path[len(path)-1]
+	if _, ok := t[key]; ok {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	t[key] = v
+	return nil
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, errors.New("value expected")
+	}
+	switch p.s[p.pos] {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		p.pos++
+		list := []interface{}{}
+		for {
+			p.skipBlank()
+			if p.peek("]") {
+				p.pos++
+				return list, nil
+			}
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skipBlank()
+			if p.peek(",") {
+				p.pos++
+			} else if !p.peek("]") {
+				return nil, errors.New("',' or ']' expected in array")
+			}
+		}
+	case '{':
+		p.pos++
+		t := make(map[string]interface{})
+		for p.skipSpaces(); !p.peek("}"); p.skipSpaces() {
+			if len(t) > 0 {
+				if !p.peek(",") {
+					return nil, errors.New("',' or '}' expected in inline table")
+				}
+				p.pos++
+			}
+			if err := p.parseKeyValue(t); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++
+		return t, nil
+	}
+
+	// Booleans, numbers and dates, which end at delimiters. Dates can contain a space before time.
+	start := p.pos
+	for !p.eof() && strings.IndexByte(",]}#\n", p.s[p.pos]) == -1 {
+		p.pos++
+	}
+	v := strings.TrimSpace(p.s[start:p.pos])
+	p.pos = start + len(v)
+	if len(v) == 0 {
+		return nil, errors.New("value expected")
+	}
+	if n, err := strconv.ParseInt(strings.Replace(v, "_", "", -1), 0, 64); err == nil {
+		return strconv.FormatInt(n, 10), nil
+	}
+	if v == "true" || v == "false" {
+		return v, nil
+	}
+	if c := v[0]; c != '+' && c != '-' && c != 'i' && c != 'n' && (c < '0' || c > '9') {
+		return nil, fmt.Errorf("invalid value %q", v)
+	}
+	return strings.Replace(v, "_", "", -1), nil
+}
+
+// parseString parses a basic, literal, or multi-line string.
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.s[p.pos]
+	delim := strings.Repeat(string(quote), 3)
+	if p.peek(delim) {
+		p.pos += 3
+		// A newline immediately following the opening delimiter is trimmed.
+		if p.peek("\n") {
+			p.pos++
+		}
+		end := strings.Index(p.s[p.pos:], delim)
+		if end == -1 {
+			return "", errors.New("unterminated multi-line string")
+		}
+		s := p.s[p.pos : p.pos+end]
+		p.pos += end + 3
+		if quote == '\'' {
+			return s, nil
+		}
+		return tomlUnescape(s)
+	}
+
+	end := closingQuote(p.s[p.pos:], quote)
+	if end == -1 || strings.Contains(p.s[p.pos:p.pos+end], "\n") {
+		return "", errors.New("unterminated string")
+	}
+	s := p.s[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	if quote == '\'' {
+		return s, nil
+	}
+	return tomlUnescape(s)
+}
+
+// tomlUnescape replaces escapes of basic strings. In multi-line strings, a backslash at the end
+// of line trims all whitespace and newlines up to the next non-whitespace.
+func tomlUnescape(s string) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			if rest := strings.TrimLeft(s[i+1:], " \t"); strings.HasPrefix(rest, "\n") {
+				i = len(s) - len(strings.TrimLeft(rest, " \t\n")) - 1
+				continue
+			}
+			buf.WriteString(s[i : i+2])
+			i++
+		case c == '"':
+			buf.WriteString(`\"`)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+
+	v, err := strconv.Unquote(buf.String())
+	if err != nil {
+		return "", errors.New("invalid escape in string")
+	}
+	return v, nil
+}
+
+// ConfigChange is a change of configuration value made by reloading.
+type ConfigChange struct {
+	Section  string
+	Key      string
+	OldValue string
+	NewValue string
+	// Added and Removed are true if the key is added or removed, whose old or new value is empty.
+	Added   bool
+	Removed bool
+}
+
+type configListener struct {
+	fn func([]ConfigChange)
+}
+
+// configStore keeps the configuration loaded from sources, which is replaced as a whole
+// when reloaded, so readers never see partial reloads.
+type configStore struct {
+	lock    sync.RWMutex
+	cfg     *ini.File
+	sources []interface{}
+
+	// swapLock serializes swaps of configuration, so listeners are notified in order.
+	swapLock  sync.Mutex
+	listeners []*configListener
+}
+
+// defaultConfig is the configuration of package-level functions, and of Macaron instances
+// that have not set their own.
+var defaultConfig = &configStore{}
+
+// get returns the configuration, or an empty one if there is none.
+func (s *configStore) get() *ini.File {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.cfg == nil {
+		return ini.Empty()
+	}
+	return s.cfg
+}
+
+func (s *configStore) set(sources []interface{}) (*ini.File, error) {
+	f, err := loadConfig(sources)
+	s.swap(f, sources)
+	return s.get(), err
+}
+
+func (s *configStore) onChange(fn func([]ConfigChange)) (cancel func()) {
+	l := &configListener{fn}
+	s.swapLock.Lock()
+	s.listeners = append(s.listeners, l)
+	s.swapLock.Unlock()
+
+	return func() {
+		s.swapLock.Lock()
+		defer s.swapLock.Unlock()
+		for i := range s.listeners {
+			if s.listeners[i] == l {
+				s.listeners = append(s.listeners[:i:i], s.listeners[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// diffConfig returns changes of values from old to new configuration, ordered by sections and keys.
+func diffConfig(old, new *ini.File) []ConfigChange {
+	values := func(f *ini.File) map[[2]string]string {
+		m := make(map[[2]string]string)
+		if f == nil {
+			return m
+		}
+		for _, sec := range f.Sections() {
+			for _, key := range sec.Keys() {
+				m[[2]string{sec.Name(), key.Name()}] = key.Value()
+			}
+		}
+		return m
+	}
+	oldValues, newValues := values(old), values(new)
+
+	var changes []ConfigChange
+	for k, v := range oldValues {
+		if nv, ok := newValues[k]; !ok {
+			changes = append(changes, ConfigChange{Section: k[0], Key: k[1], OldValue: v, Removed: true})
+		} else if nv != v {
+			changes = append(changes, ConfigChange{Section: k[0], Key: k[1], OldValue: v, NewValue: nv})
+		}
+	}
+	for k, v := range newValues {
+		if _, ok := oldValues[k]; !ok {
+			changes = append(changes, ConfigChange{Section: k[0], Key: k[1], NewValue: v, Added: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Section != changes[j].Section {
+			return changes[i].Section < changes[j].Section
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// swap replaces the configuration and its sources, and notifies listeners of changes.
+func (s *configStore) swap(f *ini.File, sources []interface{}) {
+	s.swapLock.Lock()
+	defer s.swapLock.Unlock()
+
+	s.lock.Lock()
+	old := s.cfg
+	s.cfg, s.sources = f, sources
+	s.lock.Unlock()
+
+	if changes := diffConfig(old, f); len(changes) > 0 {
+		for _, l := range s.listeners {
+			l.fn(changes)
+		}
+	}
+}
+
+func (s *configStore) reload() error {
+	s.lock.RLock()
+	sources := s.sources
+	s.lock.RUnlock()
+	if len(sources) == 0 {
+		return errors.New("no configuration source has been set")
+	}
+
+	f, err := loadConfig(sources)
+	if err != nil {
+		return err
+	}
+	s.swap(f, sources)
+	return nil
+}
+
+// fileModTime returns the modification time of the file, or zero time if it does not exist.
+func fileModTime(name string) time.Time {
+	if fi, err := os.Stat(name); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+func (s *configStore) watch(interval time.Duration, onError func(error)) (stop func()) {
+	modTimes := make(map[string]time.Time)
+	changed := func() bool {
+		s.lock.RLock()
+		sources := s.sources
+		s.lock.RUnlock()
+
+		changed := false
+		env := safeEnv()
+		for _, source := range sources {
+			name, ok := source.(string)
+			if !ok {
+				continue
+			}
+			// Overlays are watched as well, even if they do not exist yet.
+			for _, name := range []string{name, configOverlay(name, env)} {
+				t := fileModTime(name)
+				if last, ok := modTimes[name]; ok && !last.Equal(t) {
+					changed = true
+				}
+				modTimes[name] = t
+			}
+		}
+		return changed
+	}
+	changed()
+
+	ticker := time.NewTicker(interval)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := ctx.Done()
+	s.lock.RLock()
+	for _, source := range s.sources {
+		if w, ok := source.(ConfigWatcher); ok {
+			go s.watchSource(ctx, w, interval, onError)
+		}
+	}
+	s.lock.RUnlock()
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !changed() {
+					continue
+				}
+				if err := s.reload(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			cancel()
+		})
+	}
+}
+
+// watchSource reloads configuration whenever values of the source change until ctx is done.
+// Errors of waiting are retried after interval.
+func (s *configStore) watchSource(ctx context.Context, w ConfigWatcher, interval time.Duration, onError func(error)) {
+	for {
+		err := w.WaitChange(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = s.reload()
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// OnConfigChange registers the function that is called with changes of values after configuration
+// is reloaded, e.g. to adjust log level or rate limits at runtime. Functions are called in order of
+// registration, and must not set or reload configuration. It returns a function to unregister.
+func OnConfigChange(fn func(changes []ConfigChange)) (cancel func()) {
+	return defaultConfig.onChange(fn)
+}
+
+// ReloadConfig loads sources set by SetConfig again, and replaces the configuration if they are
+// loaded successfully, otherwise the configuration in use is kept.
+func ReloadConfig() error {
+	return defaultConfig.reload()
+}
+
+// WatchConfig starts checking files of configuration sources and their overlays every interval, and
+// reloads them by ReloadConfig when any of them changes. Sources that implement ConfigWatcher are
+// reloaded as soon as they change. Errors of reloading are given to onError if not nil. It returns
+// a function to stop watching.
+func WatchConfig(interval time.Duration, onError func(error)) (stop func()) {
+	return defaultConfig.watch(interval, onError)
+}
+
+// configStore returns the configuration set by m.SetConfig, or the global one if there is none.
+func (m *Macaron) configStore() *configStore {
+	if m.config != nil {
+		return m.config
+	}
+	return defaultConfig
+}
+
+// SetConfig sets data sources for configuration of the instance as the package-level SetConfig does,
+// so instances in the same process can have different configuration. The configuration is also
+// provided as a service of *ini.File, which is the current one when configuration is reloaded.
+// Instances without their own configuration use the global one.
+func (m *Macaron) SetConfig(source interface{}, others ...interface{}) (*ini.File, error) {
+	if m.config == nil {
+		m.config = &configStore{}
+		m.Provide(func() *ini.File {
+			return m.Config()
+		})
+	}
+	return m.config.set(append([]interface{}{source}, others...))
+}
+
+// Config returns configuration of the instance, or the global one if it has not set its own.
+func (m *Macaron) Config() *ini.File {
+	return m.configStore().get()
+}
+
+// ReloadConfig reloads configuration of the instance as the package-level ReloadConfig does.
+func (m *Macaron) ReloadConfig() error {
+	return m.configStore().reload()
+}
+
+// OnConfigChange registers the function that is called with changes after configuration
+// of the instance is reloaded, as the package-level OnConfigChange does.
+func (m *Macaron) OnConfigChange(fn func(changes []ConfigChange)) (cancel func()) {
+	return m.configStore().onChange(fn)
+}
+
+// WatchConfig watches configuration of the instance as the package-level WatchConfig does.
+func (m *Macaron) WatchConfig(interval time.Duration, onError func(error)) (stop func()) {
+	return m.configStore().watch(interval, onError)
+}
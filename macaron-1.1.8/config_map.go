@@ -0,0 +1,323 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// ByteSize is a size in bytes, which is parsed from configuration with an optional unit,
+// e.g. "512", "100KB", "10MB" or "1.5GB". Units are powers of 1024.
+type ByteSize int64
+
+// ParseByteSize parses size in bytes with an optional unit of B, KB, MB, GB or TB, which can also
+// be written like "K" or "KiB", case-insensitively.
+func ParseByteSize(s string) (ByteSize, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.Replace(v, "IB", "B", 1), "B")
+	shift := uint(0)
+	if i := len(v) - 1; i >= 0 {
+		if n := strings.IndexByte("KMGT", v[i]); n > -1 {
+			shift = uint(n+1) * 10
+			v = strings.TrimSpace(v[:i])
+		}
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+		return ByteSize(n << shift), nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(f * float64(int64(1)<<shift)), nil
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// normalizeConfigName returns the name in lower case without "_" and "-", so field names like
+// "HTTPPort" match keys like "http_port".
+func normalizeConfigName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// findConfigSection returns the section of the name, which is matched case-insensitively
+// and ignoring "_" and "-" of each part, or nil if there is none.
+func findConfigSection(f *ini.File, name string) *ini.Section {
+	parts := strings.Split(name, ".")
+	for _, sec := range f.Sections() {
+		secParts := strings.Split(sec.Name(), ".")
+		if len(secParts) != len(parts) {
+			continue
+		}
+		match := true
+		for i := range parts {
+			match = match && normalizeConfigName(secParts[i]) == normalizeConfigName(parts[i])
+		}
+		if match {
+			return sec
+		}
+	}
+	return nil
+}
+
+// findConfigKey returns the key of the name, which is matched as findConfigSection does, or nil if there is none.
+func findConfigKey(sec *ini.Section, name string) *ini.Key {
+	if sec == nil {
+		return nil
+	}
+	for _, key := range sec.Keys() {
+		if normalizeConfigName(key.Name()) == normalizeConfigName(name) {
+			return key
+		}
+	}
+	return nil
+}
+
+// setConfigField parses the value into the field.
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Type() {
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case byteSizeType:
+		n, err := ParseByteSize(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigField(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// isConfigSection returns true if the field of the type is mapped from a section rather than a key.
+func isConfigSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// mapConfigSection maps keys of the section onto fields of the struct, and nested structs onto
+// sections named by the path. Errors are appended to errs.
+func mapConfigSection(f *ini.File, path string, v reflect.Value, errs *[]string) {
+	secName := path
+	if len(path) == 0 {
+		secName = ini.DEFAULT_SECTION
+	}
+	sec := findConfigSection(f, secName)
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("ini"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		if isConfigSection(field.Type) {
+			if len(path) > 0 {
+				name = path + "." + name
+			}
+			mapConfigSection(f, name, v.Field(i), errs)
+			continue
+		}
+
+		required := len(tag) > 1 && tag[1] == "required"
+		fullName := name
+		if len(path) > 0 {
+			fullName = path + "." + name
+		}
+		value, ok := field.Tag.Lookup("default")
+		if key := findConfigKey(sec, name); key != nil {
+			value, ok = key.Value(), true
+		} else if required {
+			*errs = append(*errs, fmt.Sprintf("%s is required", fullName))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := setConfigField(v.Field(i), value); err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %v", fullName, err))
+		}
+	}
+}
+
+// MapConfig maps configuration onto fields of the struct that v points to. Fields of struct types
+// are mapped from sections, and others from keys of the default section or the section of the struct
+// they belong to. Names of sections and keys are field names or names given by "ini" tags, which are
+// matched case-insensitively and ignoring "_" and "-", e.g. field HTTPPort matches key "http_port".
+// Tag options can mark keys as required, and tag "default" gives values of missing keys, e.g.
+//
+//	type Settings struct {
+//		AppName string `ini:"app_name,required"`
+//		Server  struct {
+//			Port        int           `default:"4000"`
+//			ReadTimeout time.Duration `default:"30s"`
+//			MaxBody     macaron.ByteSize `default:"10MB"`
+//			Hosts       []string
+//		}
+//	}
+//
+// Values are parsed by types of fields: strings, booleans, numbers, time.Duration, ByteSize, time.Time
+// in RFC 3339, and slices of them separated by ",". Fields of missing keys without default values are
+// left as they are. It returns an error of all missing required keys and invalid values.
+func MapConfig(f *ini.File, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || !isConfigSection(rv.Elem().Type()) {
+		return errors.New("config: value must be a non-nil pointer to struct")
+	}
+	var errs []string
+	mapConfigSection(f, "", rv.Elem(), &errs)
+	if len(errs) > 0 {
+		return errors.New("config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ConfigTo maps Config() onto the struct that v points to by MapConfig, and returns a middleware
+// handler that maps the pointer as a service, so handlers can depend on typed settings, e.g.
+//
+//	m.Use(macaron.ConfigTo(&Settings{}))
+//	m.Get("/", func(s *Settings) string { return s.AppName })
+//
+// It panics if the mapping fails. When configuration is reloaded, a copy of the initial struct is
+// mapped again and replaces the one mapped by later requests, so the struct mapped to a request never
+// changes. The struct in use is kept if the new configuration fails to map, which is logged.
+func ConfigTo(v interface{}) Handler {
+	return defaultConfig.configTo(v)
+}
+
+// ConfigTo maps configuration of the instance onto the struct that v points to, as the package-level
+// ConfigTo does.
+func (m *Macaron) ConfigTo(v interface{}) Handler {
+	return m.configStore().configTo(v)
+}
+
+func (s *configStore) configTo(v interface{}) Handler {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || !isConfigSection(rv.Elem().Type()) {
+		panic("config: value must be a non-nil pointer to struct")
+	}
+	initial := reflect.New(rv.Elem().Type()).Elem()
+	initial.Set(rv.Elem())
+	if err := MapConfig(s.get(), v); err != nil {
+		panic(err)
+	}
+
+	var (
+		lock     sync.Mutex
+		current  = rv
+		mapError error
+	)
+	s.onChange(func([]ConfigChange) {
+		next := reflect.New(initial.Type())
+		next.Elem().Set(initial)
+		err := MapConfig(s.get(), next.Interface())
+
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			mapError = err
+			return
+		}
+		current, mapError = next, nil
+	})
+
+	return Provides(func(ctx *Context) {
+		lock.Lock()
+		v, err := current, mapError
+		mapError = nil
+		lock.Unlock()
+
+		if err != nil && ctx.Router != nil && ctx.m != nil {
+			ctx.m.ErrorLogger().Printf("%sfail to map reloaded configuration: %v", requestTag(ctx), err)
+		}
+		ctx.Map(v.Interface())
+	}, v)
+}
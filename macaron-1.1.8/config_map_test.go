@@ -0,0 +1,172 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/ini.v1"
+)
+
+func Test_ParseByteSize(t *testing.T) {
+	Convey("Parse sizes in bytes", t, func() {
+		for s, n := range map[string]ByteSize{
+			"512":    512,
+			"100B":   100,
+			"2kb":    2 << 10,
+			"10MB":   10 << 20,
+			"10 MiB": 10 << 20,
+			"1.5G":   3 << 29,
+			"1TB":    1 << 40,
+		} {
+			size, err := ParseByteSize(s)
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, n)
+		}
+		for _, s := range []string{"", "big", "MB", "-1KB", "1XB"} {
+			_, err := ParseByteSize(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+type testSettings struct {
+	AppName string `ini:"app_name,required"`
+	Debug   bool
+	Secret  string `ini:"-"`
+	Server  struct {
+		HTTPPort    int           `default:"4000"`
+		ReadTimeout time.Duration `default:"30s"`
+		MaxBody     ByteSize      `default:"1MB"`
+		Hosts       []string
+		Ports       []uint16
+		Ratio       float64
+		Started     time.Time
+		TLS         struct {
+			Enabled bool
+			Cert    string `ini:"cert_file"`
+		}
+	}
+	Database struct {
+		Host string `ini:",required"`
+		Name string
+	} `ini:"db"`
+	ignored string
+}
+
+func Test_MapConfig(t *testing.T) {
+	Convey("Map configuration onto structs", t, func() {
+		f, err := ini.Load([]byte(`app_name = macaron
+DEBUG = true
+secret = s3cret
+
+[server]
+http_port = 8080
+max-body = 10MB
+hosts = a.example.com, b.example.com
+ports = 80,443
+ratio = 0.5
+started = 2020-01-02T03:04:05Z
+
+[server.tls]
+enabled = true
+cert_file = server.crt
+
+[db]
+host = localhost
+`))
+		So(err, ShouldBeNil)
+
+		s := &testSettings{Secret: "kept"}
+		s.Database.Name = "app"
+		So(MapConfig(f, s), ShouldBeNil)
+		So(s.AppName, ShouldEqual, "macaron")
+		So(s.Debug, ShouldBeTrue)
+		So(s.Secret, ShouldEqual, "kept")
+		So(s.Server.HTTPPort, ShouldEqual, 8080)
+		So(s.Server.ReadTimeout, ShouldEqual, 30*time.Second)
+		So(s.Server.MaxBody, ShouldEqual, 10<<20)
+		So(s.Server.Hosts, ShouldResemble, []string{"a.example.com", "b.example.com"})
+		So(s.Server.Ports, ShouldResemble, []uint16{80, 443})
+		So(s.Server.Ratio, ShouldEqual, 0.5)
+		So(s.Server.Started.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)), ShouldBeTrue)
+		So(s.Server.TLS.Enabled, ShouldBeTrue)
+		So(s.Server.TLS.Cert, ShouldEqual, "server.crt")
+		So(s.Database.Host, ShouldEqual, "localhost")
+		So(s.Database.Name, ShouldEqual, "app")
+
+		f, err = ini.Load([]byte("debug = maybe\n[server]\nhttp_port = eighty\n"))
+		So(err, ShouldBeNil)
+		err = MapConfig(f, &testSettings{})
+		So(err, ShouldNotBeNil)
+		for _, s := range []string{"app_name is required", "db.Host is required", "Debug:", "Server.HTTPPort:"} {
+			So(err.Error(), ShouldContainSubstring, s)
+		}
+
+		So(MapConfig(f, testSettings{}), ShouldNotBeNil)
+		So(MapConfig(f, (*testSettings)(nil)), ShouldNotBeNil)
+		So(MapConfig(f, new(string)), ShouldNotBeNil)
+	})
+}
+
+func Test_ConfigTo(t *testing.T) {
+	Convey("Map configuration as a service", t, func() {
+		defer SetConfig([]byte(""))
+		_, err := SetConfig([]byte("app_name = first\n[db]\nhost = localhost\n"))
+		So(err, ShouldBeNil)
+
+		So(func() { ConfigTo(&testSettings{}) }, ShouldNotPanic)
+		So(func() { ConfigTo(testSettings{}) }, ShouldPanic)
+
+		initial := &testSettings{Secret: "kept"}
+		m := New()
+		m.Use(ConfigTo(initial))
+		m.Get("/", func(s *testSettings) string {
+			return s.AppName + " " + s.Secret
+		})
+		get := func() string {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+		So(initial.AppName, ShouldEqual, "first")
+		So(get(), ShouldEqual, "first kept")
+
+		_, err = SetConfig([]byte("app_name = second\n[db]\nhost = localhost\n"))
+		So(err, ShouldBeNil)
+		So(get(), ShouldEqual, "second kept")
+		// The struct mapped to earlier requests is not changed.
+		So(initial.AppName, ShouldEqual, "first")
+
+		// Configuration that fails to map is logged, and the struct in use is kept.
+		buf := new(strings.Builder)
+		m.SetLogOutputs(nil, buf)
+		_, err = SetConfig([]byte("app_name = third\n"))
+		So(err, ShouldBeNil)
+		So(get(), ShouldEqual, "second kept")
+		So(buf.String(), ShouldContainSubstring, "db.Host is required")
+
+		_, err = SetConfig([]byte(""))
+		So(err, ShouldBeNil)
+		So(func() { ConfigTo(&testSettings{}) }, ShouldPanic)
+	})
+}
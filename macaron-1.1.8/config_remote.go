@@ -0,0 +1,327 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ConfigSource is a source of configuration other than files and data, e.g. a key-value store,
+// which can be given to SetConfig along with file sources.
+type ConfigSource interface {
+	// Load returns values of configuration as a tree of nested maps, which are loaded as sections.
+	Load() (map[string]interface{}, error)
+}
+
+// ConfigWatcher is a ConfigSource that can wait for changes of its values, which WatchConfig
+// uses to reload configuration as soon as they change.
+type ConfigWatcher interface {
+	ConfigSource
+	// WaitChange blocks until values may have changed since they were loaded last time,
+	// it should give up when ctx is done.
+	WaitChange(ctx context.Context) error
+}
+
+// configTree builds a tree of configuration from keys separated by "/", relative to the prefix,
+// e.g. key "app/server/port" of prefix "app" is loaded as key "port" of section "server".
+func configTree(prefix string, kvs map[string]string) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	for key, value := range kvs {
+		name := strings.Trim(strings.TrimPrefix(key, prefix), "/")
+		if len(name) == 0 || strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		node := tree
+		parts := strings.Split(name, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part]
+			if !ok {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			if node, ok = child.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("key %q conflicts with value of %q", key, part)
+			}
+		}
+		last := parts[len(parts)-1]
+		if _, ok := node[last].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("key %q conflicts with keys under it", key)
+		}
+		node[last] = value
+	}
+	return tree, nil
+}
+
+// remoteConfigClient returns the client, or http.DefaultClient if it is nil.
+func remoteConfigClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// ConsulSource loads configuration from keys of Consul KV store under the prefix.
+type ConsulSource struct {
+	// Address of Consul HTTP API. Default is "http://127.0.0.1:8500".
+	Address string
+	Prefix  string
+	// Token and Datacenter of requests, which are optional.
+	Token      string
+	Datacenter string
+	// Client sends requests. Default is http.DefaultClient.
+	Client *http.Client
+
+	lock  sync.Mutex
+	index uint64
+}
+
+// get requests keys under the prefix, waiting for changes after index if it is not zero.
+func (s *ConsulSource) get(ctx context.Context, index uint64) (*http.Response, error) {
+	addr := s.Address
+	if len(addr) == 0 {
+		addr = "http://127.0.0.1:8500"
+	}
+	query := url.Values{"recurse": {"true"}}
+	if len(s.Datacenter) > 0 {
+		query.Set("dc", s.Datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/kv/"+
+		strings.TrimPrefix(s.Prefix, "/")+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Token) > 0 {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+	resp, err := remoteConfigClient(s.Client).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("consul: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("consul: unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Load implements ConfigSource.
+func (s *ConsulSource) Load() (map[string]interface{}, error) {
+	resp, err := s.get(context.Background(), 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Consul responds 404 if there is no key under the prefix.
+	var pairs []struct {
+		Key   string
+		Value []byte
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+			return nil, fmt.Errorf("consul: %v", err)
+		}
+	}
+	kvs := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kvs[pair.Key] = string(pair.Value)
+	}
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	s.lock.Lock()
+	s.index = index
+	s.lock.Unlock()
+	return configTree(s.Prefix, kvs)
+}
+
+// WaitChange implements ConfigWatcher by blocking queries of Consul.
+func (s *ConsulSource) WaitChange(ctx context.Context) error {
+	s.lock.Lock()
+	index := s.index
+	s.lock.Unlock()
+
+	for {
+		resp, err := s.get(ctx, index)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		// Blocking queries return with the same index when they time out.
+		next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if next != index || index == 0 {
+			return nil
+		}
+	}
+}
+
+// EtcdSource loads configuration from keys of etcd under the prefix by its v3 JSON API.
+type EtcdSource struct {
+	// Endpoint of etcd. Default is "http://127.0.0.1:2379".
+	Endpoint string
+	Prefix   string
+	// Token is sent as Authorization header if not empty.
+	Token string
+	// Client sends requests. Default is http.DefaultClient.
+	Client *http.Client
+
+	lock     sync.Mutex
+	revision int64
+}
+
+// keyRange returns the range of keys under the prefix encoded in base64, as etcd requires.
+func (s *EtcdSource) keyRange() (key, end string) {
+	prefix := []byte(s.Prefix)
+	if len(prefix) == 0 {
+		// Range from "\x00" to "\x00" means all keys.
+		prefix = []byte{0}
+		return base64.StdEncoding.EncodeToString(prefix), base64.StdEncoding.EncodeToString(prefix)
+	}
+	rangeEnd := append([]byte(nil), prefix...)
+	for i := len(rangeEnd) - 1; i >= 0; i-- {
+		if rangeEnd[i] < 0xff {
+			rangeEnd[i]++
+			rangeEnd = rangeEnd[:i+1]
+			break
+		}
+	}
+	return base64.StdEncoding.EncodeToString(prefix), base64.StdEncoding.EncodeToString(rangeEnd)
+}
+
+func (s *EtcdSource) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	endpoint := s.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = "http://127.0.0.1:2379"
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.Token) > 0 {
+		req.Header.Set("Authorization", s.Token)
+	}
+	resp, err := remoteConfigClient(s.Client).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("etcd: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd: unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Load implements ConfigSource.
+func (s *EtcdSource) Load() (map[string]interface{}, error) {
+	key, end := s.keyRange()
+	resp, err := s.post(context.Background(), "/v3/kv/range", map[string]string{"key": key, "range_end": end})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Integers of 64 bits are encoded as strings by etcd.
+	var result struct {
+		Header struct {
+			Revision json.Number `json:"revision"`
+		} `json:"header"`
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("etcd: %v", err)
+	}
+	kvs := make(map[string]string, len(result.KVs))
+	for _, kv := range result.KVs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+
+	revision, _ := result.Header.Revision.Int64()
+	s.lock.Lock()
+	s.revision = revision
+	s.lock.Unlock()
+	return configTree(s.Prefix, kvs)
+}
+
+// WaitChange implements ConfigWatcher by watching keys under the prefix after the revision
+// loaded last time.
+func (s *EtcdSource) WaitChange(ctx context.Context) error {
+	s.lock.Lock()
+	revision := s.revision
+	s.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	key, end := s.keyRange()
+	resp, err := s.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            key,
+			"range_end":      end,
+			"start_revision": strconv.FormatInt(revision+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The response is a stream of messages, the first of which confirms the watch is created.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Canceled     bool              `json:"canceled"`
+				CancelReason string            `json:"cancel_reason"`
+				Events       []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err = dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("etcd: %v", err)
+		}
+		if msg.Result.Canceled {
+			// Watch is canceled if the revision has been compacted, so values should be loaded again.
+			return nil
+		}
+		if len(msg.Result.Events) > 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeKV is a key-value store of remote configuration tests, which notifies waiters of changes.
+type fakeKV struct {
+	lock     sync.Mutex
+	kvs      map[string]string
+	revision int64
+	changed  chan struct{}
+}
+
+func newFakeKV(kvs map[string]string) *fakeKV {
+	return &fakeKV{kvs: kvs, revision: 1, changed: make(chan struct{})}
+}
+
+func (kv *fakeKV) set(key, value string) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kv.kvs[key] = value
+	kv.revision++
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *fakeKV) snapshot(prefix string) (map[string]string, int64, chan struct{}) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kvs := make(map[string]string)
+	for k, v := range kv.kvs {
+		if strings.HasPrefix(k, prefix) {
+			kvs[k] = v
+		}
+	}
+	return kvs, kv.revision, kv.changed
+}
+
+// consulServer serves keys of kv by Consul KV API, including blocking queries.
+func consulServer(kv *fakeKV) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		kvs, index, changed := kv.snapshot(prefix)
+		if r.URL.Query().Get("index") == strconv.FormatInt(index, 10) {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			kvs, index, _ = kv.snapshot(prefix)
+		}
+
+		w.Header().Set("X-Consul-Index", strconv.FormatInt(index, 10))
+		if len(kvs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var pairs []map[string]interface{}
+		for k, v := range kvs {
+			pairs = append(pairs, map[string]interface{}{"Key": k, "Value": []byte(v)})
+		}
+		json.NewEncoder(w).Encode(pairs)
+	}))
+}
+
+// etcdServer serves keys of kv by etcd v3 JSON API, including watches.
+func etcdServer(kv *fakeKV) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key           []byte `json:"key"`
+			CreateRequest struct {
+				Key           []byte      `json:"key"`
+				StartRevision json.Number `json:"start_revision"`
+			} `json:"create_request"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			kvs, revision, _ := kv.snapshot(string(req.Key))
+			var result []map[string]interface{}
+			for k, v := range kvs {
+				result = append(result, map[string]interface{}{"key": []byte(k), "value": []byte(v)})
+			}
+			fmt.Fprintf(w, `{"header":{"revision":"%d"},"kvs":`, revision)
+			json.NewEncoder(w).Encode(result)
+			fmt.Fprint(w, "}")
+		case "/v3/watch":
+			_, revision, changed := kv.snapshot(string(req.CreateRequest.Key))
+			fmt.Fprint(w, `{"result":{"created":true}}`+"\n")
+			w.(http.Flusher).Flush()
+			if start, _ := req.CreateRequest.StartRevision.Int64(); start > revision {
+				select {
+				case <-changed:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			fmt.Fprint(w, `{"result":{"events":[{"type":"PUT"}]}}`+"\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_ConfigTree(t *testing.T) {
+	Convey("Build configuration from keys", t, func() {
+		tree, err := configTree("app", map[string]string{
+			"app/":                "",
+			"app/name":            "demo",
+			"app/server/port":     "4000",
+			"app/database/a/host": "db",
+		})
+		So(err, ShouldBeNil)
+		So(tree, ShouldResemble, map[string]interface{}{
+			"name":     "demo",
+			"server":   map[string]interface{}{"port": "4000"},
+			"database": map[string]interface{}{"a": map[string]interface{}{"host": "db"}},
+		})
+
+		_, err = configTree("", map[string]string{"a": "1", "a/b": "2"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Encode key range of etcd", t, func() {
+		decode := func(s string) string {
+			data, _ := base64.StdEncoding.DecodeString(s)
+			return string(data)
+		}
+		key, end := (&EtcdSource{Prefix: "app/"}).keyRange()
+		So(decode(key), ShouldEqual, "app/")
+		So(decode(end), ShouldEqual, "app0")
+
+		key, end = (&EtcdSource{}).keyRange()
+		So(decode(key), ShouldEqual, "\x00")
+		So(decode(end), ShouldEqual, "\x00")
+	})
+}
+
+func Test_RemoteConfig(t *testing.T) {
+	for name, serve := range map[string]func(*fakeKV) *httptest.Server{
+		"Consul": consulServer,
+		"etcd":   etcdServer,
+	} {
+		Convey("Load and watch configuration from "+name, t, func() {
+			kv := newFakeKV(map[string]string{
+				"app/level":       "info",
+				"app/server/port": "4000",
+				"other/level":     "debug",
+			})
+			srv := serve(kv)
+			defer srv.Close()
+
+			var source ConfigSource = &ConsulSource{Address: srv.URL, Prefix: "app"}
+			if name == "etcd" {
+				source = &EtcdSource{Endpoint: srv.URL, Prefix: "app/"}
+			}
+
+			m := New()
+			cfg, err := m.SetConfig([]byte("name = demo\nlevel = warn\n[server]\nhost = localhost\n"), source)
+			So(err, ShouldBeNil)
+			So(cfg.Section("").Key("name").String(), ShouldEqual, "demo")
+			So(cfg.Section("").Key("level").String(), ShouldEqual, "info")
+			So(cfg.Section("server").Key("host").String(), ShouldEqual, "localhost")
+			So(cfg.Section("server").Key("port").String(), ShouldEqual, "4000")
+
+			changed := make(chan []ConfigChange, 1)
+			defer m.OnConfigChange(func(c []ConfigChange) { changed <- c })()
+			stop := m.WatchConfig(time.Hour, func(err error) { t.Error(err) })
+			defer stop()
+
+			// Watches may not have started yet, in which case they see changes of values at once.
+			kv.set("app/server/port", "5000")
+			select {
+			case c := <-changed:
+				So(c, ShouldResemble, []ConfigChange{
+					{Section: "server", Key: "port", OldValue: "4000", NewValue: "5000"},
+				})
+			case <-time.After(5 * time.Second):
+				t.Fatal("configuration is not reloaded")
+			}
+			So(m.Config().Section("server").Key("port").String(), ShouldEqual, "5000")
+		})
+	}
+
+	Convey("Fail to load from unavailable servers", t, func() {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+		_, err := New().SetConfig([]byte(""), &ConsulSource{Address: srv.URL})
+		So(err, ShouldNotBeNil)
+		_, err = New().SetConfig([]byte(""), &EtcdSource{Endpoint: srv.URL})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ErrSecretNotFound is returned by secret sources that do not have the secret,
+// so the next source is tried.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretSource resolves secrets referenced from configuration values by placeholders
+// of "${secret:path}", which keeps credentials out of configuration files. Secret sources
+// are given to SetConfig along with configuration sources, and tried in order.
+type SecretSource interface {
+	// Secret returns the secret of the path, or ErrSecretNotFound if there is none.
+	Secret(path string) (string, error)
+}
+
+// EnvSecrets resolves secrets from environment variables named by the prefix and path,
+// e.g. "${secret:DB_PASSWORD}" of prefix "APP_" from variable APP_DB_PASSWORD.
+type EnvSecrets struct {
+	Prefix string
+}
+
+// Secret implements SecretSource.
+func (s EnvSecrets) Secret(path string) (string, error) {
+	if value, ok := os.LookupEnv(s.Prefix + path); ok {
+		return value, nil
+	}
+	return "", ErrSecretNotFound
+}
+
+// FileSecrets resolves secrets from contents of files under the directory, e.g. secrets
+// mounted by Docker or Kubernetes. Trailing line breaks of contents are removed.
+type FileSecrets struct {
+	Dir string
+}
+
+// Secret implements SecretSource.
+func (s FileSecrets) Secret(path string) (string, error) {
+	name := filepath.Clean("/" + path)
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// VaultSecrets resolves secrets from KV version 2 secrets engine of HashiCorp Vault. Paths are
+// secret paths and names of fields separated by "#", e.g. "${secret:database#password}", or field
+// "value" if there is no name.
+type VaultSecrets struct {
+	// Address of Vault. Default is "http://127.0.0.1:8200".
+	Address string
+	Token   string
+	// Mount path of the secrets engine. Default is "secret".
+	Mount string
+	// Namespace of Vault Enterprise, which is optional.
+	Namespace string
+	// Client sends requests. Default is http.DefaultClient.
+	Client *http.Client
+}
+
+// Secret implements SecretSource.
+func (s *VaultSecrets) Secret(path string) (string, error) {
+	addr, mount := s.Address, s.Mount
+	if len(addr) == 0 {
+		addr = "http://127.0.0.1:8200"
+	}
+	if len(mount) == 0 {
+		mount = "secret"
+	}
+	field := "value"
+	if i := strings.LastIndex(path, "#"); i > -1 {
+		path, field = path[:i], path[i+1:]
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+
+		strings.Trim(mount, "/")+"/data/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	if len(s.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+	resp, err := remoteConfigClient(s.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrSecretNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("vault: %v", err)
+	}
+	value, ok := result.Data.Data[field]
+	if !ok || value == nil {
+		return "", ErrSecretNotFound
+	}
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	data, _ := json.Marshal(value)
+	return string(data), nil
+}
+
+var secretPlaceholderPattern = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+// resolveConfigSecrets replaces placeholders of secrets in values by secrets of the sources,
+// each secret is requested once.
+func resolveConfigSecrets(f *ini.File, sources []SecretSource) error {
+	resolved := make(map[string]string)
+	resolve := func(path string) (string, error) {
+		if value, ok := resolved[path]; ok {
+			return value, nil
+		}
+		for _, source := range sources {
+			value, err := source.Secret(path)
+			if err == ErrSecretNotFound {
+				continue
+			} else if err != nil {
+				return "", err
+			}
+			resolved[path] = value
+			return value, nil
+		}
+		return "", ErrSecretNotFound
+	}
+
+	for _, sec := range f.Sections() {
+		for _, key := range sec.Keys() {
+			var err error
+			value := secretPlaceholderPattern.ReplaceAllStringFunc(key.Value(), func(s string) string {
+				if err != nil {
+					return s
+				}
+				path := strings.TrimSpace(secretPlaceholderPattern.FindStringSubmatch(s)[1])
+				var secret string
+				if secret, err = resolve(path); err != nil {
+					err = fmt.Errorf("%s.%s: secret %q: %v", sec.Name(), key.Name(), path, err)
+				}
+				return secret
+			})
+			if err != nil {
+				return err
+			}
+			key.SetValue(value)
+		}
+	}
+	return nil
+}
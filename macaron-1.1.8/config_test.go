@@ -0,0 +1,393 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/ini.v1"
+)
+
+func Test_SetConfig_Formats(t *testing.T) {
+	Convey("Load configuration of multiple formats", t, func() {
+		dir, err := ioutil.TempDir("", "macaron-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer SetConfig([]byte(""))
+
+		write := func(name, data string) string {
+			name = filepath.Join(dir, name)
+			So(ioutil.WriteFile(name, []byte(data), 0644), ShouldBeNil)
+			return name
+		}
+
+		Convey("YAML", func() {
+			cfg, err := SetConfig(write("app.yaml", `---
+# Application
+app_name: "Macaron # app"
+debug: true
+database:
+  host: db.local   # primary
+  port: 5432
+  replica:
+    host: 'replica''s # host'
+  options: [sslmode=disable, "timeout=5s"]
+hosts:
+- a.example.com
+- b.example.com
+servers:
+  - name: one
+    weight: 1
+  - name: two
+motd: |
+  Hello,
+  world!
+summary: >-
+  folded
+  text
+empty:
+`))
+			So(err, ShouldBeNil)
+			So(cfg.Section("").Key("app_name").String(), ShouldEqual, "Macaron # app")
+			So(cfg.Section("").Key("debug").MustBool(), ShouldBeTrue)
+			So(cfg.Section("database").Key("host").String(), ShouldEqual, "db.local")
+			So(cfg.Section("database").Key("port").MustInt(), ShouldEqual, 5432)
+			So(cfg.Section("database.replica").Key("host").String(), ShouldEqual, "replica's # host")
+			So(cfg.Section("database").Key("options").Strings(","), ShouldResemble, []string{"sslmode=disable", "timeout=5s"})
+			So(cfg.Section("").Key("hosts").String(), ShouldEqual, "a.example.com,b.example.com")
+			So(cfg.Section("servers.0").Key("name").String(), ShouldEqual, "one")
+			So(cfg.Section("servers.0").Key("weight").String(), ShouldEqual, "1")
+			So(cfg.Section("servers.1").Key("name").String(), ShouldEqual, "two")
+			So(cfg.Section("").Key("motd").String(), ShouldEqual, "Hello,\nworld!\n")
+			So(cfg.Section("").Key("summary").String(), ShouldEqual, "folded text")
+			So(cfg.Section("").HasKey("empty"), ShouldBeTrue)
+			So(Config(), ShouldEqual, cfg)
+
+			_, err = SetConfig(write("bad.yml", "a: 1\n   b: 2\n"))
+			So(err, ShouldNotBeNil)
+			_, err = SetConfig(write("list.yml", "- a\n- b\n"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("TOML", func() {
+			cfg, err := SetConfig(write("app.toml", `
+title = "TOML \"example\"" # comment
+port = 8_080
+mask = 0x1F
+ratio = 1.5
+enabled = false
+created = 1979-05-27 07:32:00Z
+site.name = 'C:\Sites'
+
+[database]
+hosts = [
+  "a.local",
+  "b.local", # trailing comma
+]
+limits = { max = 10, "idle" = 2 }
+
+[database.replica]
+host = "replica.local"
+
+[[servers]]
+name = "one"
+
+[[servers]]
+name = "two"
+
+[text]
+motd = """
+Hello,
+world!"""
+joined = """one \
+         two"""
+raw = '''C:\Users'''
+`))
+			So(err, ShouldBeNil)
+			So(cfg.Section("").Key("title").String(), ShouldEqual, `TOML "example"`)
+			So(cfg.Section("").Key("port").MustInt(), ShouldEqual, 8080)
+			So(cfg.Section("").Key("mask").MustInt(), ShouldEqual, 31)
+			So(cfg.Section("").Key("ratio").MustFloat64(), ShouldEqual, 1.5)
+			So(cfg.Section("").Key("enabled").MustBool(true), ShouldBeFalse)
+			So(cfg.Section("").Key("created").String(), ShouldEqual, "1979-05-27 07:32:00Z")
+			So(cfg.Section("site").Key("name").String(), ShouldEqual, `C:\Sites`)
+			So(cfg.Section("database").Key("hosts").String(), ShouldEqual, "a.local,b.local")
+			So(cfg.Section("database.limits").Key("max").String(), ShouldEqual, "10")
+			So(cfg.Section("database.limits").Key("idle").String(), ShouldEqual, "2")
+			So(cfg.Section("database.replica").Key("host").String(), ShouldEqual, "replica.local")
+			So(cfg.Section("servers.0").Key("name").String(), ShouldEqual, "one")
+			So(cfg.Section("servers.1").Key("name").String(), ShouldEqual, "two")
+			So(cfg.Section("text").Key("motd").String(), ShouldEqual, "Hello,\nworld!")
+			So(cfg.Section("text").Key("joined").String(), ShouldEqual, "one two")
+			So(cfg.Section("text").Key("raw").String(), ShouldEqual, `C:\Users`)
+
+			for _, data := range []string{"a = 1\na = 2", "a = ", "a = bare", "[a\nb = 1", `a = "open`} {
+				_, err = SetConfig(write("bad.toml", data))
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("JSON and .env override INI", func() {
+			iniFile := write("app.ini", "name = ini\nmode = dev\n[server]\nport = 80\nhost = localhost\n")
+			jsonFile := write("app.json", `{"mode": "prod", "server": {"port": 8080, "tls": true, "ids": [1, 2]}, "nothing": null}`)
+			envFile := write(".env", `# Secrets
+export SECRET_KEY="s3cr\"et\n"
+DB_PASSWORD='p@ss#word'
+NAME=env # comment
+`)
+			cfg, err := SetConfig(iniFile, jsonFile, envFile)
+			So(err, ShouldBeNil)
+			So(cfg.Section("").Key("mode").String(), ShouldEqual, "prod")
+			So(cfg.Section("").Key("name").String(), ShouldEqual, "ini")
+			So(cfg.Section("").Key("nothing").String(), ShouldBeEmpty)
+			So(cfg.Section("server").Key("host").String(), ShouldEqual, "localhost")
+			So(cfg.Section("server").Key("port").MustInt(), ShouldEqual, 8080)
+			So(cfg.Section("server").Key("tls").MustBool(), ShouldBeTrue)
+			So(cfg.Section("server").Key("ids").String(), ShouldEqual, "1,2")
+			So(cfg.Section("").Key("SECRET_KEY").String(), ShouldEqual, "s3cr\"et\n")
+			So(cfg.Section("").Key("DB_PASSWORD").String(), ShouldEqual, "p@ss#word")
+			So(cfg.Section("").Key("NAME").String(), ShouldEqual, "env")
+
+			_, err = SetConfig(write("bad.json", `{"a": `))
+			So(err, ShouldNotBeNil)
+			_, err = SetConfig(write("bad.env", "no equal sign"))
+			So(err, ShouldNotBeNil)
+			_, err = SetConfig(filepath.Join(dir, "404.yaml"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_ConfigEnv(t *testing.T) {
+	Convey("Override configuration by environment variables", t, func() {
+		defer SetConfig([]byte(""))
+
+		f, err := ini.Load([]byte("APP_NAME = app\n[Server]\nHTTP_PORT = 80\n[database.replica]\nhost = a\n"))
+		So(err, ShouldBeNil)
+		So(applyConfigEnv(f, []string{
+			"PATH=/bin",
+			"MACARON__APP_NAME=env app",
+			"MACARON__SERVER__HTTP_PORT=8080",
+			"MACARON__DATABASE__REPLICA__HOST=b=c",
+			"MACARON__CACHE__TTL=1m",
+			"MACARON____INVALID=1",
+			"MACARON__INVALID__=1",
+		}), ShouldBeNil)
+		So(f.Section("").Key("APP_NAME").String(), ShouldEqual, "env app")
+		So(f.Section("Server").Key("HTTP_PORT").MustInt(), ShouldEqual, 8080)
+		So(f.Section("database.replica").Key("host").String(), ShouldEqual, "b=c")
+		So(f.Section("cache").Key("ttl").MustDuration(), ShouldEqual, time.Minute)
+		So(f.SectionStrings(), ShouldResemble, []string{ini.DEFAULT_SECTION, "Server", "database.replica", "cache"})
+
+		os.Setenv("MACARON__SERVER__PORT", "9090")
+		defer os.Unsetenv("MACARON__SERVER__PORT")
+		cfg, err := SetConfig([]byte("[server]\nport = 80\n"))
+		So(err, ShouldBeNil)
+		So(cfg.Section("server").Key("port").String(), ShouldEqual, "9090")
+	})
+}
+
+func Test_ReloadConfig(t *testing.T) {
+	Convey("Reload configuration and notify changes", t, func() {
+		dir, err := ioutil.TempDir("", "macaron-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer SetConfig([]byte(""))
+
+		name := filepath.Join(dir, "app.ini")
+		So(ioutil.WriteFile(name, []byte("level = info\nremoved = 1\n[limits]\nrate = 10\n"), 0644), ShouldBeNil)
+		_, err = SetConfig(name)
+		So(err, ShouldBeNil)
+		old := Config()
+
+		var changes [][]ConfigChange
+		cancel := OnConfigChange(func(c []ConfigChange) {
+			changes = append(changes, c)
+		})
+		defer cancel()
+
+		So(ioutil.WriteFile(name, []byte("level = debug\n[limits]\nrate = 10\nburst = 5\n"), 0644), ShouldBeNil)
+		So(ReloadConfig(), ShouldBeNil)
+		So(changes, ShouldResemble, [][]ConfigChange{{
+			{Section: ini.DEFAULT_SECTION, Key: "level", OldValue: "info", NewValue: "debug"},
+			{Section: ini.DEFAULT_SECTION, Key: "removed", OldValue: "1", Removed: true},
+			{Section: "limits", Key: "burst", NewValue: "5", Added: true},
+		}})
+		So(Config().Section("").Key("level").String(), ShouldEqual, "debug")
+		// Configuration is replaced rather than modified.
+		So(old.Section("").Key("level").String(), ShouldEqual, "info")
+
+		// Nothing is notified if values do not change.
+		So(ReloadConfig(), ShouldBeNil)
+		So(len(changes), ShouldEqual, 1)
+
+		// Configuration in use is kept if reloading fails.
+		So(ioutil.WriteFile(name, []byte("[broken"), 0644), ShouldBeNil)
+		So(ReloadConfig(), ShouldNotBeNil)
+		So(Config().Section("").Key("level").String(), ShouldEqual, "debug")
+
+		cancel()
+		So(ioutil.WriteFile(name, []byte("level = warn\n"), 0644), ShouldBeNil)
+		So(ReloadConfig(), ShouldBeNil)
+		So(len(changes), ShouldEqual, 1)
+	})
+
+	Convey("Watch configuration files", t, func() {
+		dir, err := ioutil.TempDir("", "macaron-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer SetConfig([]byte(""))
+
+		name := filepath.Join(dir, "app.yaml")
+		So(ioutil.WriteFile(name, []byte("flags:\n  beta: false\n"), 0644), ShouldBeNil)
+		_, err = SetConfig(name)
+		So(err, ShouldBeNil)
+
+		changed := make(chan []ConfigChange, 1)
+		cancel := OnConfigChange(func(c []ConfigChange) {
+			changed <- c
+		})
+		defer cancel()
+		stop := WatchConfig(10*time.Millisecond, nil)
+		defer stop()
+
+		So(ioutil.WriteFile(name, []byte("flags:\n  beta: true\n"), 0644), ShouldBeNil)
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(name, future, future)
+
+		select {
+		case c := <-changed:
+			So(c, ShouldResemble, []ConfigChange{{Section: "flags", Key: "beta", OldValue: "false", NewValue: "true"}})
+		case <-time.After(5 * time.Second):
+			So("configuration is not reloaded", ShouldBeEmpty)
+		}
+		So(Config().Section("flags").Key("beta").MustBool(), ShouldBeTrue)
+	})
+}
+
+func Test_ConfigOverlay(t *testing.T) {
+	Convey("Overlay configuration of current environment", t, func() {
+		So(configOverlay("conf/app.ini", PROD), ShouldEqual, "conf/app.production.ini")
+		So(configOverlay("conf/app.yaml", TEST), ShouldEqual, "conf/app.test.yaml")
+		So(configOverlay(".env", PROD), ShouldEqual, ".env.production")
+		So(configOverlay("conf/.env", PROD), ShouldEqual, "conf/.env.production")
+
+		dir, err := ioutil.TempDir("", "macaron-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer SetConfig([]byte(""))
+
+		env := safeEnv()
+		defer func() {
+			envLock.Lock()
+			Env = env
+			envLock.Unlock()
+		}()
+		setENV(PROD)
+
+		write := func(name, data string) string {
+			name = filepath.Join(dir, name)
+			So(ioutil.WriteFile(name, []byte(data), 0644), ShouldBeNil)
+			return name
+		}
+		app := write("app.ini", "name = app\nmode = dev\nlevel = debug\n")
+		write("app.production.ini", "mode = prod\nlevel = info\n")
+		write("app.test.ini", "mode = test\n")
+		local := write("local.json", `{"level": "warn"}`)
+		dotenv := write(".env", "SECRET=dev\n")
+		write(".env.production", "SECRET=prod\n")
+
+		cfg, err := SetConfig(app, local, dotenv)
+		So(err, ShouldBeNil)
+		So(cfg.Section("").Key("name").String(), ShouldEqual, "app")
+		So(cfg.Section("").Key("mode").String(), ShouldEqual, "prod")
+		// Later sources take precedence over overlays of earlier ones.
+		So(cfg.Section("").Key("level").String(), ShouldEqual, "warn")
+		So(cfg.Section("").Key("SECRET").String(), ShouldEqual, "prod")
+
+		// Overlays given explicitly are not loaded twice.
+		So(withConfigOverlays([]interface{}{app, filepath.Join(dir, "app.production.ini")}), ShouldHaveLength, 2)
+		So(withConfigOverlays([]interface{}{[]byte("a = 1"), local}), ShouldHaveLength, 2)
+
+		setENV(TEST)
+		So(ReloadConfig(), ShouldBeNil)
+		So(Config().Section("").Key("mode").String(), ShouldEqual, "test")
+	})
+}
+
+func Test_Macaron_SetConfig(t *testing.T) {
+	Convey("Set configuration per instance", t, func() {
+		_, err := SetConfig([]byte("name = global\n"))
+		So(err, ShouldBeNil)
+		defer SetConfig([]byte(""))
+
+		m1, m2, m3 := New(), New(), New()
+		_, err = m1.SetConfig([]byte("name = one\n"))
+		So(err, ShouldBeNil)
+		_, err = m2.SetConfig([]byte("name = two\n"))
+		So(err, ShouldBeNil)
+
+		So(m1.Config().Section("").Key("name").String(), ShouldEqual, "one")
+		So(m2.Config().Section("").Key("name").String(), ShouldEqual, "two")
+		// Instances without their own configuration use the global one.
+		So(m3.Config().Section("").Key("name").String(), ShouldEqual, "global")
+		So(Config().Section("").Key("name").String(), ShouldEqual, "global")
+
+		Convey("Inject configuration of the instance", func() {
+			for _, m := range []*Macaron{m1, m2} {
+				m.Get("/", func(cfg *ini.File) string {
+					return cfg.Section("").Key("name").String()
+				})
+			}
+			for m, name := range map[*Macaron]string{m1: "one", m2: "two"} {
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest("GET", "/", nil)
+				So(err, ShouldBeNil)
+				m.ServeHTTP(resp, req)
+				So(resp.Body.String(), ShouldEqual, name)
+			}
+		})
+
+		Convey("Reload configuration of the instance", func() {
+			dir, err := ioutil.TempDir("", "macaron-config")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			name := filepath.Join(dir, "app.ini")
+			So(ioutil.WriteFile(name, []byte("name = one\n"), 0644), ShouldBeNil)
+			_, err = m1.SetConfig(name)
+			So(err, ShouldBeNil)
+
+			var local, global int
+			defer m1.OnConfigChange(func([]ConfigChange) { local++ })()
+			defer OnConfigChange(func([]ConfigChange) { global++ })()
+
+			So(ioutil.WriteFile(name, []byte("name = uno\n"), 0644), ShouldBeNil)
+			So(m1.ReloadConfig(), ShouldBeNil)
+			So(m1.Config().Section("").Key("name").String(), ShouldEqual, "uno")
+			So(local, ShouldEqual, 1)
+			So(global, ShouldEqual, 0)
+			So(m2.ReloadConfig(), ShouldBeNil)
+			So(m2.Config().Section("").Key("name").String(), ShouldEqual, "two")
+		})
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// charsetAliases maps common misspellings of charsets to their registered names.
+var charsetAliases = map[string]string{
+	"utf8":   "utf-8",
+	"latin1": "iso-8859-1",
+}
+
+// normalizeContentType returns the content type with lowercase media type and charset,
+// or false if it cannot be parsed.
+func normalizeContentType(contentType string) (string, bool) {
+	typ, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	if charset, ok := params["charset"]; ok {
+		charset = strings.ToLower(strings.TrimSpace(charset))
+		if alias, ok := charsetAliases[charset]; ok {
+			charset = alias
+		}
+		params["charset"] = charset
+	}
+	return mime.FormatMediaType(typ, params), true
+}
+
+// matchMediaType returns true if the media type matches the pattern, which can be a wildcard
+// of subtypes, e.g. "text/*", or of structured syntax suffix, e.g. "application/*+json".
+func matchMediaType(pattern, typ string) bool {
+	pattern = strings.ToLower(pattern)
+	switch {
+	case pattern == "*/*", pattern == typ:
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(typ, pattern[:len(pattern)-1])
+	case strings.Contains(pattern, "/*+"):
+		i := strings.Index(pattern, "*")
+		return strings.HasPrefix(typ, pattern[:i]) && strings.HasSuffix(typ, pattern[i+1:])
+	}
+	return false
+}
+
+// writeSupportedError writes an error page with given status that lists supported media types,
+// as JSON or plain text depends on the Accept header of the request.
+func writeSupportedError(ctx *Context, status int, supported []string) {
+	if preferJSON(ctx.Req.Request) {
+		if ctx.Router != nil && ctx.m != nil && ctx.m.problemDetails {
+			ctx.Problem(status, "", "", "", map[string]interface{}{"supported": supported})
+			return
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"status":    status,
+			"error":     http.StatusText(status),
+			"supported": supported,
+		})
+		ctx.Resp.Header().Set(_CONTENT_TYPE, _CONTENT_JSON+"; charset="+_DEFAULT_CHARSET)
+		ctx.Resp.WriteHeader(status)
+		ctx.Resp.Write(data)
+		return
+	}
+	http.Error(ctx.Resp, http.StatusText(status)+"\nSupported media types: "+strings.Join(supported, ", "), status)
+}
+
+// hasBody returns true if the request has a body to be read.
+func hasBody(req *http.Request) bool {
+	return req.ContentLength > 0 || (req.ContentLength < 0 && req.Body != nil && req.Body != http.NoBody)
+}
+
+// RequireContentType returns a middleware handler that responds with 415 Unsupported Media Type
+// to requests with body whose Content-Type does not match any of given types, which can be
+// wildcards, e.g. "text/*". Body of the response lists supported types. Content-Type of accepted
+// requests is normalized to lowercase media type and charset, e.g. "Application/JSON; charset=UTF8"
+// becomes "application/json; charset=utf-8", so handlers and binders can compare it directly.
+// It can be used per route or group, e.g.
+//
+//	m.Group("/api", func() {
+//		...
+//	}, macaron.RequireContentType("application/json", "application/*+json"))
+func RequireContentType(types ...string) Handler {
+	if len(types) == 0 {
+		panic("content type: no type is allowed")
+	}
+
+	return func(ctx *Context) {
+		if !hasBody(ctx.Req.Request) {
+			return
+		}
+
+		contentType, ok := normalizeContentType(ctx.Req.Header.Get(_CONTENT_TYPE))
+		if ok {
+			typ := strings.TrimSpace(strings.Split(contentType, ";")[0])
+			for _, pattern := range types {
+				if matchMediaType(pattern, typ) {
+					ctx.Req.Header.Set(_CONTENT_TYPE, contentType)
+					return
+				}
+			}
+		}
+
+		switch ctx.Req.Method {
+		case "POST":
+			ctx.Resp.Header().Set("Accept-Post", strings.Join(types, ", "))
+		case "PATCH":
+			ctx.Resp.Header().Set("Accept-Patch", strings.Join(types, ", "))
+		}
+		writeSupportedError(ctx, http.StatusUnsupportedMediaType, types)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RequireContentType(t *testing.T) {
+	Convey("Require content type of requests", t, func() {
+		m := New()
+		m.Group("/api", func() {
+			m.Post("/", func(ctx *Context) string {
+				return ctx.Req.Header.Get("Content-Type")
+			})
+			m.Delete("/", func() string { return "deleted" })
+		}, RequireContentType("application/json", "application/*+json", "text/*"))
+
+		serve := func(method, contentType, accept, body string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, "/api/", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			if len(contentType) > 0 {
+				req.Header.Set("Content-Type", contentType)
+			}
+			if len(accept) > 0 {
+				req.Header.Set("Accept", accept)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("POST", "Application/JSON; Charset=\"UTF8\"", "", "{}")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "application/json; charset=utf-8")
+
+		resp = serve("POST", "application/merge-patch+json", "", "{}")
+		So(resp.Body.String(), ShouldEqual, "application/merge-patch+json")
+
+		resp = serve("POST", "text/csv", "", "a,b")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+
+		resp = serve("DELETE", "", "", "")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+
+		Convey("Reject unsupported content types", func() {
+			resp := serve("POST", "application/x-www-form-urlencoded", "", "a=b")
+			So(resp.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+			So(resp.Header().Get("Accept-Post"), ShouldEqual, "application/json, application/*+json, text/*")
+			So(resp.Body.String(), ShouldContainSubstring, "Supported media types: application/json")
+
+			resp = serve("POST", "", "application/json", "{}")
+			So(resp.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+			So(resp.Body.String(), ShouldEqual, `{"error":"Unsupported Media Type","status":415,"supported":["application/json","application/*+json","text/*"]}`)
+
+			resp = serve("POST", "application/json; charset", "", "{}")
+			So(resp.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+	})
+
+	Convey("Match media types", t, func() {
+		So(matchMediaType("*/*", "image/png"), ShouldBeTrue)
+		So(matchMediaType("image/*", "image/png"), ShouldBeTrue)
+		So(matchMediaType("image/*", "text/plain"), ShouldBeFalse)
+		So(matchMediaType("application/*+json", "application/ld+json"), ShouldBeTrue)
+		So(matchMediaType("application/*+json", "application/json"), ShouldBeFalse)
+		So(matchMediaType("Application/JSON", "application/json"), ShouldBeTrue)
+	})
+}
@@ -15,6 +15,7 @@
 package macaron
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"html/template"
@@ -29,6 +30,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Unknwon/com"
@@ -74,6 +76,9 @@ func (r *Request) Body() *RequestBody {
 
 // Context represents the runtime context of current request of Macaron instance.
 // It is the integration of most frequently used middlewares and helper methods.
+//
+// Contexts are reused for later requests once handlers return, so they must not be used
+// after that, except in functions run by Go, or after Retain is called.
 type Context struct {
 	inject.Injector
 	handlers []Handler
@@ -83,10 +88,98 @@ type Context struct {
 	*Router
 	Req    Request
 	Resp   ResponseWriter
-	params Params
+	params routeParams
+	// Full pattern of matched route.
+	pattern string
 	Render
 	Locale
+	// Data is never nil, so handlers can write to it directly. It is not allocated on first use
+	// because a nil map would panic on those writes; instead the map is reused along with the
+	// context, cleared, so most requests do not allocate it.
 	Data map[string]interface{}
+
+	requestID string
+	// Types of services mapped to current request.
+	mapped []reflect.Type
+	// Services mapped by name to current request.
+	named namedServices
+	// Types of services being provided, to detect cyclic dependencies.
+	providing map[reflect.Type]bool
+	// Options of Recovery middleware that handles current request.
+	recovery *RecoveryOptions
+	// Request body before being limited by MaxBody.
+	rawBody io.ReadCloser
+	// Media types that current route produces, declared by Produces middleware.
+	produces []string
+
+	// Writer of the response created for the request, which is reused along with the context.
+	rw responseWriter
+	// Whether the context may be used after the request, so it is not reused.
+	retained bool
+}
+
+// contextPool keeps contexts of finished requests to be reused.
+var contextPool = sync.Pool{
+	New: func() interface{} {
+		return new(Context)
+	},
+}
+
+// maxPooledData is the number of entries beyond which Data maps are not reused, because
+// maps do not shrink and clearing a large map costs even if later requests barely use it.
+const maxPooledData = 64
+
+// clearData clears the data map of a finished request to be reused, or returns nil if it
+// has grown too large to be kept.
+func clearData(data map[string]interface{}) map[string]interface{} {
+	if len(data) > maxPooledData {
+		return nil
+	}
+	for k := range data {
+		delete(data, k)
+	}
+	return data
+}
+
+// Retain keeps the context from being reused after the request, so it can be used later,
+// e.g. by goroutines that handlers start. Functions run by Go retain the context already.
+func (c *Context) Retain() {
+	c.retained = true
+}
+
+// release puts the context back to the pool unless it is retained. References to values of
+// the request are cleared so they can be collected.
+func (c *Context) release() {
+	if c.retained {
+		return
+	}
+	data, mapped := clearData(c.Data), c.mapped
+	for i := range mapped {
+		mapped[i] = nil
+	}
+	*c = Context{Data: data, mapped: mapped[:0]}
+	contextPool.Put(c)
+}
+
+// Map maps the value as a service of its own type for current request.
+func (c *Context) Map(val interface{}) inject.TypeMapper {
+	c.Injector.Map(val)
+	c.mapped = append(c.mapped, reflect.TypeOf(val))
+	return c
+}
+
+// MapTo maps the value as a service of the interface type that ifacePtr points to for current request.
+func (c *Context) MapTo(val interface{}, ifacePtr interface{}) inject.TypeMapper {
+	c.Injector.MapTo(val, ifacePtr)
+	c.mapped = append(c.mapped, inject.InterfaceOf(ifacePtr))
+	return c
+}
+
+// Set maps the value as a service of given type for current request.
+func (c *Context) Set(typ reflect.Type, val reflect.Value) inject.TypeMapper {
+	c.Injector.Set(typ, val)
+	c.mapped = append(c.mapped, typ)
+	return c
 }
 
 func (c *Context) handler() Handler {
@@ -99,6 +192,16 @@ func (c *Context) handler() Handler {
 	panic("invalid index for context handler")
 }
 
+// hasLater returns true if any handler after the current one in the chain matches.
+func (c *Context) hasLater(match func(Handler) bool) bool {
+	for i := c.index + 1; i < len(c.handlers); i++ {
+		if match(c.handlers[i]) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Context) Next() {
 	c.index += 1
 	c.run()
@@ -110,7 +213,7 @@ func (c *Context) Written() bool {
 
 func (c *Context) run() {
 	for c.index <= len(c.handlers) {
-		vals, err := c.Invoke(c.handler())
+		vals, err := c.invoke(c.handler())
 		if err != nil {
 			panic(err)
 		}
@@ -118,7 +221,7 @@ func (c *Context) run() {
 
 		// if the handler returned something, write it to the http response
 		if len(vals) > 0 {
-			ev := c.GetVal(reflect.TypeOf(ReturnHandler(nil)))
+			ev := c.GetVal(returnHandlerType)
 			handleReturn := ev.Interface().(ReturnHandler)
 			handleReturn(c, vals)
 		}
@@ -129,6 +232,32 @@ func (c *Context) run() {
 	}
 }
 
+// Go runs fn in a new goroutine, a panic inside of it is recovered rather than crashing
+// the whole process. The panic is logged and reported to the OnPanic hook in the same way
+// as Recovery middleware does, but no response is written because the request may have
+// been finished already.
+func (c *Context) Go(fn func()) {
+	c.retained = true
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				opt := RecoveryOptions{}
+				if c.recovery != nil {
+					opt = *c.recovery
+				}
+				logPanic(opt, c, c.m.ErrorLogger(), err, callers(3))
+			}
+		}()
+		fn()
+	}()
+}
+
+// Context returns the context of the request, which is canceled when the client goes away.
+// Middleware like Timeout and Tracing replace it, so it should be passed to downstream calls.
+func (ctx *Context) Context() context.Context {
+	return ctx.Req.Context()
+}
+
 // RemoteAddr returns more real IP address.
 func (ctx *Context) RemoteAddr() string {
 	addr := ctx.Req.Header.Get("X-Real-IP")
@@ -144,6 +273,47 @@ func (ctx *Context) RemoteAddr() string {
 	return addr
 }
 
+const (
+	_REQUEST_ID = "X-Request-Id"
+	// maxRequestIDLength is the maximum length of request IDs taken from requests.
+	maxRequestIDLength = 64
+)
+
+// isRequestID returns true if the ID is short and only has letters, digits, '.', '_' and '-',
+// so that it is safe to be logged and passed on.
+func isRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns the ID of current request, which is either set by
+// SetRequestID or taken from the X-Request-Id header of the request.
+// A random ID is generated if the header is missing, or is not an ID of
+// at most 64 letters, digits, '.', '_' and '-'.
+func (ctx *Context) RequestID() string {
+	if len(ctx.requestID) == 0 {
+		if id := ctx.Req.Header.Get(_REQUEST_ID); isRequestID(id) {
+			ctx.requestID = id
+		} else {
+			ctx.requestID = randomHex(16)
+		}
+	}
+	return ctx.requestID
+}
+
+// SetRequestID sets the ID of current request.
+func (ctx *Context) SetRequestID(id string) {
+	ctx.requestID = id
+}
+
 func (ctx *Context) renderHTML(status int, setName, tplName string, data ...interface{}) {
 	if len(data) <= 0 {
 		ctx.Render.HTMLSet(status, setName, tplName, ctx.Data)
@@ -173,6 +343,32 @@ func (ctx *Context) Redirect(location string, status ...int) {
 	http.Redirect(ctx.Resp, ctx.Req.Request, location, code)
 }
 
+// AddTrailer adds a value of given HTTP trailer, which is sent to the client after the
+// response body, e.g. a checksum of streamed content. The trailer is declared in the
+// "Trailer" header as well if the response header has not been sent yet.
+func (ctx *Context) AddTrailer(key, value string) {
+	key = http.CanonicalHeaderKey(key)
+	sent := ctx.Resp.Written()
+	if rw, ok := baseResponseWriter(ctx.Resp); ok {
+		sent = rw.headerSent()
+	}
+	if !sent && !com.IsSliceContainsStr(ctx.Resp.Header()["Trailer"], key) {
+		ctx.Resp.Header().Add("Trailer", key)
+	}
+	ctx.Resp.Header().Add(http.TrailerPrefix+key, value)
+}
+
+// Push initiates an HTTP/2 server push of given target, e.g. critical CSS and JS of the page.
+// It returns http.ErrNotSupported if the connection does not support server push,
+// so it is safe to be called for any request.
+func (ctx *Context) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := ctx.Resp.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
 // Maximum amount of memory to use when parsing a multipart form.
 // Set this to whatever value you prefer; default is 10 MB.
 var MaxMemory = int64(1024 * 1024 * 10)
@@ -249,7 +445,13 @@ func (ctx *Context) Params(name string) string {
 	if len(name) > 1 && name[0] != ':' {
 		name = ":" + name
 	}
-	return ctx.params[name]
+	return ctx.params.get(name)
+}
+
+// RoutePattern returns the pattern of the route that matches current request,
+// e.g. "/user/:id". It returns empty string if no route is matched.
+func (ctx *Context) RoutePattern() string {
+	return ctx.pattern
 }
 
 // SetParams sets value of param with given name.
@@ -257,7 +459,16 @@ func (ctx *Context) SetParams(name, val string) {
 	if !strings.HasPrefix(name, ":") {
 		name = ":" + name
 	}
-	ctx.params[name] = val
+	ctx.params.set(name, val)
+}
+
+type paramsContextKey struct{}
+
+// ParamsFromContext returns route params in the context of requests served by http.Handler
+// values used as handlers, or nil if there is none. Names of params are prefixed by ":", e.g. ":uid".
+func ParamsFromContext(ctx context.Context) Params {
+	params, _ := ctx.Value(paramsContextKey{}).(Params)
+	return params
 }
 
 // ParamsEscape returns escapred params result.
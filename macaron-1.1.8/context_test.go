@@ -16,10 +16,12 @@ package macaron
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -373,3 +375,145 @@ func Test_Context_Redirect(t *testing.T) {
 		So(resp.HeaderMap["Location"][0], ShouldEqual, "/path/two")
 	})
 }
+
+func Test_Context_Push(t *testing.T) {
+	Convey("Push assets with HTTP/2 server push", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) string {
+			So(ctx.Push("/app.css", nil), ShouldBeNil)
+			So(ctx.Push("/app.js", &http.PushOptions{Method: "GET"}), ShouldBeNil)
+			return "page"
+		})
+
+		resp := newPushableRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.pushed, ShouldResemble, []string{"/app.css", "/app.js"})
+		So(resp.Body.String(), ShouldEqual, "page")
+	})
+
+	Convey("Push without server push support", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) {
+			So(ctx.Push("/app.css", nil), ShouldEqual, http.ErrNotSupported)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+	})
+}
+
+func Test_Context_AddTrailer(t *testing.T) {
+	Convey("Send HTTP trailers after response body", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) {
+			ctx.AddTrailer("x-checksum", "c0ffee")
+			ctx.Resp.Write([]byte("Hello world"))
+			ctx.AddTrailer("X-Status", "done")
+		})
+
+		ts := httptest.NewServer(m)
+		defer ts.Close()
+
+		resp, err := http.Get(ts.URL)
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		_, declared := resp.Trailer["X-Checksum"]
+		So(declared, ShouldBeTrue)
+		_, declared = resp.Trailer["X-Status"]
+		So(declared, ShouldBeFalse)
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Hello world")
+		So(resp.Trailer.Get("X-Checksum"), ShouldEqual, "c0ffee")
+		So(resp.Trailer.Get("X-Status"), ShouldEqual, "done")
+	})
+
+	Convey("Declare trailers of buffered response", t, func() {
+		m := New()
+		m.Get("/", Buffered(), func(ctx *Context) {
+			ctx.Resp.Write([]byte("Hello world"))
+			ctx.AddTrailer("X-Checksum", "c0ffee")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Result().Header.Get("Trailer"), ShouldEqual, "X-Checksum")
+		So(resp.Result().Trailer.Get("X-Checksum"), ShouldEqual, "c0ffee")
+	})
+}
+
+func Test_Context_Pool(t *testing.T) {
+	Convey("Reuse contexts of finished requests", t, func() {
+		type service struct{ name string }
+
+		m := New()
+		var contexts []*Context
+		m.Get("/:name", func(ctx *Context) string {
+			contexts = append(contexts, ctx)
+			// Nothing is left by earlier requests.
+			_, hasData := ctx.Data["name"]
+			hasService := ctx.GetVal(reflect.TypeOf(&service{})).IsValid()
+			status, requestID := ctx.Resp.Status(), ctx.requestID
+
+			ctx.Data["name"] = ctx.Params("name")
+			ctx.Map(&service{ctx.Params("name")})
+			ctx.SetRequestID(ctx.Params("name"))
+			if ctx.Query("retain") == "1" {
+				ctx.Retain()
+			}
+			return fmt.Sprint(hasData, hasService, status, requestID == "")
+		})
+
+		for _, name := range []string{"a", "b?retain=1", "c"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/"+name, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "false false 0 true")
+		}
+
+		// Retained contexts keep their values, and are never reused.
+		So(contexts[1].Data["name"], ShouldEqual, "b")
+		So(contexts[2], ShouldNotEqual, contexts[1])
+	})
+
+	Convey("Reuse data maps unless they are large", t, func() {
+		data := map[string]interface{}{"a": 1, "b": 2}
+		So(clearData(data), ShouldBeEmpty)
+		So(clearData(data), ShouldNotBeNil)
+
+		for i := 0; i <= maxPooledData; i++ {
+			data[fmt.Sprint(i)] = i
+		}
+		So(clearData(data), ShouldBeNil)
+		So(clearData(nil), ShouldBeNil)
+	})
+
+	Convey("Contexts used by goroutines are not reused", t, func() {
+		m := New()
+		done := make(chan string)
+		m.Get("/", func(ctx *Context) {
+			ctx.Data["name"] = "go"
+			ctx.Go(func() {
+				time.Sleep(10 * time.Millisecond)
+				done <- ctx.Data["name"].(string)
+			})
+		})
+		m.Get("/other", func(ctx *Context) {
+			ctx.Data["name"] = "other"
+		})
+
+		for _, path := range []string{"/", "/other", "/other"} {
+			req, err := http.NewRequest("GET", path, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		So(<-done, ShouldEqual, "go")
+	})
+}
@@ -0,0 +1,196 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions is a struct for specifying configuration options for the macaron.CORS middleware.
+type CORSOptions struct {
+	// AllowOrigins is the list of origins allowed to make cross-origin requests, e.g.
+	// "https://example.com". An origin can contain a "*" as wildcard, e.g. "https://*.example.com",
+	// and a single "*" allows all origins. Default is "*".
+	AllowOrigins []string
+	// AllowOriginRegexps is the list of regular expressions that allowed origins match in addition to AllowOrigins.
+	AllowOriginRegexps []string
+	// AllowMethods is the list of methods allowed in cross-origin requests.
+	// Default is GET, POST, PUT, PATCH, DELETE and HEAD.
+	AllowMethods []string
+	// AllowHeaders is the list of headers allowed in cross-origin requests.
+	// Headers asked by preflight request are allowed if it is empty.
+	AllowHeaders []string
+	// ExposeHeaders is the list of response headers that clients are allowed to access.
+	ExposeHeaders []string
+	// AllowCredentials indicates whether cookies and HTTP authentication can be sent in cross-origin requests.
+	// Origins must be listed to allow credentials, it can't be used with "*" or origins like "https://*".
+	AllowCredentials bool
+	// MaxAge is how long the result of preflight request can be cached by clients.
+	MaxAge time.Duration
+}
+
+type corsOrigins struct {
+	all      bool
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func newCORSOrigins(opt CORSOptions) *corsOrigins {
+	o := &corsOrigins{exact: make(map[string]bool)}
+	for _, origin := range opt.AllowOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			o.all = true
+		case strings.Contains(origin, "*"):
+			parts := strings.Split(origin, "*")
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			o.patterns = append(o.patterns, regexp.MustCompile("^"+strings.Join(parts, "[^/]*")+"$"))
+		default:
+			o.exact[origin] = true
+		}
+	}
+	for _, expr := range opt.AllowOriginRegexps {
+		o.patterns = append(o.patterns, regexp.MustCompile(expr))
+	}
+	return o
+}
+
+func (o *corsOrigins) allowed(origin string) bool {
+	if o.all {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if o.exact[origin] {
+		return true
+	}
+	for _, p := range o.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func prepareCORSOptions(options []CORSOptions) CORSOptions {
+	var opt CORSOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.AllowOrigins) == 0 && len(opt.AllowOriginRegexps) == 0 {
+		opt.AllowOrigins = []string{"*"}
+	}
+	if len(opt.AllowMethods) == 0 {
+		opt.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+	}
+	methods := make([]string, len(opt.AllowMethods))
+	for i := range opt.AllowMethods {
+		methods[i] = strings.ToUpper(opt.AllowMethods[i])
+	}
+	opt.AllowMethods = methods
+	headers := make([]string, len(opt.AllowHeaders))
+	for i := range opt.AllowHeaders {
+		headers[i] = http.CanonicalHeaderKey(opt.AllowHeaders[i])
+	}
+	opt.AllowHeaders = headers
+
+	if opt.AllowCredentials {
+		for _, origin := range opt.AllowOrigins {
+			if isAnyOrigin(origin) {
+				panic("cors: credentials cannot be allowed for any origin " + origin)
+			}
+		}
+	}
+	return opt
+}
+
+// isAnyOrigin returns true if the origin has nothing but wildcards besides the scheme,
+// which allows all origins, or all of the scheme.
+func isAnyOrigin(origin string) bool {
+	rest := strings.Replace(origin, "*", "", -1)
+	return len(rest) == 0 || strings.HasSuffix(rest, "://")
+}
+
+// CORS returns a middleware handler that allows cross-origin requests from allowed origins,
+// and responds to preflight requests. It should be used globally so that preflight requests
+// to routes without an OPTIONS handler are also handled.
+func CORS(options ...CORSOptions) Handler {
+	opt := prepareCORSOptions(options)
+	origins := newCORSOrigins(opt)
+	allowMethods := strings.Join(opt.AllowMethods, ", ")
+	allowHeaders := strings.Join(opt.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(opt.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(int(opt.MaxAge / time.Second))
+	// Response differs by origin unless all origins get the same "*".
+	varyOrigin := !origins.all || opt.AllowCredentials
+
+	return func(ctx *Context) {
+		header := ctx.Resp.Header()
+		origin := ctx.Req.Header.Get("Origin")
+		preflight := ctx.Req.Method == "OPTIONS" && len(ctx.Req.Header.Get("Access-Control-Request-Method")) > 0
+
+		if varyOrigin {
+			header.Add("Vary", "Origin")
+		}
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if len(origin) == 0 {
+			return
+		}
+		if !origins.allowed(origin) {
+			if preflight {
+				ctx.Resp.WriteHeader(http.StatusForbidden)
+			}
+			return
+		}
+
+		if varyOrigin {
+			header.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+		if opt.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(exposeHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", allowMethods)
+		if len(allowHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", allowHeaders)
+		} else if reqHeaders := ctx.Req.Header.Get("Access-Control-Request-Headers"); len(reqHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if opt.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		ctx.Resp.WriteHeader(http.StatusNoContent)
+	}
+}
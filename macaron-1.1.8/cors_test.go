@@ -0,0 +1,121 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CORS(t *testing.T) {
+	Convey("Allow all origins", t, func() {
+		m := New()
+		m.Use(CORS())
+		m.Get("/", func() string { return "ok" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Origin", "https://example.com")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Body.String(), ShouldEqual, "ok")
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+		So(resp.Header().Get("Vary"), ShouldBeBlank)
+	})
+
+	Convey("Allow listed origins", t, func() {
+		m := New()
+		m.Use(CORS(CORSOptions{
+			AllowOrigins:       []string{"https://example.com", "https://*.example.org"},
+			AllowOriginRegexps: []string{`^https://app-\d+\.example\.net$`},
+			AllowCredentials:   true,
+			ExposeHeaders:      []string{"X-Total-Count"},
+		}))
+		m.Get("/", func() string { return "ok" })
+
+		for origin, allowed := range map[string]bool{
+			"https://example.com":        true,
+			"https://EXAMPLE.com":        true,
+			"https://api.example.org":    true,
+			"https://app-42.example.net": true,
+			"https://app-x.example.net":  false,
+			"https://example.com.evil":   false,
+			"http://example.com":         false,
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Origin", origin)
+			m.ServeHTTP(resp, req)
+
+			So(resp.Body.String(), ShouldEqual, "ok")
+			So(resp.Header().Get("Vary"), ShouldEqual, "Origin")
+			if allowed {
+				So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, origin)
+				So(resp.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+				So(resp.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Total-Count")
+			} else {
+				So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldBeBlank)
+			}
+		}
+	})
+
+	Convey("Refuse to allow credentials for any origin", t, func() {
+		for _, origins := range [][]string{nil, {"*"}, {"https://example.com", "https://*"}, {"**"}} {
+			So(func() {
+				CORS(CORSOptions{AllowOrigins: origins, AllowCredentials: true})
+			}, ShouldPanic)
+		}
+		So(func() {
+			CORS(CORSOptions{AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true})
+		}, ShouldNotPanic)
+	})
+
+	Convey("Respond to preflight request", t, func() {
+		m := New()
+		m.Use(CORS(CORSOptions{
+			AllowOrigins: []string{"https://example.com"},
+			AllowMethods: []string{"get", "post"},
+			MaxAge:       10 * time.Minute,
+		}))
+		m.Post("/users", func() string { return "created" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("OPTIONS", "/users", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type, X-Token")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusNoContent)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://example.com")
+		So(resp.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST")
+		So(resp.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Content-Type, X-Token")
+		So(resp.Header().Get("Access-Control-Max-Age"), ShouldEqual, "600")
+		So(resp.Header()["Vary"], ShouldResemble, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"})
+
+		resp = httptest.NewRecorder()
+		req.Header.Set("Origin", "https://evil.com")
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusForbidden)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldBeBlank)
+	})
+}
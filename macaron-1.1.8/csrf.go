@@ -0,0 +1,176 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// CSRFStore keeps CSRF tokens on server side, e.g. in sessions, so that tokens
+// are checked against the stored ones rather than a cookie.
+type CSRFStore interface {
+	// Get returns token of the client, or empty string if it has none.
+	Get(ctx *Context) string
+	// Set saves the token for the client.
+	Set(ctx *Context, token string)
+}
+
+// CSRFOptions is a struct for specifying configuration options for the macaron.CSRF middleware.
+type CSRFOptions struct {
+	// Cookie is the name of cookie that keeps the token. Default is "_csrf".
+	Cookie string
+	// CookiePath is the path of the cookie. Default is "/".
+	CookiePath string
+	// CookieDomain is the domain of the cookie.
+	CookieDomain string
+	// Secure forces the cookie to be sent over HTTPS only,
+	// it is always the case for requests over TLS.
+	Secure bool
+	// SameSite is the SameSite attribute of the cookie. Default is http.SameSiteLaxMode.
+	SameSite http.SameSite
+	// Header is the name of header to submit the token by AJAX requests. Default is "X-CSRF-Token".
+	Header string
+	// Form is the name of form field to submit the token. Default is "_csrf".
+	Form string
+	// Store keeps tokens on server side instead of the cookie, e.g. in sessions,
+	// in which case the cookie is not used at all.
+	Store CSRFStore
+}
+
+func prepareCSRFOptions(options []CSRFOptions) CSRFOptions {
+	var opt CSRFOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Cookie) == 0 {
+		opt.Cookie = "_csrf"
+	}
+	if len(opt.CookiePath) == 0 {
+		opt.CookiePath = "/"
+	}
+	if opt.SameSite == 0 {
+		opt.SameSite = http.SameSiteLaxMode
+	}
+	if len(opt.Header) == 0 {
+		opt.Header = "X-CSRF-Token"
+	}
+	if len(opt.Form) == 0 {
+		opt.Form = "_csrf"
+	}
+	return opt
+}
+
+// CSRFToken is the CSRF token of current request, which is mapped as a service by CSRF middleware.
+type CSRFToken struct {
+	// Value is the token that requests must submit.
+	Value string
+	// Header is the name of header to submit the token by AJAX requests.
+	Header string
+	// Form is the name of form field to submit the token.
+	Form string
+}
+
+// Field returns the hidden form field that submits the token.
+func (t *CSRFToken) Field() template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(t.Form), template.HTMLEscapeString(t.Value)))
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("csrf: fail to generate token: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// csrfExempt is the type of SkipCSRF, which tells it from other handlers.
+type csrfExempt func()
+
+// SkipCSRF exempts a route from CSRF protection when it is placed among handlers of the route,
+// e.g. for webhooks that are authenticated by other means:
+//
+//	m.Post("/webhook", macaron.SkipCSRF, webhook)
+var SkipCSRF Handler = csrfExempt(func() {})
+
+func isSkipCSRF(h Handler) bool {
+	_, ok := h.(csrfExempt)
+	return ok
+}
+
+// CSRF returns a middleware handler that protects from cross-site request forgery. Requests other than
+// GET, HEAD, OPTIONS and TRACE are rejected with 403 Forbidden unless they submit the token of the client
+// by form field or header. Token is kept in a cookie and compared with the submitted one, known as
+// double-submit cookie pattern, or kept by Store if one is given, known as synchronizer token pattern.
+//
+// Token is mapped as *CSRFToken, and set in ctx.Data as "CSRFToken" and "CSRFField", so templates
+// can put the hidden form field by {{.CSRFField}}, or put the token in a meta tag for AJAX requests.
+func CSRF(options ...CSRFOptions) Handler {
+	opt := prepareCSRFOptions(options)
+
+	return Provides(func(ctx *Context) {
+		var token string
+		if opt.Store != nil {
+			token = opt.Store.Get(ctx)
+		} else if cookie, err := ctx.Req.Cookie(opt.Cookie); err == nil {
+			token = cookie.Value
+		}
+		expected := token
+
+		if len(token) == 0 {
+			token = newCSRFToken()
+			if opt.Store != nil {
+				opt.Store.Set(ctx, token)
+			} else {
+				http.SetCookie(ctx.Resp, &http.Cookie{
+					Name:     opt.Cookie,
+					Value:    token,
+					Path:     opt.CookiePath,
+					Domain:   opt.CookieDomain,
+					Secure:   opt.Secure || ctx.Req.TLS != nil,
+					HttpOnly: true,
+					SameSite: opt.SameSite,
+				})
+			}
+		}
+
+		t := &CSRFToken{Value: token, Header: opt.Header, Form: opt.Form}
+		ctx.Map(t)
+		ctx.Data["CSRFToken"] = t.Value
+		ctx.Data["CSRFField"] = t.Field()
+
+		switch ctx.Req.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+			return
+		}
+		if ctx.hasLater(isSkipCSRF) {
+			return
+		}
+
+		submitted := ctx.Req.Header.Get(opt.Header)
+		if len(submitted) == 0 {
+			submitted = ctx.Req.FormValue(opt.Form)
+		}
+		if len(expected) == 0 || !SecureCompare(submitted, expected) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusForbidden, "")
+		}
+	}, (*CSRFToken)(nil))
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mapCSRFStore map[string]string
+
+func (s mapCSRFStore) Get(ctx *Context) string {
+	return s[ctx.Req.Header.Get("X-User")]
+}
+
+func (s mapCSRFStore) Set(ctx *Context, token string) {
+	s[ctx.Req.Header.Get("X-User")] = token
+}
+
+func Test_CSRF(t *testing.T) {
+	Convey("Protect with double-submit cookie", t, func() {
+		m := New()
+		m.Use(CSRF())
+		m.Get("/", func(t *CSRFToken) string {
+			return string(t.Field())
+		})
+		m.Post("/", func() string { return "ok" })
+		m.Post("/webhook", SkipCSRF, func() string { return "hooked" })
+		So(m.Check(), ShouldBeEmpty)
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		cookies := resp.Result().Cookies()
+		So(cookies, ShouldHaveLength, 1)
+		cookie := cookies[0]
+		So(cookie.Name, ShouldEqual, "_csrf")
+		So(cookie.HttpOnly, ShouldBeTrue)
+		So(cookie.SameSite, ShouldEqual, http.SameSiteLaxMode)
+		So(resp.Body.String(), ShouldEqual, `<input type="hidden" name="_csrf" value="`+cookie.Value+`">`)
+
+		Convey("Submit token by form", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/", strings.NewReader(url.Values{"_csrf": {cookie.Value}}.Encode()))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(cookie)
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "ok")
+			So(resp.Header().Get("Set-Cookie"), ShouldBeBlank)
+		})
+
+		Convey("Submit token by header", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("X-CSRF-Token", cookie.Value)
+			req.AddCookie(cookie)
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "ok")
+		})
+
+		Convey("Submit wrong token", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("X-CSRF-Token", "wrong")
+			req.AddCookie(cookie)
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Submit token without cookie", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("X-CSRF-Token", cookie.Value)
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Skip exempted route", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/webhook", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "hooked")
+		})
+	})
+
+	Convey("Protect with synchronizer token", t, func() {
+		store := make(mapCSRFStore)
+		m := New()
+		m.Use(CSRF(CSRFOptions{Store: store, Header: "X-XSRF-Token"}))
+		m.Get("/", func(ctx *Context) string {
+			return ctx.Data["CSRFToken"].(string)
+		})
+		m.Post("/", func() string { return "ok" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-User", "foo")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Header().Get("Set-Cookie"), ShouldBeBlank)
+		token := resp.Body.String()
+		So(store["foo"], ShouldEqual, token)
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-User", "foo")
+		req.Header.Set("X-XSRF-Token", token)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "ok")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-User", "bar")
+		req.Header.Set("X-XSRF-Token", token)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusForbidden)
+	})
+}
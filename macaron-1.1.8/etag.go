@@ -0,0 +1,189 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETagOptions is a struct for specifying configuration options for the macaron.ETag middleware.
+type ETagOptions struct {
+	// MaxSize is the maximum size of responses to be buffered, larger ones are written through
+	// without ETag. Default is 1 MB.
+	MaxSize int
+	// ContentTypes is the list of media types of responses to get ETag, responses without
+	// Content-Type are also eligible. Default is HTML, CSS, JavaScript, JSON, XML and plain text.
+	ContentTypes []string
+}
+
+func prepareETagOptions(options []ETagOptions) ETagOptions {
+	var opt ETagOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.MaxSize <= 0 {
+		opt.MaxSize = 1 << 20
+	}
+	if len(opt.ContentTypes) == 0 {
+		opt.ContentTypes = []string{
+			"text/html", "text/css", "text/plain", "text/javascript", "text/xml",
+			"application/javascript", "application/json", "application/xml",
+		}
+	}
+	return opt
+}
+
+// etagWriter holds eligible responses in buffer, and writes others through.
+type etagWriter struct {
+	http.ResponseWriter
+	opt    ETagOptions
+	status int
+	// buffering is true while the response is held in buf.
+	buffering bool
+	buf       bytes.Buffer
+}
+
+func (w *etagWriter) eligible(status int) bool {
+	header := w.Header()
+	if status != http.StatusOK || len(header.Get("ETag")) > 0 || len(header.Get("Content-Encoding")) > 0 {
+		return false
+	}
+	if n, err := strconv.Atoi(header.Get("Content-Length")); err == nil && n > w.opt.MaxSize {
+		return false
+	}
+	contentType := header.Get(_CONTENT_TYPE)
+	if len(contentType) == 0 {
+		return true
+	}
+	if i := strings.IndexByte(contentType, ';'); i > -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	for _, t := range w.opt.ContentTypes {
+		if strings.EqualFold(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if w.buffering = w.eligible(status); !w.buffering {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		if w.buf.Len()+len(p) <= w.opt.MaxSize {
+			return w.buf.Write(p)
+		}
+		if err := w.writeThrough(); err != nil {
+			return 0, err
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// writeThrough stops buffering, and writes status and body held so far.
+func (w *etagWriter) writeThrough() error {
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.buf.WriteTo(w.ResponseWriter)
+	return err
+}
+
+// Flush stops buffering since the handler wants the response to be streamed.
+func (w *etagWriter) Flush() {
+	if w.buffering {
+		w.writeThrough()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// matchETag returns true if any entity tag in the value of If-Match or If-None-Match header
+// matches the ETag, by strong comparison that requires neither of them is weak, or weak comparison.
+// "*" matches any current representation, even without ETag.
+func matchETag(header, etag string, strong bool) bool {
+	weak := strings.HasPrefix(etag, "W/")
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		if len(etag) == 0 || (strong && (weak || strings.HasPrefix(v, "W/"))) {
+			continue
+		}
+		if strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ETag returns a middleware handler that buffers successful responses of GET requests up to MaxSize,
+// and sets strong ETag header computed from their bodies. Requests with If-None-Match header that
+// matches the ETag are responded with 304 Not Modified without body.
+// Responses that already have ETag or Content-Encoding, or are flushed by handlers, are written through.
+func ETag(options ...ETagOptions) Handler {
+	opt := prepareETagOptions(options)
+
+	return func(ctx *Context) {
+		if ctx.Req.Method != "GET" {
+			return
+		}
+
+		orig := ctx.Resp
+		ew := &etagWriter{ResponseWriter: orig, opt: opt}
+		ctx.setResponseWriter(NewResponseWriter(ew))
+		defer ctx.setResponseWriter(orig)
+
+		ctx.Next()
+
+		if !ew.buffering {
+			return
+		}
+		sum := sha256.Sum256(ew.buf.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		header := orig.Header()
+		header.Set("ETag", etag)
+		if matchETag(ctx.Req.Header.Get("If-None-Match"), etag, false) {
+			header.Del(_CONTENT_TYPE)
+			header.Del("Content-Length")
+			orig.WriteHeader(http.StatusNotModified)
+			return
+		}
+		header.Set("Content-Length", strconv.Itoa(ew.buf.Len()))
+		orig.WriteHeader(ew.status)
+		orig.Write(ew.buf.Bytes())
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ETag(t *testing.T) {
+	Convey("Set ETag of responses", t, func() {
+		m := New()
+		m.Use(Renderer())
+		m.Use(ETag(ETagOptions{MaxSize: 16}))
+		m.Get("/json", func(ctx *Context) {
+			ctx.JSON(200, map[string]int{"id": 1})
+		})
+		m.Get("/large", func() string {
+			return strings.Repeat("a", 17)
+		})
+		m.Get("/binary", func(ctx *Context) {
+			ctx.Resp.Header().Set(_CONTENT_TYPE, "image/png")
+			ctx.Resp.Write([]byte("png"))
+		})
+		m.Get("/created", func(ctx *Context) {
+			ctx.Resp.WriteHeader(http.StatusCreated)
+		})
+		m.Get("/stream", func(ctx *Context) {
+			ctx.Resp.Write([]byte("chunk"))
+			ctx.Resp.Flush()
+		})
+
+		serve := func(method, url, ifNoneMatch string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, nil)
+			So(err, ShouldBeNil)
+			if len(ifNoneMatch) > 0 {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("GET", "/json", "")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, `{"id":1}`)
+		So(resp.Header().Get("Content-Length"), ShouldEqual, "8")
+		etag := resp.Header().Get("ETag")
+		So(etag, ShouldStartWith, `"`)
+		So(len(etag), ShouldEqual, 34)
+
+		So(serve("GET", "/json", "").Header().Get("ETag"), ShouldEqual, etag)
+
+		Convey("Respond with 304 if it matches", func() {
+			resp := serve("GET", "/json", `"other", W/`+etag)
+			So(resp.Code, ShouldEqual, http.StatusNotModified)
+			So(resp.Body.Len(), ShouldEqual, 0)
+			So(resp.Header().Get("ETag"), ShouldEqual, etag)
+
+			resp = serve("GET", "/json", `"other"`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, `{"id":1}`)
+		})
+
+		Convey("Write ineligible responses through", func() {
+			for _, url := range []string{"/large", "/binary", "/created", "/stream"} {
+				resp := serve("GET", url, "")
+				So(resp.Header().Get("ETag"), ShouldBeEmpty)
+			}
+			So(serve("GET", "/large", "").Body.String(), ShouldEqual, strings.Repeat("a", 17))
+			So(serve("GET", "/binary", "").Body.String(), ShouldEqual, "png")
+			So(serve("GET", "/created", "").Code, ShouldEqual, http.StatusCreated)
+			So(serve("GET", "/stream", "").Body.String(), ShouldEqual, "chunk")
+		})
+	})
+
+	Convey("Match entity tags", t, func() {
+		So(matchETag(`"a", "b"`, `"b"`, false), ShouldBeTrue)
+		So(matchETag(`W/"b"`, `"b"`, false), ShouldBeTrue)
+		So(matchETag(`"b"`, `W/"b"`, false), ShouldBeTrue)
+		So(matchETag(`*`, `"b"`, false), ShouldBeTrue)
+		So(matchETag(`"a"`, `"b"`, false), ShouldBeFalse)
+		So(matchETag(``, `"b"`, false), ShouldBeFalse)
+		So(matchETag(`"b"`, ``, false), ShouldBeFalse)
+
+		Convey("By strong comparison", func() {
+			So(matchETag(`"a", "b"`, `"b"`, true), ShouldBeTrue)
+			So(matchETag(`W/"b"`, `"b"`, true), ShouldBeFalse)
+			So(matchETag(`"b"`, `W/"b"`, true), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,55 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"reflect"
+
+	"github.com/go-macaron/inject"
+)
+
+// typeOf returns the type T, which works for interface types as well.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Map maps the value as a service of type T, either globally by passing a *Macaron or
+// for current request by passing a *Context. It is the same as MapTo when T is an
+// interface type, e.g. macaron.Map[Store](m, redisStore).
+func Map[T any](mapper inject.TypeMapper, val T) {
+	mapper.Set(typeOf[T](), reflect.ValueOf(&val).Elem())
+}
+
+// Lookup returns the service of type T for current request, and whether it is available.
+// Services are resolved the same way as handler arguments, including providers and constructors.
+func Lookup[T any](ctx *Context) (T, bool) {
+	var zero T
+	val, err := ctx.resolve(typeOf[T]())
+	if err != nil || !val.IsValid() {
+		return zero, false
+	}
+	v, ok := val.Interface().(T)
+	return v, ok
+}
+
+// Get returns the service of type T for current request, or the zero value of T
+// if it is not available, e.g. db := macaron.Get[*sql.DB](ctx).
+func Get[T any](ctx *Context) T {
+	val, _ := Lookup[T](ctx)
+	return val
+}
@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type genericStore interface {
+	Name() string
+}
+
+type memoryStore struct{}
+
+func (memoryStore) Name() string { return "memory" }
+
+func Test_Generics(t *testing.T) {
+	Convey("Map and get services with type parameters", t, func() {
+		m := New()
+		Map[genericStore](m, memoryStore{})
+		m.Provide(func() *provideSession { return &provideSession{"joe"} })
+		m.Use(func(ctx *Context) {
+			Map(ctx, 42)
+		})
+		m.Get("/", func(store genericStore) {}, func(ctx *Context) string {
+			_, ok := Lookup[*provideTenant](ctx)
+			So(ok, ShouldBeFalse)
+			So(Get[*provideTenant](ctx), ShouldBeNil)
+			return fmt.Sprintf("%s,%d,%s", Get[genericStore](ctx).Name(), Get[int](ctx), Get[*provideSession](ctx).user)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "memory,42,joe")
+	})
+}
@@ -0,0 +1,409 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"sync"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of MaxMind DB files.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdb is a reader of MaxMind DB files, e.g. GeoLite2 databases,
+// see https://maxmind.github.io/MaxMind-DB/ for the format.
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node of IPv4 addresses in IPv6 trees, i.e. after 96 zero bits.
+	ipv4Start uint
+}
+
+func openMMDB(filename string) (*mmdb, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(buf)
+}
+
+func parseMMDB(buf []byte) (*mmdb, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i == -1 {
+		return nil, errors.New("mmdb: metadata not found")
+	}
+	metadata := buf[i+len(mmdbMetadataMarker):]
+	v, _, err := decodeMMDB(metadata, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: invalid metadata: %v", err)
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb: invalid metadata")
+	}
+	uintOf := func(key string) uint {
+		n, _ := meta[key].(uint64)
+		return uint(n)
+	}
+
+	db := &mmdb{
+		nodeCount:  uintOf("node_count"),
+		recordSize: uintOf("record_size"),
+		ipVersion:  uintOf("ip_version"),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("mmdb: unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("mmdb: search tree is truncated")
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[treeSize+16 : i]
+
+	if db.ipVersion == 6 {
+		for j := 0; j < 96 && db.ipv4Start < db.nodeCount; j++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (0) or right (1) record of the node.
+func (db *mmdb) record(node, bit uint) uint {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the data of the network that contains the IP, or nil if there is none.
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(ip[i/8]>>(7-uint(i%8))&1))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	} else if node < db.nodeCount {
+		return nil, errors.New("mmdb: invalid search tree")
+	}
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.data)) {
+		return nil, errors.New("mmdb: invalid data pointer")
+	}
+	v, _, err := decodeMMDB(db.data, offset)
+	return v, err
+}
+
+var errMMDBTruncated = errors.New("data is truncated")
+
+// decodeMMDB decodes the value at offset of the data section, and returns offset of the next value.
+func decodeMMDB(data []byte, offset uint) (interface{}, uint, error) {
+	next := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(data)) {
+			return nil, errMMDBTruncated
+		}
+		b := data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	typ := uint(ctrl >> 5)
+
+	if typ == 1 {
+		// Pointer to the value elsewhere in the data section.
+		size := uint(ctrl>>3) & 3
+		b, err := next(size + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var p uint
+		if size < 3 {
+			p = uint(ctrl & 7)
+		}
+		for _, c := range b {
+			p = p<<8 | uint(c)
+		}
+		p += [4]uint{0, 2048, 526336, 0}[size]
+		v, _, err := decodeMMDB(data, p)
+		return v, offset, err
+	}
+
+	if typ == 0 {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+	}
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		b, err := next(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		size = 0
+		for _, c := range b {
+			size = size<<8 | uint(c)
+		}
+		size += [4]uint{0, 29, 285, 65821}[n]
+	}
+
+	switch typ {
+	case 7: // Map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, o, err := decodeMMDB(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if m[key], offset, err = decodeMMDB(data, o); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case 11: // Array
+		a := make([]interface{}, size)
+		for i := range a {
+			if a[i], offset, err = decodeMMDB(data, offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case 14: // Boolean
+		return size != 0, offset, nil
+	}
+
+	b, err = next(size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch typ {
+	case 2: // UTF-8 string
+		return string(b), offset, nil
+	case 3: // Double
+		if size != 8 {
+			return nil, 0, errors.New("invalid size of double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4: // Bytes
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9: // Unsigned integers
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8: // Signed 32-bit integer
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case 10: // Unsigned 128-bit integer
+		return append([]byte(nil), b...), offset, nil
+	case 15: // Float
+		if size != 4 {
+			return nil, 0, errors.New("invalid size of float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// GeoInfo is the geographic information of the client, which is mapped as a service by GeoIP.
+// Fields are empty if they are unknown.
+type GeoInfo struct {
+	// Country is the ISO 3166-1 code of the country, e.g. "US".
+	Country     string
+	CountryName string
+	City        string
+	Latitude    float64
+	Longitude   float64
+	// ASN is the number of the autonomous system, and ASOrg is the organization of it.
+	ASN   uint
+	ASOrg string
+}
+
+// GeoIPOptions is a struct for specifying configuration options for the macaron.GeoIP middleware.
+type GeoIPOptions struct {
+	// Database is the path of a country or city database of MaxMind DB format, e.g. "GeoLite2-City.mmdb".
+	Database string
+	// ASNDatabase is the path of an ASN database of MaxMind DB format, e.g. "GeoLite2-ASN.mmdb".
+	ASNDatabase string
+	// Language of names. Default is "en".
+	Language string
+	// CacheSize is the maximum number of addresses whose information is cached. Default is 4096.
+	CacheSize int
+}
+
+func prepareGeoIPOptions(options []GeoIPOptions) GeoIPOptions {
+	var opt GeoIPOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Language) == 0 {
+		opt.Language = "en"
+	}
+	if opt.CacheSize <= 0 {
+		opt.CacheSize = 4096
+	}
+	return opt
+}
+
+// lookupPath returns the value of nested maps by keys, e.g. "country", "iso_code".
+func lookupPath(v interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+type geoIPResolver struct {
+	opt  GeoIPOptions
+	city *mmdb
+	asn  *mmdb
+
+	lock  sync.Mutex
+	cache map[string]*GeoInfo
+}
+
+func (r *geoIPResolver) resolve(ip net.IP) (*GeoInfo, error) {
+	key := ip.String()
+	r.lock.Lock()
+	info, ok := r.cache[key]
+	r.lock.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info = &GeoInfo{}
+	if r.city != nil {
+		v, err := r.city.lookup(ip)
+		if err != nil {
+			return info, err
+		}
+		info.Country, _ = lookupPath(v, "country", "iso_code").(string)
+		info.CountryName, _ = lookupPath(v, "country", "names", r.opt.Language).(string)
+		info.City, _ = lookupPath(v, "city", "names", r.opt.Language).(string)
+		info.Latitude, _ = lookupPath(v, "location", "latitude").(float64)
+		info.Longitude, _ = lookupPath(v, "location", "longitude").(float64)
+	}
+	if r.asn != nil {
+		v, err := r.asn.lookup(ip)
+		if err != nil {
+			return info, err
+		}
+		asn, _ := lookupPath(v, "autonomous_system_number").(uint64)
+		info.ASN = uint(asn)
+		info.ASOrg, _ = lookupPath(v, "autonomous_system_organization").(string)
+	}
+
+	r.lock.Lock()
+	// Simply start over when the cache is full, which is rare as clients are usually from a few networks.
+	if len(r.cache) >= r.opt.CacheSize {
+		r.cache = make(map[string]*GeoInfo)
+	}
+	r.cache[key] = info
+	r.lock.Unlock()
+	return info, nil
+}
+
+// GeoIP returns a middleware handler that resolves geographic information of the client by ClientIP,
+// from databases of MaxMind DB format, and maps it as *GeoInfo. Databases that fail to open are
+// logged and skipped, and so are lookup errors, so GeoInfo is always mapped but may be empty.
+func GeoIP(options ...GeoIPOptions) Handler {
+	opt := prepareGeoIPOptions(options)
+	r := &geoIPResolver{opt: opt, cache: make(map[string]*GeoInfo)}
+	var errs []error
+	if len(opt.Database) > 0 {
+		var err error
+		if r.city, err = openMMDB(opt.Database); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(opt.ASNDatabase) > 0 {
+		var err error
+		if r.asn, err = openMMDB(opt.ASNDatabase); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var once sync.Once
+	return Provides(func(ctx *Context, log *log.Logger) {
+		once.Do(func() {
+			for _, err := range errs {
+				log.Printf("GeoIP: fail to open database: %v", err)
+			}
+		})
+
+		info := &GeoInfo{}
+		if ip := ctx.ClientIP(); ip != nil {
+			var err error
+			if info, err = r.resolve(ip); err != nil {
+				log.Printf("%sGeoIP: fail to look up %s: %v", requestTag(ctx), ip, err)
+			}
+		}
+		ctx.Map(info)
+	}, (*GeoInfo)(nil))
+}
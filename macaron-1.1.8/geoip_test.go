@@ -0,0 +1,263 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// encodeMMDB encodes the value in the data section format of MaxMind DB.
+func encodeMMDB(buf *bytes.Buffer, v interface{}) {
+	header := func(typ, size int) {
+		var extra []byte
+		switch {
+		case size >= 285:
+			extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+			size = 30
+		case size >= 29:
+			extra = []byte{byte(size - 29)}
+			size = 29
+		}
+		if typ > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+		} else {
+			buf.WriteByte(byte(typ<<5 | size))
+		}
+		buf.Write(extra)
+	}
+	switch v := v.(type) {
+	case string:
+		header(2, len(v))
+		buf.WriteString(v)
+	case float64:
+		header(3, 8)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case uint32:
+		header(6, 4)
+		binary.Write(buf, binary.BigEndian, v)
+	case uint16:
+		header(5, 2)
+		binary.Write(buf, binary.BigEndian, v)
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		header(14, n)
+	case []interface{}:
+		header(11, len(v))
+		for _, e := range v {
+			encodeMMDB(buf, e)
+		}
+	case map[string]interface{}:
+		header(7, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encodeMMDB(buf, k)
+			encodeMMDB(buf, v[k])
+		}
+	}
+}
+
+// buildMMDB builds a MaxMind DB of IPv6 search tree with 24-bit records, which maps networks to data.
+func buildMMDB(networks map[string]interface{}) []byte {
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	data := new(bytes.Buffer)
+	// leaves are records pointing to data, by offset in data section.
+	type leaf struct{ offset int }
+	leaves := map[[2]int]leaf{}
+
+	for cidr, v := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ip := network.IP.To16()
+		ones, bits := network.Mask.Size()
+		if bits == 32 {
+			// IPv4 networks are in the subtree of ::/96.
+			ip = append(make(net.IP, 12), network.IP.To4()...)
+			ones += 96
+		}
+		offset := data.Len()
+		encodeMMDB(data, v)
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				leaves[[2]int{node, bit}] = leaf{offset}
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for i, n := range nodes {
+		for bit, r := range n {
+			if l, ok := leaves[[2]int{i, bit}]; ok {
+				r = len(nodes) + 16 + l.offset
+			} else if r == empty {
+				r = len(nodes)
+			}
+			buf.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.Write(mmdbMetadataMarker)
+	encodeMMDB(buf, map[string]interface{}{
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(6),
+		"database_type": "Test",
+	})
+	return buf.Bytes()
+}
+
+func Test_MMDB(t *testing.T) {
+	Convey("Look up MaxMind DB", t, func() {
+		db, err := parseMMDB(buildMMDB(map[string]interface{}{
+			"1.2.3.0/24": map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "AU"},
+				"tags":    []interface{}{"a", true, uint16(7)},
+			},
+			"2001:db8::/32": "ipv6",
+		}))
+		So(err, ShouldBeNil)
+
+		v, err := db.lookup(net.ParseIP("1.2.3.4"))
+		So(err, ShouldBeNil)
+		So(v, ShouldResemble, map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "AU"},
+			"tags":    []interface{}{"a", true, uint64(7)},
+		})
+
+		v, err = db.lookup(net.ParseIP("2001:db8::1"))
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "ipv6")
+
+		v, err = db.lookup(net.ParseIP("1.2.4.1"))
+		So(err, ShouldBeNil)
+		So(v, ShouldBeNil)
+
+		_, err = parseMMDB([]byte("not a database"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Decode pointers and extended sizes", t, func() {
+		long := bytes.Repeat([]byte("a"), 300)
+		data := []byte{2<<5 | 30, 0, 15}
+		data = append(data, long...)
+		// Pointer to the string at offset 0.
+		data = append(data, 1<<5, 0)
+
+		v, next, err := decodeMMDB(data, 0)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, string(long))
+		v, _, err = decodeMMDB(data, next)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, string(long))
+
+		_, _, err = decodeMMDB(data[:10], 0)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_GeoIP(t *testing.T) {
+	Convey("Resolve geographic information of clients", t, func() {
+		dir, err := ioutil.TempDir("", "geoip")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		city := filepath.Join(dir, "city.mmdb")
+		So(ioutil.WriteFile(city, buildMMDB(map[string]interface{}{
+			"81.2.69.0/24": map[string]interface{}{
+				"country": map[string]interface{}{
+					"iso_code": "GB",
+					"names":    map[string]interface{}{"en": "United Kingdom", "fr": "Royaume-Uni"},
+				},
+				"city":     map[string]interface{}{"names": map[string]interface{}{"en": "London"}},
+				"location": map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931},
+			},
+		}), 0644), ShouldBeNil)
+		asn := filepath.Join(dir, "asn.mmdb")
+		So(ioutil.WriteFile(asn, buildMMDB(map[string]interface{}{
+			"81.2.0.0/16": map[string]interface{}{
+				"autonomous_system_number":       uint32(20712),
+				"autonomous_system_organization": "Andrews & Arnold Ltd",
+			},
+		}), 0644), ShouldBeNil)
+
+		serve := func(opt GeoIPOptions, remote string) *GeoInfo {
+			var info *GeoInfo
+			m := New()
+			m.Use(GeoIP(opt))
+			m.Get("/", func(geo *GeoInfo) {
+				info = geo
+			})
+			So(m.Check(), ShouldBeEmpty)
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			req.RemoteAddr = remote
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			return info
+		}
+
+		info := serve(GeoIPOptions{Database: city, ASNDatabase: asn, Language: "fr"}, "81.2.69.160:1234")
+		So(*info, ShouldResemble, GeoInfo{
+			Country:     "GB",
+			CountryName: "Royaume-Uni",
+			Latitude:    51.5142,
+			Longitude:   -0.0931,
+			ASN:         20712,
+			ASOrg:       "Andrews & Arnold Ltd",
+		})
+
+		info = serve(GeoIPOptions{Database: city, ASNDatabase: asn}, "81.2.70.1:1234")
+		So(*info, ShouldResemble, GeoInfo{ASN: 20712, ASOrg: "Andrews & Arnold Ltd"})
+
+		Convey("Degrade when databases are missing", func() {
+			info := serve(GeoIPOptions{Database: filepath.Join(dir, "missing.mmdb"), ASNDatabase: asn}, "81.2.69.160:1234")
+			So(*info, ShouldResemble, GeoInfo{ASN: 20712, ASOrg: "Andrews & Arnold Ltd"})
+		})
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Sources of services in the injection graph, other than middleware.
+const (
+	SOURCE_MACARON     = "macaron"
+	SOURCE_GLOBAL      = "global"
+	SOURCE_PROVIDER    = "provider"
+	SOURCE_CONSTRUCTOR = "constructor"
+)
+
+// ServiceNode represents a type of service in the injection graph.
+type ServiceNode struct {
+	Type reflect.Type
+	// MappedBy contains names of middleware that map the service, or one of
+	// SOURCE_* constants. It is empty if nothing is known to map the service.
+	MappedBy []string
+	// ConsumedBy contains names of handlers, providers and constructors that require the service.
+	ConsumedBy []string
+}
+
+// InjectionGraph describes which services are mapped by whom, and which handlers consume them.
+type InjectionGraph struct {
+	// Services sorted by name of type.
+	Services []*ServiceNode
+}
+
+type serviceNodes map[reflect.Type]*ServiceNode
+
+func (ns serviceNodes) node(t reflect.Type) *ServiceNode {
+	n, ok := ns[t]
+	if !ok {
+		n = &ServiceNode{Type: t}
+		ns[t] = n
+	}
+	return n
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func (ns serviceNodes) mappedBy(source string, types ...reflect.Type) {
+	for _, t := range types {
+		n := ns.node(t)
+		n.MappedBy = appendUnique(n.MappedBy, source)
+	}
+}
+
+// consumedBy records types of arguments of the handler as consumed by it,
+// and services it is annotated to map, see Provides.
+func (ns serviceNodes) consumedBy(h Handler) {
+	name := handlerName(h)
+	plan := planOf(reflect.TypeOf(h))
+	for i, t := range plan.args {
+		if fields, ok := plan.paramFields[i]; ok {
+			for _, f := range fields {
+				n := ns.node(f.typ)
+				n.ConsumedBy = appendUnique(n.ConsumedBy, name)
+			}
+			continue
+		}
+		n := ns.node(t)
+		n.ConsumedBy = appendUnique(n.ConsumedBy, name)
+	}
+	ns.mappedBy(name, providesOf(h)...)
+}
+
+type serviceNodesByType []*ServiceNode
+
+func (s serviceNodesByType) Len() int           { return len(s) }
+func (s serviceNodesByType) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s serviceNodesByType) Less(i, j int) bool { return s[i].Type.String() < s[j].Type.String() }
+
+// InjectionGraph returns every known type of service, which middleware or source maps it,
+// and which handlers consume it. Services that middleware maps are only known when the
+// middleware is annotated by Provides.
+func (m *Macaron) InjectionGraph() *InjectionGraph {
+	nodes := make(serviceNodes)
+	nodes.mappedBy(SOURCE_MACARON, requestServiceTypes...)
+	nodes.mappedBy(SOURCE_GLOBAL, m.mapped...)
+	for t, provider := range m.providers {
+		nodes.mappedBy(SOURCE_PROVIDER, t)
+		nodes.consumedBy(provider)
+	}
+	if m.constructors != nil {
+		for t, constructor := range m.constructors.funcs {
+			nodes.mappedBy(SOURCE_CONSTRUCTOR, t)
+			nodes.consumedBy(constructor)
+		}
+	}
+
+	for _, h := range m.handlers {
+		nodes.consumedBy(h)
+	}
+	for _, r := range m.routes {
+		for _, h := range r.handlers {
+			nodes.consumedBy(h)
+		}
+	}
+	for _, h := range m.notFoundHandlers {
+		nodes.consumedBy(h)
+	}
+
+	g := &InjectionGraph{Services: make([]*ServiceNode, 0, len(nodes))}
+	for _, n := range nodes {
+		sort.Strings(n.MappedBy)
+		sort.Strings(n.ConsumedBy)
+		g.Services = append(g.Services, n)
+	}
+	sort.Sort(serviceNodesByType(g.Services))
+	return g
+}
+
+// String returns a readable listing of the graph.
+func (g *InjectionGraph) String() string {
+	buf := new(bytes.Buffer)
+	for _, n := range g.Services {
+		fmt.Fprintf(buf, "%v\n", n.Type)
+		for _, s := range n.MappedBy {
+			fmt.Fprintf(buf, "\tmapped by %s\n", s)
+		}
+		if len(n.MappedBy) == 0 {
+			fmt.Fprintf(buf, "\tnot mapped\n")
+		}
+		for _, s := range n.ConsumedBy {
+			fmt.Fprintf(buf, "\tconsumed by %s\n", s)
+		}
+	}
+	return buf.String()
+}
+
+// DOT returns the graph in Graphviz DOT format, where services are ellipses and
+// handlers are boxes, edges point from who maps a service to who consumes it.
+func (g *InjectionGraph) DOT() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("digraph injection {\n\trankdir=LR;\n")
+	handlers := make(map[string]bool)
+	for _, n := range g.Services {
+		fmt.Fprintf(buf, "\t%q [shape=ellipse];\n", n.Type.String())
+		for _, s := range n.MappedBy {
+			handlers[s] = true
+			fmt.Fprintf(buf, "\t%q -> %q;\n", s, n.Type.String())
+		}
+		for _, s := range n.ConsumedBy {
+			handlers[s] = true
+			fmt.Fprintf(buf, "\t%q -> %q;\n", n.Type.String(), s)
+		}
+	}
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(buf, "\t%q [shape=box];\n", name)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_InjectionGraph(t *testing.T) {
+	Convey("Build injection graph", t, func() {
+		m := New()
+		m.Use(Provides(checkAuth, &checkUser{}))
+		m.MapTo(checkMemoryStore{}, (*checkStore)(nil))
+		m.Provide(func(checkStore) *provideSession { return nil })
+		m.Get("/", func(*checkUser, *provideSession) {})
+		m.Get("/tenant", func(p struct {
+			In
+			Tenant *provideTenant `inject:"optional"`
+		}) {
+		})
+
+		g := m.InjectionGraph()
+		nodes := make(map[reflect.Type]*ServiceNode)
+		for _, n := range g.Services {
+			nodes[n.Type] = n
+		}
+
+		user := nodes[reflect.TypeOf(&checkUser{})]
+		So(user, ShouldNotBeNil)
+		So(user.MappedBy, ShouldHaveLength, 1)
+		So(user.MappedBy[0], ShouldEqual, handlerName(checkAuth))
+		So(user.ConsumedBy, ShouldHaveLength, 1)
+
+		store := nodes[reflect.TypeOf((*checkStore)(nil)).Elem()]
+		So(store.MappedBy, ShouldResemble, []string{SOURCE_GLOBAL})
+		So(store.ConsumedBy, ShouldHaveLength, 1)
+
+		So(nodes[reflect.TypeOf(&provideSession{})].MappedBy, ShouldResemble, []string{SOURCE_PROVIDER})
+		So(nodes[reflect.TypeOf(&provideTenant{})].MappedBy, ShouldBeEmpty)
+		So(nodes[contextType].MappedBy, ShouldResemble, []string{SOURCE_MACARON})
+
+		So(g.String(), ShouldContainSubstring, "*macaron.provideTenant\n\tnot mapped\n")
+		dot := g.DOT()
+		So(strings.HasPrefix(dot, "digraph injection {"), ShouldBeTrue)
+		So(dot, ShouldContainSubstring, `"*macaron.checkUser" [shape=ellipse];`)
+		So(dot, ShouldContainSubstring, `"`+handlerName(checkAuth)+`" -> "*macaron.checkUser";`)
+		So(dot, ShouldContainSubstring, `"`+handlerName(checkAuth)+`" [shape=box];`)
+	})
+}
@@ -0,0 +1,379 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// GraphQLRequest is a request of GraphQL over HTTP.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLError is an error in GraphQLResponse.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLResponse is the result of executing a GraphQL request.
+type GraphQLResponse struct {
+	Data       json.RawMessage        `json:"data,omitempty"`
+	Errors     []*GraphQLError        `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLExecutor executes the request against a schema. No GraphQL implementation is included
+// in Macaron, so it is an adapter of the GraphQL library of application, e.g. for graph-gophers/graphql-go:
+//
+//	func(ctx context.Context, req *macaron.GraphQLRequest) *macaron.GraphQLResponse {
+//		resp := schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+//		errs := make([]*macaron.GraphQLError, len(resp.Errors))
+//		for i, err := range resp.Errors {
+//			errs[i] = &macaron.GraphQLError{Message: err.Message, Path: err.Path}
+//		}
+//		return &macaron.GraphQLResponse{Data: resp.Data, Errors: errs}
+//	}
+type GraphQLExecutor func(ctx context.Context, req *GraphQLRequest) *GraphQLResponse
+
+// PersistedQueryStore keeps queries by their SHA-256 hashes for automatic persisted queries,
+// so clients can send hashes instead of full queries.
+type PersistedQueryStore interface {
+	// Get returns the query of the hash, or false if there is none.
+	Get(hash string) (string, bool)
+	// Set stores the query of the hash.
+	Set(hash, query string)
+}
+
+// memoryPersistedQueryStore is a PersistedQueryStore that keeps queries in memory.
+type memoryPersistedQueryStore struct {
+	lock    sync.RWMutex
+	queries map[string]string
+}
+
+// NewMemoryPersistedQueryStore returns a PersistedQueryStore that keeps queries in memory of current process.
+func NewMemoryPersistedQueryStore() PersistedQueryStore {
+	return &memoryPersistedQueryStore{queries: make(map[string]string)}
+}
+
+func (s *memoryPersistedQueryStore) Get(hash string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	query, ok := s.queries[hash]
+	return query, ok
+}
+
+func (s *memoryPersistedQueryStore) Set(hash, query string) {
+	s.lock.Lock()
+	s.queries[hash] = query
+	s.lock.Unlock()
+}
+
+// GraphQLOptions is a struct for specifying configuration options for macaron.GraphQL.
+type GraphQLOptions struct {
+	// Playground serves a page of GraphiQL to browsers that visit the endpoint, for development.
+	Playground bool
+	// MaxBodySize is the maximum size of request bodies in bytes. Default is 1MB.
+	MaxBodySize int64
+	// PersistedQueries enables automatic persisted queries, which are kept in the store.
+	PersistedQueries PersistedQueryStore
+}
+
+func prepareGraphQLOptions(options []GraphQLOptions) GraphQLOptions {
+	var opt GraphQLOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = 1 << 20
+	}
+	return opt
+}
+
+type macaronContextKey struct{}
+
+// FromContext returns Context of the request that the context belongs to, e.g. in resolvers of GraphQL,
+// or nil if there is none. Services mapped for the request can be retrieved from it, e.g.
+//
+//	macaron.FromContext(ctx).Invoke(func(db *sql.DB, user *User) { ... })
+func FromContext(ctx context.Context) *Context {
+	c, _ := ctx.Value(macaronContextKey{}).(*Context)
+	return c
+}
+
+var graphqlPlayground = template.Must(template.New("graphiql").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>GraphiQL</title>
+	<link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css">
+</head>
+<body style="margin: 0">
+	<div id="graphiql" style="height: 100vh"></div>
+	<script src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+	<script src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+	<script src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+	<script>
+		var fetcher = GraphiQL.createFetcher({url: {{.}}});
+		ReactDOM.createRoot(document.getElementById("graphiql")).render(React.createElement(GraphiQL, {fetcher: fetcher}));
+	</script>
+</body>
+</html>
+`))
+
+// writeGraphQL writes the response as JSON with given status.
+func writeGraphQL(ctx *Context, status int, resp *GraphQLResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(&GraphQLResponse{Errors: []*GraphQLError{{Message: err.Error()}}})
+	}
+	ctx.Resp.Header().Set(_CONTENT_TYPE, _CONTENT_JSON+"; charset="+_DEFAULT_CHARSET)
+	ctx.Resp.WriteHeader(status)
+	ctx.Resp.Write(data)
+}
+
+func graphqlError(message, code string) *GraphQLResponse {
+	e := &GraphQLError{Message: message}
+	if len(code) > 0 {
+		e.Extensions = map[string]interface{}{"code": code}
+	}
+	return &GraphQLResponse{Errors: []*GraphQLError{e}}
+}
+
+// parseGraphQLRequest parses the request from query of GET requests, or body of POST requests.
+func parseGraphQLRequest(ctx *Context) (*GraphQLRequest, error) {
+	req := new(GraphQLRequest)
+	if ctx.Req.Method == "GET" {
+		req.Query = ctx.Query("query")
+		req.OperationName = ctx.Query("operationName")
+		for name, v := range map[string]*map[string]interface{}{
+			"variables":  &req.Variables,
+			"extensions": &req.Extensions,
+		} {
+			if s := ctx.Query(name); len(s) > 0 {
+				if err := json.Unmarshal([]byte(s), v); err != nil {
+					return nil, errors.New("invalid " + name + ": " + err.Error())
+				}
+			}
+		}
+		return req, nil
+	}
+
+	body, err := ioutil.ReadAll(ctx.Req.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	typ, _, _ := mime.ParseMediaType(ctx.Req.Header.Get(_CONTENT_TYPE))
+	if typ == "application/graphql" {
+		req.Query = string(body)
+		return req, nil
+	}
+	if err = json.Unmarshal(body, req); err != nil {
+		return nil, errors.New("invalid request body: " + err.Error())
+	}
+	return req, nil
+}
+
+// persistedQuery resolves the query by automatic persisted queries, it returns error response if any.
+func persistedQuery(store PersistedQueryStore, req *GraphQLRequest) *GraphQLResponse {
+	pq, _ := req.Extensions["persistedQuery"].(map[string]interface{})
+	hash, _ := pq["sha256Hash"].(string)
+	if store == nil || len(hash) == 0 {
+		return nil
+	}
+	if len(req.Query) == 0 {
+		query, ok := store.Get(hash)
+		if !ok {
+			return graphqlError("PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND")
+		}
+		req.Query = query
+		return nil
+	}
+	sum := sha256.Sum256([]byte(req.Query))
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), hash) {
+		return graphqlError("provided sha does not match query", "PERSISTED_QUERY_HASH_MISMATCH")
+	}
+	store.Set(hash, req.Query)
+	return nil
+}
+
+// graphqlOperation is an operation defined in a GraphQL document.
+type graphqlOperation struct {
+	typ  string
+	name string
+}
+
+// graphqlOperations returns operations defined at top level of the document. It only scans tokens
+// as far as to tell definitions apart, so the document is still validated by the executor.
+func graphqlOperations(doc string) []graphqlOperation {
+	var ops []graphqlOperation
+	depth := 0
+	expectDef, expectName := true, false
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		switch {
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' && doc[i] != '\r' {
+				i++
+			}
+			continue
+		case c == '"':
+			if strings.HasPrefix(doc[i:], `"""`) {
+				i += 3
+				for i < len(doc) && !strings.HasPrefix(doc[i:], `"""`) {
+					if strings.HasPrefix(doc[i:], `\"""`) {
+						i += 3
+					}
+					i++
+				}
+				i += 3
+			} else {
+				for i++; i < len(doc) && doc[i] != '"' && doc[i] != '\n'; i++ {
+					if doc[i] == '\\' {
+						i++
+					}
+				}
+				i++
+			}
+			expectName = false
+			continue
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			start := i
+			for i < len(doc) && (doc[i] == '_' || 'a' <= doc[i] && doc[i] <= 'z' ||
+				'A' <= doc[i] && doc[i] <= 'Z' || '0' <= doc[i] && doc[i] <= '9') {
+				i++
+			}
+			name := doc[start:i]
+			if depth > 0 {
+				continue
+			}
+			switch {
+			case expectDef:
+				expectDef = false
+				if name == "query" || name == "mutation" || name == "subscription" {
+					ops = append(ops, graphqlOperation{typ: name})
+					expectName = true
+				}
+			case expectName:
+				ops[len(ops)-1].name = name
+				expectName = false
+			}
+			continue
+		case c == '{' || c == '(' || c == '[':
+			if depth == 0 && expectDef {
+				// Shorthand of a query without name.
+				ops = append(ops, graphqlOperation{typ: "query"})
+				expectDef = false
+			}
+			depth++
+		case c == '}' || c == ')' || c == ']':
+			if depth--; depth == 0 && c == '}' {
+				expectDef = true
+			}
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			expectName = false
+		}
+		i++
+	}
+	return ops
+}
+
+// isQuery returns true if the operation selected by the name is a query, which is the only type of
+// operations allowed by GET requests. If none can be selected, the executor will refuse the request,
+// so it returns true only if the document has no other types of operations in case it is parsed differently.
+func isQuery(doc, operationName string) bool {
+	ops := graphqlOperations(doc)
+	if len(ops) == 1 && len(operationName) == 0 {
+		return ops[0].typ == "query"
+	}
+	if len(operationName) > 0 {
+		for _, op := range ops {
+			if op.name == operationName {
+				return op.typ == "query"
+			}
+		}
+	}
+	for _, op := range ops {
+		if op.typ != "query" {
+			return false
+		}
+	}
+	return true
+}
+
+// GraphQL registers a GraphQL endpoint that accepts GET and POST requests, whose bodies are limited
+// by MaxBody, and executes them by the executor. Operations other than queries are refused for GET
+// requests, so they can't be forged by links of other sites. Context of requests passed to the executor carries
+// Context of Macaron, which resolvers can get by FromContext to access services mapped by middleware.
+func (r *Router) GraphQL(pattern string, exec GraphQLExecutor, options ...GraphQLOptions) *Route {
+	opt := prepareGraphQLOptions(options)
+
+	return r.Route(pattern, "GET,POST", MaxBody(opt.MaxBodySize), func(ctx *Context) {
+		if opt.Playground && ctx.Req.Method == "GET" && len(ctx.Query("query")) == 0 &&
+			strings.Contains(ctx.Req.Header.Get("Accept"), _CONTENT_HTML) {
+			ctx.Resp.Header().Set(_CONTENT_TYPE, _CONTENT_HTML+"; charset="+_DEFAULT_CHARSET)
+			graphqlPlayground.Execute(ctx.Resp, ctx.Req.URL.Path)
+			return
+		}
+
+		req, err := parseGraphQLRequest(ctx)
+		if err != nil {
+			if IsBodyTooLarge(err) {
+				writeGraphQL(ctx, http.StatusRequestEntityTooLarge, graphqlError("request body is too large", ""))
+				return
+			}
+			writeGraphQL(ctx, http.StatusBadRequest, graphqlError(err.Error(), ""))
+			return
+		}
+		if resp := persistedQuery(opt.PersistedQueries, req); resp != nil {
+			writeGraphQL(ctx, http.StatusOK, resp)
+			return
+		}
+		if len(req.Query) == 0 {
+			writeGraphQL(ctx, http.StatusBadRequest, graphqlError("must provide query string", ""))
+			return
+		}
+		if ctx.Req.Method == "GET" && !isQuery(req.Query, req.OperationName) {
+			ctx.Resp.Header().Set("Allow", "POST")
+			writeGraphQL(ctx, http.StatusMethodNotAllowed, graphqlError("only queries are allowed by GET requests", ""))
+			return
+		}
+
+		resp := exec(context.WithValue(ctx.Req.Context(), macaronContextKey{}, ctx), req)
+		if resp == nil {
+			resp = &GraphQLResponse{}
+		}
+		writeGraphQL(ctx, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type graphqlUser struct {
+	Name string
+}
+
+func Test_Router_GraphQL(t *testing.T) {
+	Convey("Serve GraphQL requests", t, func() {
+		var executed []string
+		exec := func(ctx context.Context, req *GraphQLRequest) *GraphQLResponse {
+			executed = append(executed, req.Query)
+			var name string
+			FromContext(ctx).Invoke(func(u *graphqlUser) {
+				name = u.Name
+			})
+			data, _ := json.Marshal(map[string]interface{}{"me": name, "vars": req.Variables, "op": req.OperationName})
+			return &GraphQLResponse{Data: data}
+		}
+
+		m := New()
+		m.Use(func(ctx *Context) {
+			ctx.Map(&graphqlUser{"joe"})
+		})
+		m.GraphQL("/graphql", exec, GraphQLOptions{
+			Playground:       true,
+			MaxBodySize:      256,
+			PersistedQueries: NewMemoryPersistedQueryStore(),
+		})
+
+		serve := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, path, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			if len(contentType) > 0 {
+				req.Header.Set("Content-Type", contentType)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("POST", "/graphql", "application/json",
+			`{"query":"query Me { me }","operationName":"Me","variables":{"x":1}}`)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, `{"data":{"me":"joe","op":"Me","vars":{"x":1}}}`)
+
+		resp = serve("POST", "/graphql", "application/graphql", `{ me }`)
+		So(resp.Body.String(), ShouldEqual, `{"data":{"me":"joe","op":"","vars":null}}`)
+
+		resp = serve("GET", "/graphql?query="+url.QueryEscape("{ me }"), "", "")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+
+		Convey("Reject invalid requests", func() {
+			resp := serve("GET", "/graphql?query="+url.QueryEscape("mutation { del }"), "", "")
+			So(resp.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			resp = serve("GET", "/graphql?query="+url.QueryEscape("#x\nmutation { del }"), "", "")
+			So(resp.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			resp = serve("GET", "/graphql?operationName=B&query="+url.QueryEscape("query A { me } mutation B { del }"), "", "")
+			So(resp.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			resp = serve("POST", "/graphql", "application/json", `{"query":""}`)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"message":"must provide query string"}]}`)
+			resp = serve("POST", "/graphql", "application/json", `{`)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			resp = serve("POST", "/graphql", "application/graphql", strings.Repeat("x", 300))
+			So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+			// Chunked bodies have no Content-Length.
+			resp = httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/graphql", ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 300))))
+			req.Header.Set("Content-Type", "application/graphql")
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(executed, ShouldHaveLength, 3)
+		})
+
+		Convey("Automatic persisted queries", func() {
+			hash := "ba6a6fa45a2bf0d0d01c6e0da9dba1ad6d0e29d8bc8e4ba9eb6ee9c3a7dbb1f4"
+			ext := `"extensions":{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`
+			resp := serve("POST", "/graphql", "application/json", `{`+ext+`}`)
+			So(resp.Body.String(), ShouldContainSubstring, "PERSISTED_QUERY_NOT_FOUND")
+
+			resp = serve("POST", "/graphql", "application/json", `{"query":"{ other }",`+ext+`}`)
+			So(resp.Body.String(), ShouldContainSubstring, "PERSISTED_QUERY_HASH_MISMATCH")
+		})
+
+		Convey("Serve the playground", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/graphql", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept", "text/html,application/xhtml+xml")
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldContainSubstring, `createFetcher({url: "/graphql"})`)
+		})
+	})
+
+	Convey("Persist queries by hashes", t, func() {
+		store := NewMemoryPersistedQueryStore()
+		ext := map[string]interface{}{"persistedQuery": map[string]interface{}{
+			"sha256Hash": "b7e4ef0c41abe27fe98d162502c81bdd0611cd1b7555f1d6cf8d12b822111ba5",
+		}}
+		So(persistedQuery(store, &GraphQLRequest{Extensions: ext}), ShouldNotBeNil)
+		So(persistedQuery(store, &GraphQLRequest{Query: "{ me }", Extensions: ext}), ShouldBeNil)
+
+		req := &GraphQLRequest{Extensions: ext}
+		So(persistedQuery(store, req), ShouldBeNil)
+		So(req.Query, ShouldEqual, "{ me }")
+	})
+}
+
+func Test_GraphQL_Operations(t *testing.T) {
+	Convey("Tell types of operations selected from documents", t, func() {
+		for _, tc := range []struct {
+			doc, name string
+			query     bool
+		}{
+			{"{ me }", "", true},
+			{"query { me }", "", true},
+			{"mutation { del }", "", false},
+			{"subscription { events }", "", false},
+			{"# mutation\n{ me }", "", true},
+			{"#x\nmutation { del }", "", false},
+			{`query A($s: String = "mutation { del }") { me(s: $s) }`, "", true},
+			{`query A { me(s: """ } mutation { """) }`, "", true},
+			{"fragment F on mutation { id } query Q { ...F }", "", true},
+			{"query A { me } mutation B { del }", "A", true},
+			{"query A { me } mutation B { del }", "B", false},
+			{"query A { me } mutation B { del }", "", false},
+			{"query A { me } mutation B { del }", "C", false},
+			{"query A { me } query B { you }", "", true},
+			{"query A { me }, mutation { del }", "A", true},
+		} {
+			So(isQuery(tc.doc, tc.name), ShouldEqual, tc.query)
+		}
+	})
+}
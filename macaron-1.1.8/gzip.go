@@ -0,0 +1,388 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GzipOptions is a struct for specifying configuration options for the macaron.Gzip middleware.
+type GzipOptions struct {
+	// Level is the compression level, from gzip.BestSpeed to gzip.BestCompression.
+	// Default is gzip.DefaultCompression.
+	Level int
+	// MinSize is the minimum size in bytes of response body to be compressed. Default is 1024.
+	MinSize int
+	// ContentTypes is the list of content types of response to be compressed, an entry
+	// matches all content types it is prefix of, e.g. "text/" matches "text/html".
+	// Default includes text, JSON, JavaScript, XML and SVG.
+	ContentTypes []string
+	// ContentTypeLevels overrides Level for content types, matched same as ContentTypes,
+	// e.g. {"text/html": gzip.BestCompression}.
+	ContentTypeLevels map[string]int
+	// Encoders is the list of additional content codings, e.g. brotli, which are preferred
+	// over gzip in given order for clients that accept them equally.
+	Encoders []Encoder
+}
+
+// Encoder represents a content coding to compress response body with, other than gzip.
+// The standard library does not ship a brotli encoder, it can be enabled with any
+// implementation, e.g.
+//
+//	macaron.Gzip(macaron.GzipOptions{
+//		Encoders: []macaron.Encoder{{
+//			Name:  "br",
+//			Level: 5,
+//			ContentTypeLevels: map[string]int{"text/html": 9},
+//			NewWriter: func(w io.Writer, level int) io.WriteCloser {
+//				return brotli.NewWriterLevel(w, level)
+//			},
+//		}},
+//	})
+type Encoder struct {
+	// Name is the content coding in Accept-Encoding and Content-Encoding headers, e.g. "br".
+	Name string
+	// Level is the default compression level.
+	Level int
+	// ContentTypeLevels overrides Level for content types, matched same as GzipOptions.ContentTypes.
+	ContentTypeLevels map[string]int
+	// NewWriter returns a writer that writes compressed data to w with given level.
+	// The response is flushed through it if it implements http.Flusher or
+	// has a Flush() error method.
+	NewWriter func(w io.Writer, level int) io.WriteCloser
+}
+
+// levelFor returns compression level for given content type.
+func levelFor(contentType string, level int, levels map[string]int) int {
+	contentType = strings.ToLower(contentType)
+	matched := ""
+	for t, l := range levels {
+		// The longest match is the most specific.
+		if strings.HasPrefix(contentType, t) && len(t) > len(matched) {
+			matched, level = t, l
+		}
+	}
+	return level
+}
+
+var defaultGzipContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"image/svg+xml",
+}
+
+func prepareGzipOptions(options []GzipOptions) GzipOptions {
+	var opt GzipOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Level == 0 {
+		opt.Level = gzip.DefaultCompression
+	}
+	if opt.Level < gzip.HuffmanOnly || opt.Level > gzip.BestCompression {
+		panic(fmt.Sprintf("invalid gzip compression level: %d", opt.Level))
+	}
+	if opt.MinSize <= 0 {
+		opt.MinSize = 1024
+	}
+	if len(opt.ContentTypes) == 0 {
+		opt.ContentTypes = defaultGzipContentTypes
+	}
+	for t, level := range opt.ContentTypeLevels {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			panic(fmt.Sprintf("invalid gzip compression level of %s: %d", t, level))
+		}
+	}
+	for _, enc := range opt.Encoders {
+		if len(enc.Name) == 0 || enc.NewWriter == nil {
+			panic("encoder must have name and NewWriter")
+		}
+	}
+	return opt
+}
+
+// encodingQuality returns quality values of content codings from the Accept-Encoding header.
+func encodingQuality(req *http.Request) map[string]float64 {
+	qs := make(map[string]float64)
+	for _, accept := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if name, q := parseAcceptType(accept); len(name) > 0 {
+			qs[name] = q
+		}
+	}
+	return qs
+}
+
+// negotiateEncoding returns the content coding that the client prefers among given codings
+// in order of server preference, or empty string if none of them is acceptable.
+func negotiateEncoding(req *http.Request, codings []string) string {
+	qs := encodingQuality(req)
+	best, bestQ := "", 0.0
+	for _, coding := range codings {
+		q, ok := qs[coding]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressible returns true if the response with given content type should be compressed.
+func compressible(contentType string, types []string) bool {
+	contentType = strings.ToLower(contentType)
+	// Streaming responses have to reach clients as they are written.
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	for _, t := range types {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyAllowed returns true if a response with given status can have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// gzipResponseWriter holds the beginning of response body until it knows whether
+// the response should be compressed, then writes it through the encoding if so.
+type gzipResponseWriter struct {
+	rw       ResponseWriter
+	opt      *GzipOptions
+	encoding string
+	pools    *gzipPools
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      io.WriteCloser
+	level   int
+}
+
+func (w *gzipResponseWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.status = status
+	if !bodyAllowed(status) {
+		w.decide()
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.rw.Write(b)
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.opt.MinSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide writes the response header, with compression enabled if the response qualifies,
+// and the body held so far.
+func (w *gzipResponseWriter) decide() error {
+	w.decided = true
+	header := w.rw.Header()
+	if len(header.Get(_CONTENT_TYPE)) == 0 && len(w.buf) > 0 {
+		header.Set(_CONTENT_TYPE, http.DetectContentType(w.buf))
+	}
+
+	if bodyAllowed(w.status) && len(w.buf) >= w.opt.MinSize &&
+		len(header.Get("Content-Encoding")) == 0 &&
+		compressible(header.Get(_CONTENT_TYPE), w.opt.ContentTypes) {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del(_CONTENT_LENGTH)
+		w.gz = w.newWriter(header.Get(_CONTENT_TYPE))
+	}
+
+	w.rw.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.rw.Write(buf)
+	}
+	return err
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.decide()
+	}
+	switch gz := w.gz.(type) {
+	case http.Flusher:
+		gz.Flush()
+	case interface {
+		Flush() error
+	}:
+		gz.Flush()
+	}
+	w.rw.Flush()
+}
+
+// close writes the rest of response and finishes compression.
+func (w *gzipResponseWriter) close() {
+	if !w.decided && w.status != 0 {
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		if gz, ok := w.gz.(*gzip.Writer); ok {
+			w.pools.put(w.level, gz)
+		}
+		w.gz = nil
+	}
+}
+
+// newWriter returns a writer of negotiated encoding with level for given content type.
+func (w *gzipResponseWriter) newWriter(contentType string) io.WriteCloser {
+	if w.encoding == "gzip" {
+		w.level = levelFor(contentType, w.opt.Level, w.opt.ContentTypeLevels)
+		return w.pools.get(w.level, w.rw)
+	}
+	for _, enc := range w.opt.Encoders {
+		if enc.Name == w.encoding {
+			w.level = levelFor(contentType, enc.Level, enc.ContentTypeLevels)
+			return enc.NewWriter(w.rw, w.level)
+		}
+	}
+	panic("unknown encoding: " + w.encoding)
+}
+
+// gzipPools holds pools of gzip writers by compression level.
+type gzipPools struct {
+	lock  sync.Mutex
+	pools map[int]*sync.Pool
+}
+
+func (p *gzipPools) pool(level int) *sync.Pool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pool, ok := p.pools[level]
+	if !ok {
+		pool = &sync.Pool{
+			New: func() interface{} {
+				gz, _ := gzip.NewWriterLevel(nil, level)
+				return gz
+			},
+		}
+		p.pools[level] = pool
+	}
+	return pool
+}
+
+func (p *gzipPools) get(level int, w io.Writer) *gzip.Writer {
+	gz := p.pool(level).Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz
+}
+
+func (p *gzipPools) put(level int, gz *gzip.Writer) {
+	p.pool(level).Put(gz)
+}
+
+func (w *gzipResponseWriter) CloseNotify() <-chan bool {
+	return w.rw.(http.CloseNotifier).CloseNotify()
+}
+
+// Gzip returns a middleware handler that compresses response body with gzip, or one of
+// additional encoders in options, for clients that accept it. Small responses, responses of content types not listed in options,
+// already encoded responses and event streams are sent as they are.
+// It should be used before macaron.Renderer so rendered content is compressed as well.
+func Gzip(options ...GzipOptions) Handler {
+	opt := prepareGzipOptions(options)
+	pools := &gzipPools{pools: make(map[int]*sync.Pool)}
+	codings := make([]string, 0, len(opt.Encoders)+1)
+	for _, enc := range opt.Encoders {
+		codings = append(codings, enc.Name)
+	}
+	codings = append(codings, "gzip")
+
+	return func(ctx *Context) {
+		ctx.Resp.Header().Add("Vary", "Accept-Encoding")
+		if ctx.Req.Method == "HEAD" || strings.EqualFold(ctx.Req.Header.Get("Connection"), "upgrade") {
+			return
+		}
+		encoding := negotiateEncoding(ctx.Req.Request, codings)
+		if len(encoding) == 0 {
+			return
+		}
+
+		orig := ctx.Resp
+		gw := &gzipResponseWriter{rw: orig, opt: &opt, encoding: encoding, pools: pools}
+		// Connections are hijacked and pushed through the original writer, so the wrapper
+		// implements http.Hijacker and http.Pusher only if the original one does.
+		ctx.setResponseWriter(wrapResponseWriter(&responseWriter{ResponseWriter: gw, conn: orig, start: time.Now()}))
+		defer func() {
+			gw.close()
+			ctx.setResponseWriter(orig)
+		}()
+
+		ctx.Next()
+	}
+}
+
+// setResponseWriter replaces the ResponseWriter of the context and services that depend on it.
+func (ctx *Context) setResponseWriter(rw ResponseWriter) {
+	ctx.Resp = rw
+	ctx.MapTo(rw, (*http.ResponseWriter)(nil))
+	if r, ok := ctx.Render.(*DummyRender); ok {
+		r.ResponseWriter = rw
+	} else if ctx.Render != nil {
+		ctx.Render.SetResponseWriter(rw)
+	}
+}
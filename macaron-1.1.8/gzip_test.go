@@ -0,0 +1,261 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func gunzip(body []byte) string {
+	r, err := gzip.NewReader(strings.NewReader(string(body)))
+	So(err, ShouldBeNil)
+	data, err := ioutil.ReadAll(r)
+	So(err, ShouldBeNil)
+	return string(data)
+}
+
+type fakeEncoder struct {
+	io.Writer
+}
+
+func (e fakeEncoder) Write(p []byte) (int, error) {
+	return e.Writer.Write(p)
+}
+
+func (e fakeEncoder) Close() error {
+	return nil
+}
+
+func Test_Gzip(t *testing.T) {
+	large := strings.Repeat("Hello world! ", 200)
+
+	Convey("Compress response", t, func() {
+		m := New()
+		m.Use(Gzip())
+		m.Use(Renderer(RenderOptions{Directory: "fixtures/basic"}))
+		m.Get("/", func() string { return large })
+		m.Get("/json", func(ctx *Context) {
+			ctx.JSON(200, map[string]string{"greeting": large})
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+		So(resp.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+		So(resp.Body.Len(), ShouldBeLessThan, len(large))
+		So(gunzip(resp.Body.Bytes()), ShouldEqual, large)
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/json", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+		So(gunzip(resp.Body.Bytes()), ShouldEqual, `{"greeting":"`+large+`"}`)
+	})
+
+	Convey("Do not compress response", t, func() {
+		m := New()
+		m.Use(Gzip(GzipOptions{MinSize: 100}))
+		m.Get("/small", func() string { return "Hello world" })
+		m.Get("/large", func() string { return large })
+		m.Get("/image", func(ctx *Context) {
+			ctx.Resp.Header().Set("Content-Type", "image/png")
+			ctx.Resp.Write([]byte(large))
+		})
+		m.Get("/encoded", func(ctx *Context) {
+			ctx.Resp.Header().Set("Content-Encoding", "br")
+			ctx.Resp.Write([]byte(large))
+		})
+		m.Get("/events", func(ctx *Context) {
+			ctx.Resp.Header().Set("Content-Type", "text/event-stream")
+			ctx.Resp.Write([]byte(large))
+			ctx.Resp.Flush()
+		})
+		m.Get("/empty", func(ctx *Context) {
+			ctx.Resp.WriteHeader(http.StatusNoContent)
+		})
+
+		for _, c := range []struct {
+			path, acceptEncoding string
+		}{
+			{"/small", "gzip"},
+			{"/large", ""},
+			{"/large", "gzip;q=0, deflate"},
+			{"/image", "gzip"},
+			{"/encoded", "gzip"},
+			{"/events", "gzip"},
+			{"/empty", "gzip"},
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", c.path, nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept-Encoding", c.acceptEncoding)
+			m.ServeHTTP(resp, req)
+
+			So(resp.Header().Get("Content-Encoding"), ShouldNotEqual, "gzip")
+			So(resp.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+			switch c.path {
+			case "/small":
+				So(resp.Body.String(), ShouldEqual, "Hello world")
+			case "/empty":
+				So(resp.Code, ShouldEqual, http.StatusNoContent)
+			default:
+				So(resp.Body.String(), ShouldEqual, large)
+			}
+		}
+	})
+
+	Convey("Flush compressed response", t, func() {
+		m := New()
+		m.Use(Gzip(GzipOptions{MinSize: 10}))
+		m.Get("/", func(ctx *Context) {
+			ctx.Resp.Write([]byte(large))
+			ctx.Resp.Flush()
+			ctx.Resp.Write([]byte(large))
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Flushed, ShouldBeTrue)
+		So(gunzip(resp.Body.Bytes()), ShouldEqual, large+large)
+	})
+
+	Convey("Keep optional interfaces of the original writer", t, func() {
+		m := New()
+		m.Use(Gzip())
+		m.Get("/", func(ctx *Context, rw http.ResponseWriter) {
+			_, hijacker := rw.(http.Hijacker)
+			_, pusher := rw.(http.Pusher)
+			_, readerFrom := rw.(io.ReaderFrom)
+			So(readerFrom, ShouldBeFalse)
+			ctx.Resp.Header().Set("X-Hijacker", strconv.FormatBool(hijacker))
+			ctx.Resp.Header().Set("X-Pusher", strconv.FormatBool(pusher))
+			if pusher {
+				So(ctx.Push("/style.css", nil), ShouldBeNil)
+			}
+		})
+
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("X-Hijacker"), ShouldEqual, "false")
+		So(resp.Header().Get("X-Pusher"), ShouldEqual, "false")
+
+		pushable := newPushableRecorder()
+		m.ServeHTTP(pushable, req)
+		So(pushable.Header().Get("X-Hijacker"), ShouldEqual, "false")
+		So(pushable.Header().Get("X-Pusher"), ShouldEqual, "true")
+		So(pushable.pushed, ShouldResemble, []string{"/style.css"})
+	})
+
+	Convey("Negotiate additional encoders", t, func() {
+		levels := make(map[string]int)
+		m := New()
+		m.Use(Gzip(GzipOptions{
+			ContentTypeLevels: map[string]int{"text/": gzip.BestSpeed},
+			Encoders: []Encoder{
+				{
+					Name:              "br",
+					Level:             5,
+					ContentTypeLevels: map[string]int{"text/html": 11},
+					NewWriter: func(w io.Writer, level int) io.WriteCloser {
+						levels["br"] = level
+						io.WriteString(w, "br:")
+						return fakeEncoder{w}
+					},
+				},
+				{
+					Name: "deflate",
+					NewWriter: func(w io.Writer, level int) io.WriteCloser {
+						fw, _ := flate.NewWriter(w, level)
+						return fw
+					},
+				},
+			},
+		}))
+		m.Get("/", func() string { return large })
+		m.Get("/html", func(ctx *Context) {
+			ctx.Resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+			ctx.Resp.Write([]byte(large))
+		})
+
+		for _, c := range []struct {
+			path, acceptEncoding, encoding string
+			level                          int
+		}{
+			{"/", "gzip, deflate, br", "br", 5},
+			{"/html", "gzip, deflate, br", "br", 11},
+			{"/", "gzip, deflate", "deflate", 0},
+			{"/", "gzip, deflate;q=0.5, br;q=0.1", "gzip", 0},
+			{"/", "*", "br", 5},
+		} {
+			levels = make(map[string]int)
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", c.path, nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept-Encoding", c.acceptEncoding)
+			m.ServeHTTP(resp, req)
+
+			So(resp.Header().Get("Content-Encoding"), ShouldEqual, c.encoding)
+			switch c.encoding {
+			case "br":
+				So(levels["br"], ShouldEqual, c.level)
+				So(resp.Body.String(), ShouldEqual, "br:"+large)
+			case "deflate":
+				data, err := ioutil.ReadAll(flate.NewReader(resp.Body))
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, large)
+			case "gzip":
+				So(gunzip(resp.Body.Bytes()), ShouldEqual, large)
+			}
+		}
+	})
+
+	Convey("Choose compression level by content type", t, func() {
+		So(levelFor("text/html; charset=UTF-8", 5, map[string]int{"text/": 1, "text/html": 9}), ShouldEqual, 9)
+		So(levelFor("text/plain", 5, map[string]int{"text/": 1, "text/html": 9}), ShouldEqual, 1)
+		So(levelFor("application/json", 5, map[string]int{"text/": 1}), ShouldEqual, 5)
+	})
+
+	Convey("Invalid compression level", t, func() {
+		defer func() {
+			So(recover(), ShouldNotBeNil)
+		}()
+		Gzip(GzipOptions{Level: 10})
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is the timeout of health checks that do not specify one.
+var DefaultHealthCheckTimeout = 5 * time.Second
+
+// Status of health checks.
+const (
+	HEALTH_OK   = "ok"
+	HEALTH_FAIL = "fail"
+)
+
+// HealthCheck checks if a dependency of the application works, e.g. by pinging the database.
+type HealthCheck struct {
+	Name string
+	// Check returns error if the dependency does not work, it should give up when ctx is done.
+	Check func(ctx context.Context) error
+	// Timeout of the check. Default is DefaultHealthCheckTimeout.
+	Timeout time.Duration
+}
+
+// HealthCheckResult is the result of a health check.
+type HealthCheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is the aggregated result of health checks.
+type HealthReport struct {
+	Status string                        `json:"status"`
+	Checks map[string]*HealthCheckResult `json:"checks,omitempty"`
+}
+
+// runHealthCheck runs the check within its timeout, a panic inside it is reported as failure.
+func runHealthCheck(parent context.Context, check HealthCheck) *HealthCheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				errChan <- fmt.Errorf("panic: %v", err)
+			}
+		}()
+		errChan <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+
+	result := &HealthCheckResult{Status: HEALTH_OK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = HEALTH_FAIL
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// CheckHealth runs all checks concurrently and returns the aggregated result,
+// which fails if any of checks fails.
+func CheckHealth(ctx context.Context, checks ...HealthCheck) *HealthReport {
+	report := &HealthReport{Status: HEALTH_OK, Checks: make(map[string]*HealthCheckResult, len(checks))}
+	results := make([]*HealthCheckResult, len(checks))
+	done := make(chan struct{}, len(checks))
+	for i := range checks {
+		go func(i int) {
+			results[i] = runHealthCheck(ctx, checks[i])
+			done <- struct{}{}
+		}(i)
+	}
+	for range checks {
+		<-done
+	}
+
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != HEALTH_OK {
+			report.Status = HEALTH_FAIL
+		}
+	}
+	return report
+}
+
+func writeHealthReport(rw http.ResponseWriter, report *HealthReport) {
+	status := http.StatusOK
+	if report.Status != HEALTH_OK {
+		status = http.StatusServiceUnavailable
+	}
+	data, _ := json.Marshal(report)
+	rw.Header().Set(_CONTENT_TYPE, _CONTENT_JSON+"; charset="+_DEFAULT_CHARSET)
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	rw.Write(data)
+}
+
+// Health registers a liveness endpoint that responds with {"status":"ok"} as long as the server is up.
+func (m *Macaron) Health(pattern string) *Route {
+	return m.Get(pattern, func(ctx *Context) {
+		writeHealthReport(ctx.Resp, &HealthReport{Status: HEALTH_OK})
+	})
+}
+
+// Ready registers a readiness endpoint that runs checks concurrently, and responds with the aggregated
+// result as JSON, with status 200 if all checks pass or 503 Service Unavailable otherwise, e.g.
+//
+//	m.Ready("/readyz", macaron.HealthCheck{Name: "db", Check: db.PingContext})
+func (m *Macaron) Ready(pattern string, checks ...HealthCheck) *Route {
+	for _, check := range checks {
+		if check.Check == nil {
+			panic("health check " + check.Name + " has no check function")
+		}
+	}
+	return m.Get(pattern, func(ctx *Context) {
+		writeHealthReport(ctx.Resp, CheckHealth(ctx.Req.Context(), checks...))
+	})
+}
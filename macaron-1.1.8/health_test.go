@@ -0,0 +1,85 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Health(t *testing.T) {
+	Convey("Serve liveness endpoint", t, func() {
+		m := New()
+		m.Health("/healthz")
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/healthz", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=UTF-8")
+		So(resp.Body.String(), ShouldEqual, `{"status":"ok"}`)
+	})
+
+	Convey("Serve readiness endpoint", t, func() {
+		ok := HealthCheck{Name: "db", Check: func(context.Context) error { return nil }}
+		failing := HealthCheck{Name: "cache", Check: func(context.Context) error { return errors.New("connection refused") }}
+		slow := HealthCheck{Name: "slow", Timeout: 10 * time.Millisecond, Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Second)
+			return nil
+		}}
+		panicking := HealthCheck{Name: "panic", Check: func(context.Context) error { panic("oops") }}
+
+		m := New()
+		m.Ready("/readyz", ok)
+		m.Ready("/readyz/all", ok, failing, slow, panicking)
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/readyz", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+
+		var report HealthReport
+		So(json.Unmarshal(resp.Body.Bytes(), &report), ShouldBeNil)
+		So(report.Status, ShouldEqual, HEALTH_OK)
+		So(report.Checks["db"].Status, ShouldEqual, HEALTH_OK)
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/readyz/all", nil)
+		So(err, ShouldBeNil)
+		start := time.Now()
+		m.ServeHTTP(resp, req)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+
+		report = HealthReport{}
+		So(json.Unmarshal(resp.Body.Bytes(), &report), ShouldBeNil)
+		So(report.Status, ShouldEqual, HEALTH_FAIL)
+		So(report.Checks["db"].Status, ShouldEqual, HEALTH_OK)
+		So(report.Checks["cache"].Error, ShouldEqual, "connection refused")
+		So(report.Checks["slow"].Error, ShouldEqual, "timed out after 10ms")
+		So(report.Checks["panic"].Error, ShouldEqual, "panic: oops")
+	})
+}
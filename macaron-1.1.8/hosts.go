@@ -0,0 +1,71 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetAllowedHosts sets hosts accepted in Host header of requests, which is checked before
+// routing. Hosts can contain a port to match only the port, and start with "*." to match
+// all subdomains, e.g. "example.com", "*.example.com" or "localhost:4000".
+// Requests without Host header are responded with 400 Bad Request, and requests for other
+// hosts with 421 Misdirected Request, so that links built from Host header, e.g. in
+// password reset emails, and shared caches cannot be poisoned by forged hosts.
+func (m *Macaron) SetAllowedHosts(hosts ...string) {
+	m.allowedHosts = make([]string, len(hosts))
+	for i, host := range hosts {
+		m.allowedHosts[i] = strings.ToLower(host)
+	}
+}
+
+// hostAllowed returns true if the host, which may contain a port, matches any allowed host.
+func hostAllowed(allowed []string, host string) bool {
+	host = strings.ToLower(host)
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	for _, pattern := range allowed {
+		target := name
+		if _, _, err := net.SplitHostPort(pattern); err == nil {
+			target = host
+		}
+		switch {
+		case pattern == "*", pattern == target:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(target, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost responds to the request and returns false if its Host header is not allowed.
+func (m *Macaron) checkHost(rw http.ResponseWriter, req *http.Request) bool {
+	if len(req.Host) == 0 {
+		http.Error(rw, "Missing Host header", http.StatusBadRequest)
+		return false
+	}
+	if !hostAllowed(m.allowedHosts, req.Host) {
+		http.Error(rw, "Unknown host "+req.Host, http.StatusMisdirectedRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Macaron_SetAllowedHosts(t *testing.T) {
+	Convey("Reject requests for unexpected hosts", t, func() {
+		m := New()
+		m.SetAllowedHosts("Example.com", "*.example.com", "localhost:4000")
+		m.Get("/", func() string { return "home" })
+
+		for _, c := range []struct {
+			host string
+			code int
+		}{
+			{"example.com", http.StatusOK},
+			{"EXAMPLE.com:8080", http.StatusOK},
+			{"www.example.com", http.StatusOK},
+			{"a.b.example.com.", http.StatusOK},
+			{"localhost:4000", http.StatusOK},
+			{"localhost:4001", http.StatusMisdirectedRequest},
+			{"localhost", http.StatusMisdirectedRequest},
+			{"evilexample.com", http.StatusMisdirectedRequest},
+			{"example.com.evil.com", http.StatusMisdirectedRequest},
+			{"", http.StatusBadRequest},
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			req.Host = c.host
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, c.code)
+		}
+	})
+
+	Convey("Allow all hosts by default", t, func() {
+		m := New()
+		m.Get("/", func() string { return "home" })
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.Host = "anything"
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RedirectOptions is a struct for specifying configuration options for the macaron.RedirectHTTPS middleware.
+type RedirectOptions struct {
+	// Permanent redirects with 301 Moved Permanently or 308 Permanent Redirect,
+	// instead of 302 Found or 307 Temporary Redirect.
+	Permanent bool
+	// TrustForwardedProto treats requests as HTTPS if X-Forwarded-Proto header is "https",
+	// when they come from trusted proxies set by SetTrustedProxies, e.g. load balancers
+	// that terminate TLS.
+	TrustForwardedProto bool
+	// Host replaces host of the request in redirect URLs, e.g. the canonical host of the site.
+	Host string
+	// Port is the HTTPS port in redirect URLs. Default is 443, which is omitted.
+	Port int
+}
+
+func prepareRedirectOptions(options []RedirectOptions) RedirectOptions {
+	var opt RedirectOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Port <= 0 {
+		opt.Port = 443
+	}
+	return opt
+}
+
+// IsSecure returns true if the request is made over HTTPS, or has X-Forwarded-Proto header
+// of "https" set by a trusted proxy when trustForwarded is true.
+func (ctx *Context) IsSecure(trustForwarded bool) bool {
+	if ctx.Req.TLS != nil {
+		return true
+	}
+	return trustForwarded && ctx.fromTrustedProxy() &&
+		strings.EqualFold(strings.TrimSpace(strings.Split(ctx.Req.Header.Get("X-Forwarded-Proto"), ",")[0]), "https")
+}
+
+// RedirectHTTPS returns a middleware handler that redirects requests over HTTP to the same URL
+// over HTTPS. Methods other than GET and HEAD are redirected with 307 or 308, so they are not
+// changed to GET by clients. It should be used globally before other middleware.
+func RedirectHTTPS(options ...RedirectOptions) Handler {
+	opt := prepareRedirectOptions(options)
+
+	return func(ctx *Context) {
+		if ctx.IsSecure(opt.TrustForwardedProto) {
+			return
+		}
+
+		host := opt.Host
+		if len(host) == 0 {
+			host = ctx.Req.Host
+			// SplitHostPort leaves IPv6 addresses without brackets, hosts without ports are kept as they are.
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+				if strings.Contains(host, ":") {
+					host = "[" + host + "]"
+				}
+			}
+		}
+		if opt.Port != 443 {
+			host += ":" + strconv.Itoa(opt.Port)
+		}
+
+		u := *ctx.Req.URL
+		u.Scheme = "https"
+		u.Host = host
+		if ctx.Router != nil && ctx.m != nil && ctx.m.hasURLPrefix {
+			u.Path = ctx.m.urlPrefix + u.Path
+			u.RawPath = ""
+		}
+
+		isRead := ctx.Req.Method == "GET" || ctx.Req.Method == "HEAD"
+		status := http.StatusFound
+		switch {
+		case opt.Permanent && isRead:
+			status = http.StatusMovedPermanently
+		case opt.Permanent:
+			status = http.StatusPermanentRedirect
+		case !isRead:
+			status = http.StatusTemporaryRedirect
+		}
+		http.Redirect(ctx.Resp, ctx.Req.Request, u.String(), status)
+	}
+}
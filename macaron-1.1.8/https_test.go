@@ -0,0 +1,117 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RedirectHTTPS(t *testing.T) {
+	Convey("Redirect requests over HTTP to HTTPS", t, func() {
+		m := New()
+		So(m.SetTrustedProxies("10.0.0.1"), ShouldBeNil)
+		m.Use(RedirectHTTPS(RedirectOptions{Permanent: true, TrustForwardedProto: true}))
+		m.Get("/", func() string { return "home" })
+		m.Post("/", func() string { return "posted" })
+
+		serve := func(method, url, remote, proto string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, nil)
+			So(err, ShouldBeNil)
+			req.RemoteAddr = remote
+			if len(proto) > 0 {
+				req.Header.Set("X-Forwarded-Proto", proto)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("GET", "http://example.com:8080/?q=1", "1.2.3.4:1234", "")
+		So(resp.Code, ShouldEqual, http.StatusMovedPermanently)
+		So(resp.Header().Get("Location"), ShouldEqual, "https://example.com/?q=1")
+
+		resp = serve("GET", "http://example.com/missing", "1.2.3.4:1234", "")
+		So(resp.Code, ShouldEqual, http.StatusMovedPermanently)
+		So(resp.Header().Get("Location"), ShouldEqual, "https://example.com/missing")
+
+		resp = serve("POST", "http://example.com/", "1.2.3.4:1234", "")
+		So(resp.Code, ShouldEqual, http.StatusPermanentRedirect)
+
+		Convey("Trust X-Forwarded-Proto from trusted proxies only", func() {
+			resp := serve("GET", "http://example.com/", "10.0.0.1:1234", "https")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "home")
+
+			resp = serve("GET", "http://example.com/", "1.2.3.4:1234", "https")
+			So(resp.Code, ShouldEqual, http.StatusMovedPermanently)
+
+			resp = serve("GET", "http://example.com/", "10.0.0.1:1234", "http")
+			So(resp.Code, ShouldEqual, http.StatusMovedPermanently)
+		})
+
+		Convey("Serve requests over TLS", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "https://example.com/", nil)
+			So(err, ShouldBeNil)
+			req.TLS = &tls.ConnectionState{}
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+
+	Convey("Redirect to given host and port temporarily", t, func() {
+		m := New()
+		m.SetURLPrefix("/app")
+		m.Use(RedirectHTTPS(RedirectOptions{Host: "secure.example.com", Port: 8443}))
+		m.Get("/", func() string { return "home" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://example.com/app/?q=1", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusFound)
+		So(resp.Header().Get("Location"), ShouldEqual, "https://secure.example.com:8443/app/?q=1")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("PUT", "http://[::1]:80/app/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusTemporaryRedirect)
+	})
+
+	Convey("Redirect IPv6 hosts", t, func() {
+		m := New()
+		m.Use(RedirectHTTPS())
+		m.Get("/", func() string { return "home" })
+		m.Get("/x", func() string { return "x" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://[::1]:80/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("Location"), ShouldEqual, "https://[::1]/")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "http://[::1]/x", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("Location"), ShouldEqual, "https://[::1]/x")
+	})
+}
@@ -0,0 +1,351 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// I18nOptions is a struct for specifying configuration options for the macaron.I18n middleware.
+type I18nOptions struct {
+	// Directory to load message catalogs from, which are files named by languages with extension
+	// of their formats: ".ini", ".json" or ".po", e.g. "en-US.ini". Default is "conf/locale".
+	Directory string
+	// Langs is the list of supported languages. Default is all languages in Directory.
+	Langs []string
+	// Default is the language used when none of supported languages is requested,
+	// and for messages missing in other languages. Default is the first of Langs.
+	Default string
+	// Cookie is the name of cookie that keeps language chosen by user. Default is "lang".
+	Cookie string
+	// URLPrefix enables detecting language by the first segment of request path, e.g. "/en-US/about".
+	// Routes should contain the segment, e.g. in m.Group("/:lang", ...).
+	URLPrefix bool
+}
+
+// catalogLoaders load message catalogs by extensions of files.
+var catalogLoaders = map[string]func([]byte) (map[string]string, error){
+	".ini":  loadINICatalog,
+	".json": loadJSONCatalog,
+	".po":   loadPOCatalog,
+}
+
+// loadINICatalog loads messages of INI format, keys in sections are prefixed by "section.".
+func loadINICatalog(data []byte) (map[string]string, error) {
+	f, err := ini.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	messages := make(map[string]string)
+	for _, sec := range f.Sections() {
+		prefix := ""
+		if sec.Name() != ini.DEFAULT_SECTION {
+			prefix = sec.Name() + "."
+		}
+		for _, key := range sec.Keys() {
+			messages[prefix+key.Name()] = key.Value()
+		}
+	}
+	return messages, nil
+}
+
+// loadJSONCatalog loads messages of JSON format, keys in nested objects are joined by ".".
+func loadJSONCatalog(data []byte) (map[string]string, error) {
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	messages := make(map[string]string)
+	var flatten func(prefix string, tree map[string]interface{}) error
+	flatten = func(prefix string, tree map[string]interface{}) error {
+		for k, v := range tree {
+			switch v := v.(type) {
+			case string:
+				messages[prefix+k] = v
+			case map[string]interface{}:
+				if err := flatten(prefix+k+".", v); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("message %q is not a string", prefix+k)
+			}
+		}
+		return nil
+	}
+	return messages, flatten("", tree)
+}
+
+// loadPOCatalog loads messages of gettext PO format, untranslated messages and
+// message contexts are ignored, and only the singular form of plural messages is used.
+func loadPOCatalog(data []byte) (map[string]string, error) {
+	messages := make(map[string]string)
+	var id, str, field string
+	flush := func() {
+		if len(id) > 0 && len(str) > 0 {
+			messages[id] = str
+		}
+		id, str, field = "", "", ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if line[0] != '"' {
+			i := strings.IndexByte(line, ' ')
+			if i == -1 {
+				return nil, fmt.Errorf("line %d: invalid syntax", n)
+			}
+			field, line = line[:i], strings.TrimSpace(line[i+1:])
+			if field == "msgid" {
+				flush()
+				field = "msgid"
+			}
+		}
+		s, err := strconv.Unquote(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		switch field {
+		case "msgid":
+			id += s
+		case "msgstr", "msgstr[0]":
+			str += s
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return messages, nil
+}
+
+func prepareI18nOptions(options []I18nOptions) I18nOptions {
+	var opt I18nOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Directory) == 0 {
+		opt.Directory = "conf/locale"
+	}
+	if len(opt.Cookie) == 0 {
+		opt.Cookie = "lang"
+	}
+	return opt
+}
+
+// loadCatalogs loads message catalogs of languages in the directory, or all languages
+// found in the directory if langs is empty. It returns the languages loaded.
+func loadCatalogs(dir string, langs []string) ([]string, map[string]map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, lang := range langs {
+		wanted[lang] = true
+	}
+
+	catalogs := make(map[string]map[string]string)
+	for _, fi := range files {
+		ext := filepath.Ext(fi.Name())
+		load, ok := catalogLoaders[ext]
+		lang := strings.TrimSuffix(fi.Name(), ext)
+		if fi.IsDir() || !ok || (len(langs) > 0 && !wanted[lang]) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		messages, err := load(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fi.Name(), err)
+		}
+		if catalogs[lang] == nil {
+			catalogs[lang] = messages
+			continue
+		}
+		for k, v := range messages {
+			catalogs[lang][k] = v
+		}
+	}
+
+	if len(langs) == 0 {
+		for lang := range catalogs {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+	}
+	for _, lang := range langs {
+		if catalogs[lang] == nil {
+			return nil, nil, fmt.Errorf("no message catalog for language %q", lang)
+		}
+	}
+	return langs, catalogs, nil
+}
+
+// locale implements Locale by message catalogs.
+type locale struct {
+	lang     string
+	messages map[string]string
+	fallback map[string]string
+}
+
+func (l *locale) Language() string {
+	return l.lang
+}
+
+// Tr returns the message of the key in the language, or in the default language if it
+// is missing, or the key itself. Message is formatted with args by fmt.Sprintf if any.
+func (l *locale) Tr(key string, args ...interface{}) string {
+	msg, ok := l.messages[key]
+	if !ok {
+		if msg, ok = l.fallback[key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// acceptLanguages returns languages in the Accept-Language header ordered by preference.
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var list []weighted
+	for _, part := range strings.Split(header, ",") {
+		lang, q := strings.TrimSpace(part), 1.0
+		if i := strings.IndexByte(lang, ';'); i > -1 {
+			if v := strings.TrimSpace(lang[i+1:]); strings.HasPrefix(v, "q=") {
+				q, _ = strconv.ParseFloat(v[2:], 64)
+			}
+			lang = strings.TrimSpace(lang[:i])
+		}
+		if len(lang) > 0 && lang != "*" && q > 0 {
+			list = append(list, weighted{lang, q})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+
+	langs := make([]string, len(list))
+	for i := range list {
+		langs[i] = list[i].lang
+	}
+	return langs
+}
+
+// matchLanguage returns the supported language that matches given one case-insensitively,
+// or shares its primary subtag, e.g. "zh" and "zh-CN".
+func matchLanguage(supported []string, lang string) (string, bool) {
+	primary := func(s string) string {
+		if i := strings.IndexAny(s, "-_"); i > -1 {
+			return s[:i]
+		}
+		return s
+	}
+	for _, s := range supported {
+		if strings.EqualFold(s, lang) {
+			return s, true
+		}
+	}
+	for _, s := range supported {
+		if strings.EqualFold(primary(s), primary(lang)) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// I18n returns a middleware handler that detects language of the request by the URL prefix
+// if enabled, the cookie, and then Accept-Language header, and maps Locale of the language
+// with messages loaded from catalogs in the directory. It panics if catalogs fail to load.
+//
+// Locale is also set as ctx.Locale, and in ctx.Data as "Locale" along with "Lang" and "Langs",
+// so templates can translate messages by {{.Locale.Tr "key" args}}.
+func I18n(options ...I18nOptions) Handler {
+	opt := prepareI18nOptions(options)
+	langs, catalogs, err := loadCatalogs(opt.Directory, opt.Langs)
+	if err != nil {
+		panic("i18n: fail to load message catalogs: " + err.Error())
+	}
+	if len(langs) == 0 {
+		panic("i18n: no message catalog in " + opt.Directory)
+	}
+	if len(opt.Default) == 0 {
+		opt.Default = langs[0]
+	}
+	if catalogs[opt.Default] == nil {
+		panic("i18n: no message catalog for default language " + opt.Default)
+	}
+
+	locales := make(map[string]*locale, len(langs))
+	for _, lang := range langs {
+		locales[lang] = &locale{lang, catalogs[lang], catalogs[opt.Default]}
+	}
+
+	return Provides(func(ctx *Context) {
+		lang, ok := "", false
+		if opt.URLPrefix {
+			segment := strings.SplitN(strings.TrimPrefix(ctx.Req.URL.Path, "/"), "/", 2)[0]
+			for _, s := range langs {
+				if strings.EqualFold(s, segment) {
+					lang, ok = s, true
+					break
+				}
+			}
+		}
+		if !ok {
+			lang, ok = matchLanguage(langs, ctx.GetCookie(opt.Cookie))
+		}
+		if !ok {
+			for _, accept := range acceptLanguages(ctx.Req.Header.Get("Accept-Language")) {
+				if lang, ok = matchLanguage(langs, accept); ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			lang = opt.Default
+		}
+
+		l := locales[lang]
+		ctx.Locale = l
+		ctx.MapTo(l, (*Locale)(nil))
+		ctx.Data["Lang"] = lang
+		ctx.Data["Langs"] = langs
+		ctx.Data["Locale"] = l
+	}, (*Locale)(nil))
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_I18n(t *testing.T) {
+	Convey("Load message catalogs", t, func() {
+		langs, catalogs, err := loadCatalogs("fixtures/locale", nil)
+		So(err, ShouldBeNil)
+		So(langs, ShouldResemble, []string{"en-US", "fr-FR", "zh-CN"})
+		So(catalogs["en-US"]["nav.home"], ShouldEqual, "Home")
+		So(catalogs["fr-FR"]["nav.home"], ShouldEqual, "Accueil")
+		So(catalogs["zh-CN"]["welcome"], ShouldEqual, "欢迎, %s!")
+		_, ok := catalogs["zh-CN"]["nav.about"]
+		So(ok, ShouldBeFalse)
+
+		_, _, err = loadCatalogs("fixtures/locale", []string{"de-DE"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Parse Accept-Language header", t, func() {
+		So(acceptLanguages("fr;q=0.8, zh-CN, en;q=0.9, *;q=0.1"), ShouldResemble, []string{"zh-CN", "en", "fr"})
+		So(acceptLanguages(""), ShouldBeEmpty)
+	})
+
+	Convey("Detect language of request", t, func() {
+		m := New()
+		m.Use(I18n(I18nOptions{
+			Directory: "fixtures/locale",
+			Default:   "en-US",
+			URLPrefix: true,
+		}))
+		handler := func(l Locale, ctx *Context) string {
+			return l.Language() + ": " + l.Tr("welcome", "Macaron") + " " + ctx.Tr("nav.about")
+		}
+		m.Get("/", handler)
+		m.Get("/:lang/", handler)
+		So(m.Check(), ShouldBeEmpty)
+
+		serve := func(url, cookie, accept string) string {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			if len(cookie) > 0 {
+				req.AddCookie(&http.Cookie{Name: "lang", Value: cookie})
+			}
+			req.Header.Set("Accept-Language", accept)
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+
+		So(serve("/", "", ""), ShouldEqual, "en-US: Welcome, Macaron! About")
+		So(serve("/", "", "de, fr;q=0.5"), ShouldEqual, "fr-FR: Bienvenue, Macaron ! About")
+		So(serve("/", "zh-CN", "fr"), ShouldEqual, "zh-CN: 欢迎, Macaron! About")
+		So(serve("/fr-FR/", "zh-CN", ""), ShouldEqual, "fr-FR: Bienvenue, Macaron ! About")
+		So(serve("/de-DE/", "", ""), ShouldEqual, "en-US: Welcome, Macaron! About")
+	})
+}
@@ -0,0 +1,242 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// In can be embedded in a struct to make it a parameter struct of handlers. Instead of
+// the struct itself, each exported field of the struct is resolved as a service when the
+// handler is invoked. A field tagged with `inject:"optional"` is left as its zero value
+// rather than failing the request if the service has not been mapped. A field tagged with
+// `name:"..."` is resolved as the service mapped by MapNamed under given name, e.g.
+//
+//	type params struct {
+//		macaron.In
+//		Ctx     *macaron.Context
+//		User    *User   `inject:"optional"`
+//		Replica *sql.DB `name:"replica"`
+//	}
+//
+//	m.Get("/", func(p params) { ... })
+type In struct{}
+
+var (
+	contextType = reflect.TypeOf((*Context)(nil))
+	inType      = reflect.TypeOf(In{})
+)
+
+// invocationPlan is the result of analyzing signature of a handler,
+// so it does not have to be done again for every request.
+type invocationPlan struct {
+	args []reflect.Type
+	// Indexes of arguments that are the *Context itself.
+	contextArgs []int
+	// Fields of parameter struct arguments by index of argument.
+	paramFields map[int][]paramField
+}
+
+// paramField is a field of a parameter struct, see In.
+type paramField struct {
+	index    int
+	typ      reflect.Type
+	optional bool
+	name     string
+}
+
+// isParamStruct returns true if t is a struct that embeds In.
+func isParamStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous && f.Type == inType {
+			return true
+		}
+	}
+	return false
+}
+
+func paramFieldsOf(t reflect.Type) []paramField {
+	fields := make([]paramField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type == inType || len(f.PkgPath) > 0 {
+			continue
+		}
+		fields = append(fields, paramField{i, f.Type, f.Tag.Get("inject") == "optional", f.Tag.Get("name")})
+	}
+	return fields
+}
+
+var invocationPlans = struct {
+	sync.RWMutex
+	m map[reflect.Type]*invocationPlan
+}{m: make(map[reflect.Type]*invocationPlan)}
+
+// planOf returns the invocation plan of given handler type, the plan is built
+// and cached when the type is seen for the first time.
+func planOf(t reflect.Type) *invocationPlan {
+	invocationPlans.RLock()
+	plan, ok := invocationPlans.m[t]
+	invocationPlans.RUnlock()
+	if ok {
+		return plan
+	}
+
+	plan = &invocationPlan{args: make([]reflect.Type, t.NumIn())}
+	for i := range plan.args {
+		plan.args[i] = t.In(i)
+		if plan.args[i] == contextType {
+			plan.contextArgs = append(plan.contextArgs, i)
+		} else if isParamStruct(plan.args[i]) {
+			if plan.paramFields == nil {
+				plan.paramFields = make(map[int][]paramField)
+			}
+			plan.paramFields[i] = paramFieldsOf(plan.args[i])
+		}
+	}
+
+	invocationPlans.Lock()
+	invocationPlans.m[t] = plan
+	invocationPlans.Unlock()
+	return plan
+}
+
+// invoke calls the handler with arguments resolved from services of the context.
+// It behaves the same as Invoke, but calls common handler signatures without
+// reflection and uses cached invocation plan for the rest.
+func (c *Context) invoke(h Handler) ([]reflect.Value, error) {
+	switch h := h.(type) {
+	case func(*Context):
+		h(c)
+		return nil, nil
+	case func():
+		h()
+		return nil, nil
+	}
+
+	plan := planOf(reflect.TypeOf(h))
+	in := make([]reflect.Value, len(plan.args))
+	for _, i := range plan.contextArgs {
+		in[i] = reflect.ValueOf(c)
+	}
+	for i, t := range plan.args {
+		if in[i].IsValid() {
+			continue
+		}
+		if fields, ok := plan.paramFields[i]; ok {
+			val, err := c.resolveParams(t, fields)
+			if err != nil {
+				return nil, err
+			}
+			in[i] = val
+			continue
+		}
+		val, err := c.resolve(t)
+		if err != nil {
+			return nil, err
+		} else if !val.IsValid() {
+			return nil, fmt.Errorf("Value not found for type %v", t)
+		}
+		in[i] = val
+	}
+	return reflect.ValueOf(h).Call(in), nil
+}
+
+// resolveParams creates a parameter struct of type t with its fields resolved as services.
+func (c *Context) resolveParams(t reflect.Type, fields []paramField) (reflect.Value, error) {
+	params := reflect.New(t).Elem()
+	for _, f := range fields {
+		var val reflect.Value
+		if len(f.name) > 0 {
+			val = c.GetNamedVal(f.name, f.typ)
+		} else if f.typ == contextType {
+			val = reflect.ValueOf(c)
+		} else {
+			var err error
+			if val, err = c.resolve(f.typ); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if !val.IsValid() {
+			if f.optional {
+				continue
+			}
+			if len(f.name) > 0 {
+				return reflect.Value{}, fmt.Errorf("Value not found for type %v named %q", f.typ, f.name)
+			}
+			return reflect.Value{}, fmt.Errorf("Value not found for type %v", f.typ)
+		}
+		params.Field(f.index).Set(val)
+	}
+	return params, nil
+}
+
+type namedKey struct {
+	name string
+	typ  reflect.Type
+}
+
+// namedServices holds services mapped under names, so there can be multiple
+// services of the same type, e.g. connections to primary and replica databases.
+type namedServices map[namedKey]reflect.Value
+
+func (ns namedServices) get(name string, t reflect.Type) reflect.Value {
+	if val, ok := ns[namedKey{name, t}]; ok {
+		return val
+	}
+	if t.Kind() == reflect.Interface {
+		for k, v := range ns {
+			if k.name == name && k.typ.Implements(t) {
+				return v
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+// MapNamed maps the value as a global service of its own type under given name.
+// Named services are requested by fields tagged with `name:"..."` of parameter
+// structs, see In.
+func (m *Macaron) MapNamed(name string, val interface{}) {
+	if m.named == nil {
+		m.named = make(namedServices)
+	}
+	m.named[namedKey{name, reflect.TypeOf(val)}] = reflect.ValueOf(val)
+}
+
+// MapNamed maps the value as a service of its own type under given name for current request.
+func (c *Context) MapNamed(name string, val interface{}) {
+	if c.named == nil {
+		c.named = make(namedServices)
+	}
+	c.named[namedKey{name, reflect.TypeOf(val)}] = reflect.ValueOf(val)
+}
+
+// GetNamedVal returns the service of given type mapped under given name for current request
+// or globally. The returned value is invalid if there is no such service.
+func (c *Context) GetNamedVal(name string, t reflect.Type) reflect.Value {
+	if val := c.named.get(name, t); val.IsValid() {
+		return val
+	}
+	if c.Router != nil && c.m != nil {
+		return c.m.named.get(name, t)
+	}
+	return reflect.Value{}
+}
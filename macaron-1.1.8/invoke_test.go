@@ -0,0 +1,200 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type invokeService struct {
+	name string
+}
+
+func Test_Invoke(t *testing.T) {
+	Convey("Build invocation plan at registration", t, func() {
+		handler := func(*Context, http.ResponseWriter, *invokeService, *Context) {}
+		m := New()
+		m.Get("/", handler)
+
+		invocationPlans.RLock()
+		plan := invocationPlans.m[reflect.TypeOf(handler)]
+		invocationPlans.RUnlock()
+		So(plan, ShouldNotBeNil)
+		So(len(plan.args), ShouldEqual, 4)
+		So(plan.contextArgs, ShouldResemble, []int{0, 3})
+	})
+
+	Convey("Invoke handlers with services", t, func() {
+		m := New()
+		m.Map(&invokeService{"global"})
+		m.Use(func(ctx *Context) {
+			ctx.Map(&invokeService{"request"})
+		})
+		m.Get("/", func(ctx *Context, rw http.ResponseWriter, req *http.Request, s *invokeService) string {
+			So(ctx.Resp, ShouldEqual, rw)
+			So(ctx.Req.Request, ShouldEqual, req)
+			return s.name
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "request")
+	})
+
+	Convey("Invoke handler with missing service", t, func() {
+		ctx := &Context{Injector: New().Injector}
+		_, err := ctx.invoke(func(*invokeService) {})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Value not found for type *macaron.invokeService")
+	})
+}
+
+type invokeParams struct {
+	In
+	Ctx     *Context
+	Service *invokeService `inject:"optional"`
+	Resp    http.ResponseWriter
+	ignored *invokeService
+}
+
+func Test_Invoke_In(t *testing.T) {
+	Convey("Invoke handler with parameter struct", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) {
+			ctx.Map(&invokeService{"user"})
+		}, func(p invokeParams) string {
+			So(p.Ctx, ShouldNotBeNil)
+			So(p.Resp, ShouldEqual, p.Ctx.Resp)
+			So(p.ignored, ShouldBeNil)
+			return p.Service.name
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "user")
+	})
+
+	Convey("Leave missing optional service as zero value", t, func() {
+		m := New()
+		m.Get("/", func(p invokeParams) string {
+			if p.Service == nil {
+				return "anonymous"
+			}
+			return p.Service.name
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "anonymous")
+	})
+
+	Convey("Fail on missing required service", t, func() {
+		ctx := &Context{Injector: New().Injector}
+		_, err := ctx.invoke(func(struct {
+			In
+			Service *invokeService
+		}) {
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Value not found for type *macaron.invokeService")
+	})
+}
+
+type namedDB struct {
+	dsn string
+}
+
+func Test_Invoke_Named(t *testing.T) {
+	Convey("Invoke handler with named services", t, func() {
+		m := New()
+		m.Map(&namedDB{"default"})
+		m.MapNamed("primary", &namedDB{"primary"})
+		m.MapNamed("replica", &namedDB{"replica"})
+		m.Use(func(ctx *Context) {
+			ctx.MapNamed("replica", &namedDB{"request replica"})
+		})
+		m.Get("/", func(p struct {
+			In
+			Default *namedDB
+			Primary *namedDB `name:"primary"`
+			Replica *namedDB `name:"replica"`
+		}) string {
+			return p.Default.dsn + "," + p.Primary.dsn + "," + p.Replica.dsn
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "default,primary,request replica")
+	})
+
+	Convey("Get named service by interface", t, func() {
+		m := New()
+		m.MapNamed("recorder", httptest.NewRecorder())
+		ctx := m.createContext(httptest.NewRecorder(), &http.Request{})
+
+		val := ctx.GetNamedVal("recorder", reflect.TypeOf((*http.ResponseWriter)(nil)).Elem())
+		So(val.IsValid(), ShouldBeTrue)
+		So(ctx.GetNamedVal("unknown", reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()).IsValid(), ShouldBeFalse)
+	})
+
+	Convey("Fail on missing named service", t, func() {
+		m := New()
+		ctx := m.createContext(httptest.NewRecorder(), &http.Request{})
+		_, err := ctx.invoke(func(struct {
+			In
+			DB *namedDB `name:"replica"`
+		}) {
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, `Value not found for type *macaron.namedDB named "replica"`)
+	})
+}
+
+func Benchmark_Invoke(b *testing.B) {
+	m := New()
+	m.Get("/", func(ctx *Context, rw http.ResponseWriter, req *http.Request) {})
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func Benchmark_Params(b *testing.B) {
+	m := New()
+	m.Get("/users/:uid/posts/:pid", func(ctx *Context) {
+		ctx.Params("uid")
+	})
+	req, _ := http.NewRequest("GET", "/users/1/posts/2", nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
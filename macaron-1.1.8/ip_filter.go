@@ -0,0 +1,170 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseIPNets parses CIDRs, or single IP addresses that are taken as networks of themselves.
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTrustedProxies sets proxies whose X-Forwarded-For and X-Real-IP headers are trusted
+// by ClientIP, by CIDRs or single IP addresses, e.g. "10.0.0.0/8" or "127.0.0.1".
+func (m *Macaron) SetTrustedProxies(proxies ...string) error {
+	nets, err := parseIPNets(proxies)
+	if err != nil {
+		return err
+	}
+	m.trustedProxies = nets
+	return nil
+}
+
+// remoteIP returns IP address of the peer, or nil if it cannot be parsed.
+func (ctx *Context) remoteIP() net.IP {
+	host, _, err := net.SplitHostPort(ctx.Req.RemoteAddr)
+	if err != nil {
+		host = ctx.Req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// fromTrustedProxy returns true if the peer is a trusted proxy set by SetTrustedProxies.
+func (ctx *Context) fromTrustedProxy() bool {
+	ip := ctx.remoteIP()
+	return ip != nil && ctx.Router != nil && ctx.m != nil && containsIP(ctx.m.trustedProxies, ip)
+}
+
+// ClientIP returns IP address of the client. Unlike RemoteAddr, forwarded headers are only
+// trusted when the request comes from a trusted proxy set by SetTrustedProxies, and the client
+// is the right-most address of X-Forwarded-For that is not a trusted proxy.
+// It returns nil if the address cannot be parsed.
+func (ctx *Context) ClientIP() net.IP {
+	ip := ctx.remoteIP()
+	if !ctx.fromTrustedProxy() {
+		return ip
+	}
+
+	var forwarded []string
+	for _, v := range ctx.Req.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(ctx.m.trustedProxies, hop) {
+			return ip
+		}
+	}
+	if len(forwarded) == 0 {
+		if realIP := net.ParseIP(strings.TrimSpace(ctx.Req.Header.Get("X-Real-IP"))); realIP != nil {
+			return realIP
+		}
+	}
+	return ip
+}
+
+// IPFilterOptions is a struct for specifying configuration options for the macaron.IPFilter middleware.
+type IPFilterOptions struct {
+	// Allow is the list of CIDRs or IP addresses allowed, all addresses are allowed if it is empty.
+	Allow []string
+	// Deny is the list of CIDRs or IP addresses denied, which takes precedence over Allow.
+	Deny []string
+	// Status is the status code of response to denied requests. Default is 403 Forbidden.
+	Status int
+	// Body is the response body to denied requests. Default is the error page of the status.
+	Body string
+}
+
+func prepareIPFilterOptions(options []IPFilterOptions) IPFilterOptions {
+	var opt IPFilterOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Status == 0 {
+		opt.Status = http.StatusForbidden
+	}
+	return opt
+}
+
+// IPFilter returns a middleware handler that denies requests by IP address of clients given by
+// ClientIP, so it should be used along with SetTrustedProxies when the server is behind proxies.
+// It panics if any of addresses in options is invalid.
+func IPFilter(options ...IPFilterOptions) Handler {
+	opt := prepareIPFilterOptions(options)
+	allow, err := parseIPNets(opt.Allow)
+	if err != nil {
+		panic("IPFilter: " + err.Error())
+	}
+	deny, err := parseIPNets(opt.Deny)
+	if err != nil {
+		panic("IPFilter: " + err.Error())
+	}
+
+	return func(ctx *Context) {
+		ip := ctx.ClientIP()
+		if ip != nil && !containsIP(deny, ip) && (len(allow) == 0 || containsIP(allow, ip)) {
+			return
+		}
+
+		if len(opt.Body) == 0 {
+			writeErrorPage(ctx, ctx.Resp, opt.Status, "")
+			return
+		}
+		ctx.Resp.WriteHeader(opt.Status)
+		ctx.Resp.Write([]byte(opt.Body))
+	}
+}
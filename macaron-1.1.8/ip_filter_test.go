@@ -0,0 +1,98 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Context_ClientIP(t *testing.T) {
+	Convey("Get IP address of client", t, func() {
+		m := New()
+		So(m.SetTrustedProxies("10.0.0.0/8", "127.0.0.1"), ShouldBeNil)
+		So(m.SetTrustedProxies("10.0.0.0/33"), ShouldNotBeNil)
+		m.Get("/", func(ctx *Context) string {
+			return ctx.ClientIP().String()
+		})
+
+		serve := func(remote, forwarded, realIP string) string {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			req.RemoteAddr = remote
+			if len(forwarded) > 0 {
+				req.Header.Set("X-Forwarded-For", forwarded)
+			}
+			if len(realIP) > 0 {
+				req.Header.Set("X-Real-IP", realIP)
+			}
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+
+		So(serve("1.2.3.4:1234", "", ""), ShouldEqual, "1.2.3.4")
+		So(serve("1.2.3.4:1234", "5.6.7.8", "5.6.7.8"), ShouldEqual, "1.2.3.4")
+		So(serve("127.0.0.1:1234", "5.6.7.8", ""), ShouldEqual, "5.6.7.8")
+		So(serve("127.0.0.1:1234", "9.9.9.9, 5.6.7.8, 10.1.1.1", ""), ShouldEqual, "5.6.7.8")
+		So(serve("127.0.0.1:1234", "10.2.2.2, 10.1.1.1", ""), ShouldEqual, "10.2.2.2")
+		So(serve("127.0.0.1:1234", "", "5.6.7.8"), ShouldEqual, "5.6.7.8")
+		So(serve("[::1]:1234", "5.6.7.8", ""), ShouldEqual, "::1")
+	})
+}
+
+func Test_IPFilter(t *testing.T) {
+	Convey("Filter requests by IP address", t, func() {
+		m := New()
+		So(m.SetTrustedProxies("127.0.0.1"), ShouldBeNil)
+		m.Get("/admin", IPFilter(IPFilterOptions{
+			Allow: []string{"10.0.0.0/8", "192.168.1.1"},
+			Deny:  []string{"10.0.0.13"},
+		}), func() string { return "admin" })
+		m.Get("/internal", IPFilter(IPFilterOptions{
+			Deny:   []string{"0.0.0.0/0"},
+			Status: http.StatusNotFound,
+			Body:   "nothing here",
+		}), func() string { return "internal" })
+
+		serve := func(url, remote, forwarded string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			req.RemoteAddr = remote
+			if len(forwarded) > 0 {
+				req.Header.Set("X-Forwarded-For", forwarded)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		So(serve("/admin", "10.1.2.3:1234", "").Body.String(), ShouldEqual, "admin")
+		So(serve("/admin", "192.168.1.1:1234", "").Body.String(), ShouldEqual, "admin")
+		So(serve("/admin", "127.0.0.1:1234", "10.1.2.3").Body.String(), ShouldEqual, "admin")
+		So(serve("/admin", "192.168.1.2:1234", "").Code, ShouldEqual, http.StatusForbidden)
+		So(serve("/admin", "10.0.0.13:1234", "").Code, ShouldEqual, http.StatusForbidden)
+		So(serve("/admin", "8.8.8.8:1234", "10.1.2.3").Code, ShouldEqual, http.StatusForbidden)
+
+		resp := serve("/internal", "10.1.2.3:1234", "")
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldEqual, "nothing here")
+
+		So(func() { IPFilter(IPFilterOptions{Allow: []string{"nope"}}) }, ShouldPanic)
+	})
+}
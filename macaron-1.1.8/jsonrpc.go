@@ -0,0 +1,353 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+)
+
+// Error codes of JSON-RPC 2.0.
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+	RPC_SERVER_ERROR     = -32000
+)
+
+// RPCError is an error of JSON-RPC, methods return it to respond with given code and data.
+// Other errors are responded with code RPC_SERVER_ERROR and their messages.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("jsonrpc: %d %s", e.Code, e.Message)
+}
+
+type rpcResponse struct {
+	JSONRPC string
+	Result  interface{}
+	Error   *RPCError
+	ID      json.RawMessage
+}
+
+// MarshalJSON includes either result, which can be null, or error in the response.
+func (r *rpcResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *RPCError       `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{r.JSONRPC, r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{r.JSONRPC, r.Result, r.ID})
+}
+
+// rpcMethod is an exported method of a service that can be called by JSON-RPC.
+type rpcMethod struct {
+	rcvr   reflect.Value
+	method reflect.Method
+	// withContext is true if the first argument is *Context.
+	withContext bool
+	// ctxField is the index of *Context field of the receiver struct, or -1 if there is none.
+	ctxField int
+	params   []reflect.Type
+	// hasResult and hasError tell what the method returns.
+	hasResult bool
+	hasError  bool
+}
+
+// newRPCMethod returns the method if its signature is supported, or nil.
+func newRPCMethod(rcvr reflect.Value, method reflect.Method, ctxField int) *rpcMethod {
+	t := method.Type
+	m := &rpcMethod{rcvr: rcvr, method: method, ctxField: ctxField}
+	for i := 1; i < t.NumIn(); i++ {
+		if i == 1 && t.In(i) == contextType {
+			m.withContext = true
+			continue
+		}
+		m.params = append(m.params, t.In(i))
+	}
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		m.hasError = t.Out(0) == errorType
+		m.hasResult = !m.hasError
+	case 2:
+		if t.Out(1) != errorType {
+			return nil
+		}
+		m.hasResult, m.hasError = true, true
+	default:
+		return nil
+	}
+	return m
+}
+
+// decodeParams decodes positional params from an array, or named params from an object
+// into the only param of the method.
+func (m *rpcMethod) decodeParams(raw json.RawMessage) ([]reflect.Value, error) {
+	raw = bytes.TrimSpace(raw)
+	args := make([]reflect.Value, len(m.params))
+	for i, t := range m.params {
+		args[i] = reflect.New(t)
+	}
+
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '[':
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+		if len(list) != len(m.params) {
+			return nil, fmt.Errorf("expect %d params but got %d", len(m.params), len(list))
+		}
+		for i := range list {
+			if err := json.Unmarshal(list[i], args[i].Interface()); err != nil {
+				return nil, fmt.Errorf("param %d: %v", i, err)
+			}
+		}
+	case raw[0] == '{':
+		if len(m.params) != 1 {
+			return nil, fmt.Errorf("named params require exactly 1 param but method has %d", len(m.params))
+		}
+		if err := json.Unmarshal(raw, args[0].Interface()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("params must be an array or an object")
+	}
+
+	for i := range args {
+		args[i] = args[i].Elem()
+	}
+	return args, nil
+}
+
+// call calls the method with the request, and returns its result or error.
+func (m *rpcMethod) call(ctx *Context, params json.RawMessage) (result interface{}, rpcErr *RPCError) {
+	args, err := m.decodeParams(params)
+	if err != nil {
+		return nil, &RPCError{Code: RPC_INVALID_PARAMS, Message: "Invalid params: " + err.Error()}
+	}
+
+	rcvr := m.rcvr
+	if m.ctxField >= 0 {
+		// Methods are called on a copy of the receiver with Context of the request.
+		copied := reflect.New(rcvr.Elem().Type())
+		copied.Elem().Set(rcvr.Elem())
+		copied.Elem().Field(m.ctxField).Set(reflect.ValueOf(ctx))
+		rcvr = copied
+	}
+	in := make([]reflect.Value, 0, len(args)+2)
+	in = append(in, rcvr)
+	if m.withContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, args...)
+
+	defer func() {
+		if err := recover(); err != nil {
+			if ctx.Router != nil && ctx.m != nil {
+				ctx.m.ErrorLogger().Printf("%sPANIC in JSON-RPC method %s: %v", requestTag(ctx), m.method.Name, err)
+			}
+			result, rpcErr = nil, &RPCError{Code: RPC_INTERNAL_ERROR, Message: "Internal error"}
+		}
+	}()
+	out := m.method.Func.Call(in)
+
+	if m.hasError {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			var e *RPCError
+			if errors.As(err, &e) {
+				return nil, e
+			}
+			return nil, &RPCError{Code: RPC_SERVER_ERROR, Message: err.Error()}
+		}
+	}
+	if m.hasResult {
+		return out[0].Interface(), nil
+	}
+	return nil, nil
+}
+
+// rpcServer dispatches JSON-RPC requests to methods of services.
+type rpcServer struct {
+	methods map[string]*rpcMethod
+}
+
+// newRPCServer reflects exported methods of services, which are named by their types, e.g. "Arith.Add".
+func newRPCServer(services []interface{}) *rpcServer {
+	s := &rpcServer{methods: make(map[string]*rpcMethod)}
+	for _, svc := range services {
+		rcvr := reflect.ValueOf(svc)
+		t := rcvr.Type()
+		name := reflect.Indirect(rcvr).Type().Name()
+		if len(name) == 0 {
+			panic(fmt.Sprintf("jsonrpc: service of type %v has no name", t))
+		}
+
+		ctxField := -1
+		if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+			for i := 0; i < t.Elem().NumField(); i++ {
+				f := t.Elem().Field(i)
+				if f.Type == contextType && f.PkgPath == "" {
+					ctxField = i
+					break
+				}
+			}
+		}
+
+		n := 0
+		for i := 0; i < t.NumMethod(); i++ {
+			m := newRPCMethod(rcvr, t.Method(i), ctxField)
+			if m == nil {
+				continue
+			}
+			full := name + "." + m.method.Name
+			if _, ok := s.methods[full]; ok {
+				panic("jsonrpc: duplicate method " + full)
+			}
+			s.methods[full] = m
+			n++
+		}
+		if n == 0 {
+			panic("jsonrpc: service " + name + " has no method")
+		}
+	}
+	return s
+}
+
+// handle handles a single request, and returns nil for notifications.
+func (s *rpcServer) handle(ctx *Context, raw json.RawMessage) *rpcResponse {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPC_INVALID_REQUEST, Message: "Invalid request"}}
+	}
+	id, hasID := fields["id"]
+	resp := &rpcResponse{JSONRPC: "2.0", ID: id}
+	if !hasID {
+		resp.ID = json.RawMessage("null")
+	}
+
+	var version, method string
+	if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" ||
+		json.Unmarshal(fields["method"], &method) != nil || len(method) == 0 {
+		resp.Error = &RPCError{Code: RPC_INVALID_REQUEST, Message: "Invalid request"}
+		return resp
+	}
+
+	m, ok := s.methods[method]
+	if !ok {
+		resp.Error = &RPCError{Code: RPC_METHOD_NOT_FOUND, Message: "Method not found"}
+	} else {
+		resp.Result, resp.Error = m.call(ctx, fields["params"])
+	}
+	if !hasID {
+		return nil
+	}
+	return resp
+}
+
+func writeRPC(ctx *Context, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(&rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPC_INTERNAL_ERROR, Message: "Internal error"}})
+	}
+	ctx.Resp.Header().Set(_CONTENT_TYPE, _CONTENT_JSON+"; charset="+_DEFAULT_CHARSET)
+	ctx.Resp.WriteHeader(http.StatusOK)
+	ctx.Resp.Write(data)
+}
+
+func (s *rpcServer) serve(ctx *Context) {
+	body, err := ioutil.ReadAll(ctx.Req.Request.Body)
+	body = bytes.TrimSpace(body)
+	if err != nil || !json.Valid(body) {
+		writeRPC(ctx, &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPC_PARSE_ERROR, Message: "Parse error"}})
+		return
+	}
+
+	if body[0] != '[' {
+		if resp := s.handle(ctx, body); resp != nil {
+			writeRPC(ctx, resp)
+		} else {
+			ctx.Resp.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(body, &batch)
+	if len(batch) == 0 {
+		writeRPC(ctx, &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPC_INVALID_REQUEST, Message: "Invalid request"}})
+		return
+	}
+	resps := make([]*rpcResponse, 0, len(batch))
+	for _, raw := range batch {
+		if resp := s.handle(ctx, raw); resp != nil {
+			resps = append(resps, resp)
+		}
+	}
+	if len(resps) == 0 {
+		ctx.Resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPC(ctx, resps)
+}
+
+// JSONRPC registers a JSON-RPC 2.0 endpoint that accepts POST requests, including batches, and calls
+// exported methods of services, named by their types and methods, e.g. "Arith.Add". It panics if
+// any of services has no method that can be called.
+//
+// Methods take params positionally, or a single param by name from an object, and may take *Context
+// as the first argument. Methods of a pointer to struct that has an exported *Context field are called
+// on a copy of the struct with the field set to Context of the request. They return a result and/or
+// an error, which is responded as it is if it is *RPCError, e.g.
+//
+//	type Arith struct {
+//		Ctx *macaron.Context
+//	}
+//
+//	func (a *Arith) Div(x, y int) (int, error) {
+//		if y == 0 {
+//			return 0, &macaron.RPCError{Code: 1, Message: "division by zero"}
+//		}
+//		return x / y, nil
+//	}
+//
+//	m.JSONRPC("/rpc", &Arith{})
+func (r *Router) JSONRPC(pattern string, services ...interface{}) *Route {
+	s := newRPCServer(services)
+	return r.Post(pattern, s.serve)
+}
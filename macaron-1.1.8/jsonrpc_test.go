@@ -0,0 +1,115 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Arith struct {
+	Ctx *Context
+}
+
+type ArithPair struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func (a *Arith) Add(x, y int) int {
+	return x + y
+}
+
+func (a *Arith) Div(p ArithPair) (int, error) {
+	if p.Y == 0 {
+		return 0, &RPCError{Code: 1, Message: "division by zero", Data: p}
+	}
+	return p.X / p.Y, nil
+}
+
+func (a *Arith) Agent() string {
+	return a.Ctx.Req.UserAgent()
+}
+
+func (a *Arith) Path(ctx *Context) (string, error) {
+	return ctx.Req.URL.Path, errors.New("just failed")
+}
+
+func (a *Arith) Crash() {
+	panic("boom")
+}
+
+func Test_Router_JSONRPC(t *testing.T) {
+	Convey("Serve JSON-RPC 2.0 requests", t, func() {
+		m := New()
+		m.SetLogOutputs(nil, new(strings.Builder))
+		m.JSONRPC("/rpc", &Arith{})
+
+		call := func(body string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/rpc", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			req.Header.Set("User-Agent", "tester")
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		So(call(`{"jsonrpc":"2.0","method":"Arith.Add","params":[1,2],"id":1}`).Body.String(), ShouldEqual,
+			`{"jsonrpc":"2.0","result":3,"id":1}`)
+		So(call(`{"jsonrpc":"2.0","method":"Arith.Div","params":{"x":7,"y":2},"id":"a"}`).Body.String(), ShouldEqual,
+			`{"jsonrpc":"2.0","result":3,"id":"a"}`)
+		So(call(`{"jsonrpc":"2.0","method":"Arith.Div","params":{"x":7,"y":0},"id":2}`).Body.String(), ShouldEqual,
+			`{"jsonrpc":"2.0","error":{"code":1,"message":"division by zero","data":{"x":7,"y":0}},"id":2}`)
+		So(call(`{"jsonrpc":"2.0","method":"Arith.Agent","id":3}`).Body.String(), ShouldEqual,
+			`{"jsonrpc":"2.0","result":"tester","id":3}`)
+		So(call(`{"jsonrpc":"2.0","method":"Arith.Path","id":null}`).Body.String(), ShouldEqual,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"just failed"},"id":null}`)
+		So(call(`{"jsonrpc":"2.0","method":"Arith.Crash","id":4}`).Body.String(), ShouldEqual,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":4}`)
+
+		Convey("Errors of requests", func() {
+			So(call(`{"jsonrpc":"2.0","method":"Arith.Nope","id":1}`).Body.String(), ShouldContainSubstring, `"code":-32601`)
+			So(call(`{"jsonrpc":"2.0","method":"Arith.Add","params":[1],"id":1}`).Body.String(), ShouldContainSubstring, `"code":-32602`)
+			So(call(`{"method":"Arith.Add","id":1}`).Body.String(), ShouldContainSubstring, `"code":-32600`)
+			So(call(`{"jsonrpc":`).Body.String(), ShouldContainSubstring, `"code":-32700`)
+			So(call(`[]`).Body.String(), ShouldContainSubstring, `"code":-32600`)
+		})
+
+		Convey("Batches and notifications", func() {
+			resp := call(`[
+				{"jsonrpc":"2.0","method":"Arith.Add","params":[1,1],"id":1},
+				{"jsonrpc":"2.0","method":"Arith.Add","params":[2,2]},
+				1,
+				{"jsonrpc":"2.0","method":"Arith.Add","params":[3,3],"id":2}
+			]`)
+			So(resp.Body.String(), ShouldEqual, `[{"jsonrpc":"2.0","result":2,"id":1},`+
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"},"id":null},`+
+				`{"jsonrpc":"2.0","result":6,"id":2}]`)
+
+			resp = call(`{"jsonrpc":"2.0","method":"Arith.Add","params":[1,1]}`)
+			So(resp.Code, ShouldEqual, http.StatusNoContent)
+			So(resp.Body.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Services without methods", func() {
+			So(func() { m.JSONRPC("/bad", &struct{}{}) }, ShouldPanic)
+		})
+	})
+}
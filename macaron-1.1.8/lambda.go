@@ -0,0 +1,249 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// LambdaRequest is an event of AWS Lambda from API Gateway REST APIs (payload version 1.0),
+// HTTP APIs (payload version 2.0), or Application Load Balancers.
+type LambdaRequest struct {
+	// Version is "2.0" for HTTP APIs, and "1.0" or empty for others.
+	Version string `json:"version"`
+
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	Headers                         map[string]string   `json:"headers"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+
+	// RawPath, RawQueryString and Cookies are of payload version 2.0.
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	RequestContext struct {
+		RequestID string `json:"requestId"`
+		Identity  struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+		ELB *struct {
+			TargetGroupArn string `json:"targetGroupArn"`
+		} `json:"elb"`
+	} `json:"requestContext"`
+
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+}
+
+// LambdaResponse is the response to API Gateway or Application Load Balancer.
+type LambdaResponse struct {
+	StatusCode int `json:"statusCode"`
+	// StatusDescription is required by Application Load Balancers.
+	StatusDescription string              `json:"statusDescription,omitempty"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	// Cookies are of payload version 2.0.
+	Cookies         []string `json:"cookies,omitempty"`
+	Body            string   `json:"body"`
+	IsBase64Encoded bool     `json:"isBase64Encoded"`
+}
+
+// lambdaResponseWriter records the response of the request.
+type lambdaResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *lambdaResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *lambdaResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *lambdaResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *lambdaResponseWriter) Flush() {}
+
+// newLambdaRequest converts the event into a request.
+func newLambdaRequest(ctx context.Context, event *LambdaRequest) (*http.Request, error) {
+	method, path, sourceIP := event.HTTPMethod, event.Path, event.RequestContext.Identity.SourceIP
+	var rawPath string
+	query := make(url.Values)
+	if event.Version == "2.0" {
+		method, sourceIP = event.RequestContext.HTTP.Method, event.RequestContext.HTTP.SourceIP
+		// Paths of HTTP APIs are passed as they are, without decoding.
+		var err error
+		rawPath = event.RawPath
+		if path, err = url.PathUnescape(rawPath); err != nil {
+			return nil, err
+		}
+		if query, err = url.ParseQuery(event.RawQueryString); err != nil {
+			return nil, err
+		}
+	} else {
+		// Application Load Balancers pass query parameters as they are, without decoding.
+		unescape := func(s string) string {
+			if event.RequestContext.ELB != nil {
+				if v, err := url.QueryUnescape(s); err == nil {
+					return v
+				}
+			}
+			return s
+		}
+		if len(event.MultiValueQueryStringParameters) > 0 {
+			for k, vs := range event.MultiValueQueryStringParameters {
+				for _, v := range vs {
+					query.Add(unescape(k), unescape(v))
+				}
+			}
+		} else {
+			for k, v := range event.QueryStringParameters {
+				query.Set(unescape(k), unescape(v))
+			}
+		}
+	}
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(event.Body); err != nil {
+			return nil, fmt.Errorf("lambda: invalid base64 body: %v", err)
+		}
+	}
+
+	u := &url.URL{Path: path, RawPath: rawPath, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(event.MultiValueHeaders) > 0 {
+		for k, vs := range event.MultiValueHeaders {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	} else {
+		for k, v := range event.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	if len(event.Cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(event.Cookies, "; "))
+	}
+	req.Host = req.Header.Get("Host")
+	req.RequestURI = u.RequestURI()
+	if len(sourceIP) > 0 {
+		req.RemoteAddr = net.JoinHostPort(sourceIP, "0")
+	}
+	if len(event.RequestContext.RequestID) > 0 && len(req.Header.Get("X-Request-Id")) == 0 {
+		req.Header.Set("X-Request-Id", event.RequestContext.RequestID)
+	}
+	return req, nil
+}
+
+// isTextContent returns true if the response can be returned as text rather than base64.
+func isTextContent(header http.Header, body []byte) bool {
+	if len(header.Get("Content-Encoding")) > 0 || !utf8.Valid(body) {
+		return false
+	}
+	typ := strings.ToLower(header.Get(_CONTENT_TYPE))
+	if len(typ) == 0 {
+		return true
+	}
+	for _, t := range []string{"text/", "json", "xml", "javascript", "x-www-form-urlencoded"} {
+		if strings.Contains(typ, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeLambda serves the event of AWS Lambda from API Gateway or Application Load Balancer through
+// ServeHTTP, and converts the response back, so applications can be deployed to Lambda as they are.
+// No AWS library is required by Macaron, it is started by the one of application, e.g.
+//
+//	lambda.Start(m.ServeLambda)
+//
+// Other serverless platforms that pass http.Request, e.g. Google Cloud Functions, can call ServeHTTP directly.
+func (m *Macaron) ServeLambda(ctx context.Context, payload json.RawMessage) (*LambdaResponse, error) {
+	var event LambdaRequest
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("lambda: invalid event: %v", err)
+	}
+	req, err := newLambdaRequest(ctx, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &lambdaResponseWriter{header: make(http.Header)}
+	m.ServeHTTP(rw, req)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	resp := &LambdaResponse{StatusCode: rw.status}
+	if event.RequestContext.ELB != nil {
+		resp.StatusDescription = fmt.Sprintf("%d %s", rw.status, http.StatusText(rw.status))
+	}
+	header := rw.header
+	if event.Version == "2.0" {
+		resp.Cookies = header["Set-Cookie"]
+		header.Del("Set-Cookie")
+	}
+	if len(event.MultiValueHeaders) > 0 {
+		resp.MultiValueHeaders = header
+	} else {
+		resp.Headers = make(map[string]string, len(header))
+		for k, vs := range header {
+			resp.Headers[k] = strings.Join(vs, ", ")
+		}
+	}
+
+	body := rw.body.Bytes()
+	if isTextContent(header, body) {
+		resp.Body = string(body)
+	} else {
+		resp.Body = base64.StdEncoding.EncodeToString(body)
+		resp.IsBase64Encoded = true
+	}
+	return resp, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Macaron_ServeLambda(t *testing.T) {
+	Convey("Serve AWS Lambda events", t, func() {
+		m := New()
+		m.Post("/items/:id", func(ctx *Context) string {
+			body, _ := ioutil.ReadAll(ctx.Req.Body().ReadCloser())
+			ctx.SetCookie("a", "1")
+			ctx.SetCookie("b", "2")
+			return ctx.Params("id") + " " + ctx.Query("q") + " " + string(body) + " " +
+				ctx.GetCookie("sid") + " " + ctx.RemoteAddr() + " " + ctx.Req.Header.Get("X-Request-Id")
+		})
+		m.Get("/files/:name", func(ctx *Context) string {
+			return ctx.Params("name") + " " + ctx.Req.URL.Path + " " + ctx.Req.URL.EscapedPath()
+		})
+		m.Get("/bin", func(ctx *Context) {
+			ctx.Resp.Header().Set("Content-Type", "image/png")
+			ctx.Resp.Write([]byte{0x89, 'P', 'N', 'G'})
+		})
+
+		Convey("REST APIs", func() {
+			resp, err := m.ServeLambda(context.Background(), []byte(`{
+				"httpMethod": "POST", "path": "/items/7",
+				"multiValueHeaders": {"Cookie": ["sid=abc"], "Host": ["api.example.com"]},
+				"multiValueQueryStringParameters": {"q": ["a b"]},
+				"requestContext": {"requestId": "r-1", "identity": {"sourceIp": "1.2.3.4"}},
+				"body": "aGVsbG8=", "isBase64Encoded": true}`))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.Body, ShouldEqual, "7 a b hello abc 1.2.3.4 r-1")
+			So(resp.IsBase64Encoded, ShouldBeFalse)
+			So(resp.MultiValueHeaders["Set-Cookie"], ShouldHaveLength, 2)
+			So(resp.Headers, ShouldBeNil)
+		})
+
+		Convey("HTTP APIs", func() {
+			resp, err := m.ServeLambda(context.Background(), []byte(`{
+				"version": "2.0", "rawPath": "/items/8", "rawQueryString": "q=x%26y",
+				"cookies": ["sid=def"], "headers": {"host": "api.example.com"},
+				"requestContext": {"http": {"method": "POST", "sourceIp": "5.6.7.8"}},
+				"body": "hi"}`))
+			So(err, ShouldBeNil)
+			So(resp.Body, ShouldEqual, "8 x&y hi def 5.6.7.8 ")
+			So(resp.Cookies, ShouldResemble, []string{"a=1; Path=/", "b=2; Path=/"})
+			So(resp.Headers, ShouldNotBeNil)
+			So(resp.MultiValueHeaders, ShouldBeNil)
+
+			// Paths are passed without decoding.
+			resp, err = m.ServeLambda(context.Background(), []byte(`{
+				"version": "2.0", "rawPath": "/files/a%20b%3F",
+				"requestContext": {"http": {"method": "GET"}}}`))
+			So(err, ShouldBeNil)
+			So(resp.Body, ShouldEqual, "a b? /files/a b? /files/a%20b%3F")
+		})
+
+		Convey("Application Load Balancers", func() {
+			resp, err := m.ServeLambda(context.Background(), []byte(`{
+				"httpMethod": "GET", "path": "/bin",
+				"queryStringParameters": {"q": "a%20b"},
+				"requestContext": {"elb": {"targetGroupArn": "arn"}}}`))
+			So(err, ShouldBeNil)
+			So(resp.StatusDescription, ShouldEqual, "200 OK")
+			So(resp.IsBase64Encoded, ShouldBeTrue)
+			So(resp.Body, ShouldEqual, base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'}))
+
+			resp, err = m.ServeLambda(context.Background(), []byte(`{"httpMethod": "GET", "path": "/none",
+				"requestContext": {"elb": {"targetGroupArn": "arn"}}}`))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 404)
+			So(resp.StatusDescription, ShouldEqual, "404 Not Found")
+		})
+
+		Convey("Invalid events", func() {
+			_, err := m.ServeLambda(context.Background(), []byte(`[`))
+			So(err, ShouldNotBeNil)
+			_, err = m.ServeLambda(context.Background(), []byte(`{"httpMethod": "GET", "body": "!", "isBase64Encoded": true}`))
+			So(err, ShouldNotBeNil)
+			_, err = m.ServeLambda(context.Background(), []byte(`{"version": "2.0", "rawPath": "/%zz"}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
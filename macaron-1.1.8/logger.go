@@ -20,6 +20,7 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"sort"
 	"time"
 )
 
@@ -32,17 +33,57 @@ func init() {
 	ColorLog = runtime.GOOS != "windows"
 }
 
+// requestTag returns the request ID of given context in the form of "[id] ",
+// so log lines belong to the same request can be grouped together.
+func requestTag(ctx *Context) string {
+	if id := ctx.RequestID(); len(id) > 0 {
+		return "[" + id + "] "
+	}
+	return ""
+}
+
+// LoggerOptions is a struct for specifying configuration options for the macaron.Logger middleware.
+type LoggerOptions struct {
+	// CollectStats enables collecting latency statistics of every route,
+	// which can be retrieved by Macaron.Stats.
+	CollectStats bool
+	// LatencyBuckets are upper bounds of latency histogram buckets of statistics.
+	// Default is 5ms, 10ms, 25ms, 50ms, 100ms, 250ms, 500ms, 1s, 2.5s, 5s and 10s.
+	LatencyBuckets []time.Duration
+}
+
+func prepareLoggerOptions(options []LoggerOptions) LoggerOptions {
+	var opt LoggerOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.LatencyBuckets) == 0 {
+		opt.LatencyBuckets = defaultLatencyBuckets
+	}
+	opt.LatencyBuckets = append([]time.Duration(nil), opt.LatencyBuckets...)
+	sort.Slice(opt.LatencyBuckets, func(i, j int) bool { return opt.LatencyBuckets[i] < opt.LatencyBuckets[j] })
+	return opt
+}
+
 // Logger returns a middleware handler that logs the request as it goes in and the response as it goes out.
-func Logger() Handler {
+func Logger(options ...LoggerOptions) Handler {
+	opt := prepareLoggerOptions(options)
+
 	return func(ctx *Context, log *log.Logger) {
 		start := time.Now()
 
-		log.Printf("%s: Started %s %s for %s", time.Now().Format(LogTimeFormat), ctx.Req.Method, ctx.Req.RequestURI, ctx.RemoteAddr())
+		log.Printf("%s: %sStarted %s %s for %s", time.Now().Format(LogTimeFormat), requestTag(ctx), ctx.Req.Method, ctx.Req.RequestURI, ctx.RemoteAddr())
 
 		rw := ctx.Resp.(ResponseWriter)
 		ctx.Next()
 
-		content := fmt.Sprintf("%s: Completed %s %v %s in %v", time.Now().Format(LogTimeFormat), ctx.Req.RequestURI, rw.Status(), http.StatusText(rw.Status()), time.Since(start))
+		if opt.CollectStats {
+			ctx.m.stats.record(ctx.Req.Method, ctx.RoutePattern(), rw.Status(), time.Since(start), opt.LatencyBuckets)
+		}
+
+		content := fmt.Sprintf("%s: %sCompleted %s %v %s in %v", time.Now().Format(LogTimeFormat), requestTag(ctx), ctx.Req.RequestURI, rw.Status(), http.StatusText(rw.Status()), time.Since(start))
 		if ColorLog {
 			switch rw.Status() {
 			case 200, 201, 202:
@@ -20,6 +20,8 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/Unknwon/com"
@@ -46,6 +48,33 @@ func Test_Logger(t *testing.T) {
 		So(len(buf.String()), ShouldBeGreaterThan, 0)
 	})
 
+	Convey("Logger with request ID", t, func() {
+		buf := bytes.NewBufferString("")
+		m := New()
+		m.Map(log.New(buf, "[Macaron] ", 0))
+		m.Use(Logger())
+		m.Get("/", func() {})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:4000/", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-Request-Id", "c0ffee")
+		m.ServeHTTP(resp, req)
+		So(strings.Count(buf.String(), "[c0ffee] "), ShouldEqual, 2)
+
+		// Invalid IDs are replaced by generated ones.
+		for _, id := range []string{"", "a]\nforged", strings.Repeat("a", 65)} {
+			buf.Reset()
+			resp = httptest.NewRecorder()
+			req.Header.Set("X-Request-Id", id)
+			m.ServeHTTP(resp, req)
+			match := regexp.MustCompile(`\[([0-9a-f]{32})\] `).FindStringSubmatch(buf.String())
+			So(match, ShouldHaveLength, 2)
+			So(strings.Count(buf.String(), match[0]), ShouldEqual, 2)
+			So(buf.String(), ShouldNotContainSubstring, "forged")
+		}
+	})
+
 	if ColorLog {
 		Convey("Color console output", t, func() {
 			m := Classic()
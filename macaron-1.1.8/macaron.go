@@ -18,13 +18,18 @@
 package macaron
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/cgi"
 	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Unknwon/com"
 	"gopkg.in/ini.v1"
@@ -53,6 +58,7 @@ func validateHandler(h Handler) {
 	if reflect.TypeOf(h).Kind() != reflect.Func {
 		panic("Macaron handler must be a callable function")
 	}
+	planOf(reflect.TypeOf(h))
 }
 
 // 批量校验
@@ -64,6 +70,35 @@ func validateHandlers(handlers []Handler) {
 	}
 }
 
+// wrapHandler converts http.Handler, including http.HandlerFunc, and functions with the signature
+// of http.HandlerFunc into handlers that serve the request with route params in its context,
+// which can be read by ParamsFromContext. The writer and request are injected as arguments of
+// other handlers are, so those remapped by earlier handlers are used. Other handlers are returned
+// unchanged.
+func wrapHandler(h Handler) Handler {
+	var hh http.Handler
+	switch v := h.(type) {
+	case http.Handler:
+		hh = v
+	case func(http.ResponseWriter, *http.Request):
+		hh = http.HandlerFunc(v)
+	default:
+		return h
+	}
+	return func(ctx *Context, rw http.ResponseWriter, req *http.Request) {
+		hh.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), paramsContextKey{}, ctx.params.toMap())))
+	}
+}
+
+// wrapHandlers converts http.Handler values in handlers by wrapHandler.
+func wrapHandlers(handlers []Handler) []Handler {
+	wrapped := make([]Handler, len(handlers))
+	for i, h := range handlers {
+		wrapped[i] = wrapHandler(h)
+	}
+	return wrapped
+}
+
 //-----------------------------------------------------
 
 
@@ -81,6 +116,38 @@ type Macaron struct {
 				     // 这里需要理解一下: 是 嵌入, 不是 循环引用, 跟Macaron定义不冲突
 
 	logger       *log.Logger     // 日志记录器
+	errLogger    *log.Logger
+	stats        *routeStats
+	mapped       []reflect.Type  // Types of global services.
+	named        namedServices   // Services mapped by name.
+	providers    map[reflect.Type]interface{} // Lazy providers of request services.
+	constructors *constructors                // Constructors of global services.
+	trustedProxies []*net.IPNet               // Proxies whose forwarded headers are trusted.
+	allowedHosts   []string                   // Hosts accepted in Host header, all if empty.
+	problemDetails bool                       // Write error responses as RFC 7807 problems.
+	proxyProtocol  []string                   // Load balancers of PROXY protocol, disabled if nil.
+	config         *configStore               // Configuration of the instance, the global one if nil.
+}
+
+// Map maps the value as a global service of its own type.
+func (m *Macaron) Map(val interface{}) inject.TypeMapper {
+	m.Injector.Map(val)
+	m.mapped = append(m.mapped, reflect.TypeOf(val))
+	return m
+}
+
+// MapTo maps the value as a global service of the interface type that ifacePtr points to.
+func (m *Macaron) MapTo(val interface{}, ifacePtr interface{}) inject.TypeMapper {
+	m.Injector.MapTo(val, ifacePtr)
+	m.mapped = append(m.mapped, inject.InterfaceOf(ifacePtr))
+	return m
+}
+
+// Set maps the value as a global service of given type.
+func (m *Macaron) Set(typ reflect.Type, val reflect.Value) inject.TypeMapper {
+	m.Injector.Set(typ, val)
+	m.mapped = append(m.mapped, typ)
+	return m
 }
 
 // NewWithLogger creates a bare bones Macaron instance.
@@ -92,13 +159,29 @@ func NewWithLogger(out io.Writer) *Macaron {
 		action:   func() {},
 		Router:   NewRouter(),
 		logger:   log.New(out, "[Macaron] ", 0),
+		stats:    newRouteStats(),
 	}
 	m.Router.m = m
 	m.Map(m.logger)
 	m.Map(defaultReturnHandler())
-	m.NotFound(http.NotFound)
-	m.InternalServerError(func(rw http.ResponseWriter, err error) {
-		http.Error(rw, err.Error(), 500)
+	m.NotFound(defaultNotFound)
+	m.InternalServerError(func(ctx *Context, err error) {
+		var p *Problem
+		if errors.As(err, &p) {
+			writeProblem(ctx.Resp, p)
+			return
+		}
+		// Do not reveal internal error to users in production mode.
+		if Env == PROD {
+			m.ErrorLogger().Printf("%sERROR: %v", requestTag(ctx), err)
+			writeErrorPage(ctx, ctx.Resp, 500, ErrorTemplate)
+			return
+		}
+		if m.problemDetails && preferJSON(ctx.Req.Request) {
+			writeProblem(ctx.Resp, &Problem{Status: 500, Title: http.StatusText(500), Detail: err.Error()})
+			return
+		}
+		http.Error(ctx.Resp, err.Error(), 500)
 	})
 	return m
 }
@@ -154,7 +237,9 @@ func (m *Macaron) Before(handler BeforeHandler) {
 // Use adds a middleware Handler to the stack,
 // and panics if the handler is not a callable func.
 // Middleware Handlers are invoked in the order that they are added.
+// Values of http.Handler are also accepted, and serve the request directly.
 func (m *Macaron) Use(handler Handler) {
+	handler = wrapHandler(handler)
 	validateHandler(handler) // 校验
 	m.handlers = append(m.handlers, handler)  // 添加到方法列表
 }
@@ -164,22 +249,39 @@ func (m *Macaron) Use(handler Handler) {
 //	- 类似 flask 的上下文概念
 // 	- 这部分代码的实现, 注意阅读, 不好理解
 func (m *Macaron) createContext(rw http.ResponseWriter, req *http.Request) *Context {
-	c := &Context{
-		Injector: inject.New(),
-		handlers: m.handlers,
-		action:   m.action,
-		index:    0,
-		Router:   m.Router,
-		Req:      Request{req},
-		Resp:     NewResponseWriter(rw),
-		Render:   &DummyRender{rw},
-		Data:     make(map[string]interface{}),
+	c := contextPool.Get().(*Context)
+	m.initContext(c, rw, req)
+	return c
+}
+
+// initContext initializes the context taken from the pool for the request. Injector is created
+// for every request because mapped services cannot be removed from it.
+func (m *Macaron) initContext(c *Context, rw http.ResponseWriter, req *http.Request) {
+	c.Injector = inject.New()
+	c.handlers = m.handlers
+	c.action = m.action
+	c.index = 0
+	c.Router = m.Router
+	c.Req = Request{req}
+	c.rw = responseWriter{ResponseWriter: rw, start: time.Now()}
+	c.Resp = wrapResponseWriter(&c.rw)
+	c.Render = &DummyRender{rw}
+	// Data is kept by the context when it is reused, unless it has grown too large. Only fresh
+	// contexts allocate it, because it must not be nil for handlers writing to it directly.
+	if c.Data == nil {
+		c.Data = make(map[string]interface{})
 	}
 	c.SetParent(m)		// 关键方法
 	c.Map(c)
 	c.MapTo(c.Resp, (*http.ResponseWriter)(nil))
 	c.Map(req)
-	return c
+}
+
+// NewContext creates the context of the request as the instance does for requests it serves,
+// but without matching routes or running handlers, so handlers can be unit-tested in isolation,
+// e.g. by ctx.Invoke(handler). Global services and providers of the instance are available.
+func (m *Macaron) NewContext(rw http.ResponseWriter, req *http.Request) *Context {
+	return m.createContext(rw, req)
 }
 
 //*************************************
@@ -191,6 +293,9 @@ func (m *Macaron) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if m.hasURLPrefix {
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, m.urlPrefix)
 	}
+	if len(m.allowedHosts) > 0 && !m.checkHost(rw, req) {
+		return
+	}
 
 	// handler 钩子方法列表不空, 先行执行钩子方法
 	for _, h := range m.befores {
@@ -203,6 +308,15 @@ func (m *Macaron) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	m.Router.ServeHTTP(rw, req)
 }
 
+// MatchRoute returns the route that matches the method and path as Router.MatchRoute does,
+// with URL prefix of the instance removed from the path first.
+func (m *Macaron) MatchRoute(method, path string) *RouteMatch {
+	if m.hasURLPrefix {
+		path = strings.TrimPrefix(path, m.urlPrefix)
+	}
+	return m.Router.MatchRoute(method, path)
+}
+
 func GetDefaultListenInfo() (string, int) {
 	host := os.Getenv("HOST")
 	if len(host) == 0 {
@@ -215,12 +329,9 @@ func GetDefaultListenInfo() (string, int) {
 	return host, port
 }
 
-//*************************************
-// 模块入口:
-//
-// Run the http server. Listening on os.GetEnv("PORT") or 4000 by default.
-func (m *Macaron) Run(args ...interface{}) {
-	host, port := GetDefaultListenInfo()	// 获取默认IP+端口
+// listenAddr returns the address to listen on by optional host and port of Run.
+func listenAddr(args []interface{}) string {
+	host, port := GetDefaultListenInfo()
 	if len(args) == 1 {
 		switch arg := args[0].(type) {
 		case string:
@@ -236,11 +347,77 @@ func (m *Macaron) Run(args ...interface{}) {
 			port = arg
 		}
 	}
+	return host + ":" + com.ToStr(port)
+}
 
-	addr := host + ":" + com.ToStr(port)	// IP + 端口
+//*************************************
+// 模块入口:
+//
+// Run the http server. Listening on os.GetEnv("PORT") or 4000 by default.
+func (m *Macaron) Run(args ...interface{}) {
+	addr := listenAddr(args)	// IP + 端口
 	logger := m.GetVal(reflect.TypeOf(m.logger)).Interface().(*log.Logger)
 	logger.Printf("listening on %s (%s)\n", addr, safeEnv())
-	logger.Fatalln(http.ListenAndServe(addr, m))	// 启动监听服务
+	if m.proxyProtocol == nil {
+		logger.Fatalln(http.ListenAndServe(addr, m))	// 启动监听服务
+	}
+	l, err := m.listen(addr)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	logger.Fatalln(http.Serve(l, m))
+}
+
+// listen listens on the TCP address, accepting PROXY protocol headers if set by SetProxyProtocol.
+func (m *Macaron) listen(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil || m.proxyProtocol == nil {
+		return l, err
+	}
+	return ProxyProtocolListener(l, m.proxyProtocol...)
+}
+
+// RunCGI serves a single request as a CGI program by net/http/cgi, which reads the request from
+// environment variables and standard input, and writes the response to standard output. It is for
+// environments where a long-running listener is not possible, e.g. legacy hosting or git hooks.
+// Logs written to standard output are moved to standard error, so they do not corrupt the response.
+func (m *Macaron) RunCGI() error {
+	logger := m.GetVal(reflect.TypeOf(m.logger)).Interface().(*log.Logger)
+	if logger.Writer() == os.Stdout {
+		m.SetLogOutputs(os.Stderr, nil)
+	}
+	if m.errLogger != nil && m.errLogger.Writer() == os.Stdout {
+		m.SetLogOutputs(nil, os.Stderr)
+	}
+	return cgi.Serve(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// net/http/cgi does not set RequestURI, which is logged by Logger.
+		if len(req.RequestURI) == 0 {
+			req.RequestURI = req.URL.RequestURI()
+		}
+		m.ServeHTTP(rw, req)
+	}))
+}
+
+// SetLogOutputs sets output writers for access log and error log respectively.
+// Access log is written by middlewares like Logger and Static, and error log is
+// written by Recovery. A nil writer leaves corresponding output unchanged.
+func (m *Macaron) SetLogOutputs(access, errs io.Writer) {
+	if access != nil {
+		m.logger = log.New(access, "[Macaron] ", 0)
+		m.Map(m.logger)
+	}
+	if errs != nil {
+		m.errLogger = log.New(errs, "[Macaron] ", 0)
+	}
+}
+
+// ErrorLogger returns the logger for errors and panics. It is same as the access
+// logger unless a different output is set by SetLogOutputs.
+func (m *Macaron) ErrorLogger() *log.Logger {
+	if m.errLogger != nil {
+		return m.errLogger
+	}
+	return m.GetVal(reflect.TypeOf(m.logger)).Interface().(*log.Logger)
 }
 
 // SetURLPrefix sets URL prefix of router layer, so that it support suburl.
@@ -274,8 +451,6 @@ var (
 	// Flash applies to current request.
 	FlashNow bool
 
-	// Configuration convention object.
-	cfg *ini.File
 )
 
 func setENV(e string) {
@@ -304,17 +479,39 @@ func init() {
 	}
 }
 
-// SetConfig sets data sources for configuration.
-func SetConfig(source interface{}, others ...interface{}) (_ *ini.File, err error) {
-	cfg, err = ini.Load(source, others...)
-	return Config(), err
+// SetConfig sets data sources for configuration, later ones override values of earlier ones.
+// Sources are INI data or files, or files of other formats detected by extensions: YAML (".yaml",
+// ".yml"), TOML (".toml"), JSON (".json") and dotenv (".env"). Values of all formats are accessed
+// in the same way, nested keys are in sections named by their paths joined with ".", e.g.
+//
+//	macaron.SetConfig("conf/app.yaml", "conf/.env")
+//	host := macaron.Config().Section("database.replica").Key("host").String()
+//
+// Every file is overlaid by the file of current Env next to it if exists, e.g. "conf/app.production.ini"
+// for "conf/app.ini", or ".env.production" for ".env". Values are overridden in the order of: each file,
+// its overlay, later sources, and then environment variables with ConfigEnvPrefix, e.g. MACARON__DATABASE__HOST.
+// Sources can also be ConfigSource, e.g. ConsulSource or EtcdSource to manage settings centrally,
+// whose keys are merged with files in the same way:
+//
+//	macaron.SetConfig("conf/app.ini", &macaron.ConsulSource{Prefix: "services/app"})
+//
+// Sources can also be SecretSource, e.g. EnvSecrets, FileSecrets or VaultSecrets, which resolve
+// placeholders of secrets in values after everything else is loaded, e.g.
+//
+//	; conf/app.ini
+//	password = ${secret:database#password}
+//
+//	macaron.SetConfig("conf/app.ini", &macaron.VaultSecrets{Token: token}, macaron.EnvSecrets{})
+//
+// Sources can be reloaded later by ReloadConfig or WatchConfig.
+func SetConfig(source interface{}, others ...interface{}) (*ini.File, error) {
+	return defaultConfig.set(append([]interface{}{source}, others...))
 }
 
 // Config returns configuration convention object.
 // It returns an empty object if there is no one available.
+// The object is replaced rather than modified when configuration is reloaded,
+// so it should be called again to get new values.
 func Config() *ini.File {
-	if cfg == nil {
-		return ini.Empty()
-	}
-	return cfg
+	return defaultConfig.get()
 }
@@ -16,6 +16,8 @@
 package macaron
 
 import (
+	"bytes"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -46,6 +48,73 @@ func Test_New(t *testing.T) {
 	})
 }
 
+func Test_Macaron_SetLogOutputs(t *testing.T) {
+	Convey("Write access log and error log to different outputs", t, func() {
+		access := bytes.NewBufferString("")
+		errs := bytes.NewBufferString("")
+		m := New()
+		m.SetLogOutputs(access, errs)
+		m.Use(Logger())
+		m.Use(Recovery())
+		m.Get("/", func() {})
+		m.Get("/panic", func() {
+			panic("here is a panic!")
+		})
+
+		for _, url := range []string{"/", "/panic"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+		}
+		So(access.String(), ShouldContainSubstring, "Completed")
+		So(access.String(), ShouldNotContainSubstring, "PANIC")
+		So(errs.String(), ShouldContainSubstring, "PANIC: here is a panic!")
+		So(m.ErrorLogger(), ShouldNotBeNil)
+	})
+}
+
+func Test_Macaron_RunCGI(t *testing.T) {
+	Convey("Serve a request as CGI program", t, func() {
+		for k, v := range map[string]string{
+			"REQUEST_METHOD":  "GET",
+			"SERVER_PROTOCOL": "HTTP/1.1",
+			"REQUEST_URI":     "/hello/cgi?x=1",
+			"HTTP_HOST":       "example.com",
+		} {
+			os.Setenv(k, v)
+			defer os.Unsetenv(k)
+		}
+
+		stdout, stderr := os.Stdout, os.Stderr
+		r, w, err := os.Pipe()
+		So(err, ShouldBeNil)
+		logR, logW, err := os.Pipe()
+		So(err, ShouldBeNil)
+		os.Stdout, os.Stderr = w, logW
+		defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+		m := NewWithLogger(os.Stdout)
+		m.Use(Logger())
+		m.Get("/hello/:name", func(ctx *Context) string {
+			return "hello " + ctx.Params("name") + " " + ctx.Query("x")
+		})
+		So(m.RunCGI(), ShouldBeNil)
+		w.Close()
+		logW.Close()
+		os.Stdout, os.Stderr = stdout, stderr
+
+		out, err := ioutil.ReadAll(r)
+		So(err, ShouldBeNil)
+		So(string(out), ShouldStartWith, "Status: 200 OK\r\n")
+		So(string(out), ShouldEndWith, "\r\n\r\nhello cgi 1")
+
+		log, err := ioutil.ReadAll(logR)
+		So(err, ShouldBeNil)
+		So(string(log), ShouldContainSubstring, "Started GET /hello/cgi?x=1")
+	})
+}
+
 func Test_Macaron_Before(t *testing.T) {
 	Convey("Register before handlers", t, func() {
 		m := New()
@@ -174,6 +243,26 @@ func Test_Macaron_Written(t *testing.T) {
 	})
 }
 
+func Test_Macaron_NewContext(t *testing.T) {
+	Convey("Create context without routing", t, func() {
+		m := New()
+		m.Map("global")
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/users/42", nil)
+		So(err, ShouldBeNil)
+
+		ctx := m.NewContext(resp, req)
+		ctx.SetParams("id", "42")
+		_, err = ctx.Invoke(func(ctx *Context, s string) {
+			ctx.Resp.WriteHeader(http.StatusAccepted)
+			ctx.Resp.Write([]byte(s + " " + ctx.Params(":id")))
+		})
+		So(err, ShouldBeNil)
+		So(resp.Code, ShouldEqual, http.StatusAccepted)
+		So(resp.Body.String(), ShouldEqual, "global 42")
+	})
+}
+
 func Test_Macaron_Basic_NoRace(t *testing.T) {
 	Convey("Make sure no race between requests", t, func() {
 		m := New()
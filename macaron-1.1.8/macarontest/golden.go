@@ -0,0 +1,136 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macarontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/macaron.v1"
+)
+
+// updateGolden makes AssertGolden write golden files instead of comparing with them,
+// e.g. go test -run TestPages -macarontest.update.
+var updateGolden = flag.Bool("macarontest.update", false, "update golden files of response snapshots")
+
+// VolatileHeaders are removed from response snapshots because they change between runs.
+var VolatileHeaders = []string{"Date", "X-Request-Id", "Traceparent", "Tracestate"}
+
+// GoldenOptions represents options of response snapshots.
+type GoldenOptions struct {
+	// Headers removed from snapshots besides VolatileHeaders.
+	IgnoreHeaders []string
+	// Normalize changes the body before it is compared, e.g. to replace timestamps.
+	Normalize func(body []byte) []byte
+}
+
+// Snapshot returns the status, headers and body of the response as text. Volatile headers are
+// removed, headers are sorted, and JSON bodies are indented for readable differences.
+func Snapshot(resp *httptest.ResponseRecorder, opts ...GoldenOptions) string {
+	var opt GoldenOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	ignored := make(map[string]bool)
+	for _, name := range append(append([]string{}, VolatileHeaders...), opt.IgnoreHeaders...) {
+		ignored[http.CanonicalHeaderKey(name)] = true
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %s\n", resp.Code, http.StatusText(resp.Code))
+	header := resp.Result().Header
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if !ignored[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(&buf, "%s: %s\n", name, value)
+		}
+	}
+	buf.WriteString("\n")
+
+	body := resp.Body.Bytes()
+	if opt.Normalize != nil {
+		body = opt.Normalize(body)
+	}
+	var indented bytes.Buffer
+	if strings.Contains(header.Get("Content-Type"), "json") && json.Indent(&indented, body, "", "  ") == nil {
+		body = append(indented.Bytes(), '\n')
+	}
+	buf.Write(body)
+	return buf.String()
+}
+
+// firstDiffLine returns the number of first line that differs between texts, starting from 1.
+func firstDiffLine(a, b string) int {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(al) && i < len(bl); i++ {
+		if al[i] != bl[i] {
+			return i + 1
+		}
+	}
+	if len(al) < len(bl) {
+		return len(al) + 1
+	}
+	return len(bl) + 1
+}
+
+// AssertGolden serves the request by the instance, and compares snapshot of the response with the
+// golden file, which is created or updated instead when tests run with flag -macarontest.update, e.g.
+//
+//	macarontest.AssertGolden(t, m, macarontest.NewRequest("GET", "/users/42", nil), "testdata/user.golden")
+//
+// It reports the test as failed if they are different, and returns the recorder of the response.
+func AssertGolden(t testing.TB, m *macaron.Macaron, req *http.Request, golden string, opts ...GoldenOptions) *httptest.ResponseRecorder {
+	t.Helper()
+	resp := PerformRequest(m, req)
+	actual := Snapshot(resp, opts...)
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(golden), os.ModePerm); err != nil {
+			t.Fatalf("fail to create directory of golden file: %v", err)
+		}
+		if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+			t.Fatalf("fail to write golden file: %v", err)
+		}
+		return resp
+	}
+
+	data, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) {
+		t.Fatalf("golden file %s does not exist, run tests with -macarontest.update to create it", golden)
+	} else if err != nil {
+		t.Fatalf("fail to read golden file: %v", err)
+	}
+	if expected := string(data); expected != actual {
+		t.Errorf("response of %s %s differs from %s at line %d, run tests with -macarontest.update if it is expected\n"+
+			"--- expected\n%s\n+++ actual\n%s", req.Method, req.URL, golden, firstDiffLine(expected, actual), expected, actual)
+	}
+	return resp
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macarontest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+func Test_Snapshot(t *testing.T) {
+	Convey("Snapshot responses", t, func() {
+		m := macaron.New()
+		m.Use(macaron.Renderer())
+		m.Get("/users/:id", func(ctx *macaron.Context) {
+			ctx.Resp.Header().Set("Date", time.Now().Format(http.TimeFormat))
+			ctx.Resp.Header().Set("X-Request-Id", fmt.Sprint(time.Now().UnixNano()))
+			ctx.Resp.Header().Set("X-Total-Count", "1")
+			ctx.JSON(http.StatusOK, map[string]interface{}{
+				"id":         ctx.Params("id"),
+				"created_at": time.Now().Format(time.RFC3339Nano),
+			})
+		})
+
+		created := regexp.MustCompile(`"created_at":"[^"]*"`)
+		opt := GoldenOptions{
+			IgnoreHeaders: []string{"x-total-count"},
+			Normalize: func(body []byte) []byte {
+				return created.ReplaceAll(body, []byte(`"created_at":"<time>"`))
+			},
+		}
+		So(Snapshot(PerformRequest(m, NewRequest("GET", "/users/42", nil)), opt), ShouldEqual, `200 OK
+Content-Type: application/json; charset=UTF-8
+
+{
+  "created_at": "<time>",
+  "id": "42"
+}
+`)
+
+		Convey("Compare with golden files", func() {
+			dir, err := ioutil.TempDir("", "macarontest")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			golden := filepath.Join(dir, "testdata", "user.golden")
+
+			*updateGolden = true
+			AssertGolden(t, m, NewRequest("GET", "/users/42", nil), golden, opt)
+			*updateGolden = false
+			data, err := ioutil.ReadFile(golden)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"id": "42"`)
+
+			rec := &recordingTB{TB: t}
+			resp := AssertGolden(rec, m, NewRequest("GET", "/users/42", nil), golden, opt)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(rec.errors, ShouldBeEmpty)
+
+			AssertGolden(rec, m, NewRequest("GET", "/users/43", nil), golden, opt)
+			So(rec.errors, ShouldHaveLength, 1)
+			So(rec.errors[0], ShouldContainSubstring, "at line 6")
+		})
+	})
+}
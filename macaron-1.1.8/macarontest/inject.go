@@ -0,0 +1,120 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macarontest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-macaron/inject"
+)
+
+// mappedAs is a dependency mapped as an interface type.
+type mappedAs struct {
+	val      interface{}
+	ifacePtr interface{}
+}
+
+// As returns a dependency of Inject that is mapped as the interface type that ifacePtr points to,
+// which is required when more than one dependency implements the interface, e.g.
+//
+//	macarontest.Inject(t, handler, macarontest.As(primary, (*Store)(nil)), replica)
+func As(val, ifacePtr interface{}) interface{} {
+	return mappedAs{val, ifacePtr}
+}
+
+// Inject invokes the handler with arguments resolved from the dependencies only, e.g. fakes of
+// services, and returns values returned by the handler, e.g.
+//
+//	vals := macarontest.Inject(t, GetUser, ctx, &fakeStore{})
+//
+// Arguments of interface types are resolved by dependencies that implement them. The test fails
+// with the list of arguments that cannot be resolved, or are resolved ambiguously, without
+// invoking the handler.
+func Inject(t testing.TB, handler interface{}, deps ...interface{}) []reflect.Value {
+	t.Helper()
+	ht := reflect.TypeOf(handler)
+	if ht == nil || ht.Kind() != reflect.Func {
+		t.Fatalf("macarontest: handler must be a function, but got %T", handler)
+		return nil
+	}
+
+	inj := inject.New()
+	given := make([]string, 0, len(deps))
+	concrete := make([]reflect.Type, 0, len(deps))
+	for i, dep := range deps {
+		switch v := dep.(type) {
+		case nil:
+			t.Fatalf("macarontest: dependency %d is nil, use As to map nil values of interfaces", i+1)
+			return nil
+		case mappedAs:
+			inj.MapTo(v.val, v.ifacePtr)
+			given = append(given, inject.InterfaceOf(v.ifacePtr).String())
+		default:
+			inj.Map(dep)
+			given = append(given, reflect.TypeOf(dep).String())
+			concrete = append(concrete, reflect.TypeOf(dep))
+		}
+	}
+
+	var problems []string
+	for i := 0; i < ht.NumIn(); i++ {
+		at := ht.In(i)
+		if !inj.GetVal(at).IsValid() {
+			problems = append(problems, fmt.Sprintf("argument %d of type %s is not provided", i+1, at))
+			continue
+		}
+		if at.Kind() != reflect.Interface || isMappedAs(deps, at) {
+			continue
+		}
+		var impls []string
+		for _, ct := range concrete {
+			if ct != at && ct.Implements(at) {
+				impls = append(impls, ct.String())
+			}
+		}
+		if len(impls) > 1 {
+			problems = append(problems, fmt.Sprintf("argument %d of type %s is implemented by more than one dependency: %s",
+				i+1, at, strings.Join(impls, ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		if len(given) == 0 {
+			given = append(given, "none")
+		}
+		t.Fatalf("macarontest: cannot invoke %s:\n\t%s\ngiven dependencies: %s",
+			ht, strings.Join(problems, "\n\t"), strings.Join(given, ", "))
+		return nil
+	}
+
+	vals, err := inj.Invoke(handler)
+	if err != nil {
+		t.Fatalf("macarontest: fail to invoke %s: %v", ht, err)
+		return nil
+	}
+	return vals
+}
+
+// isMappedAs returns true if one of dependencies is mapped as the interface type by As.
+func isMappedAs(deps []interface{}, iface reflect.Type) bool {
+	for _, dep := range deps {
+		if v, ok := dep.(mappedAs); ok && inject.InterfaceOf(v.ifacePtr) == iface {
+			return true
+		}
+	}
+	return false
+}
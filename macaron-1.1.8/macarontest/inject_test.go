@@ -0,0 +1,68 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macarontest
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type greeter interface {
+	Greet(name string) string
+}
+
+type englishGreeter struct{}
+
+func (englishGreeter) Greet(name string) string { return "Hello, " + name }
+
+type frenchGreeter struct{}
+
+func (frenchGreeter) Greet(name string) string { return "Bonjour, " + name }
+
+func Test_Inject(t *testing.T) {
+	greet := func(g greeter, store *userStore) string {
+		return g.Greet(store.users["1"])
+	}
+	store := &userStore{users: map[string]string{"1": "Joe"}}
+
+	Convey("Invoke handlers with fakes", t, func() {
+		vals := Inject(t, greet, store, englishGreeter{})
+		So(vals, ShouldHaveLength, 1)
+		So(vals[0].String(), ShouldEqual, "Hello, Joe")
+
+		vals = Inject(t, greet, store, englishGreeter{}, As(frenchGreeter{}, (*greeter)(nil)))
+		So(vals[0].String(), ShouldEqual, "Bonjour, Joe")
+	})
+
+	Convey("Report unresolved dependencies", t, func() {
+		rec := &recordingTB{TB: t}
+		So(Inject(rec, greet), ShouldBeNil)
+		So(rec.errors, ShouldHaveLength, 1)
+		So(rec.errors[0], ShouldContainSubstring, "argument 1 of type macarontest.greeter is not provided")
+		So(rec.errors[0], ShouldContainSubstring, "argument 2 of type *macarontest.userStore is not provided")
+		So(rec.errors[0], ShouldContainSubstring, "given dependencies: none")
+
+		rec = &recordingTB{TB: t}
+		So(Inject(rec, greet, store, englishGreeter{}, frenchGreeter{}), ShouldBeNil)
+		So(rec.errors, ShouldHaveLength, 1)
+		So(rec.errors[0], ShouldContainSubstring, "implemented by more than one dependency")
+
+		rec = &recordingTB{TB: t}
+		So(Inject(rec, "greet", store), ShouldBeNil)
+		So(Inject(rec, greet, nil), ShouldBeNil)
+		So(rec.errors, ShouldHaveLength, 2)
+	})
+}
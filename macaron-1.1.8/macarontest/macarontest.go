@@ -0,0 +1,128 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package macarontest provides utilities for testing Macaron handlers and applications.
+package macarontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+)
+
+// NewRequest returns a request of the method and path for tests. The body can be nil, a string,
+// []byte or io.Reader sent as it is, url.Values sent as a form, or other values sent as JSON,
+// and Content-Type is set for forms and JSON. It panics if the value fails to encode.
+func NewRequest(method, path string, body interface{}) *http.Request {
+	var (
+		r           io.Reader
+		contentType string
+	)
+	switch v := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(v)
+	case []byte:
+		r = bytes.NewReader(v)
+	case io.Reader:
+		r = v
+	case url.Values:
+		r = strings.NewReader(v.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			panic(fmt.Sprintf("macarontest: fail to encode body: %v", err))
+		}
+		r = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req := httptest.NewRequest(method, path, r)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+// NewTestContext returns a context of the request made by NewRequest and the recorder of its response,
+// so handlers can be unit-tested without routing, e.g.
+//
+//	ctx, resp := macarontest.NewTestContext("POST", "/users/42", &User{Name: "Joe"})
+//	macarontest.SetParams(ctx, map[string]string{"id": "42"})
+//	macarontest.Map(ctx, store)
+//	ctx.Invoke(UpdateUser)
+//	// Check resp.Code and resp.Body.
+//
+// The context is created by a new Macaron instance, with a renderer of default options mapped.
+func NewTestContext(method, path string, body interface{}) (*macaron.Context, *httptest.ResponseRecorder) {
+	return NewTestContextFor(macaron.New(), method, path, body)
+}
+
+// NewTestContextFor returns a context created by the instance as NewTestContext does, so global services,
+// providers and configuration of the instance are available to handlers.
+func NewTestContextFor(m *macaron.Macaron, method, path string, body interface{}) (*macaron.Context, *httptest.ResponseRecorder) {
+	resp := httptest.NewRecorder()
+	ctx := m.NewContext(resp, NewRequest(method, path, body))
+	if _, err := ctx.Invoke(macaron.Renderer()); err != nil {
+		panic(fmt.Sprintf("macarontest: fail to map renderer: %v", err))
+	}
+	return ctx, resp
+}
+
+// Map maps services to the context, which can be given to handlers invoked later. Services of interface
+// types can be mapped by ctx.MapTo.
+func Map(ctx *macaron.Context, services ...interface{}) *macaron.Context {
+	for _, service := range services {
+		ctx.Map(service)
+	}
+	return ctx
+}
+
+// SetParams sets route params of the context, names can be with or without the leading ":".
+func SetParams(ctx *macaron.Context, params map[string]string) *macaron.Context {
+	for name, value := range params {
+		ctx.SetParams(name, value)
+	}
+	return ctx
+}
+
+// PerformRequest serves the request by the instance, including its routing and middleware,
+// and returns the recorder of the response.
+func PerformRequest(m *macaron.Macaron, req *http.Request) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	return resp
+}
+
+// MatchRoute returns the route of the instance that matches the method and path without running
+// its handlers, or nil if there is none, so routing tables can be verified in tests, e.g.
+//
+//	route := macarontest.MatchRoute(m, "GET", "/users/42?tab=posts")
+//	// route.Pattern is "/users/:id", and route.Params[":id"] is "42".
+//
+// Query of the path is ignored.
+func MatchRoute(m *macaron.Macaron, method, path string) *macaron.RouteMatch {
+	if i := strings.IndexByte(path, '?'); i > -1 {
+		path = path[:i]
+	}
+	return m.MatchRoute(method, path)
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macarontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+type user struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userStore struct {
+	users map[string]string
+}
+
+// recordingTB records errors of assertions instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingTB) Helper() {}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingTB) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+}
+
+func Test_NewRequest(t *testing.T) {
+	Convey("Create requests with bodies", t, func() {
+		req := NewRequest("GET", "/users?page=2", nil)
+		So(req.Method, ShouldEqual, "GET")
+		So(req.URL.Query().Get("page"), ShouldEqual, "2")
+
+		req = NewRequest("POST", "/users", "raw")
+		data, _ := ioutil.ReadAll(req.Body)
+		So(string(data), ShouldEqual, "raw")
+		So(req.Header.Get("Content-Type"), ShouldBeEmpty)
+
+		req = NewRequest("POST", "/users", url.Values{"name": {"Joe"}})
+		So(req.Header.Get("Content-Type"), ShouldEqual, "application/x-www-form-urlencoded")
+		So(req.FormValue("name"), ShouldEqual, "Joe")
+
+		req = NewRequest("POST", "/users", &user{Name: "Joe"})
+		So(req.Header.Get("Content-Type"), ShouldEqual, "application/json")
+		data, _ = ioutil.ReadAll(req.Body)
+		So(string(data), ShouldEqual, `{"id":"","name":"Joe"}`)
+
+		So(func() { NewRequest("POST", "/", func() {}) }, ShouldPanic)
+	})
+}
+
+func Test_NewTestContext(t *testing.T) {
+	Convey("Test handlers with contexts", t, func() {
+		handler := func(ctx *macaron.Context, store *userStore) {
+			var u user
+			if err := json.NewDecoder(ctx.Req.Body().ReadCloser()).Decode(&u); err != nil {
+				ctx.Error(http.StatusBadRequest, err.Error())
+				return
+			}
+			u.ID = ctx.Params("id")
+			store.users[u.ID] = u.Name
+			ctx.JSON(http.StatusOK, u)
+		}
+
+		ctx, resp := NewTestContext("PUT", "/users/42", &user{Name: "Joe"})
+		store := &userStore{users: make(map[string]string)}
+		Map(SetParams(ctx, map[string]string{"id": "42"}), store)
+		_, err := ctx.Invoke(handler)
+		So(err, ShouldBeNil)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, `{"id":"42","name":"Joe"}`)
+		So(store.users["42"], ShouldEqual, "Joe")
+
+		Convey("Use services of the instance", func() {
+			m := macaron.New()
+			m.Map(store)
+			ctx, resp := NewTestContextFor(m, "PUT", "/users/1", "{")
+			ctx.Invoke(handler)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func Test_PerformRequest(t *testing.T) {
+	Convey("Perform requests with routing", t, func() {
+		m := macaron.New()
+		m.Get("/users/:id", func(ctx *macaron.Context) string {
+			return "user " + ctx.Params("id")
+		})
+
+		resp := PerformRequest(m, NewRequest("GET", "/users/42", nil))
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "user 42")
+
+		resp = PerformRequest(m, NewRequest("GET", "/posts", nil))
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+	})
+}
+
+func Test_MatchRoute(t *testing.T) {
+	Convey("Match routes of the instance", t, func() {
+		m := macaron.New()
+		m.Get("/users/:id", func() {}).Name("user")
+
+		route := MatchRoute(m, "GET", "/users/42?tab=posts")
+		So(route, ShouldNotBeNil)
+		So(route.Pattern, ShouldEqual, "/users/:id")
+		So(route.Name, ShouldEqual, "user")
+		So(route.Params[":id"], ShouldEqual, "42")
+
+		So(MatchRoute(m, "PUT", "/users/42"), ShouldBeNil)
+	})
+}
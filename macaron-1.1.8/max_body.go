@@ -0,0 +1,69 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"net/http"
+)
+
+// maxBodyHandler is the type of handlers returned by MaxBody, which tells them from others.
+type maxBodyHandler func(*Context)
+
+// MaxBody returns a middleware handler that limits size of request body to given bytes.
+// Requests that declare a larger Content-Length are rejected with 413 Request Entity Too Large
+// right away, otherwise reading more than the limit from the body fails with an error, e.g. for
+// chunked bodies. Package binding responds with 413 to such errors, while handlers that read the
+// body by themselves should check errors by IsBodyTooLarge to do the same.
+//
+// It can be used globally and again for some routes with a different limit, e.g. to allow
+// larger uploads, in which case the limit of the route takes effect:
+//
+//	m.Use(macaron.MaxBody(1 << 20))
+//	m.Post("/upload", macaron.MaxBody(100<<20), upload)
+func MaxBody(limit int64) Handler {
+	return maxBodyHandler(func(ctx *Context) {
+		// Content-Length is checked by the last MaxBody only, so a route can raise the global limit.
+		if ctx.Req.ContentLength > limit && !ctx.hasLater(isMaxBody) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusRequestEntityTooLarge, "")
+			return
+		}
+
+		// Limit the original body so that a later limit replaces rather than adds to this one.
+		if ctx.rawBody == nil {
+			ctx.rawBody = ctx.Req.Request.Body
+		}
+		if ctx.rawBody != nil {
+			ctx.Req.Request.Body = http.MaxBytesReader(ctx.Resp, ctx.rawBody, limit)
+		}
+	})
+}
+
+func isMaxBody(h Handler) bool {
+	_, ok := h.(maxBodyHandler)
+	return ok
+}
+
+// IsBodyTooLarge returns true if the error, or any error it wraps, is returned by reading more than
+// the limit of MaxBody from the body. http.MaxBytesError is only available since Go 1.19, while the
+// message is the same for all versions.
+func IsBodyTooLarge(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err.Error() == "http: request body too large" {
+			return true
+		}
+	}
+	return false
+}
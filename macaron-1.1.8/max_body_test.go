@@ -0,0 +1,97 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_MaxBody(t *testing.T) {
+	Convey("Reject request with too large Content-Length", t, func() {
+		m := New()
+		m.Use(MaxBody(4))
+		m.Post("/", func() string { return "ok" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/", strings.NewReader("hello world"))
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		So(resp.Body.String(), ShouldNotContainSubstring, "ok")
+	})
+
+	Convey("Fail to read body without Content-Length beyond limit", t, func() {
+		m := New()
+		m.Use(MaxBody(4))
+		m.Post("/", func(ctx *Context) string {
+			data, err := ioutil.ReadAll(ctx.Req.Request.Body)
+			if err != nil {
+				return "error: " + string(data)
+			}
+			return string(data)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("hello world")))
+		So(err, ShouldBeNil)
+		req.ContentLength = -1
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "error: hell")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("hi")))
+		So(err, ShouldBeNil)
+		req.ContentLength = -1
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "hi")
+	})
+
+	Convey("Override limit for route", t, func() {
+		m := New()
+		m.Use(MaxBody(4))
+		m.Post("/upload", MaxBody(1<<10), func(ctx *Context) (string, error) {
+			return ctx.Req.Body().String()
+		})
+		m.Post("/", func(ctx *Context) (string, error) {
+			return ctx.Req.Body().String()
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/upload", ioutil.NopCloser(strings.NewReader("hello world")))
+		So(err, ShouldBeNil)
+		req.ContentLength = -1
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "hello world")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/upload", strings.NewReader("hello world"))
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "hello world")
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/", strings.NewReader("hello world"))
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"strings"
+)
+
+// MethodOverrideOptions is a struct for specifying configuration options for macaron.MethodOverride.
+type MethodOverrideOptions struct {
+	// Header is the name of request header that overrides the method. Default is "X-HTTP-Method-Override".
+	Header string
+	// Form is the name of form field that overrides the method. Default is "_method".
+	Form string
+	// Methods is the list of methods that requests can be overridden to. Default is PUT, PATCH and DELETE.
+	Methods []string
+}
+
+func prepareMethodOverrideOptions(options []MethodOverrideOptions) MethodOverrideOptions {
+	var opt MethodOverrideOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Header) == 0 {
+		opt.Header = "X-HTTP-Method-Override"
+	}
+	if len(opt.Form) == 0 {
+		opt.Form = "_method"
+	}
+	if len(opt.Methods) == 0 {
+		opt.Methods = []string{"PUT", "PATCH", "DELETE"}
+	}
+	return opt
+}
+
+// MethodOverride returns a before handler that overrides method of POST requests by the header
+// or form field, so HTML forms and limited clients can invoke routes of other methods. It must be
+// added by m.Before, because routes are matched before any middleware runs:
+//
+//	m.Before(macaron.MethodOverride())
+//
+// Only POST requests are overridden, and only to methods in the list.
+func MethodOverride(options ...MethodOverrideOptions) BeforeHandler {
+	opt := prepareMethodOverrideOptions(options)
+	allowed := make(map[string]bool, len(opt.Methods))
+	for _, method := range opt.Methods {
+		allowed[strings.ToUpper(method)] = true
+	}
+
+	return func(rw http.ResponseWriter, req *http.Request) bool {
+		if req.Method != "POST" {
+			return false
+		}
+
+		method := req.Header.Get(opt.Header)
+		if len(method) == 0 {
+			ct := req.Header.Get(_CONTENT_TYPE)
+			if strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+				method = req.PostFormValue(opt.Form)
+			}
+		}
+		if method = strings.ToUpper(method); allowed[method] {
+			req.Method = method
+		}
+		return false
+	}
+}
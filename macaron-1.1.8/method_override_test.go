@@ -0,0 +1,55 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_MethodOverride(t *testing.T) {
+	Convey("Override request method", t, func() {
+		m := New()
+		m.Before(MethodOverride())
+		m.Post("/", func() string { return "post" })
+		m.Put("/", func(ctx *Context) string { return "put " + ctx.Query("name") })
+		m.Delete("/", func() string { return "delete" })
+		m.Get("/", func() string { return "get" })
+
+		serve := func(method, header, form string) string {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, "/", strings.NewReader(form))
+			So(err, ShouldBeNil)
+			if len(header) > 0 {
+				req.Header.Set("X-HTTP-Method-Override", header)
+			}
+			if len(form) > 0 {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+
+		So(serve("POST", "", ""), ShouldEqual, "post")
+		So(serve("POST", "delete", ""), ShouldEqual, "delete")
+		So(serve("POST", "", "_method=PUT&name=foo"), ShouldEqual, "put foo")
+		So(serve("POST", "GET", ""), ShouldEqual, "post")
+		So(serve("GET", "DELETE", ""), ShouldEqual, "get")
+	})
+}
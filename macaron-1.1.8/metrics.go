@@ -0,0 +1,260 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsOptions is a struct for specifying configuration options for macaron.Metrics.
+type MetricsOptions struct {
+	// Namespace is the prefix of metric names, e.g. "myapp" results in "myapp_http_requests_total".
+	Namespace string
+	// DurationBuckets are upper bounds of request duration histogram buckets in seconds.
+	// Default is .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5 and 10.
+	DurationBuckets []float64
+	// SizeBuckets are upper bounds of response size histogram buckets in bytes.
+	// Default is 100, 1000, 10000, 100000 and 1000000.
+	SizeBuckets []float64
+	// Stats exports latency statistics of routes as well, which is usually Macaron.Stats
+	// for statistics collected by Logger.
+	Stats func() []RouteStats
+}
+
+func prepareMetricsOptions(options []MetricsOptions) MetricsOptions {
+	var opt MetricsOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Namespace) > 0 {
+		opt.Namespace += "_"
+	}
+	if len(opt.DurationBuckets) == 0 {
+		opt.DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	}
+	if len(opt.SizeBuckets) == 0 {
+		opt.SizeBuckets = []float64{100, 1000, 10000, 100000, 1000000}
+	}
+	opt.DurationBuckets = append([]float64(nil), opt.DurationBuckets...)
+	sort.Float64s(opt.DurationBuckets)
+	opt.SizeBuckets = append([]float64(nil), opt.SizeBuckets...)
+	sort.Float64s(opt.SizeBuckets)
+	return opt
+}
+
+type histogram struct {
+	// Counts of observations by bucket, the last one counts those greater than all bounds.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{buckets: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	h.buckets[sort.SearchFloat64s(bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+type metricsKey struct {
+	method string
+	route  string
+	status int
+}
+
+type metricsSeries struct {
+	duration *histogram
+	size     *histogram
+}
+
+// Metrics collects metrics of requests, and exposes them in Prometheus text format.
+type Metrics struct {
+	opt      MetricsOptions
+	inFlight int64
+
+	lock   sync.Mutex
+	series map[metricsKey]*metricsSeries
+}
+
+// NewMetrics creates a new collector of request metrics, whose handler should be used globally,
+// and which serves the metrics by itself, e.g.
+//
+//	metrics := macaron.NewMetrics()
+//	m.Use(metrics.Handler())
+//	m.Get("/metrics", metrics.ServeHTTP)
+func NewMetrics(options ...MetricsOptions) *Metrics {
+	return &Metrics{
+		opt:    prepareMetricsOptions(options),
+		series: make(map[metricsKey]*metricsSeries),
+	}
+}
+
+// metricsMethod returns the method as a label value, where nonstandard methods are "OTHER",
+// so clients can't add series by sending arbitrary methods.
+func metricsMethod(method string) string {
+	if _HTTP_METHODS[method] || method == "CONNECT" || method == "TRACE" {
+		return method
+	}
+	return "OTHER"
+}
+
+func (ms *Metrics) record(method, route string, status int, duration time.Duration, size int) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	key := metricsKey{metricsMethod(method), route, status}
+	s := ms.series[key]
+	if s == nil {
+		s = &metricsSeries{newHistogram(ms.opt.DurationBuckets), newHistogram(ms.opt.SizeBuckets)}
+		ms.series[key] = s
+	}
+	s.duration.observe(ms.opt.DurationBuckets, duration.Seconds())
+	s.size.observe(ms.opt.SizeBuckets, float64(size))
+}
+
+// Handler returns a middleware handler that records count, duration and response size of requests
+// by method, route pattern and status, and number of requests in flight. Requests that do not match
+// any route are recorded with route "unmatched", and nonstandard methods as "OTHER", so the number
+// of series stays bounded.
+func (ms *Metrics) Handler() Handler {
+	return func(ctx *Context) {
+		atomic.AddInt64(&ms.inFlight, 1)
+		defer atomic.AddInt64(&ms.inFlight, -1)
+
+		start := time.Now()
+		rw := ctx.Resp
+		ctx.Next()
+
+		route := ctx.RoutePattern()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		status := rw.Status()
+		// Nothing has been written, net/http will reply with 200 OK.
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ms.record(ctx.Req.Method, route, status, time.Since(start), rw.Size())
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHistogram(buf *bytes.Buffer, name, labels string, bounds []float64, h *histogram) {
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// write writes metrics in Prometheus text format.
+func (ms *Metrics) write(buf *bytes.Buffer) {
+	ms.lock.Lock()
+	keys := make([]metricsKey, 0, len(ms.series))
+	for k := range ms.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = fmt.Sprintf(`method="%s",route="%s",status="%d"`,
+			labelValueReplacer.Replace(k.method), labelValueReplacer.Replace(k.route), k.status)
+	}
+
+	prefix := ms.opt.Namespace + "http_"
+	fmt.Fprintf(buf, "# HELP %srequests_total Total number of HTTP requests.\n", prefix)
+	fmt.Fprintf(buf, "# TYPE %srequests_total counter\n", prefix)
+	for i, k := range keys {
+		fmt.Fprintf(buf, "%srequests_total{%s} %d\n", prefix, labels[i], ms.series[k].duration.count)
+	}
+	fmt.Fprintf(buf, "# HELP %srequest_duration_seconds Duration of HTTP requests in seconds.\n", prefix)
+	fmt.Fprintf(buf, "# TYPE %srequest_duration_seconds histogram\n", prefix)
+	for i, k := range keys {
+		writeHistogram(buf, prefix+"request_duration_seconds", labels[i], ms.opt.DurationBuckets, ms.series[k].duration)
+	}
+	fmt.Fprintf(buf, "# HELP %sresponse_size_bytes Size of HTTP responses in bytes.\n", prefix)
+	fmt.Fprintf(buf, "# TYPE %sresponse_size_bytes histogram\n", prefix)
+	for i, k := range keys {
+		writeHistogram(buf, prefix+"response_size_bytes", labels[i], ms.opt.SizeBuckets, ms.series[k].size)
+	}
+	ms.lock.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %srequests_in_flight Number of HTTP requests being served.\n", prefix)
+	fmt.Fprintf(buf, "# TYPE %srequests_in_flight gauge\n", prefix)
+	fmt.Fprintf(buf, "%srequests_in_flight %d\n", prefix, atomic.LoadInt64(&ms.inFlight))
+
+	if ms.opt.Stats != nil {
+		writeRouteStats(buf, prefix+"route_latency_seconds", ms.opt.Stats())
+	}
+}
+
+// writeRouteStats writes latency statistics of routes as histograms.
+func writeRouteStats(buf *bytes.Buffer, name string, stats []RouteStats) {
+	fmt.Fprintf(buf, "# HELP %s Latency of routes collected by Logger in seconds.\n", name)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for _, s := range stats {
+		route := s.Pattern
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		labels := fmt.Sprintf(`method="%s",route="%s"`, labelValueReplacer.Replace(s.Method), labelValueReplacer.Replace(route))
+		bounds := make([]float64, len(s.Bounds))
+		for i, b := range s.Bounds {
+			bounds[i] = b.Seconds()
+		}
+		h := &histogram{buckets: make([]uint64, len(s.Buckets)), sum: s.Total.Seconds(), count: uint64(s.Count)}
+		for i, n := range s.Buckets {
+			h.buckets[i] = uint64(n)
+		}
+		writeHistogram(buf, name, labels, bounds, h)
+	}
+}
+
+// ServeHTTP serves metrics in Prometheus text format.
+func (ms *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	buf := new(bytes.Buffer)
+	ms.write(buf)
+	rw.Header().Set(_CONTENT_TYPE, "text/plain; version=0.0.4; charset=utf-8")
+	rw.Write(buf.Bytes())
+}
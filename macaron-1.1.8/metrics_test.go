@@ -0,0 +1,71 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Metrics(t *testing.T) {
+	Convey("Collect and expose metrics", t, func() {
+		metrics := NewMetrics(MetricsOptions{
+			Namespace:       "app",
+			DurationBuckets: []float64{1, 0.1},
+			SizeBuckets:     []float64{10},
+		})
+		m := New()
+		m.Use(metrics.Handler())
+		m.Get("/users/:id", func(ctx *Context) string {
+			return "user " + ctx.Params(":id")
+		})
+		m.Get("/metrics", metrics.ServeHTTP)
+
+		for _, url := range []string{"/users/1", "/users/2", "/users/1234567890", "/none"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+		}
+		for _, method := range []string{"FOO", "BAR"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, "/none", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+		}
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+		body := resp.Body.String()
+		So(body, ShouldContainSubstring, "# TYPE app_http_requests_total counter\n")
+		So(body, ShouldContainSubstring, `app_http_requests_total{method="GET",route="/users/:id",status="200"} 3`+"\n")
+		So(body, ShouldContainSubstring, `app_http_requests_total{method="GET",route="unmatched",status="404"} 1`+"\n")
+		So(body, ShouldContainSubstring, `app_http_requests_total{method="OTHER",route="unmatched",status="404"} 2`+"\n")
+		So(body, ShouldNotContainSubstring, "FOO")
+		So(body, ShouldContainSubstring, `app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="0.1"} 3`+"\n")
+		So(body, ShouldContainSubstring, `app_http_request_duration_seconds_count{method="GET",route="/users/:id",status="200"} 3`+"\n")
+		So(body, ShouldContainSubstring, `app_http_response_size_bytes_bucket{method="GET",route="/users/:id",status="200",le="10"} 2`+"\n")
+		So(body, ShouldContainSubstring, `app_http_response_size_bytes_bucket{method="GET",route="/users/:id",status="200",le="+Inf"} 3`+"\n")
+		So(body, ShouldContainSubstring, `app_http_response_size_bytes_sum{method="GET",route="/users/:id",status="200"} 27`+"\n")
+		So(body, ShouldContainSubstring, "app_http_requests_in_flight 1\n")
+	})
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorOptions is a struct for specifying configuration options for the macaron.Mirror middleware.
+type MirrorOptions struct {
+	// Percent is the percentage of requests to mirror, from 0 to 100. Default is 100.
+	Percent float64
+	// Rate is the maximum number of requests mirrored per second. Default is 0, i.e. unlimited.
+	Rate float64
+	// MaxBodySize is the maximum size of request bodies to mirror, requests with larger
+	// bodies are not mirrored. Default is 1 MB.
+	MaxBodySize int64
+	// MaxConcurrent is the maximum number of mirrored requests in flight, requests beyond it
+	// are not mirrored. Default is 16.
+	MaxConcurrent int
+	// Timeout of mirrored requests. Default is 5 seconds.
+	Timeout time.Duration
+	// Client sends mirrored requests. Default is http.DefaultClient.
+	Client *http.Client
+	// OnError is called with errors of sending mirrored requests, e.g. to log them.
+	OnError func(err error)
+}
+
+func prepareMirrorOptions(options []MirrorOptions) MirrorOptions {
+	var opt MirrorOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Percent <= 0 {
+		opt.Percent = 100
+	}
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = 1 << 20
+	}
+	if opt.MaxConcurrent <= 0 {
+		opt.MaxConcurrent = 16
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = 5 * time.Second
+	}
+	if opt.Client == nil {
+		opt.Client = http.DefaultClient
+	}
+	return opt
+}
+
+// rateLimiter is a token bucket that allows rate events per second, with bursts of the same size.
+type rateLimiter struct {
+	rate float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// hopHeaders are headers of a connection, which are not copied to mirrored requests.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Mirror returns a middleware handler that replays a sample of requests to the shadow target in the
+// background, with the same method, path, query, headers and body, while handlers after it serve the
+// real response. Responses of the target are discarded. It is used to test a new version of service
+// against production traffic, e.g.
+//
+//	shadow, _ := url.Parse("http://search-v2.internal")
+//	m.Use(macaron.Mirror(shadow, macaron.MirrorOptions{Percent: 10, Rate: 50}))
+func Mirror(target *url.URL, options ...MirrorOptions) Handler {
+	opt := prepareMirrorOptions(options)
+	var limiter *rateLimiter
+	if opt.Rate > 0 {
+		limiter = newRateLimiter(opt.Rate)
+	}
+	slots := make(chan struct{}, opt.MaxConcurrent)
+
+	return func(ctx *Context) {
+		if opt.Percent < 100 && rand.Float64()*100 >= opt.Percent {
+			return
+		}
+		if ctx.Req.ContentLength > opt.MaxBodySize {
+			return
+		}
+
+		var body []byte
+		if ctx.Req.Request.Body != nil && ctx.Req.Request.Body != http.NoBody {
+			var err error
+			body, err = ioutil.ReadAll(io.LimitReader(ctx.Req.Request.Body, opt.MaxBodySize+1))
+			// Handlers still read the whole body.
+			ctx.Req.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), ctx.Req.Request.Body), ctx.Req.Request.Body}
+			if err != nil || int64(len(body)) > opt.MaxBodySize {
+				return
+			}
+		}
+
+		if limiter != nil && !limiter.allow() {
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			return
+		}
+
+		u := *ctx.Req.URL
+		u.Scheme = target.Scheme
+		u.Host = target.Host
+		u.Path = strings.TrimSuffix(target.Path, "/") + u.Path
+		u.RawPath = ""
+		header := cloneHeader(ctx.Req.Header)
+		for _, h := range hopHeaders {
+			header.Del(h)
+		}
+		method := ctx.Req.Method
+
+		go func() {
+			defer func() { <-slots }()
+
+			c, cancel := context.WithTimeout(context.Background(), opt.Timeout)
+			defer cancel()
+			req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+			if err == nil {
+				req = req.WithContext(c)
+				req.Header = header
+				var resp *http.Response
+				if resp, err = opt.Client.Do(req); err == nil {
+					io.Copy(ioutil.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}
+			if err != nil && opt.OnError != nil {
+				opt.OnError(err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Mirror(t *testing.T) {
+	Convey("Mirror requests to shadow target", t, func() {
+		mirrored := make(chan string, 10)
+		shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			mirrored <- req.Method + " " + req.URL.RequestURI() + " " + req.Header.Get("X-Token") + " " + string(body)
+			rw.Write([]byte("shadow"))
+		}))
+		defer shadow.Close()
+		target, err := url.Parse(shadow.URL + "/v2")
+		So(err, ShouldBeNil)
+
+		serve := func(opt MirrorOptions, body string) string {
+			m := New()
+			m.Use(Mirror(target, opt))
+			m.Post("/search", func(ctx *Context) string {
+				data, _ := ctx.Req.Body().String()
+				return "primary " + data
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/search?q=go", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			req.Header.Set("X-Token", "abc")
+			req.Header.Set("Connection", "close")
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+		receive := func() string {
+			select {
+			case r := <-mirrored:
+				return r
+			case <-time.After(100 * time.Millisecond):
+				return ""
+			}
+		}
+
+		So(serve(MirrorOptions{}, "hello"), ShouldEqual, "primary hello")
+		So(receive(), ShouldEqual, "POST /v2/search?q=go abc hello")
+
+		Convey("Skip large bodies", func() {
+			So(serve(MirrorOptions{MaxBodySize: 3}, "hello"), ShouldEqual, "primary hello")
+			So(receive(), ShouldBeEmpty)
+		})
+
+		Convey("Limit rate", func() {
+			m := New()
+			m.Use(Mirror(target, MirrorOptions{Rate: 1}))
+			m.Get("/", func() string { return "primary" })
+			for i := 0; i < 3; i++ {
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest("GET", "/", nil)
+				So(err, ShouldBeNil)
+				m.ServeHTTP(resp, req)
+				So(resp.Body.String(), ShouldEqual, "primary")
+			}
+			So(receive(), ShouldNotBeEmpty)
+			So(receive(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Allow events by rate", t, func() {
+		l := newRateLimiter(2)
+		So(l.allow(), ShouldBeTrue)
+		So(l.allow(), ShouldBeTrue)
+		So(l.allow(), ShouldBeFalse)
+		l.last = l.last.Add(-time.Second)
+		So(l.allow(), ShouldBeTrue)
+	})
+}
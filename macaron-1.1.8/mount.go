@@ -0,0 +1,151 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MountOptions is a struct for specifying configuration options for macaron.StripPrefixHandler.
+type MountOptions struct {
+	// FallThrough discards 404 responses of the mounted handler, so handlers after it respond instead,
+	// or NotFound handlers of the router if none of them does.
+	FallThrough bool
+}
+
+// fallThroughWriter holds back 404 responses of the mounted handler.
+type fallThroughWriter struct {
+	rw       http.ResponseWriter
+	header   http.Header
+	wrote    bool
+	notFound bool
+}
+
+func (w *fallThroughWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fallThroughWriter) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	if status == http.StatusNotFound {
+		w.notFound = true
+		return
+	}
+	header := w.rw.Header()
+	for k, v := range w.header {
+		header[k] = v
+	}
+	w.rw.WriteHeader(status)
+}
+
+func (w *fallThroughWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.notFound {
+		return len(p), nil
+	}
+	return w.rw.Write(p)
+}
+
+func (w *fallThroughWriter) Flush() {
+	if f, ok := w.rw.(http.Flusher); ok && !w.notFound {
+		f.Flush()
+	}
+}
+
+// StripPrefixHandler returns a handler that serves requests by the http.Handler, e.g. http.ServeMux
+// or router of another framework, with the prefix removed from the path, so existing handler trees
+// can be mounted during incremental migration, e.g.
+//
+//	m.Any("/legacy/*", macaron.StripPrefixHandler("/legacy", legacyMux))
+//
+// Route params are available to the handler by ParamsFromContext. Requests whose path does not
+// have the prefix are not found.
+func StripPrefixHandler(prefix string, h http.Handler, options ...MountOptions) Handler {
+	var opt MountOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return func(ctx *Context) {
+		req := ctx.Req.Request
+		path := strings.TrimPrefix(req.URL.Path, prefix)
+		rawPath := strings.TrimPrefix(req.URL.RawPath, prefix)
+		found := len(path) < len(req.URL.Path) || len(prefix) == 0
+		if found && (len(path) == 0 || path[0] != '/') {
+			// "/legacyfoo" does not have the prefix "/legacy".
+			found = len(path) == 0
+			path = "/" + path
+			if len(rawPath) > 0 {
+				rawPath = "/" + rawPath
+			}
+		}
+
+		rw := http.ResponseWriter(ctx.Resp)
+		var ft *fallThroughWriter
+		if opt.FallThrough {
+			ft = &fallThroughWriter{rw: ctx.Resp, header: make(http.Header)}
+			rw = ft
+		}
+
+		if found {
+			r := req.WithContext(context.WithValue(req.Context(), paramsContextKey{}, ctx.params.toMap()))
+			r.URL = new(url.URL)
+			*r.URL = *req.URL
+			r.URL.Path = path
+			r.URL.RawPath = rawPath
+			h.ServeHTTP(rw, r)
+		} else {
+			http.NotFound(rw, req)
+		}
+
+		if ft == nil || !ft.notFound {
+			return
+		}
+		ctx.Next()
+		if !ctx.Written() && ctx.Router != nil {
+			ctx.index = 0
+			ctx.handlers = ctx.Router.notFoundHandlers
+			ctx.run()
+		}
+	}
+}
+
+// Mount registers the http.Handler for all methods on the pattern and paths under it, which is served
+// with the full path after handlers, within current group, so it shares middleware, e.g. auth, logging
+// and metrics, with other routes. It suits generated muxes of gRPC-gateway or grpc-web wrappers,
+// which match full paths of requests, e.g.
+//
+//	gw := runtime.NewServeMux()
+//	pb.RegisterUserServiceHandlerFromEndpoint(ctx, gw, "localhost:9090", opts)
+//	m.Group("/api", func() {
+//		m.Mount("/v1", gw, auth)
+//	}, metrics.Handler())
+func (r *Router) Mount(pattern string, h http.Handler, handlers ...Handler) *Route {
+	pattern = strings.TrimSuffix(pattern, "/")
+	hs := make([]Handler, 0, len(handlers)+1)
+	hs = append(hs, handlers...)
+	hs = append(hs, h)
+	if len(pattern) > 0 {
+		r.Any(pattern, hs...)
+	}
+	return r.Any(pattern+"/*", hs...)
+}
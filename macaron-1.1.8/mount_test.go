@@ -0,0 +1,117 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_StripPrefixHandler(t *testing.T) {
+	Convey("Mount http.Handler trees under a prefix", t, func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/" {
+				http.NotFound(rw, req)
+				return
+			}
+			rw.Write([]byte("legacy index"))
+		})
+		mux.HandleFunc("/users", func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Legacy", "yes")
+			rw.Write([]byte("legacy users " + req.URL.Query().Get("page")))
+		})
+
+		m := New()
+		m.Any("/legacy/*", StripPrefixHandler("/legacy", mux))
+		m.Any("/old/*", StripPrefixHandler("/old/", mux, MountOptions{FallThrough: true}))
+		m.Get("/both/*", StripPrefixHandler("/both", mux, MountOptions{FallThrough: true}), func() string {
+			return "macaron"
+		})
+		m.NotFound(func() string {
+			return "custom not found"
+		})
+
+		serve := func(url string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("/legacy/users?page=2")
+		So(resp.Body.String(), ShouldEqual, "legacy users 2")
+		So(resp.Header().Get("X-Legacy"), ShouldEqual, "yes")
+
+		resp = serve("/legacy/missing")
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldEqual, "404 page not found\n")
+
+		resp = serve("/old/users")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "legacy users ")
+
+		resp = serve("/old/missing")
+		So(resp.Body.String(), ShouldEqual, "custom not found")
+		So(resp.Header().Get("X-Content-Type-Options"), ShouldBeEmpty)
+
+		So(serve("/both/users").Body.String(), ShouldEqual, "legacy users ")
+		So(serve("/both/missing").Body.String(), ShouldEqual, "macaron")
+	})
+}
+
+func Test_Router_Mount(t *testing.T) {
+	Convey("Mount http.Handler with full paths in groups", t, func() {
+		gw := http.NewServeMux()
+		gw.HandleFunc("/api/v1/users/", func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(req.Method + " " + req.URL.Path + " " + ParamsFromContext(req.Context())[":version"]))
+		})
+		gw.HandleFunc("/api/v1", func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte("root"))
+		})
+
+		m := New()
+		m.Group("/api", func() {
+			m.Mount("/:version/", gw, func(ctx *Context) {
+				if ctx.Req.Header.Get("Authorization") != "token" {
+					ctx.Resp.WriteHeader(http.StatusUnauthorized)
+				}
+			})
+		}, func(ctx *Context) {
+			ctx.Resp.Header().Set("X-Group", "yes")
+		})
+
+		serve := func(method, url string, auth bool) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, nil)
+			So(err, ShouldBeNil)
+			if auth {
+				req.Header.Set("Authorization", "token")
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("POST", "/api/v1/users/7", true)
+		So(resp.Body.String(), ShouldEqual, "POST /api/v1/users/7 v1")
+		So(resp.Header().Get("X-Group"), ShouldEqual, "yes")
+		So(serve("GET", "/api/v1", true).Body.String(), ShouldEqual, "root")
+		So(serve("GET", "/api/v1/users/7", false).Code, ShouldEqual, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"strings"
+)
+
+// acceptQuality returns the quality value that the Accept header gives to the media type,
+// from the most specific media range that matches it, or 0 if none does.
+func acceptQuality(accept, typ string) float64 {
+	typ = strings.ToLower(typ)
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		r, rq := parseAcceptType(part)
+		s := -1
+		switch {
+		case r == typ:
+			s = 2
+		case strings.HasSuffix(r, "/*") && strings.HasPrefix(typ, r[:len(r)-1]):
+			s = 1
+		case r == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = rq, s
+		}
+	}
+	return q
+}
+
+// Negotiate returns the media type among offers that the client prefers according to the Accept
+// header, ties are broken by order of offers. It returns the first offer if the request has no
+// Accept header, or empty string if none of offers is acceptable. Media types declared by Produces
+// are used if no offer is given, e.g.
+//
+//	switch ctx.Negotiate("application/json", "text/html") {
+//	case "application/json":
+//		ctx.JSON(200, data)
+//	case "text/html":
+//		ctx.HTML(200, "page", data)
+//	default:
+//		ctx.Status(406)
+//	}
+func (ctx *Context) Negotiate(offers ...string) string {
+	if len(offers) == 0 {
+		offers = ctx.produces
+	}
+	accept := strings.TrimSpace(ctx.Req.Header.Get("Accept"))
+	if len(accept) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// Produces returns a middleware handler that declares media types that routes after it produce,
+// and responds with 406 Not Acceptable to requests whose Accept header accepts none of them,
+// instead of serving a format the client cannot handle. Body of the response lists supported types.
+// Handlers can choose the format by ctx.Negotiate(). It can be used globally, or per route or group, e.g.
+//
+//	m.Group("/api", func() {
+//		...
+//	}, macaron.Produces("application/json", "application/xml"))
+func Produces(types ...string) Handler {
+	if len(types) == 0 {
+		panic("produces: no media type is given")
+	}
+
+	return func(ctx *Context) {
+		ctx.produces = types
+		if len(ctx.Negotiate()) == 0 {
+			writeSupportedError(ctx, http.StatusNotAcceptable, types)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Negotiate(t *testing.T) {
+	Convey("Negotiate media types", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) string {
+			return ctx.Negotiate("application/json", "text/html")
+		})
+
+		negotiate := func(accept string) string {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			if len(accept) > 0 {
+				req.Header.Set("Accept", accept)
+			}
+			m.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+
+		So(negotiate(""), ShouldEqual, "application/json")
+		So(negotiate("*/*"), ShouldEqual, "application/json")
+		So(negotiate("text/html"), ShouldEqual, "text/html")
+		So(negotiate("text/*;q=0.9, application/json;q=0.5"), ShouldEqual, "text/html")
+		So(negotiate("*/*;q=0.1, application/json;q=0"), ShouldEqual, "text/html")
+		So(negotiate("image/png"), ShouldEqual, "")
+	})
+}
+
+func Test_Produces(t *testing.T) {
+	Convey("Respond with 406 to requests accepting none of media types produced", t, func() {
+		m := New()
+		m.Group("/api", func() {
+			m.Get("/", func(ctx *Context) string {
+				return ctx.Negotiate()
+			})
+		}, Produces("application/json", "application/xml"))
+
+		serve := func(accept string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept", accept)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("application/xml, application/json;q=0.8")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "application/xml")
+
+		resp = serve("text/html")
+		So(resp.Code, ShouldEqual, http.StatusNotAcceptable)
+		So(resp.Body.String(), ShouldEqual, "Not Acceptable\nSupported media types: application/json, application/xml\n")
+
+		resp = serve("application/*+json, application/json;q=0")
+		So(resp.Code, ShouldEqual, http.StatusNotAcceptable)
+	})
+}
@@ -0,0 +1,394 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuth2Options is a struct for specifying configuration options for macaron.OAuth2.
+type OAuth2Options struct {
+	ClientID     string
+	ClientSecret string
+	// AuthURL and TokenURL are endpoints of the provider, e.g. "https://accounts.google.com/o/oauth2/v2/auth"
+	// and "https://oauth2.googleapis.com/token".
+	AuthURL  string
+	TokenURL string
+	// UserInfoURL is the OpenID Connect userinfo endpoint, which is requested after login if it is set.
+	UserInfoURL string
+	// RedirectURL is the absolute URL of the callback route registered with the provider.
+	RedirectURL string
+	Scopes      []string
+
+	// Secret encrypts and authenticates cookies of login state and session. Required.
+	Secret string
+	// Cookie is the name of session cookie, and the prefix of login state cookie. Default is "_oauth2".
+	Cookie string
+	// CookieSecure marks cookies to be sent only over HTTPS.
+	CookieSecure bool
+	// SessionLifetime is how long users stay logged in. Default is 24 hours.
+	SessionLifetime time.Duration
+	// LoginPath is where RequireLogin redirects users who have not logged in. Default is "/login".
+	LoginPath string
+
+	// OnLogin is called after token exchange, e.g. to create the account or store tokens for
+	// later API calls. Login fails with 500 Internal Server Error if it returns an error.
+	OnLogin func(ctx *Context, token *OAuth2Token, user *OAuth2User) error
+	// HTTPClient requests the provider. Default is http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func prepareOAuth2Options(opt OAuth2Options) OAuth2Options {
+	// Defaults
+	if len(opt.Cookie) == 0 {
+		opt.Cookie = "_oauth2"
+	}
+	if opt.SessionLifetime <= 0 {
+		opt.SessionLifetime = 24 * time.Hour
+	}
+	if len(opt.LoginPath) == 0 {
+		opt.LoginPath = "/login"
+	}
+	if opt.HTTPClient == nil {
+		opt.HTTPClient = http.DefaultClient
+	}
+	return opt
+}
+
+// OAuth2Token is the token response of the provider.
+type OAuth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	// IDToken is the OpenID Connect ID token, it is not verified since it is received
+	// from the token endpoint directly.
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// OAuth2User is the logged-in user, which is mapped as a service by RequireLogin.
+type OAuth2User struct {
+	// Subject is the identifier of the user at the provider.
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Picture string `json:"picture,omitempty"`
+}
+
+// oauth2State is kept in a cookie between redirect to the provider and the callback.
+type oauth2State struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+}
+
+// oauth2Session is kept in the session cookie.
+type oauth2Session struct {
+	User    *OAuth2User `json:"user"`
+	Expires int64       `json:"expires"`
+}
+
+// OAuth2 is a client of OAuth 2.0 authorization code flow with PKCE, and OpenID Connect userinfo.
+// Login state and session of users are kept in encrypted cookies.
+type OAuth2 struct {
+	opt OAuth2Options
+	gcm cipher.AEAD
+}
+
+// NewOAuth2 creates a new OAuth 2.0 client, whose handlers are registered for login routes, e.g.
+//
+//	auth := macaron.NewOAuth2(macaron.OAuth2Options{...})
+//	m.Get("/login", auth.Login)
+//	m.Get("/oauth2/callback", auth.Callback)
+//	m.Post("/logout", auth.Logout)
+//	m.Get("/profile", auth.RequireLogin(), func(user *macaron.OAuth2User) string { return user.Name })
+func NewOAuth2(opt OAuth2Options) *OAuth2 {
+	if len(opt.Secret) == 0 {
+		panic("OAuth2: secret is required")
+	}
+	key := sha256.Sum256([]byte(opt.Secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic("OAuth2: " + err.Error())
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("OAuth2: " + err.Error())
+	}
+	return &OAuth2{opt: prepareOAuth2Options(opt), gcm: gcm}
+}
+
+// seal encrypts the value as JSON, and binds it to the cookie name.
+func (o *OAuth2) seal(name string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, o.gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(o.gcm.Seal(nonce, nonce, data, []byte(name))), nil
+}
+
+// open decrypts value of the cookie into v, and returns false if it is absent or has been tampered.
+func (o *OAuth2) open(ctx *Context, name string, v interface{}) bool {
+	cookie, err := ctx.Req.Cookie(name)
+	if err != nil {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(data) < o.gcm.NonceSize() {
+		return false
+	}
+	nonce, data := data[:o.gcm.NonceSize()], data[o.gcm.NonceSize():]
+	if data, err = o.gcm.Open(nil, nonce, data, []byte(name)); err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+func (o *OAuth2) setCookie(ctx *Context, name, value string, maxAge int) {
+	http.SetCookie(ctx.Resp, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   o.opt.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("OAuth2: fail to generate random token: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath returns the path if it is local to the site, or "/" otherwise, to prevent open redirects.
+// Browsers ignore tabs and newlines in URLs and take backslashes as slashes, so paths with control
+// characters are refused, and backslashes are checked as slashes.
+func localPath(p string) string {
+	for i := 0; i < len(p); i++ {
+		if p[i] < 0x20 || p[i] == 0x7f {
+			return "/"
+		}
+	}
+	normalized := strings.Replace(p, "\\", "/", -1)
+	if !strings.HasPrefix(normalized, "/") || strings.HasPrefix(normalized, "//") {
+		return "/"
+	}
+	u, err := url.Parse(normalized)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 {
+		return "/"
+	}
+	return p
+}
+
+// Login redirects the user to the provider, and the user returns to the path
+// of "next" query parameter after login, or "/" by default.
+func (o *OAuth2) Login(ctx *Context) {
+	state := oauth2State{
+		State:    randomToken(),
+		Verifier: randomToken(),
+		Next:     localPath(ctx.Query("next")),
+	}
+	value, err := o.seal(o.opt.Cookie+"_state", state)
+	if err != nil {
+		panic("OAuth2: fail to seal login state: " + err.Error())
+	}
+	o.setCookie(ctx, o.opt.Cookie+"_state", value, 600)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.opt.ClientID},
+		"redirect_uri":          {o.opt.RedirectURL},
+		"state":                 {state.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if len(o.opt.Scopes) > 0 {
+		query.Set("scope", strings.Join(o.opt.Scopes, " "))
+	}
+	sep := "?"
+	if strings.Contains(o.opt.AuthURL, "?") {
+		sep = "&"
+	}
+	ctx.Redirect(o.opt.AuthURL + sep + query.Encode())
+}
+
+// Callback handles the redirect from the provider: it verifies the state, exchanges the code for
+// token, fetches userinfo, and starts the session. Requests with invalid state or denied by the user
+// are responded with 400 Bad Request and 401 Unauthorized respectively.
+func (o *OAuth2) Callback(ctx *Context) {
+	var state oauth2State
+	ok := o.open(ctx, o.opt.Cookie+"_state", &state)
+	o.setCookie(ctx, o.opt.Cookie+"_state", "", -1)
+	if !ok || !SecureCompare(ctx.Query("state"), state.State) {
+		writeErrorPage(ctx, ctx.Resp, http.StatusBadRequest, "")
+		return
+	}
+	if len(ctx.Query("error")) > 0 || len(ctx.Query("code")) == 0 {
+		writeErrorPage(ctx, ctx.Resp, http.StatusUnauthorized, "")
+		return
+	}
+
+	fail := func(err error) {
+		if ctx.Router != nil && ctx.m != nil {
+			ctx.m.ErrorLogger().Printf("%sOAuth2: %v", requestTag(ctx), err)
+		}
+		writeErrorPage(ctx, ctx.Resp, http.StatusInternalServerError, "")
+	}
+	token, err := o.Exchange(ctx.Req.Context(), ctx.Query("code"), state.Verifier)
+	if err != nil {
+		fail(err)
+		return
+	}
+	user := &OAuth2User{}
+	if len(o.opt.UserInfoURL) > 0 {
+		if user, err = o.UserInfo(ctx.Req.Context(), token); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if o.opt.OnLogin != nil {
+		if err = o.opt.OnLogin(ctx, token, user); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	value, err := o.seal(o.opt.Cookie, oauth2Session{user, time.Now().Add(o.opt.SessionLifetime).Unix()})
+	if err != nil {
+		fail(err)
+		return
+	}
+	o.setCookie(ctx, o.opt.Cookie, value, int(o.opt.SessionLifetime/time.Second))
+	ctx.Redirect(state.Next)
+}
+
+// Logout ends the session, and redirects the user to "/".
+func (o *OAuth2) Logout(ctx *Context) {
+	o.setCookie(ctx, o.opt.Cookie, "", -1)
+	ctx.Redirect("/")
+}
+
+// User returns the logged-in user of the request, or false if there is none.
+func (o *OAuth2) User(ctx *Context) (*OAuth2User, bool) {
+	var s oauth2Session
+	if !o.open(ctx, o.opt.Cookie, &s) || s.User == nil || time.Now().Unix() >= s.Expires {
+		return nil, false
+	}
+	return s.User, true
+}
+
+// RequireLogin returns a middleware handler that maps the logged-in user as *OAuth2User, or redirects
+// users who have not logged in to LoginPath, which returns them to current page after login.
+// API clients that prefer JSON are responded with 401 Unauthorized instead.
+func (o *OAuth2) RequireLogin() Handler {
+	return Provides(func(ctx *Context) {
+		user, ok := o.User(ctx)
+		if !ok {
+			if preferJSON(ctx.Req.Request) || ctx.Req.Method != "GET" {
+				writeErrorPage(ctx, ctx.Resp, http.StatusUnauthorized, "")
+				return
+			}
+			ctx.Redirect(o.opt.LoginPath + "?next=" + url.QueryEscape(ctx.Req.URL.RequestURI()))
+			return
+		}
+		ctx.Map(user)
+	}, (*OAuth2User)(nil))
+}
+
+// doJSON sends the request and decodes JSON response into v.
+func (o *OAuth2) doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", _CONTENT_JSON)
+	resp, err := o.opt.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL, resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Exchange exchanges the authorization code for token, with the PKCE verifier sent in login.
+func (o *OAuth2) Exchange(ctx context.Context, code, verifier string) (*OAuth2Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.opt.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", o.opt.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(_CONTENT_TYPE, "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.opt.ClientID), url.QueryEscape(o.opt.ClientSecret))
+
+	token := new(OAuth2Token)
+	if err = o.doJSON(req, token); err != nil {
+		return nil, fmt.Errorf("exchange token: %v", err)
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, errors.New("exchange token: no access token in response")
+	}
+	return token, nil
+}
+
+// UserInfo fetches the user of the token from the userinfo endpoint.
+func (o *OAuth2) UserInfo(ctx context.Context, token *OAuth2Token) (*OAuth2User, error) {
+	req, err := http.NewRequest("GET", o.opt.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	user := new(OAuth2User)
+	if err = o.doJSON(req, user); err != nil {
+		return nil, fmt.Errorf("fetch userinfo: %v", err)
+	}
+	if len(user.Subject) == 0 {
+		return nil, errors.New("fetch userinfo: no subject in response")
+	}
+	return user, nil
+}
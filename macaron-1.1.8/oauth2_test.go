@@ -0,0 +1,166 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_OAuth2(t *testing.T) {
+	Convey("Login with OAuth2", t, func() {
+		var challenge string
+		provider := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/token":
+				id, secret, _ := req.BasicAuth()
+				sum := sha256.Sum256([]byte(req.PostFormValue("code_verifier")))
+				if id != "client" || secret != "secret" || req.PostFormValue("code") != "abc" ||
+					base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+					http.Error(rw, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+					return
+				}
+				rw.Write([]byte(`{"access_token":"t0k3n","token_type":"Bearer","expires_in":3600}`))
+			case "/userinfo":
+				if req.Header.Get("Authorization") != "Bearer t0k3n" {
+					http.Error(rw, "", http.StatusUnauthorized)
+					return
+				}
+				rw.Write([]byte(`{"sub":"42","name":"Unknwon","email":"u@example.com"}`))
+			}
+		}))
+		defer provider.Close()
+
+		var logins []string
+		auth := NewOAuth2(OAuth2Options{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			AuthURL:      provider.URL + "/authorize",
+			TokenURL:     provider.URL + "/token",
+			UserInfoURL:  provider.URL + "/userinfo",
+			RedirectURL:  "http://localhost/oauth2/callback",
+			Scopes:       []string{"openid", "profile"},
+			Secret:       "cookie-secret",
+			OnLogin: func(ctx *Context, token *OAuth2Token, user *OAuth2User) error {
+				logins = append(logins, token.AccessToken+" "+user.Subject)
+				return nil
+			},
+		})
+		m := New()
+		m.Get("/login", auth.Login)
+		m.Get("/oauth2/callback", auth.Callback)
+		m.Post("/logout", auth.Logout)
+		m.Get("/profile", auth.RequireLogin(), func(user *OAuth2User) string {
+			return user.Name + " " + user.Email
+		})
+		So(m.Check(), ShouldBeEmpty)
+
+		serve := func(method, url string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, nil)
+			So(err, ShouldBeNil)
+			for _, c := range cookies {
+				if c.MaxAge >= 0 {
+					req.AddCookie(c)
+				}
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+		cookiesOf := func(resp *httptest.ResponseRecorder) []*http.Cookie {
+			return (&http.Response{Header: resp.Header()}).Cookies()
+		}
+
+		resp := serve("GET", "/profile", nil)
+		So(resp.Code, ShouldEqual, http.StatusFound)
+		So(resp.Header().Get("Location"), ShouldEqual, "/login?next=%2Fprofile")
+
+		resp = serve("GET", "/login?next=/profile", nil)
+		So(resp.Code, ShouldEqual, http.StatusFound)
+		location, err := url.Parse(resp.Header().Get("Location"))
+		So(err, ShouldBeNil)
+		So(location.Path, ShouldEqual, "/authorize")
+		query := location.Query()
+		So(query.Get("client_id"), ShouldEqual, "client")
+		So(query.Get("scope"), ShouldEqual, "openid profile")
+		So(query.Get("code_challenge_method"), ShouldEqual, "S256")
+		challenge = query.Get("code_challenge")
+		state := query.Get("state")
+		stateCookies := cookiesOf(resp)
+		So(stateCookies, ShouldHaveLength, 1)
+		So(stateCookies[0].HttpOnly, ShouldBeTrue)
+		So(stateCookies[0].Value, ShouldNotContainSubstring, state)
+
+		Convey("Start session after callback", func() {
+			resp := serve("GET", "/oauth2/callback?code=abc&state="+state, stateCookies)
+			So(resp.Code, ShouldEqual, http.StatusFound)
+			So(resp.Header().Get("Location"), ShouldEqual, "/profile")
+			So(logins, ShouldResemble, []string{"t0k3n 42"})
+
+			session := cookiesOf(resp)
+			resp = serve("GET", "/profile", session)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "Unknwon u@example.com")
+
+			resp = serve("POST", "/logout", session)
+			So(resp.Code, ShouldEqual, http.StatusFound)
+			So(cookiesOf(resp)[0].MaxAge, ShouldBeLessThan, 0)
+		})
+
+		Convey("Reject callback with wrong state", func() {
+			resp := serve("GET", "/oauth2/callback?code=abc&state=forged", stateCookies)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			resp = serve("GET", "/oauth2/callback?code=abc&state="+state, nil)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Reject callback with wrong code", func() {
+			resp := serve("GET", "/oauth2/callback?code=wrong&state="+state, stateCookies)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(logins, ShouldBeEmpty)
+		})
+
+		Convey("Reject tampered session", func() {
+			resp := serve("GET", "/profile", []*http.Cookie{{Name: "_oauth2", Value: strings.Repeat("A", 64)}})
+			So(resp.Code, ShouldEqual, http.StatusFound)
+
+			req, err := http.NewRequest("GET", "/profile", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+
+	Convey("Only redirect to local paths", t, func() {
+		So(localPath("/posts?id=1"), ShouldEqual, "/posts?id=1")
+		So(localPath("//evil.com"), ShouldEqual, "/")
+		So(localPath("/\\evil.com"), ShouldEqual, "/")
+		So(localPath("https://evil.com"), ShouldEqual, "/")
+		So(localPath("/\t/evil.com"), ShouldEqual, "/")
+		So(localPath("/\n/evil.com"), ShouldEqual, "/")
+		So(localPath("/\r\\evil.com"), ShouldEqual, "/")
+		So(localPath("/%"), ShouldEqual, "/")
+		So(localPath(""), ShouldEqual, "/")
+	})
+}
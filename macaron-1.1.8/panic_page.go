@@ -0,0 +1,252 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const panicPageHtml = `<html>
+<head><title>PANIC: {{.Value}}</title>
+<meta charset="utf-8" />
+<style type="text/css">
+html, body {
+	font-family: "Roboto", sans-serif;
+	color: #333333;
+	background-color: #ea5343;
+	margin: 0px;
+}
+h1 {
+	color: #d04526;
+	background-color: #ffffff;
+	padding: 20px;
+	margin: 0px;
+	border-bottom: 1px dashed #2b3848;
+}
+h2 {
+	color: #ffffff;
+	margin: 20px 20px 0px 20px;
+}
+.box {
+	margin: 20px;
+	padding: 10px 20px;
+	border: 2px solid #2b3848;
+	background-color: #ffffff;
+	word-wrap: break-word;
+}
+.frame .location {
+	font-family: monospace;
+	font-weight: bold;
+}
+.frame.internal {
+	color: #999999;
+}
+.frame.cause {
+	border-color: #d04526;
+	border-width: 4px;
+}
+pre {
+	margin: 10px 0px;
+	white-space: pre-wrap;
+}
+pre .current {
+	background-color: #fbd4cf;
+	font-weight: bold;
+}
+table {
+	border-collapse: collapse;
+	font-family: monospace;
+}
+td {
+	padding: 2px 10px 2px 0px;
+	vertical-align: top;
+}
+</style>
+</head><body>
+<h1>PANIC: {{.Value}}</h1>
+{{if .Cause}}<div class="box"><span class="location">{{.Cause.File}}:{{.Cause.Line}}</span> {{.Cause.Function}}</div>{{end}}
+
+<h2>Stack</h2>
+{{range .Frames}}<div class="box frame{{if .Internal}} internal{{end}}{{if .Cause}} cause{{end}}">
+<div class="location">{{.File}}:{{.Line}} {{.Function}}</div>
+{{if .Lines}}<pre>{{range .Lines}}<span{{if .Current}} class="current"{{end}}>{{printf "%5d" .Number}}  {{.Code}}</span>
+{{end}}</pre>{{end}}
+</div>
+{{end}}
+
+<h2>Request</h2>
+<div class="box"><table>
+<tr><td>Method</td><td>{{.Info.Method}}</td></tr>
+<tr><td>URL</td><td>{{.Info.URL}}</td></tr>
+<tr><td>Remote address</td><td>{{.Info.RemoteAddr}}</td></tr>
+{{if .Info.RequestID}}<tr><td>Request ID</td><td>{{.Info.RequestID}}</td></tr>{{end}}
+</table></div>
+
+{{if .Params}}<h2>Params</h2>
+<div class="box"><table>
+{{range .Params}}<tr><td>{{.Key}}</td><td>{{.Value}}</td></tr>
+{{end}}</table></div>{{end}}
+
+<h2>Headers</h2>
+<div class="box"><table>
+{{range .Headers}}<tr><td>{{.Key}}</td><td>{{.Value}}</td></tr>
+{{end}}</table></div>
+
+<h2>Services</h2>
+<div class="box"><table>
+{{range .Services}}<tr><td>{{.Key}}</td><td>{{.Value}}</td></tr>
+{{end}}</table></div>
+</body>
+</html>`
+
+var panicPage = template.Must(template.New("panic").Parse(panicPageHtml))
+
+// PanicSourceLines is the number of source code lines shown before and after
+// the line of each frame on the development panic page.
+var PanicSourceLines = 5
+
+type sourceLine struct {
+	Number  int
+	Code    string
+	Current bool
+}
+
+type panicPageFrame struct {
+	StackFrame
+	Internal bool
+	Cause    bool
+	Lines    []sourceLine
+}
+
+type keyValue struct {
+	Key   string
+	Value string
+}
+
+type panicPageData struct {
+	Value    string
+	Info     PanicInfo
+	Cause    *panicPageFrame
+	Frames   []panicPageFrame
+	Params   []keyValue
+	Headers  []keyValue
+	Services []keyValue
+}
+
+// sourceExcerpt returns lines around line n of given file content.
+func sourceExcerpt(lines [][]byte, n, around int) []sourceLine {
+	start, end := n-around, n+around
+	if start < 1 {
+		start = 1
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	// The file may have been shortened since the binary was built.
+	if start > end {
+		return nil
+	}
+
+	excerpt := make([]sourceLine, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		excerpt = append(excerpt, sourceLine{
+			Number:  i,
+			Code:    strings.TrimRight(string(lines[i-1]), "\r"),
+			Current: i == n,
+		})
+	}
+	return excerpt
+}
+
+func sortedKeyValues(kvs []keyValue) []keyValue {
+	sort.Sort(keyValues(kvs))
+	return kvs
+}
+
+type keyValues []keyValue
+
+func (kvs keyValues) Len() int           { return len(kvs) }
+func (kvs keyValues) Swap(i, j int)      { kvs[i], kvs[j] = kvs[j], kvs[i] }
+func (kvs keyValues) Less(i, j int) bool { return kvs[i].Key < kvs[j].Key }
+
+// serviceList returns names of services mapped to given context and its Macaron instance.
+func serviceList(c *Context) []keyValue {
+	services := make([]keyValue, 0, len(c.mapped)+len(c.m.mapped))
+	seen := make(map[reflect.Type]bool)
+	add := func(types []reflect.Type, scope string) {
+		for i := len(types) - 1; i >= 0; i-- {
+			if seen[types[i]] {
+				continue
+			}
+			seen[types[i]] = true
+			services = append(services, keyValue{types[i].String(), scope})
+		}
+	}
+	add(c.mapped, "request")
+	add(c.m.mapped, "global")
+	return sortedKeyValues(services)
+}
+
+// renderPanicPage renders the development panic page with source code excerpts of frames,
+// request information and services available.
+func renderPanicPage(c *Context, info PanicInfo) []byte {
+	data := panicPageData{
+		Value:  fmt.Sprint(info.Value),
+		Info:   info,
+		Frames: make([]panicPageFrame, len(info.Frames)),
+	}
+
+	files := make(map[string][][]byte)
+	for i, f := range info.Frames {
+		frame := panicPageFrame{StackFrame: f, Internal: f.Internal()}
+		lines, ok := files[f.File]
+		if !ok {
+			if src, err := ioutil.ReadFile(f.File); err == nil {
+				lines = bytes.Split(src, []byte{'\n'})
+			}
+			files[f.File] = lines
+		}
+		if lines != nil {
+			frame.Lines = sourceExcerpt(lines, f.Line, PanicSourceLines)
+		}
+		if data.Cause == nil && !frame.Internal {
+			frame.Cause = true
+			data.Cause = &frame
+		}
+		data.Frames[i] = frame
+	}
+
+	for k, v := range info.Params {
+		data.Params = append(data.Params, keyValue{k, v})
+	}
+	sortedKeyValues(data.Params)
+	for k, v := range info.Header {
+		data.Headers = append(data.Headers, keyValue{k, strings.Join(v, ", ")})
+	}
+	sortedKeyValues(data.Headers)
+	data.Services = serviceList(c)
+
+	buf := new(bytes.Buffer)
+	if err := panicPage.Execute(buf, data); err != nil {
+		return []byte(fmt.Sprintf(panicHtml, info.Value, info.Value, info.Stack))
+	}
+	return buf.Bytes()
+}
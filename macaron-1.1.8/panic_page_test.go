@@ -0,0 +1,72 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type panicPageService struct{}
+
+func Test_PanicPage(t *testing.T) {
+	Convey("Render development panic page", t, func() {
+		setENV(DEV)
+
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Recovery())
+		m.Use(func(ctx *Context) {
+			ctx.Map(&panicPageService{})
+		})
+		m.Get("/user/:name", func() {
+			panic("here is a <panic>!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/user/unknwon", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-Custom-Header", "foobar")
+		m.ServeHTTP(resp, req)
+
+		body := resp.Body.String()
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.HeaderMap.Get("Content-Type"), ShouldEqual, "text/html")
+		So(body, ShouldContainSubstring, "PANIC: here is a &lt;panic&gt;!")
+		So(body, ShouldContainSubstring, `<span class="current">`)
+		So(body, ShouldContainSubstring, "panic_page_test.go")
+		So(body, ShouldContainSubstring, ":name")
+		So(body, ShouldContainSubstring, "unknwon")
+		So(body, ShouldContainSubstring, "X-Custom-Header")
+		So(body, ShouldContainSubstring, "*macaron.panicPageService")
+		So(body, ShouldContainSubstring, "*log.Logger")
+	})
+
+	Convey("Get source code excerpt", t, func() {
+		lines := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+		excerpt := sourceExcerpt(lines, 1, 1)
+		So(len(excerpt), ShouldEqual, 2)
+		So(excerpt[0].Current, ShouldBeTrue)
+		So(excerpt[1].Code, ShouldEqual, "b")
+
+		So(len(sourceExcerpt(lines, 4, 5)), ShouldEqual, 4)
+		So(sourceExcerpt(lines, len(lines)+10, 5), ShouldBeNil)
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package pprof serves profiles of net/http/pprof and variables of expvar by Macaron.
+//
+// It is separated from Macaron because both packages register their handlers to
+// http.DefaultServeMux once imported, which should only happen when they are wanted.
+package pprof
+
+import (
+	"expvar"
+	"net/http/pprof"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Register registers handlers of net/http/pprof and expvar under the prefix, where handlers
+// given are run before them, e.g. for authentication:
+//
+//	pprof.Register(m.Router, "/debug/pprof", macaron.BasicAuth(macaron.BasicAuthUsers(admins)))
+//
+// Profiles are served at the prefix, e.g. "/debug/pprof/heap", and expvar at "/vars" under it.
+func Register(r *macaron.Router, prefix string, h ...macaron.Handler) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	r.Group(prefix, func() {
+		r.Get("/", pprof.Index)
+		r.Get("/cmdline", pprof.Cmdline)
+		r.Get("/profile", pprof.Profile)
+		r.Route("/symbol", "GET,POST", pprof.Symbol)
+		r.Get("/trace", pprof.Trace)
+		r.Get("/vars", expvar.Handler().ServeHTTP)
+		r.Get("/:name", func(ctx *macaron.Context) {
+			pprof.Handler(ctx.Params(":name")).ServeHTTP(ctx.Resp, ctx.Req.Request)
+		})
+	}, h...)
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package pprof
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/macaron.v1"
+)
+
+func Test_Register(t *testing.T) {
+	Convey("Serve profiles under prefix", t, func() {
+		m := macaron.New()
+		Register(m.Router, "/admin/pprof/", macaron.BasicAuth(macaron.BasicAuthUsers(map[string]string{"admin": "secret"})))
+
+		serve := func(url string, auth bool) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			if auth {
+				req.SetBasicAuth("admin", "secret")
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		So(serve("/admin/pprof/", false).Code, ShouldEqual, http.StatusUnauthorized)
+
+		resp := serve("/admin/pprof/", true)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldContainSubstring, "goroutine")
+
+		resp = serve("/admin/pprof/goroutine?debug=1", true)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldContainSubstring, "goroutine profile:")
+
+		resp = serve("/admin/pprof/cmdline", true)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+
+		resp = serve("/admin/pprof/vars", true)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldContainSubstring, `"memstats"`)
+
+		So(serve("/admin/pprof/nothing", true).Code, ShouldEqual, http.StatusNotFound)
+	})
+}
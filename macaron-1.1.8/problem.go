@@ -0,0 +1,135 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const _CONTENT_PROBLEM = "application/problem+json"
+
+// Problem is a machine-readable error of HTTP APIs defined by RFC 7807. It implements error,
+// so handlers can return it, which is written by the default InternalServerError handler
+// with its status, e.g.
+//
+//	m.Get("/orders/:id", func(ctx *macaron.Context) error {
+//		return &macaron.Problem{Status: 404, Title: "Order not found"}
+//	})
+type Problem struct {
+	// Type is a URI that identifies the type of problem. Default is "about:blank".
+	Type string
+	// Title is a short summary of the type of problem. Default is the status text.
+	Title string
+	// Status is the HTTP status code. Default is 500.
+	Status int
+	// Detail explains this occurrence of the problem.
+	Detail string
+	// Instance is a URI that identifies this occurrence of the problem.
+	Instance string
+	// Extensions are additional members of the problem.
+	Extensions map[string]interface{}
+}
+
+func (p *Problem) Error() string {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	msg := strconv.Itoa(status) + " " + p.Title
+	if len(p.Detail) > 0 {
+		msg += ": " + p.Detail
+	}
+	return msg
+}
+
+// MarshalJSON encodes the problem as a JSON object whose standard members
+// take precedence over extensions of the same names.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		obj[k] = v
+	}
+	if len(p.Type) > 0 {
+		obj["type"] = p.Type
+	}
+	if len(p.Title) > 0 {
+		obj["title"] = p.Title
+	}
+	if p.Status > 0 {
+		obj["status"] = p.Status
+	}
+	if len(p.Detail) > 0 {
+		obj["detail"] = p.Detail
+	}
+	if len(p.Instance) > 0 {
+		obj["instance"] = p.Instance
+	}
+	return json.Marshal(obj)
+}
+
+// writeProblem writes the problem as application/problem+json, with defaults of missing members.
+func writeProblem(rw http.ResponseWriter, p *Problem) {
+	q := *p
+	if q.Status == 0 {
+		q.Status = http.StatusInternalServerError
+	}
+	if len(q.Title) == 0 && (len(q.Type) == 0 || q.Type == "about:blank") {
+		q.Title = http.StatusText(q.Status)
+	}
+	data, err := json.Marshal(&q)
+	if err != nil {
+		q.Extensions = nil
+		data, _ = json.Marshal(&q)
+	}
+	rw.Header().Set(_CONTENT_TYPE, _CONTENT_PROBLEM)
+	rw.WriteHeader(q.Status)
+	rw.Write(data)
+}
+
+// Problem writes a problem of RFC 7807 with given status, type URI, title and detail,
+// and members of extensions, e.g.
+//
+//	ctx.Problem(403, "https://example.com/probs/out-of-credit", "You do not have enough credit.",
+//		"Your current balance is 30, but that costs 50.", map[string]interface{}{"balance": 30})
+func (ctx *Context) Problem(status int, typeURI, title, detail string, extensions ...map[string]interface{}) {
+	p := &Problem{Type: typeURI, Title: title, Status: status, Detail: detail}
+	for _, ext := range extensions {
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]interface{})
+		}
+		for k, v := range ext {
+			p.Extensions[k] = v
+		}
+	}
+	writeProblem(ctx.Resp, p)
+}
+
+// SetProblemDetails sets whether error responses of the framework to clients that prefer JSON,
+// e.g. of NotFound, InternalServerError, Recovery and other middleware, are written as problems
+// of RFC 7807 instead of {"status":404,"error":"Not Found"}.
+func (m *Macaron) SetProblemDetails(enable bool) {
+	m.problemDetails = enable
+}
+
+// defaultNotFound is the default NotFound handler.
+func defaultNotFound(ctx *Context) {
+	if ctx.Router != nil && ctx.m != nil && ctx.m.problemDetails && preferJSON(ctx.Req.Request) {
+		writeProblem(ctx.Resp, &Problem{Status: http.StatusNotFound})
+		return
+	}
+	http.NotFound(ctx.Resp, ctx.Req.Request)
+}
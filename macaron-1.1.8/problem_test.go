@@ -0,0 +1,96 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Problem(t *testing.T) {
+	Convey("Write problems", t, func() {
+		m := New()
+		m.Get("/credit", func(ctx *Context) {
+			ctx.Problem(403, "https://example.com/probs/out-of-credit", "You do not have enough credit.",
+				"Your current balance is 30, but that costs 50.", map[string]interface{}{"balance": 30, "status": 1})
+		})
+		m.Get("/order", func() error {
+			return fmt.Errorf("load order: %w", &Problem{Status: 404, Detail: "Order 7 does not exist."})
+		})
+		m.Get("/error", func() error { return errors.New("database is down") })
+		m.Get("/unsupported", RequireContentType("application/json"), func() {})
+
+		serve := func(method, url string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, http.NoBody)
+			So(err, ShouldBeNil)
+			req.ContentLength = 1
+			req.Header.Set("Accept", "application/problem+json, application/json")
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("GET", "/credit")
+		So(resp.Code, ShouldEqual, http.StatusForbidden)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, "application/problem+json")
+		So(resp.Body.String(), ShouldEqual, `{"balance":30,"detail":"Your current balance is 30, but that costs 50.",`+
+			`"status":403,"title":"You do not have enough credit.","type":"https://example.com/probs/out-of-credit"}`)
+
+		resp = serve("GET", "/order")
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldEqual, `{"detail":"Order 7 does not exist.","status":404,"title":"Not Found"}`)
+
+		Convey("Without problem details", func() {
+			resp := serve("GET", "/missing")
+			So(resp.Body.String(), ShouldEqual, "404 page not found\n")
+
+			resp = serve("GET", "/unsupported")
+			So(resp.Body.String(), ShouldEqual, `{"error":"Unsupported Media Type","status":415,"supported":["application/json"]}`)
+		})
+
+		Convey("With problem details", func() {
+			m.SetProblemDetails(true)
+
+			resp := serve("GET", "/missing")
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldEqual, `{"status":404,"title":"Not Found"}`)
+
+			resp = serve("GET", "/error")
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(resp.Body.String(), ShouldEqual, `{"detail":"database is down","status":500,"title":"Internal Server Error"}`)
+
+			resp = serve("GET", "/unsupported")
+			So(resp.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+			So(resp.Body.String(), ShouldEqual, `{"status":415,"supported":["application/json"],"title":"Unsupported Media Type"}`)
+
+			Env = PROD
+			defer func() { Env = DEV }()
+			m.SetLogOutputs(nil, new(bytes.Buffer))
+			resp = serve("GET", "/error")
+			So(resp.Body.String(), ShouldEqual, `{"status":500,"title":"Internal Server Error"}`)
+		})
+	})
+
+	Convey("Format problems as errors", t, func() {
+		So((&Problem{Title: "Oops"}).Error(), ShouldEqual, "500 Oops")
+		So((&Problem{Status: 400, Title: "Bad", Detail: "Name is empty."}).Error(), ShouldEqual, "400 Bad: Name is empty.")
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// validateProvider makes sure a provider is a function that returns a service
+// and optionally an error, and panics if it is not. It returns type of the service.
+func validateProvider(provider interface{}) reflect.Type {
+	t := reflect.TypeOf(provider)
+	if t == nil || t.Kind() != reflect.Func ||
+		t.NumOut() < 1 || t.NumOut() > 2 ||
+		(t.NumOut() == 2 && t.Out(1) != errorType) {
+		panic("Macaron provider must be a function that returns a service and optionally an error")
+	}
+	planOf(t)
+	return t.Out(0)
+}
+
+// Provide registers a provider of the service type it returns. Instead of being created
+// for every request in a middleware, the service is created by calling the provider only
+// when a handler asks for its type and has not been mapped, then it is mapped for the rest
+// of the request. Arguments of the provider are injected same as handlers, and the provider
+// may return an error as second value to fail the request, e.g.
+//
+//	m.Provide(func(ctx *macaron.Context, db *sql.DB) (*Session, error) { ... })
+func (m *Macaron) Provide(provider interface{}) {
+	t := validateProvider(provider)
+	if m.providers == nil {
+		m.providers = make(map[reflect.Type]interface{})
+	}
+	m.providers[t] = provider
+}
+
+// resolve returns the service of given type for current request, calling its provider if
+// it has not been mapped. The returned value is invalid if there is no such service.
+func (c *Context) resolve(t reflect.Type) (reflect.Value, error) {
+	if val := c.GetVal(t); val.IsValid() {
+		return val, nil
+	}
+	if c.Router == nil || c.m == nil {
+		return reflect.Value{}, nil
+	}
+	if val, err := c.m.constructors.get(c.m, t); err != nil || val.IsValid() {
+		return val, err
+	}
+	provider, ok := c.m.providers[t]
+	if !ok {
+		return reflect.Value{}, nil
+	}
+
+	if c.providing[t] {
+		return reflect.Value{}, fmt.Errorf("cyclic dependency when providing type %v", t)
+	}
+	if c.providing == nil {
+		c.providing = make(map[reflect.Type]bool)
+	}
+	c.providing[t] = true
+	defer delete(c.providing, t)
+
+	vals, err := c.invoke(provider)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if len(vals) == 2 && !vals[1].IsNil() {
+		return reflect.Value{}, fmt.Errorf("provide %v: %v", t, vals[1].Interface())
+	}
+	c.Set(t, vals[0])
+	return vals[0], nil
+}
+
+// constructors creates global services by their constructors on demand,
+// each service is only created once.
+type constructors struct {
+	lock   sync.RWMutex
+	funcs  map[reflect.Type]interface{}
+	values map[reflect.Type]reflect.Value
+	// Types of services being constructed, to detect cyclic dependencies.
+	constructing map[reflect.Type]bool
+}
+
+// Construct registers a constructor of the global service type it returns. The service is
+// created once when it is first needed, by calling the constructor with its arguments
+// resolved from global services, which may be created by other constructors as well.
+// The constructor may return an error as second value, e.g.
+//
+//	m.Construct(NewUserService) // func NewUserService(db *sql.DB, cfg *Config) (*UserService, error)
+func (m *Macaron) Construct(constructor interface{}) {
+	t := validateProvider(constructor)
+	if m.constructors == nil {
+		m.constructors = &constructors{
+			funcs:        make(map[reflect.Type]interface{}),
+			values:       make(map[reflect.Type]reflect.Value),
+			constructing: make(map[reflect.Type]bool),
+		}
+	}
+	m.constructors.lock.Lock()
+	m.constructors.funcs[t] = constructor
+	m.constructors.lock.Unlock()
+}
+
+// get returns the global service of given type created by its constructor.
+// The returned value is invalid if there is no constructor of the type.
+func (cs *constructors) get(m *Macaron, t reflect.Type) (reflect.Value, error) {
+	if cs == nil {
+		return reflect.Value{}, nil
+	}
+
+	// Services constructed already and types without constructors are resolved by every
+	// request, so they only take the read lock.
+	cs.lock.RLock()
+	val, ok := cs.values[t]
+	_, hasConstructor := cs.funcs[t]
+	cs.lock.RUnlock()
+	if ok {
+		return val, nil
+	} else if !hasConstructor {
+		return reflect.Value{}, nil
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	return cs.construct(m, t)
+}
+
+func (cs *constructors) construct(m *Macaron, t reflect.Type) (reflect.Value, error) {
+	if val, ok := cs.values[t]; ok {
+		return val, nil
+	}
+	constructor, ok := cs.funcs[t]
+	if !ok {
+		return reflect.Value{}, nil
+	}
+
+	if cs.constructing[t] {
+		return reflect.Value{}, fmt.Errorf("cyclic dependency when constructing type %v", t)
+	}
+	cs.constructing[t] = true
+	defer delete(cs.constructing, t)
+
+	plan := planOf(reflect.TypeOf(constructor))
+	in := make([]reflect.Value, len(plan.args))
+	for i, argType := range plan.args {
+		val := m.GetVal(argType)
+		if !val.IsValid() {
+			var err error
+			if val, err = cs.construct(m, argType); err != nil {
+				return reflect.Value{}, err
+			} else if !val.IsValid() {
+				return reflect.Value{}, fmt.Errorf("construct %v: value not found for type %v", t, argType)
+			}
+		}
+		in[i] = val
+	}
+
+	vals := reflect.ValueOf(constructor).Call(in)
+	if len(vals) == 2 && !vals[1].IsNil() {
+		return reflect.Value{}, fmt.Errorf("construct %v: %v", t, vals[1].Interface())
+	}
+	cs.values[t] = vals[0]
+	return vals[0], nil
+}
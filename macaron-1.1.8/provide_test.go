@@ -0,0 +1,195 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type provideSession struct {
+	user string
+}
+
+type provideTenant struct {
+	name string
+}
+
+func Test_Provide(t *testing.T) {
+	Convey("Provide service lazily", t, func() {
+		calls := 0
+		m := New()
+		m.Map(&provideTenant{"acme"})
+		m.Provide(func(ctx *Context, tenant *provideTenant) *provideSession {
+			calls++
+			return &provideSession{ctx.Query("user") + "@" + tenant.name}
+		})
+		m.Get("/", func(s *provideSession) {}, func(s *provideSession) string {
+			return s.user
+		})
+		m.Get("/skip", func() string {
+			return "skipped"
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/?user=joe", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "joe@acme")
+		So(calls, ShouldEqual, 1)
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/skip", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "skipped")
+		So(calls, ShouldEqual, 1)
+	})
+
+	Convey("Mapped service takes precedence over provider", t, func() {
+		m := New()
+		m.Provide(func() *provideSession {
+			return &provideSession{"provided"}
+		})
+		m.Use(func(ctx *Context) {
+			ctx.Map(&provideSession{"mapped"})
+		})
+		m.Get("/", func(s *provideSession) string {
+			return s.user
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "mapped")
+	})
+
+	Convey("Fail request when provider returns error", t, func() {
+		m := New()
+		m.Provide(func() (*provideSession, error) {
+			return nil, errors.New("no session")
+		})
+		ctx := m.createContext(httptest.NewRecorder(), &http.Request{})
+		_, err := ctx.invoke(func(*provideSession) {})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "provide *macaron.provideSession: no session")
+	})
+
+	Convey("Detect cyclic providers", t, func() {
+		m := New()
+		m.Provide(func(*provideTenant) *provideSession { return nil })
+		m.Provide(func(*provideSession) *provideTenant { return nil })
+		ctx := m.createContext(httptest.NewRecorder(), &http.Request{})
+		_, err := ctx.invoke(func(*provideSession) {})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "cyclic dependency when providing type *macaron.provideSession")
+	})
+
+	Convey("Register invalid provider", t, func() {
+		defer func() {
+			So(recover(), ShouldNotBeNil)
+		}()
+		New().Provide(func() (*provideSession, string) { return nil, "" })
+	})
+}
+
+type constructConfig struct {
+	dsn string
+}
+
+type constructDB struct {
+	cfg *constructConfig
+}
+
+type constructUserService struct {
+	db *constructDB
+}
+
+func Test_Construct(t *testing.T) {
+	Convey("Construct global services on demand", t, func() {
+		calls := 0
+		m := New()
+		m.Map(&constructConfig{"mysql://"})
+		m.Construct(func(db *constructDB) *constructUserService {
+			calls++
+			return &constructUserService{db}
+		})
+		m.Construct(func(cfg *constructConfig) (*constructDB, error) {
+			return &constructDB{cfg}, nil
+		})
+		m.Get("/", func(s *constructUserService) string {
+			return s.db.cfg.dsn
+		})
+
+		for i := 0; i < 2; i++ {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Body.String(), ShouldEqual, "mysql://")
+		}
+		So(calls, ShouldEqual, 1)
+	})
+
+	Convey("Construct global services once for concurrent requests", t, func() {
+		var calls int32
+		m := New()
+		m.Construct(func() *constructConfig {
+			atomic.AddInt32(&calls, 1)
+			return &constructConfig{"mysql://"}
+		})
+		m.Get("/", func(cfg *constructConfig) string {
+			return cfg.dsn
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp := httptest.NewRecorder()
+				m.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+			}()
+		}
+		wg.Wait()
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+	})
+
+	Convey("Fail to construct global services", t, func() {
+		m := New()
+		m.Construct(func(*constructConfig) *constructDB { return nil })
+		ctx := m.createContext(httptest.NewRecorder(), &http.Request{})
+		_, err := ctx.invoke(func(*constructDB) {})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "construct *macaron.constructDB: value not found for type *macaron.constructConfig")
+
+		m.Construct(func() (*constructConfig, error) { return nil, errors.New("no config") })
+		_, err = ctx.invoke(func(*constructDB) {})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "construct *macaron.constructConfig: no config")
+
+		m.Construct(func(*constructDB) *constructConfig { return nil })
+		_, err = ctx.invoke(func(*constructDB) {})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "cyclic dependency when constructing type *macaron.constructDB")
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the transport of Proxy when requests are not sent
+// because the target has failed too many times in a row.
+var ErrCircuitOpen = errors.New("proxy: circuit breaker is open")
+
+// ProxyOptions is a struct for specifying configuration options for macaron.Proxy.
+type ProxyOptions struct {
+	// Transport sends requests to the target. Default is http.DefaultTransport.
+	Transport http.RoundTripper
+	// StripPrefix is removed from request path before it is joined with path of the target.
+	StripPrefix string
+	// PreserveHost keeps Host header of the request instead of using host of the target.
+	PreserveHost bool
+	// RequestHeaders are set on requests to the target, headers with empty values are removed.
+	RequestHeaders map[string]string
+	// ResponseHeaders are set on responses from the target, headers with empty values are removed.
+	ResponseHeaders map[string]string
+	// Director modifies requests to the target after default rewriting, e.g. to add credentials
+	// of current user. It is called with the context of the request being proxied.
+	Director func(ctx *Context, req *http.Request)
+	// ModifyResponse modifies responses from the target, the error it returns fails the request.
+	ModifyResponse func(resp *http.Response) error
+	// Retries is the number of times idempotent requests are retried when they fail to be sent.
+	Retries int
+	// RetryDelay is the delay before every retry. Default is 100 milliseconds.
+	RetryDelay time.Duration
+	// FailureThreshold is the number of failures in a row that opens the circuit breaker,
+	// requests are rejected with 503 Service Unavailable while it is open. Failures are
+	// errors of sending requests and responses of status 502, 503 and 504. Default is 0, i.e. disabled.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit breaker stays open, before a request is let through
+	// to check if the target recovers. Default is 30 seconds.
+	OpenTimeout time.Duration
+}
+
+func prepareProxyOptions(options []ProxyOptions) ProxyOptions {
+	var opt ProxyOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Transport == nil {
+		opt.Transport = http.DefaultTransport
+	}
+	if opt.RetryDelay <= 0 {
+		opt.RetryDelay = 100 * time.Millisecond
+	}
+	if opt.OpenTimeout <= 0 {
+		opt.OpenTimeout = 30 * time.Second
+	}
+	return opt
+}
+
+// circuitBreaker rejects requests for a while after the target fails too many times in a row.
+type circuitBreaker struct {
+	threshold int
+	timeout   time.Duration
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns true if a request can be sent. Once the breaker has been open for the timeout,
+// only one request is let through until it succeeds or fails.
+func (b *circuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) record(ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.timeout)
+	}
+}
+
+// proxyTransport retries requests and records results to the circuit breaker.
+type proxyTransport struct {
+	opt     ProxyOptions
+	breaker *circuitBreaker
+}
+
+// retryable returns true if the request can be sent again safely.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for i := 0; ; i++ {
+		if t.breaker != nil && !t.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		resp, err := t.opt.Transport.RoundTrip(req)
+		if t.breaker != nil {
+			t.breaker.record(err == nil && resp.StatusCode != http.StatusBadGateway &&
+				resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusGatewayTimeout)
+		}
+		if err == nil || i >= t.opt.Retries || !retryable(req) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		select {
+		case <-time.After(t.opt.RetryDelay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func setHeaders(header http.Header, values map[string]string) {
+	for k, v := range values {
+		if len(v) == 0 {
+			header.Del(k)
+		} else {
+			header.Set(k, v)
+		}
+	}
+}
+
+type proxyContextKey struct{}
+
+// Proxy returns a handler that forwards requests to the target, and copies responses back.
+// Path of the request is joined with path of the target, and X-Forwarded-For, X-Forwarded-Host
+// and X-Forwarded-Proto headers are set. WebSocket and other upgraded connections are passed through.
+// Requests that fail to be sent are responded with 502 Bad Gateway, e.g.
+//
+//	target, _ := url.Parse("http://localhost:8080/v1")
+//	m.Any("/api/*", macaron.Proxy(target, macaron.ProxyOptions{StripPrefix: "/api", Retries: 2}))
+func Proxy(target *url.URL, options ...ProxyOptions) Handler {
+	opt := prepareProxyOptions(options)
+	transport := &proxyTransport{opt: opt}
+	if opt.FailureThreshold > 0 {
+		transport.breaker = &circuitBreaker{threshold: opt.FailureThreshold, timeout: opt.OpenTimeout}
+	}
+	retryAfter := strconv.Itoa(int((opt.OpenTimeout + time.Second - 1) / time.Second))
+
+	rp := &httputil.ReverseProxy{
+		Transport: transport,
+		Director: func(req *http.Request) {
+			ctx := req.Context().Value(proxyContextKey{}).(*Context)
+
+			if !opt.PreserveHost {
+				req.Host = ""
+			}
+			req.Header.Set("X-Forwarded-Host", ctx.Req.Host)
+			proto := "http"
+			if ctx.Req.TLS != nil {
+				proto = "https"
+			}
+			req.Header.Set("X-Forwarded-Proto", proto)
+
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			p := strings.TrimPrefix(req.URL.Path, opt.StripPrefix)
+			if len(p) == 0 || p[0] != '/' {
+				p = "/" + p
+			}
+			req.URL.Path = strings.TrimSuffix(target.Path, "/") + p
+			req.URL.RawPath = ""
+			if len(target.RawQuery) > 0 {
+				if len(req.URL.RawQuery) > 0 {
+					req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+				} else {
+					req.URL.RawQuery = target.RawQuery
+				}
+			}
+			setHeaders(req.Header, opt.RequestHeaders)
+
+			if opt.Director != nil {
+				opt.Director(ctx, req)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			setHeaders(resp.Header, opt.ResponseHeaders)
+			if opt.ModifyResponse != nil {
+				return opt.ModifyResponse(resp)
+			}
+			return nil
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			ctx := req.Context().Value(proxyContextKey{}).(*Context)
+			if err == ErrCircuitOpen {
+				rw.Header().Set("Retry-After", retryAfter)
+				writeErrorPage(ctx, rw, http.StatusServiceUnavailable, "")
+				return
+			}
+			ctx.m.ErrorLogger().Printf("%sproxy: %v", requestTag(ctx), err)
+			writeErrorPage(ctx, rw, http.StatusBadGateway, "")
+		},
+	}
+
+	return func(ctx *Context) {
+		req := ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), proxyContextKey{}, ctx))
+		rp.ServeHTTP(ctx.Resp, req)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyHeaderTimeout is the timeout of reading PROXY protocol headers of connections.
+var ProxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts headers of PROXY protocol version 2.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn is a connection that may start with a PROXY protocol header, which is read
+// on first use, and replaces the remote address by the one of the client.
+type proxyConn struct {
+	net.Conn
+	br      *bufio.Reader
+	trusted bool
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		if !c.trusted {
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		addr, err := readProxyHeader(c.br)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = fmt.Errorf("proxy protocol: %v", err)
+			c.Conn.Close()
+			return
+		}
+		if addr != nil {
+			c.remote = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyHeader reads the header of PROXY protocol version 1 or 2, and returns the address
+// of the client, or nil if there is no header, or the header does not carry the address.
+func readProxyHeader(br *bufio.Reader) (net.Addr, error) {
+	if sig, err := br.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(br)
+	}
+	if prefix, err := br.Peek(6); err != nil || string(prefix) != "PROXY " {
+		// Connections without header are served as they are.
+		return nil, nil
+	}
+
+	// Header of version 1 is at most 107 bytes, e.g. "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n".
+	var line []byte
+	for len(line) < 107 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid header of version 1")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid header of version 1")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.New("invalid address of version 1")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(br *bufio.Reader) (net.Addr, error) {
+	var head [16]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", head[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command is sent by the proxy itself, e.g. for health checks.
+	if head[12]&0x0f == 0 {
+		return nil, nil
+	}
+	if head[12]&0x0f != 1 {
+		return nil, fmt.Errorf("unsupported command %d", head[12]&0x0f)
+	}
+	switch head[13] >> 4 {
+	case 1:
+		if len(body) < 12 {
+			return nil, errors.New("invalid IPv4 address of version 2")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2:
+		if len(body) < 36 {
+			return nil, errors.New("invalid IPv6 address of version 2")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	// Unspecified or Unix socket addresses are ignored.
+	return nil, nil
+}
+
+// proxyListener accepts connections that may start with PROXY protocol headers.
+type proxyListener struct {
+	net.Listener
+	loadBalancers []*net.IPNet
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	trusted := false
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		trusted = containsIP(l.loadBalancers, addr.IP)
+	}
+	return &proxyConn{Conn: conn, br: bufio.NewReader(conn), trusted: trusted}, nil
+}
+
+// ProxyProtocolListener wraps the listener to accept HAProxy PROXY protocol version 1 and 2 headers,
+// so RemoteAddr of connections is the address of the client rather than the load balancer. Headers are
+// accepted only from load balancers given by CIDRs or single IP addresses, at least one of which must
+// be given. To accept headers from any peer, e.g. when only load balancers can reach the listener,
+// give "0.0.0.0/0" and "::/0" explicitly. Connections without header are served as they are, and those
+// with invalid header are closed.
+func ProxyProtocolListener(l net.Listener, loadBalancers ...string) (net.Listener, error) {
+	nets, err := parseProxyLoadBalancers(loadBalancers)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyListener{l, nets}, nil
+}
+
+func parseProxyLoadBalancers(loadBalancers []string) ([]*net.IPNet, error) {
+	if len(loadBalancers) == 0 {
+		return nil, errors.New("proxy protocol: no load balancer is given")
+	}
+	return parseIPNets(loadBalancers)
+}
+
+// SetProxyProtocol makes listeners started by Run accept PROXY protocol headers from the load balancers,
+// see ProxyProtocolListener, so RemoteAddr of requests, and therefore ClientIP and logs, reflect
+// real clients behind TCP load balancers.
+func (m *Macaron) SetProxyProtocol(loadBalancers ...string) error {
+	if _, err := parseProxyLoadBalancers(loadBalancers); err != nil {
+		return err
+	}
+	m.proxyProtocol = append([]string{}, loadBalancers...)
+	return nil
+}
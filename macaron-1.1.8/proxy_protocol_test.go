@@ -0,0 +1,92 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ProxyProtocolListener(t *testing.T) {
+	Convey("Accept PROXY protocol headers", t, func() {
+		m := New()
+		m.Get("/", func(ctx *Context) string {
+			return ctx.Req.RemoteAddr
+		})
+
+		serve := func(loadBalancers []string, header []byte) (string, error) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			pl, err := ProxyProtocolListener(l, loadBalancers...)
+			So(err, ShouldBeNil)
+			defer pl.Close()
+			go http.Serve(pl, m)
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write(append(header, "GET / HTTP/1.0\r\n\r\n"...))
+			data, err := ioutil.ReadAll(conn)
+			if err != nil || len(data) == 0 {
+				return "", err
+			}
+			parts := strings.SplitN(string(data), "\r\n\r\n", 2)
+			return parts[len(parts)-1], nil
+		}
+		body := func(loadBalancers []string, header []byte) string {
+			resp, _ := serve(loadBalancers, header)
+			return resp
+		}
+
+		local := []string{"127.0.0.1"}
+		So(body(local, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n")), ShouldEqual, "203.0.113.7:51234")
+		So(body(local, []byte("PROXY TCP6 2001:db8::1 ::1 443 80\r\n")), ShouldEqual, "[2001:db8::1]:443")
+		So(body(local, []byte("PROXY UNKNOWN\r\n")), ShouldStartWith, "127.0.0.1:")
+		So(body(local, nil), ShouldStartWith, "127.0.0.1:")
+
+		v2 := append([]byte{}, proxyV2Signature...)
+		v2 = append(v2, 0x21, 0x11, 0, 12, 198, 51, 100, 9, 10, 0, 0, 1, 0x1f, 0x90, 0, 80)
+		So(body(local, v2), ShouldEqual, "198.51.100.9:8080")
+
+		v2Local := append([]byte{}, proxyV2Signature...)
+		v2Local = append(v2Local, 0x20, 0x00, 0, 0)
+		So(body(local, v2Local), ShouldStartWith, "127.0.0.1:")
+
+		// Headers from peers that are not load balancers are not accepted.
+		resp, _ := serve([]string{"10.0.0.0/8"}, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n"))
+		So(resp, ShouldNotContainSubstring, "203.0.113.7")
+		So(body([]string{"0.0.0.0/0", "::/0"}, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n")), ShouldEqual, "203.0.113.7:51234")
+
+		// Invalid headers close the connection.
+		resp, _ = serve(local, []byte("PROXY TCP4 nonsense\r\n"))
+		So(resp, ShouldBeEmpty)
+
+		_, err := ProxyProtocolListener(nil, "bad")
+		So(err, ShouldNotBeNil)
+		_, err = ProxyProtocolListener(nil)
+		So(err, ShouldNotBeNil)
+		So(m.SetProxyProtocol(), ShouldNotBeNil)
+		So(m.proxyProtocol, ShouldBeNil)
+		So(m.SetProxyProtocol("10.0.0.0/8"), ShouldBeNil)
+		So(m.proxyProtocol, ShouldResemble, []string{"10.0.0.0/8"})
+	})
+}
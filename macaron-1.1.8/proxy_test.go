@@ -0,0 +1,202 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// roundTripFunc is a http.RoundTripper of a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_Proxy(t *testing.T) {
+	Convey("Proxy requests to the target", t, func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Internal", "secret")
+			rw.Header().Set("X-Path", req.URL.RequestURI())
+			rw.Header().Set("X-Host", req.Host)
+			rw.Header().Set("X-Forwarded", req.Header.Get("X-Forwarded-Host")+" "+req.Header.Get("X-Forwarded-Proto"))
+			rw.Header().Set("X-Auth", req.Header.Get("Authorization"))
+			rw.Header().Set("X-Cookie", req.Header.Get("Cookie"))
+			rw.Write([]byte("backend"))
+		}))
+		defer backend.Close()
+		target, err := url.Parse(backend.URL + "/v1?key=1")
+		So(err, ShouldBeNil)
+
+		m := New()
+		m.Get("/api/*", func(ctx *Context) {
+			ctx.Data["User"] = "unknwon"
+		}, Proxy(target, ProxyOptions{
+			StripPrefix:     "/api",
+			RequestHeaders:  map[string]string{"Cookie": ""},
+			ResponseHeaders: map[string]string{"X-Internal": "", "X-Proxy": "macaron"},
+			Director: func(ctx *Context, req *http.Request) {
+				req.Header.Set("Authorization", "User "+ctx.Data["User"].(string))
+			},
+		}))
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/users?page=2", nil)
+		So(err, ShouldBeNil)
+		req.Host = "example.com"
+		req.Header.Set("Cookie", "session=1")
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "backend")
+		So(resp.Header().Get("X-Path"), ShouldEqual, "/v1/users?key=1&page=2")
+		So(resp.Header().Get("X-Host"), ShouldEqual, target.Host)
+		So(resp.Header().Get("X-Forwarded"), ShouldEqual, "example.com http")
+		So(resp.Header().Get("X-Auth"), ShouldEqual, "User unknwon")
+		So(resp.Header().Get("X-Cookie"), ShouldBeEmpty)
+		So(resp.Header().Get("X-Internal"), ShouldBeEmpty)
+		So(resp.Header().Get("X-Proxy"), ShouldEqual, "macaron")
+	})
+
+	Convey("Pass through upgraded connections", t, func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			conn, buf, err := rw.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			buf.Flush()
+			line, _ := buf.ReadString('\n')
+			buf.WriteString("echo: " + line)
+			buf.Flush()
+		}))
+		defer backend.Close()
+		target, _ := url.Parse(backend.URL)
+
+		m := New()
+		m.Get("/ws", Proxy(target))
+		server := httptest.NewServer(m)
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+
+		conn.Write([]byte("hello\n"))
+		line, err := r.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(line, ShouldEqual, "echo: hello\n")
+	})
+
+	Convey("Retry idempotent requests", t, func() {
+		var calls int32
+		target, _ := url.Parse("http://backend")
+		transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		})
+
+		m := New()
+		m.Any("/", Proxy(target, ProxyOptions{Transport: transport, Retries: 2, RetryDelay: time.Millisecond}))
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+
+		Convey("But not others", func() {
+			atomic.StoreInt32(&calls, 0)
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Open circuit breaker after failures", t, func() {
+		var calls int32
+		var healthy int32
+		target, _ := url.Parse("http://backend")
+		transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			status := http.StatusServiceUnavailable
+			if atomic.LoadInt32(&healthy) == 1 {
+				status = http.StatusOK
+			}
+			return &http.Response{
+				StatusCode: status,
+				Header:     make(http.Header),
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		})
+
+		m := New()
+		m.Get("/", Proxy(target, ProxyOptions{
+			Transport:        transport,
+			FailureThreshold: 2,
+			OpenTimeout:      20 * time.Millisecond,
+		}))
+		serve := func() *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		So(serve().Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(serve().Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+
+		resp := serve()
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(resp.Header().Get("Retry-After"), ShouldEqual, "1")
+		So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+
+		time.Sleep(30 * time.Millisecond)
+		atomic.StoreInt32(&healthy, 1)
+		So(serve().Code, ShouldEqual, http.StatusOK)
+		So(serve().Code, ShouldEqual, http.StatusOK)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 4)
+	})
+}
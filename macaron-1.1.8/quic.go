@@ -0,0 +1,103 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/Unknwon/com"
+)
+
+// QUICServer serves HTTP/3 over QUIC, e.g. http3.Server of github.com/quic-go/quic-go.
+type QUICServer interface {
+	ListenAndServeTLS(certFile, keyFile string) error
+	Close() error
+}
+
+// NewQUICServer creates servers of HTTP/3 for RunQUIC. No QUIC implementation is included
+// in Macaron, so it must be set to an adapter of a QUIC library before RunQUIC is called, e.g.
+//
+//	macaron.NewQUICServer = func(addr string, handler http.Handler) macaron.QUICServer {
+//		return &http3.Server{Addr: addr, Handler: handler}
+//	}
+var NewQUICServer func(addr string, handler http.Handler) QUICServer
+
+// ErrNoQUICServer is returned by RunQUIC when NewQUICServer is not set.
+var ErrNoQUICServer = errors.New("macaron: NewQUICServer is not set")
+
+// AltSvc returns a middleware handler that advertises HTTP/3 on given UDP port by Alt-Svc header,
+// so clients connected by TCP switch to QUIC for later requests. Advertisement is cached
+// by clients for maxAge, default is 24 hours.
+func AltSvc(port int, maxAge ...time.Duration) Handler {
+	value := altSvcValue(port, maxAge...)
+	return func(ctx *Context) {
+		ctx.Resp.Header().Set("Alt-Svc", value)
+	}
+}
+
+func altSvcValue(port int, maxAge ...time.Duration) string {
+	age := 24 * time.Hour
+	if len(maxAge) > 0 && maxAge[0] > 0 {
+		age = maxAge[0]
+	}
+	return fmt.Sprintf(`h3=":%d"; ma=%d`, port, int64(age/time.Second))
+}
+
+// serveQUIC serves HTTP/3 on the UDP address and HTTPS on the TCP address of same port,
+// whose responses advertise HTTP/3 by Alt-Svc header. It returns when either of servers fails.
+func (m *Macaron) serveQUIC(addr, certFile, keyFile string) error {
+	if NewQUICServer == nil {
+		return ErrNoQUICServer
+	}
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	altSvc := altSvcValue(com.StrTo(portStr).MustInt())
+
+	quic := NewQUICServer(addr, m)
+	tcp := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Alt-Svc", altSvc)
+		m.ServeHTTP(rw, req)
+	})}
+
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- quic.ListenAndServeTLS(certFile, keyFile)
+	}()
+	go func() {
+		errChan <- tcp.ListenAndServeTLS(certFile, keyFile)
+	}()
+	err = <-errChan
+	quic.Close()
+	tcp.Close()
+	return err
+}
+
+// RunQUIC starts serving HTTP/3 by the server of NewQUICServer, along with HTTPS over TCP
+// on the same port for clients that do not speak QUIC yet, which is advertised to them by
+// Alt-Svc header. Host and port are given as Run does.
+func (m *Macaron) RunQUIC(certFile, keyFile string, args ...interface{}) {
+	addr := listenAddr(args)
+	logger := m.GetVal(reflect.TypeOf(m.logger)).Interface().(*log.Logger)
+	logger.Printf("listening on %s with HTTP/3 (%s)\n", addr, safeEnv())
+	logger.Fatalln(m.serveQUIC(addr, certFile, keyFile))
+}
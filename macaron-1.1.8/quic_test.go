@@ -0,0 +1,89 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeQUICServer struct {
+	addr    string
+	handler http.Handler
+	closed  chan struct{}
+}
+
+func (s *fakeQUICServer) ListenAndServeTLS(certFile, keyFile string) error {
+	<-s.closed
+	return errors.New("quic: server closed")
+}
+
+func (s *fakeQUICServer) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func Test_AltSvc(t *testing.T) {
+	Convey("Advertise HTTP/3 by Alt-Svc header", t, func() {
+		m := New()
+		m.Use(AltSvc(8443))
+		m.Get("/", func() string { return "ok" })
+		m.Get("/short", AltSvc(443, time.Hour), func() string { return "ok" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("Alt-Svc"), ShouldEqual, `h3=":8443"; ma=86400`)
+
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/short", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("Alt-Svc"), ShouldEqual, `h3=":443"; ma=3600`)
+	})
+}
+
+func Test_Macaron_serveQUIC(t *testing.T) {
+	Convey("Serve HTTP/3 along with HTTPS", t, func() {
+		m := New()
+		defer func() { NewQUICServer = nil }()
+
+		Convey("Without QUIC implementation", func() {
+			NewQUICServer = nil
+			So(m.serveQUIC("127.0.0.1:0", "cert.pem", "key.pem"), ShouldEqual, ErrNoQUICServer)
+		})
+
+		Convey("Stop both servers when either fails", func() {
+			var quic *fakeQUICServer
+			NewQUICServer = func(addr string, handler http.Handler) QUICServer {
+				quic = &fakeQUICServer{addr: addr, handler: handler, closed: make(chan struct{})}
+				return quic
+			}
+			// HTTPS fails to load the missing certificate, which closes the QUIC server.
+			err := m.serveQUIC("127.0.0.1:0", "missing-cert.pem", "missing-key.pem")
+			So(err, ShouldNotBeNil)
+			So(quic.addr, ShouldEqual, "127.0.0.1:0")
+			So(quic.handler, ShouldEqual, m)
+			_, open := <-quic.closed
+			So(open, ShouldBeFalse)
+		})
+	})
+}
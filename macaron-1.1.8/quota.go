@@ -0,0 +1,215 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QuotaStore counts requests for Quota, so budgets can be shared by multiple instances.
+type QuotaStore interface {
+	// Incr increments the counter of given key, which expires at given time,
+	// and returns the count after increment.
+	Incr(key string, expires time.Time) (int64, error)
+}
+
+type memoryQuotaCounter struct {
+	count   int64
+	expires time.Time
+}
+
+// memoryQuotaStore is a QuotaStore that keeps counters in memory.
+type memoryQuotaStore struct {
+	lock     sync.Mutex
+	counters map[string]*memoryQuotaCounter
+	cleaned  time.Time
+}
+
+// NewMemoryQuotaStore returns a QuotaStore that keeps counters in memory of current process.
+func NewMemoryQuotaStore() QuotaStore {
+	return &memoryQuotaStore{counters: make(map[string]*memoryQuotaCounter)}
+}
+
+func (s *memoryQuotaStore) Incr(key string, expires time.Time) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Clean up expired counters by the way, at most once a minute.
+	now := time.Now()
+	if now.Sub(s.cleaned) > time.Minute {
+		for k, c := range s.counters {
+			if now.After(c.expires) {
+				delete(s.counters, k)
+			}
+		}
+		s.cleaned = now
+	}
+
+	c := s.counters[key]
+	if c == nil || now.After(c.expires) {
+		c = &memoryQuotaCounter{expires: expires}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, nil
+}
+
+// QuotaLimits is the budget of requests of a key, zero means unlimited.
+type QuotaLimits struct {
+	Daily   int64
+	Monthly int64
+}
+
+// QuotaOptions is a struct for specifying configuration options for the macaron.Quota middleware.
+type QuotaOptions struct {
+	// Store counts requests. Default is a store in memory.
+	Store QuotaStore
+	// Key returns the key that requests are counted by, requests with empty key are not counted.
+	// Default is name of APIKey if it is mapped, or AuthenticatedUser otherwise.
+	Key func(ctx *Context) string
+	// Limits returns the budget of given key, e.g. by plan of the customer. Default is the budget
+	// passed to Quota for all keys.
+	Limits func(key string) QuotaLimits
+	// Location is the time zone that days and months start in. Default is UTC.
+	Location *time.Location
+	// OnExhausted is called once per period, by the request that uses up the budget of the key,
+	// period is "daily" or "monthly".
+	OnExhausted func(ctx *Context, key, period string)
+}
+
+func prepareQuotaOptions(options []QuotaOptions) QuotaOptions {
+	var opt QuotaOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Store == nil {
+		opt.Store = NewMemoryQuotaStore()
+	}
+	if opt.Key == nil {
+		opt.Key = func(ctx *Context) string {
+			if v := ctx.GetVal(reflect.TypeOf((*APIKey)(nil))); v.IsValid() && !v.IsNil() {
+				return v.Interface().(*APIKey).Name
+			}
+			if v := ctx.GetVal(reflect.TypeOf(AuthenticatedUser(""))); v.IsValid() {
+				return v.String()
+			}
+			return ""
+		}
+	}
+	if opt.Location == nil {
+		opt.Location = time.UTC
+	}
+	return opt
+}
+
+type quotaPeriod struct {
+	name  string
+	limit int64
+	// Start of current period formatted, and start of the next one.
+	id    string
+	reset time.Time
+}
+
+// quotaPeriods returns periods of given limits at given time.
+func quotaPeriods(limits QuotaLimits, now time.Time) []quotaPeriod {
+	var periods []quotaPeriod
+	if limits.Daily > 0 {
+		y, m, d := now.Date()
+		periods = append(periods, quotaPeriod{"daily", limits.Daily,
+			now.Format("2006-01-02"), time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())})
+	}
+	if limits.Monthly > 0 {
+		y, m, _ := now.Date()
+		periods = append(periods, quotaPeriod{"monthly", limits.Monthly,
+			now.Format("2006-01"), time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())})
+	}
+	return periods
+}
+
+// Quota returns a middleware handler that enforces daily and monthly budgets of requests per API key
+// or user, for metered APIs. It should be used after authentication middleware. Responses have headers
+// X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset (in seconds) of the period closest to running out,
+// and requests over budget are responded with 429 Too Many Requests until the period resets.
+// Rejected requests are counted too. Requests are let through if the store fails, e.g.
+//
+//	m.Group("/api", func() {
+//		...
+//	}, macaron.APIKeyAuth(keys), macaron.Quota(macaron.QuotaLimits{Daily: 1000, Monthly: 20000}))
+func Quota(limits QuotaLimits, options ...QuotaOptions) Handler {
+	opt := prepareQuotaOptions(options)
+
+	return func(ctx *Context) {
+		key := opt.Key(ctx)
+		if len(key) == 0 {
+			return
+		}
+		l := limits
+		if opt.Limits != nil {
+			l = opt.Limits(key)
+		}
+
+		now := time.Now().In(opt.Location)
+		remaining := int64(math.MaxInt64)
+		var binding *quotaPeriod
+		var exceeded bool
+		for _, p := range quotaPeriods(l, now) {
+			p := p
+			count, err := opt.Store.Incr("quota:"+p.name+":"+key+":"+p.id, p.reset)
+			if err != nil {
+				if ctx.Router != nil && ctx.m != nil {
+					ctx.m.ErrorLogger().Printf("%sQuota: fail to count request: %v", requestTag(ctx), err)
+				}
+				return
+			}
+			if count == p.limit && opt.OnExhausted != nil {
+				opt.OnExhausted(ctx, key, p.name)
+			}
+
+			left := p.limit - count
+			if left < 0 {
+				left = 0
+			}
+			// The period that runs out last decides when requests are allowed again.
+			if count > p.limit && (!exceeded || !p.reset.Before(binding.reset)) {
+				binding, exceeded = &p, true
+			} else if !exceeded && left < remaining {
+				binding, remaining = &p, left
+			}
+		}
+		if binding == nil {
+			return
+		}
+		if exceeded {
+			remaining = 0
+		}
+
+		reset := int64(math.Ceil(binding.reset.Sub(now).Seconds()))
+		header := ctx.Resp.Header()
+		header.Set("X-Quota-Limit", strconv.FormatInt(binding.limit, 10))
+		header.Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+		header.Set("X-Quota-Reset", strconv.FormatInt(reset, 10))
+		if exceeded {
+			header.Set("Retry-After", strconv.FormatInt(reset, 10))
+			writeErrorPage(ctx, ctx.Resp, http.StatusTooManyRequests, "")
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type failingQuotaStore struct{}
+
+func (failingQuotaStore) Incr(string, time.Time) (int64, error) {
+	return 0, errors.New("store is down")
+}
+
+func Test_Quota(t *testing.T) {
+	Convey("Enforce budgets of requests per API key", t, func() {
+		var exhausted []string
+		m := New()
+		m.Use(APIKeyAuth(APIKeys(map[string]*APIKey{
+			"free-key": {Name: "free"},
+			"pro-key":  {Name: "pro"},
+		})))
+		m.Use(Quota(QuotaLimits{Daily: 2, Monthly: 3}, QuotaOptions{
+			Limits: func(key string) QuotaLimits {
+				if key == "pro" {
+					return QuotaLimits{Monthly: 100}
+				}
+				return QuotaLimits{Daily: 2, Monthly: 3}
+			},
+			OnExhausted: func(ctx *Context, key, period string) {
+				exhausted = append(exhausted, key+":"+period)
+			},
+		}))
+		m.Get("/", func() string { return "ok" })
+
+		serve := func(key string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("X-API-Key", key)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("free-key")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("X-Quota-Limit"), ShouldEqual, "2")
+		So(resp.Header().Get("X-Quota-Remaining"), ShouldEqual, "1")
+		reset, err := strconv.Atoi(resp.Header().Get("X-Quota-Reset"))
+		So(err, ShouldBeNil)
+		So(reset, ShouldBeGreaterThan, 0)
+		So(reset, ShouldBeLessThanOrEqualTo, 86400)
+
+		resp = serve("free-key")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("X-Quota-Remaining"), ShouldEqual, "0")
+		So(exhausted, ShouldResemble, []string{"free:daily"})
+
+		resp = serve("free-key")
+		So(resp.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(resp.Header().Get("Retry-After"), ShouldNotBeEmpty)
+		So(exhausted, ShouldResemble, []string{"free:daily", "free:monthly"})
+
+		// Monthly budget runs out later than the daily one.
+		resp = serve("free-key")
+		So(resp.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(resp.Header().Get("X-Quota-Limit"), ShouldEqual, "3")
+		So(resp.Header().Get("X-Quota-Remaining"), ShouldEqual, "0")
+
+		resp = serve("pro-key")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("X-Quota-Limit"), ShouldEqual, "100")
+		So(resp.Header().Get("X-Quota-Remaining"), ShouldEqual, "99")
+	})
+
+	Convey("Let requests through if the store fails", t, func() {
+		m := New()
+		m.Use(BasicAuth(func(user, pass string) bool { return true }))
+		m.Use(Quota(QuotaLimits{Daily: 1}, QuotaOptions{Store: failingQuotaStore{}}))
+		m.Get("/", func() string { return "ok" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		req.SetBasicAuth("user", "pass")
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("X-Quota-Remaining"), ShouldBeEmpty)
+	})
+
+	Convey("Compute periods of budgets", t, func() {
+		now := time.Date(2016, 12, 31, 15, 0, 0, 0, time.UTC)
+		periods := quotaPeriods(QuotaLimits{Daily: 10, Monthly: 100}, now)
+		So(periods, ShouldHaveLength, 2)
+		So(periods[0].id, ShouldEqual, "2016-12-31")
+		So(periods[0].reset, ShouldResemble, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+		So(periods[1].id, ShouldEqual, "2016-12")
+		So(periods[1].reset, ShouldResemble, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+		So(quotaPeriods(QuotaLimits{}, now), ShouldBeEmpty)
+	})
+}
@@ -21,7 +21,11 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-macaron/inject"
 )
@@ -70,9 +74,50 @@ var (
 	slash     = []byte("/")
 )
 
-// stack returns a nicely formated stack frame, skipping skip frames
-func stack(skip int) []byte {
-	buf := new(bytes.Buffer) // the returned data
+// StackFrame represents a parsed frame of stack trace.
+type StackFrame struct {
+	File     string
+	Line     int
+	PC       uintptr
+	Function string
+	// Source is the space-trimmed source code of the line, or "???" if source file is not available.
+	Source string
+
+	// Full name of the function including package path.
+	fullName string
+}
+
+// String returns formatted frame as it appears in the stack output.
+func (f StackFrame) String() string {
+	return fmt.Sprintf("%s:%d (0x%x)\n\t%s: %s\n", f.File, f.Line, f.PC, f.Function, f.Source)
+}
+
+// macaronFuncPrefix is the prefix of function names of this package,
+// e.g. "gopkg.in/macaron%2ev1.".
+var macaronFuncPrefix = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(callers).Pointer()).Name()
+	return strings.TrimSuffix(name, "callers")
+}()
+
+// Internal returns true if the frame belongs to Go runtime, reflection, net/http,
+// dependency injection or Macaron itself rather than the application.
+func (f StackFrame) Internal() bool {
+	switch {
+	case strings.HasPrefix(f.fullName, "runtime."),
+		strings.HasPrefix(f.fullName, "reflect."),
+		strings.HasPrefix(f.fullName, "net/http."),
+		strings.HasPrefix(f.fullName, "github.com/go-macaron/inject."):
+		return true
+	case strings.HasPrefix(f.fullName, macaronFuncPrefix):
+		// Handlers defined in test files of this package are application code.
+		return !strings.HasSuffix(f.File, "_test.go")
+	}
+	return false
+}
+
+// callers returns parsed stack frames, skipping skip frames.
+func callers(skip int) []StackFrame {
+	frames := make([]StackFrame, 0, 10)
 	// As we loop, we open files and read them. These variables record the currently
 	// loaded file.
 	var lines [][]byte
@@ -82,21 +127,76 @@ func stack(skip int) []byte {
 		if !ok {
 			break
 		}
-		// Print this much at least.  If we can't find the source, it won't show.
-		fmt.Fprintf(buf, "%s:%d (0x%x)\n", file, line, pc)
+		frame := StackFrame{
+			File:     file,
+			Line:     line,
+			PC:       pc,
+			Function: string(function(pc)),
+			Source:   string(dunno),
+		}
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			frame.fullName = fn.Name()
+		}
 		if file != lastFile {
 			data, err := ioutil.ReadFile(file)
 			if err != nil {
-				continue
+				lines, lastFile = nil, ""
+			} else {
+				lines = bytes.Split(data, []byte{'\n'})
+				lastFile = file
+			}
+		}
+		if lines != nil {
+			frame.Source = string(source(lines, line))
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// formatStack formats frames in the same way as stack does.
+func formatStack(frames []StackFrame) []byte {
+	buf := new(bytes.Buffer)
+	for _, f := range frames {
+		buf.WriteString(f.String())
+	}
+	return buf.Bytes()
+}
+
+// formatCleanStack formats frames without internal ones, and highlights the
+// first application frame at the top. It falls back to formatStack if there
+// is no application frame at all.
+func formatCleanStack(frames []StackFrame, color bool) []byte {
+	buf := new(bytes.Buffer)
+	hidden := 0
+	for _, f := range frames {
+		if f.Internal() {
+			hidden++
+			continue
+		}
+		if buf.Len() == 0 {
+			cause := fmt.Sprintf("-> %s:%d", f.File, f.Line)
+			if color {
+				cause = fmt.Sprintf("\033[1;31m%s\033[0m", cause)
 			}
-			lines = bytes.Split(data, []byte{'\n'})
-			lastFile = file
+			buf.WriteString(cause + "\n")
 		}
-		fmt.Fprintf(buf, "\t%s: %s\n", function(pc), source(lines, line))
+		buf.WriteString(f.String())
+	}
+	if buf.Len() == 0 {
+		return formatStack(frames)
+	}
+	if hidden > 0 {
+		fmt.Fprintf(buf, "(%d framework frames hidden)\n", hidden)
 	}
 	return buf.Bytes()
 }
 
+// stack returns a nicely formated stack frame, skipping skip frames
+func stack(skip int) []byte {
+	return formatStack(callers(skip + 1))
+}
+
 // source returns a space-trimmed slice of the n'th line.
 func source(lines [][]byte, n int) []byte {
 	n-- // in stack trace, lines are 1-indexed but our array is 0-indexed
@@ -131,24 +231,231 @@ func function(pc uintptr) []byte {
 	return name
 }
 
+// ErrorTemplate is the name of template used to render error page in production mode
+// by Recovery and the default internal server error handler, e.g. "errors/500" renders
+// "templates/errors/500.tmpl" when macaron.Renderer is used.
+// A plain text page is written if the template is not available.
+var ErrorTemplate = "errors/500"
+
+// preferJSON returns true if the client prefers JSON over HTML according to the Accept header.
+func preferJSON(req *http.Request) bool {
+	var htmlQ, jsonQ float64
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		typ, q := parseAcceptType(accept)
+		switch {
+		case typ == _CONTENT_HTML, typ == _CONTENT_XHTML:
+			if q > htmlQ {
+				htmlQ = q
+			}
+		case typ == _CONTENT_JSON, strings.HasSuffix(typ, "+json"):
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// parseAcceptType parses a media range of Accept header into type and quality value.
+func parseAcceptType(accept string) (string, float64) {
+	parts := strings.Split(accept, ";")
+	q := 1.0
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "q=") {
+			v, err := strconv.ParseFloat(p[2:], 64)
+			if err == nil {
+				q = v
+			}
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), q
+}
+
+// writeErrorPage writes an error page with given status that does not reveal any internal
+// information. It is rendered as JSON or by template named tplName depends on the Accept
+// header of the request, and falls back to the status text if the template is not available.
+func writeErrorPage(c *Context, rw http.ResponseWriter, status int, tplName string) {
+	if preferJSON(c.Req.Request) {
+		if c.Router != nil && c.m != nil && c.m.problemDetails {
+			writeProblem(rw, &Problem{Status: status, Title: http.StatusText(status)})
+			return
+		}
+		rw.Header().Set(_CONTENT_TYPE, _CONTENT_JSON+"; charset="+_DEFAULT_CHARSET)
+		rw.WriteHeader(status)
+		fmt.Fprintf(rw, `{"status":%d,"error":%q}`, status, http.StatusText(status))
+		return
+	}
+
+	if _, ok := c.Render.(*DummyRender); !ok && c.Render != nil && len(tplName) > 0 {
+		c.Data["Status"] = status
+		if body, err := c.Render.HTMLBytes(tplName, c.Data); err == nil {
+			rw.Header().Set(_CONTENT_TYPE, _CONTENT_HTML+"; charset="+_DEFAULT_CHARSET)
+			rw.WriteHeader(status)
+			rw.Write(body)
+			return
+		}
+	}
+
+	http.Error(rw, http.StatusText(status), status)
+}
+
+// PanicInfo represents information of a recovered panic and the request causes it.
+type PanicInfo struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the formatted stack trace of the panic.
+	Stack []byte
+	// Frames is the parsed stack trace of the panic.
+	Frames []StackFrame
+	// Time is when the panic is recovered.
+	Time time.Time
+
+	// Snapshot of the request.
+	RequestID  string
+	Method     string
+	URL        string
+	RemoteAddr string
+	Header     http.Header
+	Params     Params
+}
+
+func newPanicInfo(c *Context, err interface{}, frames []StackFrame) PanicInfo {
+	params := c.params.toMap()
+	return PanicInfo{
+		Value:      err,
+		Stack:      formatStack(frames),
+		Frames:     frames,
+		Time:       time.Now(),
+		RequestID:  c.RequestID(),
+		Method:     c.Req.Method,
+		URL:        c.Req.URL.String(),
+		RemoteAddr: c.RemoteAddr(),
+		Header:     c.Req.Header,
+		Params:     params,
+	}
+}
+
+// RecoveryOptions is a struct for specifying configuration options for the macaron.Recovery middleware.
+type RecoveryOptions struct {
+	// OnPanic is called with information of every recovered panic, e.g. to report it
+	// to an error tracking service. Panics inside of the hook are logged and discarded.
+	OnPanic func(*Context, PanicInfo)
+	// Handler is invoked to write the response of the panic instead of the default one.
+	// PanicInfo is mapped as a service, so it can be used together with other services,
+	// e.g. func(ctx *macaron.Context, info macaron.PanicInfo).
+	// Default response is written if the handler does not write anything.
+	Handler Handler
+	// ErrorTemplate is the name of template to render in production mode.
+	// Default is value of ErrorTemplate.
+	ErrorTemplate string
+	// FullStack disables hiding frames of Go runtime, reflection and Macaron itself
+	// from the printed stack trace.
+	FullStack bool
+}
+
+func prepareRecoveryOptions(options []RecoveryOptions) RecoveryOptions {
+	var opt RecoveryOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	if opt.Handler != nil {
+		validateHandler(opt.Handler)
+	}
+	if len(opt.ErrorTemplate) == 0 {
+		opt.ErrorTemplate = ErrorTemplate
+	}
+	return opt
+}
+
+// callPanicHook calls OnPanic hook and makes sure a panic inside it does not escape.
+func callPanicHook(opt RecoveryOptions, c *Context, log *log.Logger, info PanicInfo) {
+	if opt.OnPanic == nil {
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("%sPANIC in OnPanic hook: %s", requestTag(c), err)
+		}
+	}()
+	opt.OnPanic(c, info)
+}
+
+// logPanic logs the panic with stack trace and reports it to the OnPanic hook.
+func logPanic(opt RecoveryOptions, c *Context, log *log.Logger, err interface{}, frames []StackFrame) PanicInfo {
+	info := newPanicInfo(c, err, frames)
+	stack := info.Stack
+	if !opt.FullStack {
+		stack = formatCleanStack(info.Frames, ColorLog)
+	}
+	log.Printf("%sPANIC: %s\n%s", requestTag(c), err, stack)
+	callPanicHook(opt, c, log, info)
+	return info
+}
+
+// callRecoveryHandler invokes custom recovery handler and returns true if it writes the response.
+func callRecoveryHandler(opt RecoveryOptions, c *Context, log *log.Logger, info PanicInfo) (written bool) {
+	if opt.Handler == nil {
+		return false
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("%sPANIC in recovery handler: %s", requestTag(c), err)
+			written = c.Written()
+		}
+	}()
+
+	c.Map(info)
+	vals, err := c.invoke(opt.Handler)
+	if err != nil {
+		panic(err)
+	}
+	if len(vals) > 0 {
+		ev := c.GetVal(returnHandlerType)
+		handleReturn := ev.Interface().(ReturnHandler)
+		handleReturn(c, vals)
+	}
+	return c.Written()
+}
+
 // Recovery returns a middleware that recovers from any panics and writes a 500 if there was one.
 // While Martini is in development mode, Recovery will also output the panic as HTML.
-func Recovery() Handler {
+func Recovery(options ...RecoveryOptions) Handler {
+	opt := prepareRecoveryOptions(options)
+
 	return func(c *Context, log *log.Logger) {
+		c.recovery = &opt
+
 		defer func() {
 			if err := recover(); err != nil {
-				stack := stack(3)
-				log.Printf("PANIC: %s\n%s", err, stack)
+				if c.m.errLogger != nil {
+					log = c.m.errLogger
+				}
+				frames := callers(3)
+				if p, ok := err.(*handlerPanic); ok {
+					err, frames = p.value, p.frames
+				}
+				info := logPanic(opt, c, log, err, frames)
+				if callRecoveryHandler(opt, c, log, info) {
+					return
+				}
 
 				// Lookup the current responsewriter
 				val := c.GetVal(inject.InterfaceOf((*http.ResponseWriter)(nil)))
 				res := val.Interface().(http.ResponseWriter)
 
-				// respond with panic message while in development mode
+				// respond with panic message while in development mode,
+				// and a friendly error page in production mode.
 				var body []byte
-				if Env == DEV {
+				switch Env {
+				case DEV:
 					res.Header().Set("Content-Type", "text/html")
-					body = []byte(fmt.Sprintf(panicHtml, err, err, stack))
+					body = renderPanicPage(c, info)
+				case PROD:
+					writeErrorPage(c, res, http.StatusInternalServerError, opt.ErrorTemplate)
+					return
 				}
 
 				res.WriteHeader(http.StatusInternalServerError)
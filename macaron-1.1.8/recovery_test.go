@@ -20,6 +20,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -50,6 +51,202 @@ func Test_Recovery(t *testing.T) {
 		So(buf.String(), ShouldNotBeEmpty)
 	})
 
+	Convey("Recovery with request ID", t, func() {
+		buf := bytes.NewBufferString("")
+		setENV(DEV)
+
+		m := New()
+		m.Map(log.New(buf, "[Macaron] ", 0))
+		m.Use(Recovery())
+		m.Use(func(ctx *Context) {
+			ctx.SetRequestID("c0ffee")
+			panic("here is a panic!")
+		})
+		m.Get("/", func() {})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(strings.HasPrefix(buf.String(), "[Macaron] [c0ffee] PANIC: here is a panic!"), ShouldBeTrue)
+	})
+
+	Convey("Report panic with hook", t, func() {
+		setENV(DEV)
+
+		var info PanicInfo
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Recovery(RecoveryOptions{
+			OnPanic: func(ctx *Context, i PanicInfo) {
+				info = i
+				panic("panic in hook should be discarded")
+			},
+		}))
+		m.Get("/user/:id", func() {
+			panic("here is a panic!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/user/1?a=b", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-Request-Id", "c0ffee")
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(info.Value, ShouldEqual, "here is a panic!")
+		So(len(info.Stack), ShouldBeGreaterThan, 0)
+		So(info.RequestID, ShouldEqual, "c0ffee")
+		So(info.Method, ShouldEqual, "GET")
+		So(info.URL, ShouldEqual, "/user/1?a=b")
+	})
+
+	Convey("Recovery with custom handler", t, func() {
+		setENV(DEV)
+
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Recovery(RecoveryOptions{
+			Handler: func(ctx *Context, info PanicInfo) (int, string) {
+				So(len(info.Frames), ShouldBeGreaterThan, 0)
+				So(info.Frames[0].Source, ShouldEqual, `panic("here is a panic!")`)
+				So(info.Params[":id"], ShouldEqual, "1")
+				ctx.Resp.Header().Set("Content-Type", "application/json")
+				return http.StatusServiceUnavailable, `{"error":"oops"}`
+			},
+		}))
+		m.Get("/user/:id", func() {
+			panic("here is a panic!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/user/1", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(resp.HeaderMap.Get("Content-Type"), ShouldEqual, "application/json")
+		So(resp.Body.String(), ShouldEqual, `{"error":"oops"}`)
+	})
+
+	Convey("Fall back to default response if custom handler writes nothing", t, func() {
+		setENV(DEV)
+
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Recovery(RecoveryOptions{
+			Handler: func() {},
+		}))
+		m.Get("/", func() {
+			panic("here is a panic!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.HeaderMap.Get("Content-Type"), ShouldEqual, "text/html")
+	})
+
+	Convey("Hide framework frames from stack trace", t, func() {
+		buf := bytes.NewBufferString("")
+		setENV(PROD)
+		defer setENV(DEV)
+
+		m := New()
+		m.Map(log.New(buf, "[Macaron] ", 0))
+		m.Use(Recovery())
+		m.Get("/", func() {
+			panic("here is a panic!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(buf.String(), ShouldContainSubstring, "-> ")
+		So(buf.String(), ShouldContainSubstring, "recovery_test.go")
+		So(buf.String(), ShouldContainSubstring, "framework frames hidden")
+		So(buf.String(), ShouldNotContainSubstring, "reflect/value.go")
+		So(buf.String(), ShouldNotContainSubstring, "(*Context).run")
+	})
+
+	Convey("Render error page in production mode", t, func() {
+		setENV(PROD)
+		defer setENV(DEV)
+
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Recovery())
+		m.Get("/", func() {
+			panic("here is a panic!")
+		})
+		m.Get("/render", Renderer(RenderOptions{
+			Directory: "fixtures/error_pages",
+		}), func() {
+			panic("here is a panic!")
+		})
+
+		Convey("Plain text", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(resp.Body.String(), ShouldEqual, "Internal Server Error\n")
+		})
+
+		Convey("Template", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/render", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(resp.Body.String(), ShouldEqual, "<h1>500 Something went wrong</h1>")
+		})
+
+		Convey("JSON", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/render", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Accept", "application/json, text/html;q=0.5")
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(resp.Body.String(), ShouldEqual, `{"status":500,"error":"Internal Server Error"}`)
+		})
+	})
+
+	Convey("Recover panic in goroutine", t, func() {
+		buf := bytes.NewBufferString("")
+		reported := make(chan PanicInfo, 1)
+
+		m := New()
+		m.Map(log.New(buf, "[Macaron] ", 0))
+		m.Use(Recovery(RecoveryOptions{
+			OnPanic: func(ctx *Context, info PanicInfo) {
+				reported <- info
+			},
+		}))
+		m.Get("/", func(ctx *Context) string {
+			ctx.Go(func() {
+				panic("here is a panic in goroutine!")
+			})
+			return "ok"
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+
+		info := <-reported
+		So(info.Value, ShouldEqual, "here is a panic in goroutine!")
+		So(buf.String(), ShouldContainSubstring, "PANIC: here is a panic in goroutine!")
+	})
+
 	Convey("Revocery panic to another response writer", t, func() {
 		resp := httptest.NewRecorder()
 		resp2 := httptest.NewRecorder()
@@ -0,0 +1,516 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisOptions is a struct for specifying configuration options of RedisClient.
+type RedisOptions struct {
+	// Addr is the address of Redis server. Default is "127.0.0.1:6379".
+	Addr     string
+	Password string
+	DB       int
+	// Prefix is prepended to all keys, so multiple applications can share a server, e.g. "myapp:".
+	Prefix string
+	// MaxIdle is the maximum number of idle connections kept in the pool. Default is 10.
+	MaxIdle int
+	// Timeout is the timeout of dialing and each command. Default is 5 seconds.
+	Timeout time.Duration
+}
+
+func prepareRedisOptions(opt RedisOptions) RedisOptions {
+	if len(opt.Addr) == 0 {
+		opt.Addr = "127.0.0.1:6379"
+	}
+	if opt.MaxIdle <= 0 {
+		opt.MaxIdle = 10
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = 5 * time.Second
+	}
+	return opt
+}
+
+// RedisError is an error replied by Redis server.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisClient is a minimal Redis client with a pool of connections, which is used by stores
+// of middlewares to share state between multiple instances, see NewRedisCacheStore and
+// NewRedisQuotaStore and NewRedisSessionStore. It is safe to be used by multiple goroutines.
+type RedisClient struct {
+	opt RedisOptions
+
+	lock   sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// NewRedisClient creates a RedisClient with given options,
+// connections are made when commands are sent.
+func NewRedisClient(opt RedisOptions) *RedisClient {
+	return &RedisClient{opt: prepareRedisOptions(opt)}
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *RedisClient) dial() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", c.opt.Addr, c.opt.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %v", err)
+	}
+	conn := &redisConn{nc, bufio.NewReader(nc), bufio.NewWriter(nc)}
+	if len(c.opt.Password) > 0 {
+		if _, err = c.do(conn, []string{"AUTH", c.opt.Password}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.opt.DB > 0 {
+		if _, err = c.do(conn, []string{"SELECT", strconv.Itoa(c.opt.DB)}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *RedisClient) get() (*redisConn, error) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, fmt.Errorf("redis: client is closed")
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.lock.Unlock()
+		return conn, nil
+	}
+	c.lock.Unlock()
+	return c.dial()
+}
+
+func (c *RedisClient) put(conn *redisConn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed || len(c.idle) >= c.opt.MaxIdle {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// Do sends the command with arguments and returns the reply, which is nil, int64, string
+// or []interface{} of them. Keys in arguments are sent as they are, without Prefix.
+func (c *RedisClient) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends commands at once and returns their replies in order.
+// Errors replied by the server are returned as RedisError values in replies.
+func (c *RedisClient) Pipeline(cmds [][]string) ([]interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(c.opt.Timeout))
+	for _, args := range cmds {
+		writeRedisCommand(conn.w, args)
+	}
+	if err = conn.w.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("redis: %v", err)
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		if replies[i], err = readRedisReply(conn.r); err != nil {
+			// State of the connection is unknown after a broken reply.
+			conn.Close()
+			return nil, fmt.Errorf("redis: %v", err)
+		}
+	}
+	c.put(conn)
+	return replies, nil
+}
+
+// do sends a command on given connection, used before it is pooled.
+func (c *RedisClient) do(conn *redisConn, args []string) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(c.opt.Timeout))
+	writeRedisCommand(conn.w, args)
+	if err := conn.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis: %v", err)
+	}
+	reply, err := readRedisReply(conn.r)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %v", err)
+	} else if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Close closes idle connections, and connections in use once they are done.
+func (c *RedisClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	for _, conn := range c.idle {
+		conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// key returns the key with prefix of the client.
+func (c *RedisClient) key(key string) string {
+	return c.opt.Prefix + key
+}
+
+func writeRedisCommand(w *bufio.Writer, args []string) {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return RedisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		vals := make([]interface{}, n)
+		for i := range vals {
+			if vals[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// escapeRedisPattern escapes special characters of glob-style patterns of Redis.
+func escapeRedisPattern(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+const _REDIS_CACHE_PREFIX = "cache:"
+
+// redisCacheStore is a CacheStore that keeps responses in Redis.
+type redisCacheStore struct {
+	client *RedisClient
+}
+
+// NewRedisCacheStore returns a CacheStore that keeps responses in Redis, so they are shared by
+// all instances using the same server and prefix. Responses expire by TTL of Redis. Errors of
+// Redis are treated as cache misses, so requests are still served while it is unavailable.
+func NewRedisCacheStore(client *RedisClient) CacheStore {
+	return &redisCacheStore{client}
+}
+
+func (s *redisCacheStore) Get(key string) (*CachedResponse, bool) {
+	reply, err := s.client.Do("GET", s.client.key(_REDIS_CACHE_PREFIX+key))
+	data, ok := reply.(string)
+	if err != nil || !ok {
+		return nil, false
+	}
+	resp := new(CachedResponse)
+	if err = json.Unmarshal([]byte(data), resp); err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+func (s *redisCacheStore) Set(key string, resp *CachedResponse, ttl time.Duration) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	ms := int64(ttl / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	s.client.Do("SET", s.client.key(_REDIS_CACHE_PREFIX+key), string(data), "PX", strconv.FormatInt(ms, 10))
+}
+
+func (s *redisCacheStore) Delete(key string) {
+	s.client.Do("DEL", s.client.key(_REDIS_CACHE_PREFIX+key))
+}
+
+func (s *redisCacheStore) Keys() []string {
+	prefix := s.client.key(_REDIS_CACHE_PREFIX)
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.client.Do("SCAN", cursor, "MATCH", escapeRedisPattern(prefix)+"*", "COUNT", "100")
+		vals, ok := reply.([]interface{})
+		if err != nil || !ok || len(vals) != 2 {
+			return keys
+		}
+		batch, _ := vals[1].([]interface{})
+		for _, v := range batch {
+			if key, ok := v.(string); ok {
+				keys = append(keys, strings.TrimPrefix(key, prefix))
+			}
+		}
+		if cursor, _ = vals[0].(string); cursor == "0" || len(cursor) == 0 {
+			return keys
+		}
+	}
+}
+
+// redisQuotaStore is a QuotaStore that keeps counters in Redis.
+type redisQuotaStore struct {
+	client *RedisClient
+}
+
+// NewRedisQuotaStore returns a QuotaStore that keeps counters in Redis, so budgets are shared by
+// all instances using the same server and prefix.
+func NewRedisQuotaStore(client *RedisClient) QuotaStore {
+	return &redisQuotaStore{client}
+}
+
+func (s *redisQuotaStore) Incr(key string, expires time.Time) (int64, error) {
+	key = s.client.key(key)
+	// Counters of a key always expire at the same time, so setting it every time is fine,
+	// and the transaction makes sure counters never stay without expiration.
+	replies, err := s.client.Pipeline([][]string{
+		{"MULTI"},
+		{"INCR", key},
+		{"PEXPIREAT", key, strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10)},
+		{"EXEC"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return 0, err
+		}
+	}
+	results, ok := replies[3].([]interface{})
+	if !ok || len(results) != 2 {
+		return 0, fmt.Errorf("redis: transaction of %s is aborted", key)
+	}
+	if err, ok := results[0].(error); ok {
+		return 0, err
+	}
+	count, ok := results[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply of INCR: %v", results[0])
+	}
+	return count, nil
+}
+
+// RedisSessionOptions is a struct for specifying configuration options of RedisSessionStore.
+type RedisSessionOptions struct {
+	// Cookie is the name of cookie that keeps the session ID. Default is "macaron_session".
+	Cookie string
+	// CookiePath is the path of the cookie. Default is "/".
+	CookiePath string
+	// Secure forces the cookie to be sent over HTTPS only,
+	// it is always the case for requests over TLS.
+	Secure bool
+	// TTL is how long sessions are kept after they are last written. Default is 24 hours.
+	TTL time.Duration
+}
+
+func prepareRedisSessionOptions(options []RedisSessionOptions) RedisSessionOptions {
+	var opt RedisSessionOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Cookie) == 0 {
+		opt.Cookie = "macaron_session"
+	}
+	if len(opt.CookiePath) == 0 {
+		opt.CookiePath = "/"
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = 24 * time.Hour
+	}
+	return opt
+}
+
+// RedisSessionStore keeps values of client sessions in Redis, identified by a random ID in a cookie,
+// so sessions are shared by all instances using the same server and prefix. It implements CSRFStore
+// to keep CSRF tokens in sessions, e.g.
+//
+//	sessions := macaron.NewRedisSessionStore(client)
+//	m.Use(macaron.CSRF(macaron.CSRFOptions{Store: sessions}))
+type RedisSessionStore struct {
+	client *RedisClient
+	opt    RedisSessionOptions
+}
+
+// NewRedisSessionStore returns a RedisSessionStore with given options.
+func NewRedisSessionStore(client *RedisClient, options ...RedisSessionOptions) *RedisSessionStore {
+	return &RedisSessionStore{client, prepareRedisSessionOptions(options)}
+}
+
+// redisSessionID is the type of session ID mapped to the request once it is known.
+type redisSessionID string
+
+var redisSessionIDType = reflect.TypeOf(redisSessionID(""))
+
+// isSessionID returns true if the value can be a session ID generated by the store.
+func isSessionID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionID returns the session ID of the request, a new one is created if create is true
+// and the request has none.
+func (s *RedisSessionStore) sessionID(ctx *Context, create bool) string {
+	if val := ctx.GetVal(redisSessionIDType); val.IsValid() {
+		return string(val.Interface().(redisSessionID))
+	}
+	id := ctx.GetCookie(s.opt.Cookie)
+	if !isSessionID(id) {
+		if !create {
+			return ""
+		}
+		id = randomHex(32)
+		http.SetCookie(ctx.Resp, &http.Cookie{
+			Name:     s.opt.Cookie,
+			Value:    id,
+			Path:     s.opt.CookiePath,
+			Secure:   s.opt.Secure || ctx.Req.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	ctx.Map(redisSessionID(id))
+	return id
+}
+
+func (s *RedisSessionStore) key(id string) string {
+	return s.client.key("session:" + id)
+}
+
+// Value returns the value of given name in the session of the client, or empty string
+// if there is none or Redis fails.
+func (s *RedisSessionStore) Value(ctx *Context, name string) string {
+	id := s.sessionID(ctx, false)
+	if len(id) == 0 {
+		return ""
+	}
+	reply, _ := s.client.Do("HGET", s.key(id), name)
+	value, _ := reply.(string)
+	return value
+}
+
+// SetValue saves the value of given name in the session of the client, a session is started
+// if the client has none. The session expires after TTL since it is last written.
+func (s *RedisSessionStore) SetValue(ctx *Context, name, value string) error {
+	key := s.key(s.sessionID(ctx, true))
+	replies, err := s.client.Pipeline([][]string{
+		{"HSET", key, name, value},
+		{"PEXPIRE", key, strconv.FormatInt(int64(s.opt.TTL/time.Millisecond), 10)},
+	})
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements CSRFStore.
+func (s *RedisSessionStore) Get(ctx *Context) string {
+	return s.Value(ctx, "_csrf")
+}
+
+// Set implements CSRFStore.
+func (s *RedisSessionStore) Set(ctx *Context, token string) {
+	s.SetValue(ctx, "_csrf", token)
+}
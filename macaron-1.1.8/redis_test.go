@@ -0,0 +1,337 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRedis serves a few commands of Redis used by stores.
+type fakeRedis struct {
+	ln       net.Listener
+	lock     sync.Mutex
+	data     map[string]string
+	expires  map[string]time.Time
+	password string
+	conns    int
+}
+
+func newFakeRedis(password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	s := &fakeRedis{ln: ln, data: make(map[string]string), expires: make(map[string]time.Time), password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns++
+			s.lock.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	authed := len(s.password) == 0
+	var queued [][]string
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		vals := reply.([]interface{})
+		args := make([]string, len(vals))
+		for i, v := range vals {
+			args[i] = v.(string)
+		}
+
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			if authed = args[1] == s.password; authed {
+				w.WriteString("+OK\r\n")
+			} else {
+				w.WriteString("-WRONGPASS invalid password\r\n")
+			}
+		case !authed:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		case cmd == "MULTI":
+			queued = [][]string{}
+			w.WriteString("+OK\r\n")
+		case cmd == "EXEC":
+			w.WriteString("*" + strconv.Itoa(len(queued)) + "\r\n")
+			for _, args := range queued {
+				s.exec(w, args)
+			}
+			queued = nil
+		case queued != nil:
+			queued = append(queued, args)
+			w.WriteString("+QUEUED\r\n")
+		default:
+			s.exec(w, args)
+		}
+		w.Flush()
+	}
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (s *fakeRedis) exec(w *bufio.Writer, args []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, t := range s.expires {
+		if time.Now().After(t) {
+			delete(s.data, k)
+			delete(s.expires, k)
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "SELECT":
+		w.WriteString("+OK\r\n")
+	case "GET":
+		if v, ok := s.data[args[1]]; ok {
+			writeBulk(w, v)
+		} else {
+			w.WriteString("$-1\r\n")
+		}
+	case "SET":
+		s.data[args[1]] = args[2]
+		if len(args) == 5 && args[3] == "PX" {
+			ms, _ := strconv.ParseInt(args[4], 10, 64)
+			s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		delete(s.data, args[1])
+		w.WriteString(":1\r\n")
+	case "INCR":
+		n, _ := strconv.ParseInt(s.data[args[1]], 10, 64)
+		s.data[args[1]] = strconv.FormatInt(n+1, 10)
+		w.WriteString(":" + s.data[args[1]] + "\r\n")
+	case "HGET":
+		if v, ok := s.data[args[1]+"#"+args[2]]; ok {
+			writeBulk(w, v)
+		} else {
+			w.WriteString("$-1\r\n")
+		}
+	case "HSET":
+		s.data[args[1]+"#"+args[2]] = args[3]
+		w.WriteString(":1\r\n")
+	case "PEXPIRE":
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		w.WriteString(":1\r\n")
+	case "PEXPIREAT":
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		s.expires[args[1]] = time.Unix(0, ms*int64(time.Millisecond))
+		w.WriteString(":1\r\n")
+	case "SCAN":
+		// Only patterns of escaped prefixes are supported. Keys are returned in two
+		// batches to test the cursor.
+		prefix := strings.Replace(strings.TrimSuffix(args[3], "*"), `\`, "", -1)
+		var keys []string
+		for k := range s.data {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		half := len(keys) / 2
+		if args[1] == "0" {
+			keys = keys[:half]
+			w.WriteString("*2\r\n")
+			writeBulk(w, "1")
+		} else {
+			keys = keys[half:]
+			w.WriteString("*2\r\n")
+			writeBulk(w, "0")
+		}
+		w.WriteString("*" + strconv.Itoa(len(keys)) + "\r\n")
+		for _, k := range keys {
+			writeBulk(w, k)
+		}
+	default:
+		w.WriteString("-ERR unknown command '" + args[0] + "'\r\n")
+	}
+}
+
+func Test_RedisClient(t *testing.T) {
+	Convey("Send commands with a pool of connections", t, func() {
+		srv := newFakeRedis("secret")
+		defer srv.ln.Close()
+
+		client := NewRedisClient(RedisOptions{Addr: srv.ln.Addr().String(), Password: "secret", DB: 1})
+		defer client.Close()
+		reply, err := client.Do("SET", "a", "1")
+		So(err, ShouldBeNil)
+		So(reply, ShouldEqual, "OK")
+		reply, err = client.Do("GET", "a")
+		So(err, ShouldBeNil)
+		So(reply, ShouldEqual, "1")
+		reply, err = client.Do("GET", "missing")
+		So(err, ShouldBeNil)
+		So(reply, ShouldBeNil)
+		_, err = client.Do("UNKNOWN")
+		So(err, ShouldHaveSameTypeAs, RedisError(""))
+
+		// Connections are reused.
+		srv.lock.Lock()
+		So(srv.conns, ShouldEqual, 1)
+		srv.lock.Unlock()
+
+		_, err = NewRedisClient(RedisOptions{Addr: srv.ln.Addr().String(), Password: "wrong"}).Do("GET", "a")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "WRONGPASS")
+
+		client.Close()
+		_, err = client.Do("GET", "a")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Escape patterns of keys", t, func() {
+		So(escapeRedisPattern("app[1]:*?"), ShouldEqual, `app\[1\]:\*\?`)
+	})
+}
+
+func Test_RedisStores(t *testing.T) {
+	Convey("Cache responses in Redis", t, func() {
+		srv := newFakeRedis("")
+		defer srv.ln.Close()
+		client := NewRedisClient(RedisOptions{Addr: srv.ln.Addr().String(), Prefix: "app:"})
+		defer client.Close()
+
+		store := NewRedisCacheStore(client)
+		resp := &CachedResponse{Status: 200, Header: http.Header{"X-A": {"b"}}, Body: []byte("hello")}
+		store.Set("/posts/1", resp, time.Minute)
+		store.Set("/posts/2", resp, time.Minute)
+		store.Set("/expired", resp, time.Millisecond)
+		srv.lock.Lock()
+		_, ok := srv.data["app:cache:/posts/1"]
+		srv.lock.Unlock()
+		So(ok, ShouldBeTrue)
+
+		cached, ok := store.Get("/posts/1")
+		So(ok, ShouldBeTrue)
+		So(cached.Header.Get("X-A"), ShouldEqual, "b")
+		So(string(cached.Body), ShouldEqual, "hello")
+
+		time.Sleep(5 * time.Millisecond)
+		_, ok = store.Get("/expired")
+		So(ok, ShouldBeFalse)
+		So(store.Keys(), ShouldHaveLength, 2)
+
+		store.Delete("/posts/1")
+		_, ok = store.Get("/posts/1")
+		So(ok, ShouldBeFalse)
+
+		Convey("Purge responses of ResponseCache", func() {
+			cache := NewResponseCache(ResponseCacheOptions{Store: store})
+			So(cache.Purge("/posts/*"), ShouldEqual, 1)
+			So(store.Keys(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Count quotas in Redis", t, func() {
+		srv := newFakeRedis("")
+		defer srv.ln.Close()
+		client := NewRedisClient(RedisOptions{Addr: srv.ln.Addr().String(), Prefix: "app:"})
+		defer client.Close()
+
+		store := NewRedisQuotaStore(client)
+		expires := time.Now().Add(time.Hour)
+		for i := int64(1); i <= 3; i++ {
+			count, err := store.Incr("quota:api:joe:1", expires)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, i)
+		}
+		srv.lock.Lock()
+		So(srv.expires["app:quota:api:joe:1"].Unix(), ShouldEqual, expires.Unix())
+		srv.lock.Unlock()
+
+		srv.ln.Close()
+		client.Close()
+		_, err := NewRedisQuotaStore(NewRedisClient(RedisOptions{Addr: srv.ln.Addr().String()})).Incr("quota", expires)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Keep CSRF tokens in Redis sessions", t, func() {
+		srv := newFakeRedis("")
+		defer srv.ln.Close()
+		client := NewRedisClient(RedisOptions{Addr: srv.ln.Addr().String(), Prefix: "app:"})
+		defer client.Close()
+
+		sessions := NewRedisSessionStore(client)
+		m := New()
+		m.Use(CSRF(CSRFOptions{Store: sessions}))
+		m.Get("/", func(ctx *Context, token *CSRFToken) string {
+			return token.Value
+		})
+		m.Post("/", func() string { return "ok" })
+
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+		token := resp.Body.String()
+		So(token, ShouldNotBeEmpty)
+		cookies := resp.Result().Cookies()
+		So(cookies, ShouldHaveLength, 1)
+		So(cookies[0].Name, ShouldEqual, "macaron_session")
+		So(cookies[0].HttpOnly, ShouldBeTrue)
+		srv.lock.Lock()
+		So(srv.data["app:session:"+cookies[0].Value+"#_csrf"], ShouldEqual, token)
+		So(srv.expires, ShouldContainKey, "app:session:"+cookies[0].Value)
+		srv.lock.Unlock()
+
+		// The token is kept by the session.
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookies[0])
+		resp = httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, token)
+		So(resp.Result().Cookies(), ShouldBeEmpty)
+
+		req = httptest.NewRequest("POST", "/", nil)
+		req.AddCookie(cookies[0])
+		req.Header.Set("X-CSRF-Token", token)
+		resp = httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "ok")
+
+		// Forged session IDs are not used.
+		req = httptest.NewRequest("POST", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "macaron_session", Value: "../x"})
+		req.Header.Set("X-CSRF-Token", token)
+		resp = httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusForbidden)
+	})
+}
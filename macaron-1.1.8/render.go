@@ -381,7 +381,7 @@ func renderHandler(opt RenderOptions, tplSets []string) Handler {
 		ts.Set(tplName, &tmpOpt)
 	}
 
-	return func(ctx *Context) {
+	return Provides(func(ctx *Context) {
 		r := &TplRender{
 			ResponseWriter:  ctx.Resp,
 			TemplateSet:     ts,
@@ -397,7 +397,7 @@ func renderHandler(opt RenderOptions, tplSets []string) Handler {
 
 		ctx.Render = r
 		ctx.MapTo(r, (*Render)(nil))
-	}
+	}, (*Render)(nil))
 }
 
 // Renderer is a Middleware that maps a macaron.Render service into the Macaron handler chain.
@@ -16,9 +16,11 @@ package macaron
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"io"
 	"net"
 	"net/http"
+	"time"
 )
 
 // ResponseWriter is a wrapper around http.ResponseWriter that provides extra information about
@@ -36,27 +38,68 @@ type ResponseWriter interface {
 	// Before allows for a function to be called before the ResponseWriter has been written to. This is
 	// useful for setting headers or any other operations that must happen before a response has been written.
 	Before(BeforeFunc)
+	// After allows for a function to be called right after the response header has been written.
+	// This is useful for recording the status of the response without wrapping the ResponseWriter.
+	After(AfterFunc)
+	// Metrics returns timing and size information of the response written so far.
+	Metrics() ResponseMetrics
+}
+
+// ResponseMetrics contains timing and size information of a response.
+type ResponseMetrics struct {
+	// TimeToFirstByte is the duration from creating the ResponseWriter until the response
+	// header has been written to the client, 0 if it has not been written.
+	TimeToFirstByte time.Duration
+	// WriteDuration is the duration from writing the response header until the last write
+	// of the body, useful for measuring streaming responses.
+	WriteDuration time.Duration
+	// BytesWritten is the number of body bytes written to the client.
+	BytesWritten int64
 }
 
 // BeforeFunc is a function that is called before the ResponseWriter has been written to.
 type BeforeFunc func(ResponseWriter)
 
-// NewResponseWriter creates a ResponseWriter that wraps an http.ResponseWriter
+// AfterFunc is a function that is called after the response header has been written.
+type AfterFunc func(ResponseWriter)
+
+// NewResponseWriter creates a ResponseWriter that wraps an http.ResponseWriter. The returned
+// ResponseWriter implements http.Hijacker, http.Pusher and io.ReaderFrom only if the wrapped
+// one does, so checks of optional interfaces by type assertions work as they would without it.
+// http.Flusher is always implemented, Flush does nothing if the wrapped one does not support it.
 func NewResponseWriter(rw http.ResponseWriter) ResponseWriter {
-	return &responseWriter{rw, 0, 0, nil}
+	return wrapResponseWriter(&responseWriter{ResponseWriter: rw, start: time.Now()})
 }
 
 type responseWriter struct {
 	http.ResponseWriter
+	// conn is the writer of the connection to be hijacked or pushed, the underlying one if nil.
+	// It is set when the underlying one transforms the body, e.g. by compression.
+	conn        http.ResponseWriter
 	status      int
 	size        int
 	beforeFuncs []BeforeFunc
+	afterFuncs  []AfterFunc
+	// Body is held in buffer instead of being written if it is not nil.
+	buffer *bytes.Buffer
+
+	start     time.Time
+	firstByte time.Time
+	lastWrite time.Time
+	written   int64
 }
 
 func (rw *responseWriter) WriteHeader(s int) {
+	if rw.buffer != nil {
+		// Header is written when the buffer is flushed, status can still be changed until then.
+		rw.status = s
+		return
+	}
 	rw.callBefore()
 	rw.ResponseWriter.WriteHeader(s)
 	rw.status = s
+	rw.firstByte = time.Now()
+	rw.callAfter()
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
@@ -64,11 +107,66 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		rw.WriteHeader(http.StatusOK)
 	}
+	if rw.buffer != nil {
+		size, err := rw.buffer.Write(b)
+		rw.size += size
+		return size, err
+	}
 	size, err := rw.ResponseWriter.Write(b)
 	rw.size += size
+	rw.wrote(int64(size))
 	return size, err
 }
 
+func (rw *responseWriter) wrote(n int64) {
+	rw.written += n
+	rw.lastWrite = time.Now()
+}
+
+// startBuffer makes the writer hold status and body until flushBuffer is called.
+func (rw *responseWriter) startBuffer() {
+	rw.buffer = new(bytes.Buffer)
+}
+
+// discardBuffer discards status and body held so far and stops buffering.
+func (rw *responseWriter) discardBuffer() {
+	rw.buffer = nil
+	rw.status = 0
+	rw.size = 0
+}
+
+// flushBuffer writes status and body held so far to the underlying writer and stops buffering.
+func (rw *responseWriter) flushBuffer() error {
+	buf := rw.buffer
+	rw.buffer = nil
+	if rw.status == 0 {
+		return nil
+	}
+
+	rw.size = buf.Len()
+	rw.WriteHeader(rw.status)
+	n, err := buf.WriteTo(rw.ResponseWriter)
+	rw.wrote(n)
+	return err
+}
+
+// readFrom implements io.ReaderFrom so that zero-copy fast paths (e.g. sendfile) of the
+// underlying ResponseWriter are kept when serving files through http.ServeContent.
+func (rw *responseWriter) readFrom(r io.Reader) (n int64, err error) {
+	if !rw.Written() {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.buffer != nil {
+		n, err = rw.buffer.ReadFrom(r)
+		rw.size += int(n)
+		return n, err
+	}
+	n, err = rw.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	rw.size += int(n)
+	rw.wrote(n)
+	return n, err
+}
+
 func (rw *responseWriter) Status() int {
 	return rw.status
 }
@@ -81,20 +179,58 @@ func (rw *responseWriter) Written() bool {
 	return rw.status != 0
 }
 
+// headerSent returns true if the response header has been sent to the underlying writer.
+func (rw *responseWriter) headerSent() bool {
+	return !rw.firstByte.IsZero()
+}
+
+func (rw *responseWriter) Metrics() ResponseMetrics {
+	m := ResponseMetrics{BytesWritten: rw.written}
+	if !rw.firstByte.IsZero() {
+		m.TimeToFirstByte = rw.firstByte.Sub(rw.start)
+		if rw.lastWrite.After(rw.firstByte) {
+			m.WriteDuration = rw.lastWrite.Sub(rw.firstByte)
+		}
+	}
+	return m
+}
+
 func (rw *responseWriter) Before(before BeforeFunc) {
 	rw.beforeFuncs = append(rw.beforeFuncs, before)
 }
 
-func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
-	if !ok {
-		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+func (rw *responseWriter) After(after AfterFunc) {
+	rw.afterFuncs = append(rw.afterFuncs, after)
+}
+
+func (rw *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := rw.connWriter().(http.Hijacker).Hijack()
+	// Connection is taken over, e.g. by WebSocket, so nothing else should be written.
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
 	}
-	return hijacker.Hijack()
+	return conn, brw, err
+}
+
+func (rw *responseWriter) push(target string, opts *http.PushOptions) error {
+	return rw.connWriter().(http.Pusher).Push(target, opts)
 }
 
+func (rw *responseWriter) connWriter() http.ResponseWriter {
+	if rw.conn != nil {
+		return rw.conn
+	}
+	return rw.ResponseWriter
+}
+
+// CloseNotify implements http.CloseNotifier. The channel never receives if the underlying
+// ResponseWriter does not support it.
 func (rw *responseWriter) CloseNotify() <-chan bool {
-	return rw.ResponseWriter.(http.CloseNotifier).CloseNotify()
+	notifier, ok := rw.ResponseWriter.(http.CloseNotifier)
+	if !ok {
+		return nil
+	}
+	return notifier.CloseNotify()
 }
 
 func (rw *responseWriter) callBefore() {
@@ -103,9 +239,116 @@ func (rw *responseWriter) callBefore() {
 	}
 }
 
+func (rw *responseWriter) callAfter() {
+	for _, after := range rw.afterFuncs {
+		after(rw)
+	}
+}
+
 func (rw *responseWriter) Flush() {
+	if rw.buffer != nil {
+		return
+	}
 	flusher, ok := rw.ResponseWriter.(http.Flusher)
 	if ok {
 		flusher.Flush()
 	}
 }
+
+// baseResponseWriter returns the responseWriter of rw if it is created by this package.
+func baseResponseWriter(rw http.ResponseWriter) (*responseWriter, bool) {
+	if w, ok := rw.(interface{ base() *responseWriter }); ok {
+		return w.base(), true
+	}
+	return nil, false
+}
+
+func (rw *responseWriter) base() *responseWriter {
+	return rw
+}
+
+// Wrappers of responseWriter with each combination of optional interfaces. They only hold
+// the pointer, so wrapping does not allocate.
+type (
+	hijackWriter             struct{ *responseWriter }
+	pushWriter               struct{ *responseWriter }
+	readFromWriter           struct{ *responseWriter }
+	hijackPushWriter         struct{ *responseWriter }
+	hijackReadFromWriter     struct{ *responseWriter }
+	pushReadFromWriter       struct{ *responseWriter }
+	hijackPushReadFromWriter struct{ *responseWriter }
+)
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w hijackPushWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w hijackReadFromWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w hijackPushReadFromWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w pushWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+func (w hijackPushWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+func (w pushReadFromWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+func (w hijackPushReadFromWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+func (w readFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
+
+func (w hijackReadFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
+
+func (w pushReadFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
+
+func (w hijackPushReadFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
+
+// wrapResponseWriter returns rw wrapped to implement exactly the optional interfaces
+// of http.Hijacker, http.Pusher and io.ReaderFrom that its underlying writer implements,
+// or the writer of its connection for http.Hijacker and http.Pusher if it is set.
+func wrapResponseWriter(rw *responseWriter) ResponseWriter {
+	_, hijacker := rw.connWriter().(http.Hijacker)
+	_, pusher := rw.connWriter().(http.Pusher)
+	_, readerFrom := rw.ResponseWriter.(io.ReaderFrom)
+	switch {
+	case hijacker && pusher && readerFrom:
+		return hijackPushReadFromWriter{rw}
+	case hijacker && pusher:
+		return hijackPushWriter{rw}
+	case hijacker && readerFrom:
+		return hijackReadFromWriter{rw}
+	case pusher && readerFrom:
+		return pushReadFromWriter{rw}
+	case hijacker:
+		return hijackWriter{rw}
+	case pusher:
+		return pushWriter{rw}
+	case readerFrom:
+		return readFromWriter{rw}
+	}
+	return rw
+}
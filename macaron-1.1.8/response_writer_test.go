@@ -21,9 +21,12 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Unknwon/com"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -64,6 +67,30 @@ func (h *hijackableResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, nil
 }
 
+type pushableRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func newPushableRecorder() *pushableRecorder {
+	return &pushableRecorder{ResponseRecorder: httptest.NewRecorder()}
+}
+
+func (p *pushableRecorder) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	return nil
+}
+
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
 func Test_ResponseWriter(t *testing.T) {
 	Convey("Write string to response writer", t, func() {
 		resp := httptest.NewRecorder()
@@ -120,6 +147,120 @@ func Test_ResponseWriter(t *testing.T) {
 		So(result, ShouldEqual, "barfoo")
 	})
 
+	Convey("Call hooks after response header is written", t, func() {
+		result := ""
+		resp := httptest.NewRecorder()
+		rw := NewResponseWriter(resp)
+		rw.Before(func(rw ResponseWriter) {
+			rw.Header().Set("X-Before", "true")
+		})
+		rw.After(func(rw ResponseWriter) {
+			result += "foo" + com.ToStr(rw.Status())
+		})
+		rw.After(func(ResponseWriter) {
+			result += "bar"
+		})
+		rw.Write([]byte("Hello world"))
+
+		So(resp.Header().Get("X-Before"), ShouldEqual, "true")
+		So(result, ShouldEqual, "foo200bar")
+	})
+
+	Convey("Set headers in middleware before response is written", t, func() {
+		m := New()
+		m.Use(func(ctx *Context) {
+			ctx.Resp.Before(func(rw ResponseWriter) {
+				rw.Header().Set("X-Frame-Options", "DENY")
+			})
+		})
+		m.Get("/", func() string { return "Hello world" })
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Header().Get("X-Frame-Options"), ShouldEqual, "DENY")
+		So(resp.Body.String(), ShouldEqual, "Hello world")
+	})
+
+	Convey("Response writer with ReadFrom", t, func() {
+		resp := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		rw := NewResponseWriter(resp)
+		n, err := rw.(io.ReaderFrom).ReadFrom(strings.NewReader("Hello world"))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 11)
+
+		So(resp.readFrom, ShouldBeTrue)
+		So(resp.Body.String(), ShouldEqual, "Hello world")
+		So(rw.Status(), ShouldEqual, http.StatusOK)
+		So(rw.Size(), ShouldEqual, 11)
+	})
+
+	Convey("Response writer without ReadFrom", t, func() {
+		resp := httptest.NewRecorder()
+		rw := NewResponseWriter(resp)
+		_, ok := rw.(io.ReaderFrom)
+		So(ok, ShouldBeFalse)
+		rw.WriteHeader(http.StatusCreated)
+		n, err := io.Copy(rw, strings.NewReader("Hello world"))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 11)
+
+		So(resp.Code, ShouldEqual, http.StatusCreated)
+		So(resp.Body.String(), ShouldEqual, "Hello world")
+		So(rw.Size(), ShouldEqual, 11)
+	})
+
+	Convey("Serve file through ReadFrom", t, func() {
+		m := Classic()
+		m.Get("/file", func(ctx *Context) {
+			ctx.ServeFile("fixtures/custom_funcs/index.tmpl")
+		})
+
+		resp := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		req, err := http.NewRequest("GET", "/file", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.readFrom, ShouldBeTrue)
+		So(resp.Body.String(), ShouldEqual, "{{ myCustomFunc }}")
+	})
+
+	Convey("Response writer metrics", t, func() {
+		resp := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		rw := NewResponseWriter(resp)
+		So(rw.Metrics(), ShouldResemble, ResponseMetrics{})
+
+		time.Sleep(5 * time.Millisecond)
+		rw.Write([]byte("Hello"))
+		time.Sleep(5 * time.Millisecond)
+		rw.(io.ReaderFrom).ReadFrom(strings.NewReader(" world"))
+
+		metrics := rw.Metrics()
+		So(metrics.TimeToFirstByte, ShouldBeGreaterThanOrEqualTo, 5*time.Millisecond)
+		So(metrics.WriteDuration, ShouldBeGreaterThanOrEqualTo, 5*time.Millisecond)
+		So(metrics.BytesWritten, ShouldEqual, 11)
+	})
+
+	Convey("Response writer metrics with buffered response", t, func() {
+		m := New()
+		m.Get("/", Buffered(), func(ctx *Context) {
+			ctx.Resp.Write([]byte("Hello world"))
+			So(ctx.Resp.Metrics().BytesWritten, ShouldEqual, 0)
+			So(ctx.Resp.Metrics().TimeToFirstByte, ShouldEqual, 0)
+		})
+		m.Use(func(ctx *Context) {
+			ctx.Next()
+			So(ctx.Resp.Metrics().BytesWritten, ShouldEqual, 11)
+			So(ctx.Resp.Metrics().TimeToFirstByte, ShouldBeGreaterThan, 0)
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "Hello world")
+	})
+
 	Convey("Response writer with Hijack", t, func() {
 		hijackable := newHijackableResponse()
 		rw := NewResponseWriter(hijackable)
@@ -130,13 +271,84 @@ func Test_ResponseWriter(t *testing.T) {
 		So(hijackable.Hijacked, ShouldBeTrue)
 	})
 
-	Convey("Response writer with bad Hijack", t, func() {
-		hijackable := new(http.ResponseWriter)
-		rw := NewResponseWriter(*hijackable)
-		hijacker, ok := rw.(http.Hijacker)
+	Convey("Response writer without Hijack", t, func() {
+		rw := NewResponseWriter(httptest.NewRecorder())
+		_, ok := rw.(http.Hijacker)
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Response writer with Push", t, func() {
+		resp := newPushableRecorder()
+		rw := NewResponseWriter(resp)
+		pusher, ok := rw.(http.Pusher)
 		So(ok, ShouldBeTrue)
-		_, _, err := hijacker.Hijack()
-		So(err, ShouldNotBeNil)
+		So(pusher.Push("/style.css", nil), ShouldBeNil)
+		So(resp.pushed, ShouldResemble, []string{"/style.css"})
+	})
+
+	Convey("Response writer with unsupported Push", t, func() {
+		rw := NewResponseWriter(httptest.NewRecorder())
+		_, ok := rw.(http.Pusher)
+		So(ok, ShouldBeFalse)
+
+		m := New()
+		m.Get("/", func(ctx *Context) error {
+			return ctx.Push("/style.css", nil)
+		})
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.Body.String(), ShouldContainSubstring, http.ErrNotSupported.Error())
+	})
+
+	Convey("Response writer with optional interfaces of the underlying one", t, func() {
+		type hijackPushReadFrom struct {
+			*pushableRecorder
+			*hijackableResponse
+			*readFromRecorder
+		}
+		resp := hijackPushReadFrom{
+			newPushableRecorder(),
+			newHijackableResponse(),
+			&readFromRecorder{ResponseRecorder: httptest.NewRecorder()},
+		}
+		cases := []struct {
+			rw                           http.ResponseWriter
+			hijacker, pusher, readerFrom bool
+		}{
+			{httptest.NewRecorder(), false, false, false},
+			{resp.hijackableResponse, true, false, false},
+			{resp.pushableRecorder, false, true, false},
+			{resp.readFromRecorder, false, false, true},
+			{struct {
+				*pushableRecorder
+				http.Hijacker
+			}{resp.pushableRecorder, resp.hijackableResponse}, true, true, false},
+			{struct {
+				*hijackableResponse
+				io.ReaderFrom
+			}{resp.hijackableResponse, resp.readFromRecorder}, true, false, true},
+			{struct {
+				*readFromRecorder
+				http.Pusher
+			}{resp.readFromRecorder, resp.pushableRecorder}, false, true, true},
+			{struct {
+				*readFromRecorder
+				http.Pusher
+				http.Hijacker
+			}{resp.readFromRecorder, resp.pushableRecorder, resp.hijackableResponse}, true, true, true},
+		}
+		for _, c := range cases {
+			rw := NewResponseWriter(c.rw)
+			_, hijacker := rw.(http.Hijacker)
+			_, pusher := rw.(http.Pusher)
+			_, readerFrom := rw.(io.ReaderFrom)
+			So(hijacker, ShouldEqual, c.hijacker)
+			So(pusher, ShouldEqual, c.pusher)
+			So(readerFrom, ShouldEqual, c.readerFrom)
+			_, ok := baseResponseWriter(rw)
+			So(ok, ShouldBeTrue)
+		}
 	})
 
 	Convey("Response writer with close notify", t, func() {
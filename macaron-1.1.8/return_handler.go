@@ -28,6 +28,12 @@ import (
 // that are passed into this function.
 type ReturnHandler func(*Context, []reflect.Value)
 
+var (
+	returnHandlerType = reflect.TypeOf(ReturnHandler(nil))
+	stringType        = reflect.TypeOf("")
+	byteSliceType     = reflect.TypeOf([]byte(nil))
+)
+
 func canDeref(val reflect.Value) bool {
 	return val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr
 }
@@ -41,6 +47,21 @@ func isByteSlice(val reflect.Value) bool {
 	return val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8
 }
 
+// writeCommonValue writes values of string or []byte without generic checks of reflection,
+// and reports whether the value is one of them. Named types are left to the generic path
+// because they may implement error.
+func writeCommonValue(resp http.ResponseWriter, val reflect.Value) bool {
+	switch val.Type() {
+	case stringType:
+		resp.Write([]byte(val.String()))
+	case byteSliceType:
+		resp.Write(val.Bytes())
+	default:
+		return false
+	}
+	return true
+}
+
 func defaultReturnHandler() ReturnHandler {
 	return func(ctx *Context, vals []reflect.Value) {
 		rv := ctx.GetVal(inject.InterfaceOf((*http.ResponseWriter)(nil)))
@@ -49,8 +70,19 @@ func defaultReturnHandler() ReturnHandler {
 		if len(vals) > 1 && vals[0].Kind() == reflect.Int {
 			resp.WriteHeader(int(vals[0].Int()))
 			respVal = vals[1]
+			if writeCommonValue(resp, respVal) {
+				return
+			}
 		} else if len(vals) > 0 {
 			respVal = vals[0]
+			if writeCommonValue(resp, respVal) {
+				return
+			} else if respVal.Type() == errorType {
+				if !respVal.IsNil() {
+					ctx.internalServerError(ctx, respVal.Interface().(error))
+				}
+				return
+			}
 
 			if isError(respVal) {
 				err := respVal.Interface().(error)
@@ -15,6 +15,8 @@
 package macaron
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -71,6 +73,27 @@ func Test_Return_Handler(t *testing.T) {
 		})
 	})
 
+	Convey("Return with error in production mode", t, func() {
+		setENV(PROD)
+		defer setENV(DEV)
+
+		buf := bytes.NewBufferString("")
+		m := New()
+		m.SetLogOutputs(nil, buf)
+		m.Get("/", func() error {
+			return errors.New("what the hell!!!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.Body.String(), ShouldEqual, "Internal Server Error\n")
+		So(buf.String(), ShouldContainSubstring, "what the hell!!!")
+	})
+
 	Convey("Return with pointer", t, func() {
 		m := New()
 		m.Get("/", func() *string {
@@ -99,4 +122,52 @@ func Test_Return_Handler(t *testing.T) {
 
 		So(resp.Body.String(), ShouldEqual, "hello world")
 	})
+
+	Convey("Return with named types", t, func() {
+		m := New()
+		m.Get("/string", func() (int, returnString) {
+			return http.StatusCreated, "named string"
+		})
+		m.Get("/error", func() returnError {
+			return "named error"
+		})
+		m.Get("/bytes", func() json.RawMessage {
+			return json.RawMessage(`{}`)
+		})
+
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/string", nil))
+		So(resp.Code, ShouldEqual, http.StatusCreated)
+		So(resp.Body.String(), ShouldEqual, "named string")
+
+		resp = httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/error", nil))
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.Body.String(), ShouldEqual, "named error\n")
+
+		resp = httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/bytes", nil))
+		So(resp.Body.String(), ShouldEqual, "{}")
+	})
+}
+
+type returnString string
+
+type returnError string
+
+func (e returnError) Error() string {
+	return string(e)
+}
+
+func Benchmark_Return_Handler(b *testing.B) {
+	m := New()
+	m.Get("/", func() (int, string) {
+		return http.StatusOK, "hello world"
+	})
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(httptest.NewRecorder(), req)
+	}
 }
@@ -0,0 +1,286 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Unknwon/com"
+)
+
+const _BACKUP_TIME_FORMAT = "2006-01-02T15-04-05.000"
+
+// RotateOptions is a struct for specifying configuration options for the RotateWriter.
+type RotateOptions struct {
+	// Filename is the file to write logs to, backups are kept in the same directory.
+	// Default is "macaron.log" under work directory.
+	Filename string
+	// MaxSize is the maximum size in bytes of the log file before it gets rotated.
+	// Default is 100 MB.
+	MaxSize int64
+	// Interval rotates the log file once it has been written for given duration, even if
+	// it does not reach MaxSize. Rotating by time is disabled if it is 0.
+	Interval time.Duration
+	// MaxBackups is the maximum number of old log files to keep. All files are kept if it is 0.
+	MaxBackups int
+	// MaxAge is the maximum duration to keep old log files. Files are not removed by age if it is 0.
+	MaxAge time.Duration
+	// Compress determines if the rotated log files should be compressed using gzip.
+	Compress bool
+}
+
+// RotateWriter is an io.WriteCloser that writes to the file and rotates it by size or time.
+// It is safe to be used by multiple goroutines, e.g. as the output of NewWithLogger.
+type RotateWriter struct {
+	opt RotateOptions
+
+	lock     sync.Mutex
+	file     *os.File
+	size     int64
+	openTime time.Time
+
+	millLock sync.Mutex
+	wg       sync.WaitGroup
+}
+
+func prepareRotateOptions(opt RotateOptions) RotateOptions {
+	if len(opt.Filename) == 0 {
+		opt.Filename = "macaron.log"
+	}
+	if !filepath.IsAbs(opt.Filename) {
+		opt.Filename = filepath.Join(Root, opt.Filename)
+	}
+	if opt.MaxSize <= 0 {
+		opt.MaxSize = 100 << 20
+	}
+	return opt
+}
+
+// NewRotateWriter creates and opens a RotateWriter with given options.
+// Content is appended if the log file already exists.
+func NewRotateWriter(opt RotateOptions) (*RotateWriter, error) {
+	w := &RotateWriter{opt: prepareRotateOptions(opt)}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.opt.Filename), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.opt.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = fi.Size()
+	w.openTime = time.Now()
+	return nil
+}
+
+// Write implements io.Writer. The log file is rotated before the write
+// if it would exceed MaxSize or has been opened longer than Interval.
+// If rotating fails, logs are still written to the current log file.
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return 0, fmt.Errorf("write to closed rotate writer")
+	}
+
+	if (w.size > 0 && w.size+int64(len(p)) > w.opt.MaxSize) ||
+		(w.opt.Interval > 0 && time.Since(w.openTime) >= w.opt.Interval) {
+		if err := w.rotate(); err != nil && w.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate closes current log file, moves it aside as a backup and opens a new one.
+func (w *RotateWriter) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("rotate closed rotate writer")
+	}
+	return w.rotate()
+}
+
+func (w *RotateWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(w.opt.Filename)
+	prefix := strings.TrimSuffix(w.opt.Filename, ext)
+	return prefix + "." + t.Format(_BACKUP_TIME_FORMAT) + ext
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	// Make sure not to overwrite existing backup when rotates too frequently.
+	t := time.Now()
+	for com.IsExist(w.backupName(t)) || com.IsExist(w.backupName(t)+".gz") {
+		t = t.Add(time.Millisecond)
+	}
+	if err := os.Rename(w.opt.Filename, w.backupName(t)); err != nil {
+		// Keep writing to the log file rather than losing logs until restart.
+		if openErr := w.open(); openErr != nil {
+			return fmt.Errorf("%v, and fail to reopen log file: %v", err, openErr)
+		}
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.mill()
+	}()
+	return nil
+}
+
+type logBackup struct {
+	path string
+	t    time.Time
+}
+
+// backups returns all backup files of current log file, newest first.
+func (w *RotateWriter) backups() ([]logBackup, error) {
+	dir := filepath.Dir(w.opt.Filename)
+	ext := filepath.Ext(w.opt.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(w.opt.Filename), ext) + "."
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]logBackup, 0, len(fis))
+	for _, fi := range fis {
+		name := strings.TrimSuffix(fi.Name(), ".gz")
+		if fi.IsDir() || len(name) <= len(prefix)+len(ext) ||
+			!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(_BACKUP_TIME_FORMAT, name[len(prefix):len(name)-len(ext)], time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{filepath.Join(dir, fi.Name()), t})
+	}
+	sort.Sort(logBackupsByTime(backups))
+	return backups, nil
+}
+
+type logBackupsByTime []logBackup
+
+func (b logBackupsByTime) Len() int           { return len(b) }
+func (b logBackupsByTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b logBackupsByTime) Less(i, j int) bool { return b[i].t.After(b[j].t) }
+
+// mill removes backups exceed MaxBackups or MaxAge, and compresses the rest if required.
+func (w *RotateWriter) mill() {
+	w.millLock.Lock()
+	defer w.millLock.Unlock()
+
+	backups, err := w.backups()
+	if err != nil {
+		return
+	}
+
+	for i, b := range backups {
+		if (w.opt.MaxBackups > 0 && i >= w.opt.MaxBackups) ||
+			(w.opt.MaxAge > 0 && time.Since(b.t) > w.opt.MaxAge) {
+			os.Remove(b.path)
+			continue
+		}
+		if w.opt.Compress && !strings.HasSuffix(b.path, ".gz") {
+			compressLogFile(b.path)
+		}
+	}
+}
+
+func compressLogFile(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(name + ".gz")
+		}
+	}()
+
+	gw := gzip.NewWriter(dst)
+	if _, err = io.Copy(gw, src); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
+
+// Close closes the log file and waits for pending compression and cleanup to finish.
+func (w *RotateWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.wg.Wait()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RotateWriter(t *testing.T) {
+	Convey("Rotate log file by size", t, func() {
+		dir, err := ioutil.TempDir("", "macaron")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		w, err := NewRotateWriter(RotateOptions{
+			Filename:   filepath.Join(dir, "app.log"),
+			MaxSize:    10,
+			MaxBackups: 2,
+		})
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 4; i++ {
+			_, err = w.Write([]byte("12345678\n"))
+			So(err, ShouldBeNil)
+		}
+		So(w.Close(), ShouldBeNil)
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "app.log"))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "12345678\n")
+
+		backups, err := w.backups()
+		So(err, ShouldBeNil)
+		So(len(backups), ShouldEqual, 2)
+	})
+
+	Convey("Keep writing when rotating fails", t, func() {
+		dir, err := ioutil.TempDir("", "macaron")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		// Names of backups are too long to be created.
+		filename := filepath.Join(dir, strings.Repeat("a", 240)+".log")
+		w, err := NewRotateWriter(RotateOptions{Filename: filename, MaxSize: 10})
+		So(err, ShouldBeNil)
+		defer w.Close()
+
+		_, err = w.Write([]byte("12345678\n"))
+		So(err, ShouldBeNil)
+		So(w.Rotate(), ShouldNotBeNil)
+		_, err = w.Write([]byte("12345678\n"))
+		So(err, ShouldBeNil)
+
+		data, err := ioutil.ReadFile(filename)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "12345678\n12345678\n")
+	})
+
+	Convey("Keep writing when the directory is not writable", t, func() {
+		if os.Geteuid() == 0 {
+			// Permissions of directories do not apply to root.
+			return
+		}
+		dir, err := ioutil.TempDir("", "macaron")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, "app.log")
+		w, err := NewRotateWriter(RotateOptions{Filename: filename, MaxSize: 10})
+		So(err, ShouldBeNil)
+		defer w.Close()
+
+		_, err = w.Write([]byte("12345678\n"))
+		So(err, ShouldBeNil)
+		So(os.Chmod(dir, 0555), ShouldBeNil)
+		defer os.Chmod(dir, 0755)
+		_, err = w.Write([]byte("12345678\n"))
+		So(err, ShouldBeNil)
+
+		data, err := ioutil.ReadFile(filename)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "12345678\n12345678\n")
+	})
+
+	Convey("Compress rotated log files", t, func() {
+		dir, err := ioutil.TempDir("", "macaron")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		w, err := NewRotateWriter(RotateOptions{
+			Filename: filepath.Join(dir, "app.log"),
+			Compress: true,
+		})
+		So(err, ShouldBeNil)
+		_, err = w.Write([]byte("hello"))
+		So(err, ShouldBeNil)
+		So(w.Rotate(), ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+
+		backups, err := w.backups()
+		So(err, ShouldBeNil)
+		So(len(backups), ShouldEqual, 1)
+		So(strings.HasSuffix(backups[0].path, ".gz"), ShouldBeTrue)
+	})
+
+	Convey("Use as logger output", t, func() {
+		dir, err := ioutil.TempDir("", "macaron")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		w, err := NewRotateWriter(RotateOptions{Filename: filepath.Join(dir, "app.log")})
+		So(err, ShouldBeNil)
+
+		m := NewWithLogger(w)
+		m.Use(Logger())
+		m.Get("/", func() {})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(w.Close(), ShouldBeNil)
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "app.log"))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, "Completed")
+	})
+}
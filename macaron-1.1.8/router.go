@@ -75,6 +75,17 @@ type Router struct {
 	groups              []group
 	notFound            http.HandlerFunc
 	internalServerError func(*Context, error)
+
+	// Registered routes, for introspection of handlers.
+	routes           []routeInfo
+	notFoundHandlers []Handler
+}
+
+// routeInfo represents a registered route and its handlers.
+type routeInfo struct {
+	method   string
+	pattern  string
+	handlers []Handler
 }
 
 func NewRouter() *Router {
@@ -93,6 +104,64 @@ func (r *Router) SetAutoHead(v bool) {
 
 type Params map[string]string
 
+// inlineParams is the number of params stored without allocation, which is enough for most routes.
+const inlineParams = 8
+
+// routeParams stores params of a request in arrays, and in a map only for params beyond them,
+// so matching routes does not allocate for every request.
+type routeParams struct {
+	n     int
+	names [inlineParams]string
+	vals  [inlineParams]string
+	more  Params
+}
+
+func (p *routeParams) lookup(name string) (string, bool) {
+	for i := 0; i < p.n; i++ {
+		if p.names[i] == name {
+			return p.vals[i], true
+		}
+	}
+	val, ok := p.more[name]
+	return val, ok
+}
+
+func (p *routeParams) get(name string) string {
+	val, _ := p.lookup(name)
+	return val
+}
+
+// set sets value of the param, replacing the one that has been set.
+func (p *routeParams) set(name, val string) {
+	for i := 0; i < p.n; i++ {
+		if p.names[i] == name {
+			p.vals[i] = val
+			return
+		}
+	}
+	if _, ok := p.more[name]; ok || p.n == inlineParams {
+		if p.more == nil {
+			p.more = make(Params)
+		}
+		p.more[name] = val
+		return
+	}
+	p.names[p.n], p.vals[p.n] = name, val
+	p.n++
+}
+
+// toMap returns params as Params, which is allocated.
+func (p *routeParams) toMap() Params {
+	params := make(Params, p.n+len(p.more))
+	for i := 0; i < p.n; i++ {
+		params[p.names[i]] = p.vals[i]
+	}
+	for name, val := range p.more {
+		params[name] = val
+	}
+	return params
+}
+
 // Handle is a function that can be registered to a route to handle HTTP requests.
 // Like http.HandlerFunc, but has a third parameter for the values of wildcards (variables).
 type Handle func(http.ResponseWriter, *http.Request, Params)
@@ -113,8 +182,9 @@ func (r *Route) Name(name string) {
 	r.router.namedRoutes[name] = r.leaf
 }
 
-// handle adds new route to the router tree.
-func (r *Router) handle(method, pattern string, handle Handle) *Route {
+// handle adds new route to the router tree. Requests are served by serve if it is not nil,
+// which avoids converting params for handle.
+func (r *Router) handle(method, pattern string, handle Handle, serve func(*Context)) *Route {
 	method = strings.ToUpper(method)
 
 	var leaf *Leaf
@@ -147,12 +217,19 @@ func (r *Router) handle(method, pattern string, handle Handle) *Route {
 			leaf = t.Add(pattern, handle)
 			r.routers[m] = t
 		}
+		leaf.serve = serve
 		r.add(m, pattern, leaf)
 	}
 	return &Route{r, leaf}
 }
 
 // Handle registers a new request handle with the given pattern, method and handlers.
+// Handlers can also be http.Handler values, which get route params by ParamsFromContext, e.g.
+//
+//	m.Get("/debug/pprof/*", pprof.Index)
+//	m.Get("/files/:name", func(w http.ResponseWriter, r *http.Request) {
+//		name := macaron.ParamsFromContext(r.Context())[":name"]
+//	})
 func (r *Router) Handle(method string, pattern string, handlers []Handler) *Route {
 	if len(r.groups) > 0 {
 		groupPattern := ""
@@ -166,16 +243,25 @@ func (r *Router) Handle(method string, pattern string, handlers []Handler) *Rout
 		h = append(h, handlers...)
 		handlers = h
 	}
+	handlers = wrapHandlers(handlers)
 	validateHandlers(handlers)
+	r.routes = append(r.routes, routeInfo{strings.ToUpper(method), pattern, handlers})
 
-	return r.handle(method, pattern, func(resp http.ResponseWriter, req *http.Request, params Params) {
-		c := r.m.createContext(resp, req)
-		c.params = params
+	serve := func(c *Context) {
+		c.pattern = pattern
 		c.handlers = make([]Handler, 0, len(r.m.handlers)+len(handlers))
 		c.handlers = append(c.handlers, r.m.handlers...)
 		c.handlers = append(c.handlers, handlers...)
 		c.run()
-	})
+		c.release()
+	}
+	return r.handle(method, pattern, func(resp http.ResponseWriter, req *http.Request, params Params) {
+		c := r.m.createContext(resp, req)
+		for name, val := range params {
+			c.params.set(name, val)
+		}
+		serve(c)
+	}, serve)
 }
 
 func (r *Router) Group(pattern string, fn func(), h ...Handler) {
@@ -248,11 +334,14 @@ func (r *Router) Combo(pattern string, h ...Handler) *ComboRouter {
 // found. If it is not set, http.NotFound is used.
 // Be sure to set 404 response code in your handler.
 func (r *Router) NotFound(handlers ...Handler) {
+	handlers = wrapHandlers(handlers)
 	validateHandlers(handlers)
+	r.notFoundHandlers = handlers
 	r.notFound = func(rw http.ResponseWriter, req *http.Request) {
 		c := r.m.createContext(rw, req)
 		c.handlers = append(r.m.handlers, handlers...)
 		c.run()
+		c.release()
 	}
 }
 
@@ -271,19 +360,73 @@ func (r *Router) InternalServerError(handlers ...Handler) {
 
 func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if t, ok := r.routers[req.Method]; ok {
-		h, p, ok := t.Match(req.URL.Path)
-		if ok {
-			if splat, ok := p["*0"]; ok {
-				p["*"] = splat // Easy name.
+		// Params are matched into a context, which is initialized only if a route is matched.
+		c := contextPool.Get().(*Context)
+		if leaf, ok := t.matchParams(req.URL.Path, &c.params); ok {
+			if splat, ok := c.params.lookup("*0"); ok {
+				c.params.set("*", splat) // Easy name.
+			}
+			if leaf.serve != nil {
+				r.m.initContext(c, rw, req)
+				leaf.serve(c)
+				return
 			}
-			h(rw, req, p)
+			params := c.params.toMap()
+			c.release()
+			leaf.handle(rw, req, params)
 			return
 		}
+		c.release()
 	}
 
 	r.notFound(rw, req)
 }
 
+// RouteMatch is the route that matches a request, which is returned by MatchRoute.
+type RouteMatch struct {
+	Method string
+	// Full pattern of the route, e.g. "/users/:id".
+	Pattern string
+	// Name of the route given by Route.Name, empty if the route is not named.
+	Name   string
+	Params Params
+}
+
+// MatchRoute returns the route that matches the method and path as requests are routed,
+// but without running its handlers, or nil if there is none. It helps to verify routing
+// tables in tests, e.g. m.MatchRoute("GET", "/users/42").Params[":id"].
+func (r *Router) MatchRoute(method, path string) *RouteMatch {
+	method = strings.ToUpper(method)
+	t, ok := r.routers[method]
+	if !ok {
+		return nil
+	}
+	leaf, params, ok := t.MatchLeaf(path)
+	if !ok {
+		return nil
+	}
+	if splat, ok := params["*0"]; ok {
+		params["*"] = splat
+	}
+
+	match := &RouteMatch{Method: method, Params: params}
+	r.routeMap.lock.RLock()
+	for pattern, l := range r.routeMap.routes[method] {
+		if l == leaf {
+			match.Pattern = pattern
+			break
+		}
+	}
+	r.routeMap.lock.RUnlock()
+	for name, l := range r.namedRoutes {
+		if l == leaf {
+			match.Name = name
+			break
+		}
+	}
+	return match
+}
+
 // URLFor builds path part of URL by given pair values.
 func (r *Router) URLFor(name string, pairs ...string) string {
 	leaf, ok := r.namedRoutes[name]
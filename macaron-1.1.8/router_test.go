@@ -15,7 +15,9 @@
 package macaron
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -242,6 +244,84 @@ func Test_Router_URLFor(t *testing.T) {
 	})
 }
 
+func Test_Router_MatchRoute(t *testing.T) {
+	Convey("Match routes without running handlers", t, func() {
+		m := New()
+		called := false
+		handler := func() { called = true }
+		m.Get("/users/:id", handler).Name("user")
+		m.Post("/users/:id", handler)
+		m.Group("/api", func() {
+			m.Get("/posts/:year([0-9]+)/*", handler)
+		})
+
+		match := m.MatchRoute("get", "/users/42")
+		So(match, ShouldResemble, &RouteMatch{
+			Method:  "GET",
+			Pattern: "/users/:id",
+			Name:    "user",
+			Params:  Params{":id": "42"},
+		})
+		match = m.MatchRoute("POST", "/users/42/")
+		So(match.Pattern, ShouldEqual, "/users/:id")
+		So(match.Name, ShouldBeEmpty)
+
+		match = m.MatchRoute("GET", "/api/posts/2016/go/macaron")
+		So(match.Pattern, ShouldEqual, "/api/posts/:year([0-9]+)/*")
+		So(match.Params[":year"], ShouldEqual, "2016")
+		So(match.Params["*"], ShouldEqual, "go/macaron")
+
+		So(m.MatchRoute("GET", "/api/posts/latest/go"), ShouldBeNil)
+		So(m.MatchRoute("DELETE", "/users/42"), ShouldBeNil)
+		So(m.MatchRoute("GET", "/posts"), ShouldBeNil)
+		So(called, ShouldBeFalse)
+
+		Convey("Match routes with URL prefix", func() {
+			m.SetURLPrefix("/app")
+			So(m.MatchRoute("GET", "/app/users/42").Pattern, ShouldEqual, "/users/:id")
+		})
+	})
+}
+
+func Test_Router_Params(t *testing.T) {
+	Convey("Store params inline and beyond", t, func() {
+		var p routeParams
+		for i := 0; i < inlineParams+2; i++ {
+			p.set(fmt.Sprintf(":p%d", i), fmt.Sprint(i))
+		}
+		So(p.n, ShouldEqual, inlineParams)
+		So(p.more, ShouldHaveLength, 2)
+		So(p.get(":p0"), ShouldEqual, "0")
+		So(p.get(":p9"), ShouldEqual, "9")
+		So(p.get(":missing"), ShouldBeEmpty)
+
+		// Values are replaced wherever they are stored.
+		p.set(":p1", "one")
+		p.set(":p9", "nine")
+		So(p.n, ShouldEqual, inlineParams)
+		So(p.get(":p1"), ShouldEqual, "one")
+		So(p.get(":p9"), ShouldEqual, "nine")
+		So(p.toMap(), ShouldHaveLength, inlineParams+2)
+	})
+
+	Convey("Get params of routes with many params", t, func() {
+		m := New()
+		pattern := ""
+		for i := 0; i < 10; i++ {
+			pattern += fmt.Sprintf("/:p%d", i)
+		}
+		m.Get(pattern, func(ctx *Context) string {
+			return ctx.Params("p0") + ctx.Params(":p9")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/a/b/c/d/e/f/g/h/i/j", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldEqual, "aj")
+	})
+}
+
 func Test_Router_Group(t *testing.T) {
 	Convey("Register route group", t, func() {
 		m := New()
@@ -260,6 +340,74 @@ func Test_Router_Group(t *testing.T) {
 	})
 }
 
+type routerUserKey struct{}
+
+func Test_Router_HTTPHandler(t *testing.T) {
+	Convey("Use http.Handler values as handlers", t, func() {
+		m := New()
+		m.Use(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Global", "yes")
+		}))
+		m.Get("/files/:name", http.StripPrefix("/files", http.FileServer(http.Dir("."))))
+		m.Get("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte("user " + ParamsFromContext(req.Context())[":id"]))
+		})
+		m.Group("/api", func() {
+			m.Get("/items/:id", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Write([]byte("item " + ParamsFromContext(req.Context())[":id"]))
+			}))
+		}, func(ctx *Context) {
+			ctx.Resp.Header().Set("X-Group", "yes")
+		})
+		m.NotFound(http.NotFoundHandler())
+
+		serve := func(url string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := serve("/users/7")
+		So(resp.Body.String(), ShouldEqual, "user 7")
+		So(resp.Header().Get("X-Global"), ShouldEqual, "yes")
+
+		resp = serve("/api/items/9")
+		So(resp.Body.String(), ShouldEqual, "item 9")
+		So(resp.Header().Get("X-Group"), ShouldEqual, "yes")
+
+		resp = serve("/files/router_test.go")
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldContainSubstring, "func Test_Router_HTTPHandler")
+
+		resp = serve("/nowhere/at/all")
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldEqual, "404 page not found\n")
+
+		So(ParamsFromContext(context.Background()), ShouldBeNil)
+	})
+
+	Convey("Use remapped writers and requests for http.Handler values", t, func() {
+		m := New()
+		m.Use(func(ctx *Context) {
+			req := ctx.Req.Request.WithContext(context.WithValue(ctx.Req.Context(), routerUserKey{}, "joe"))
+			ctx.Map(req)
+			rec := httptest.NewRecorder()
+			ctx.MapTo(rec, (*http.ResponseWriter)(nil))
+			ctx.Next()
+			ctx.Resp.Write([]byte("wrapped " + rec.Body.String()))
+		})
+		m.Get("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(req.Context().Value(routerUserKey{}).(string) + " " + ParamsFromContext(req.Context())[":id"]))
+		})
+
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/users/7", nil))
+		So(resp.Body.String(), ShouldEqual, "wrapped joe 7")
+	})
+}
+
 func Test_Router_NotFound(t *testing.T) {
 	Convey("Custom not found handler", t, func() {
 		m := New()
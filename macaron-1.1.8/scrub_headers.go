@@ -0,0 +1,73 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+)
+
+// ScrubHeadersOptions is a struct for specifying configuration options for the macaron.ScrubHeaders middleware.
+type ScrubHeadersOptions struct {
+	// Remove is the list of response headers to remove. Default is Server, X-Powered-By,
+	// X-AspNet-Version, X-AspNetMvc-Version, X-Generator and X-Runtime.
+	Remove []string
+	// Allow is the list of headers in Remove to keep, e.g. those the application sets on purpose.
+	Allow []string
+	// Set overrides values of response headers, e.g. {"Server": "web"}.
+	Set map[string]string
+}
+
+func prepareScrubHeadersOptions(options []ScrubHeadersOptions) ScrubHeadersOptions {
+	var opt ScrubHeadersOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if len(opt.Remove) == 0 {
+		opt.Remove = []string{"Server", "X-Powered-By", "X-AspNet-Version", "X-AspNetMvc-Version", "X-Generator", "X-Runtime"}
+	}
+	return opt
+}
+
+// ScrubHeaders returns a middleware handler that removes or overrides response headers which reveal
+// software of the server, right before the header is written, so responses of all handlers, including
+// error pages, static files and proxied responses, are scrubbed. It should be used globally before
+// other middleware.
+func ScrubHeaders(options ...ScrubHeadersOptions) Handler {
+	opt := prepareScrubHeadersOptions(options)
+	allowed := make(map[string]bool, len(opt.Allow))
+	for _, h := range opt.Allow {
+		allowed[http.CanonicalHeaderKey(h)] = true
+	}
+	var remove []string
+	for _, h := range opt.Remove {
+		if !allowed[http.CanonicalHeaderKey(h)] {
+			remove = append(remove, h)
+		}
+	}
+
+	return func(ctx *Context) {
+		ctx.Resp.Before(func(rw ResponseWriter) {
+			header := rw.Header()
+			for _, h := range remove {
+				header.Del(h)
+			}
+			for k, v := range opt.Set {
+				header.Set(k, v)
+			}
+		})
+	}
+}
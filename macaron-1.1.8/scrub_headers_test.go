@@ -0,0 +1,63 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ScrubHeaders(t *testing.T) {
+	Convey("Scrub headers of responses", t, func() {
+		m := New()
+		m.Use(ScrubHeaders(ScrubHeadersOptions{
+			Allow: []string{"x-runtime"},
+			Set:   map[string]string{"Server": "web"},
+		}))
+		m.Use(func(ctx *Context) {
+			ctx.Resp.Header().Set("X-Powered-By", "Macaron")
+			ctx.Resp.Header().Set("X-Runtime", "12ms")
+		})
+		m.Use(Recovery())
+		m.Use(Static("fixtures/basic"))
+		m.Get("/", func(ctx *Context) {
+			ctx.Resp.Header().Set("Server", "Macaron/1.1.8")
+			ctx.Resp.Write([]byte("home"))
+		})
+		m.Get("/panic", func() { panic("oops") })
+
+		for _, c := range []struct {
+			url  string
+			code int
+		}{
+			{"/", http.StatusOK},
+			{"/hypertext.html", http.StatusOK},
+			{"/panic", http.StatusInternalServerError},
+			{"/missing", http.StatusNotFound},
+		} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", c.url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, c.code)
+			So(resp.Header().Get("Server"), ShouldEqual, "web")
+			So(resp.Header().Get("X-Powered-By"), ShouldBeEmpty)
+			So(resp.Header().Get("X-Runtime"), ShouldEqual, "12ms")
+		}
+	})
+}
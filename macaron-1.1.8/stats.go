@@ -0,0 +1,156 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultLatencyBuckets are upper bounds of latency histogram buckets used by route statistics
+// unless LoggerOptions.LatencyBuckets is set.
+var defaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// RouteStats represents latency statistics of a route.
+type RouteStats struct {
+	Method  string
+	Pattern string
+	// Count is the total number of requests served.
+	Count int64
+	// Statuses counts requests by response status code.
+	Statuses map[int]int64
+	Total    time.Duration
+	Min      time.Duration
+	Max      time.Duration
+	// Bounds are upper bounds of latency buckets, i.e. LoggerOptions.LatencyBuckets of the Logger
+	// that recorded the route first. It must not be modified.
+	Bounds []time.Duration
+	// Buckets counts requests by latency, Buckets[i] is the number of requests
+	// take no more than Bounds[i] but more than the previous one.
+	// The last one counts requests slower than all of Bounds.
+	Buckets []int64
+}
+
+// Mean returns average latency of the route.
+func (s RouteStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+type statsKey struct {
+	method  string
+	pattern string
+}
+
+// routeStats is a thread-safe collector of RouteStats.
+type routeStats struct {
+	lock  sync.Mutex
+	stats map[statsKey]*RouteStats
+}
+
+func newRouteStats() *routeStats {
+	return &routeStats{stats: make(map[statsKey]*RouteStats)}
+}
+
+// record records the request by bounds of buckets, which are used if the route is recorded
+// for the first time, and must be sorted and not be modified.
+func (rs *routeStats) record(method, pattern string, status int, latency time.Duration, bounds []time.Duration) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	// Nothing has been written, net/http will reply with 200 OK.
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	method = metricsMethod(method)
+	key := statsKey{method, pattern}
+	s := rs.stats[key]
+	if s == nil {
+		s = &RouteStats{
+			Method:   method,
+			Pattern:  pattern,
+			Statuses: make(map[int]int64),
+			Bounds:   bounds,
+			Buckets:  make([]int64, len(bounds)+1),
+		}
+		rs.stats[key] = s
+	}
+
+	s.Count++
+	s.Statuses[status]++
+	s.Total += latency
+	if s.Count == 1 || latency < s.Min {
+		s.Min = latency
+	}
+	if latency > s.Max {
+		s.Max = latency
+	}
+	i := sort.Search(len(s.Bounds), func(i int) bool { return latency <= s.Bounds[i] })
+	s.Buckets[i]++
+}
+
+// snapshot returns copies of all collected statistics ordered by pattern and method.
+func (rs *routeStats) snapshot() []RouteStats {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	list := make([]RouteStats, 0, len(rs.stats))
+	for _, s := range rs.stats {
+		cp := *s
+		cp.Statuses = make(map[int]int64, len(s.Statuses))
+		for k, v := range s.Statuses {
+			cp.Statuses[k] = v
+		}
+		cp.Buckets = append([]int64(nil), s.Buckets...)
+		list = append(list, cp)
+	}
+	sort.Sort(routeStatsList(list))
+	return list
+}
+
+type routeStatsList []RouteStats
+
+func (l routeStatsList) Len() int      { return len(l) }
+func (l routeStatsList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l routeStatsList) Less(i, j int) bool {
+	if l[i].Pattern != l[j].Pattern {
+		return l[i].Pattern < l[j].Pattern
+	}
+	return l[i].Method < l[j].Method
+}
+
+// Stats returns latency statistics of routes collected by Logger middleware
+// with LoggerOptions.CollectStats enabled. Requests that do not match any
+// route are counted with empty pattern, and nonstandard methods as "OTHER".
+func (m *Macaron) Stats() []RouteStats {
+	return m.stats.snapshot()
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Stats(t *testing.T) {
+	Convey("Collect route latency statistics", t, func() {
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Logger(LoggerOptions{CollectStats: true}))
+		m.Get("/user/:id", func() {})
+		m.Post("/user/:id", func(rw http.ResponseWriter) {
+			rw.WriteHeader(http.StatusCreated)
+		})
+
+		for _, url := range []string{"/user/1", "/user/2", "/404"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+		}
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/user/1", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		stats := m.Stats()
+		So(len(stats), ShouldEqual, 3)
+
+		So(stats[0].Pattern, ShouldBeBlank)
+		So(stats[0].Statuses[http.StatusNotFound], ShouldEqual, 1)
+
+		So(stats[1].Method, ShouldEqual, "GET")
+		So(stats[1].Pattern, ShouldEqual, "/user/:id")
+		So(stats[1].Count, ShouldEqual, 2)
+		So(stats[1].Statuses[http.StatusOK], ShouldEqual, 2)
+		So(stats[1].Buckets[0], ShouldEqual, 2)
+		So(stats[1].Mean(), ShouldBeGreaterThan, 0)
+
+		So(stats[2].Method, ShouldEqual, "POST")
+		So(stats[2].Statuses[http.StatusCreated], ShouldEqual, 1)
+	})
+
+	Convey("Record into latency buckets", t, func() {
+		rs := newRouteStats()
+		rs.record("GET", "/", 200, 7*time.Millisecond, defaultLatencyBuckets)
+		rs.record("GET", "/", 200, time.Minute, defaultLatencyBuckets)
+		rs.record("FOO", "/", 200, time.Minute, []time.Duration{time.Millisecond})
+		rs.record("BAR", "/", 200, time.Minute, []time.Duration{time.Millisecond})
+
+		stats := rs.snapshot()
+		So(stats, ShouldHaveLength, 2)
+		s := stats[0]
+		So(s.Buckets[1], ShouldEqual, 1)
+		So(s.Buckets[len(defaultLatencyBuckets)], ShouldEqual, 1)
+		So(s.Min, ShouldEqual, 7*time.Millisecond)
+		So(s.Max, ShouldEqual, time.Minute)
+
+		So(stats[1].Method, ShouldEqual, "OTHER")
+		So(stats[1].Count, ShouldEqual, 2)
+		So(stats[1].Buckets, ShouldResemble, []int64{0, 2})
+	})
+
+	Convey("Export statistics by metrics", t, func() {
+		m := New()
+		m.Map(log.New(bytes.NewBufferString(""), "[Macaron] ", 0))
+		m.Use(Logger(LoggerOptions{
+			CollectStats:   true,
+			LatencyBuckets: []time.Duration{time.Minute, time.Second},
+		}))
+		m.Get("/user/:id", func() {})
+		metrics := NewMetrics(MetricsOptions{Stats: m.Stats})
+		m.Get("/metrics", metrics.ServeHTTP)
+
+		var resp *httptest.ResponseRecorder
+		for _, url := range []string{"/user/1", "/metrics"} {
+			resp = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+		}
+
+		body := resp.Body.String()
+		So(body, ShouldContainSubstring, "# TYPE http_route_latency_seconds histogram\n")
+		So(body, ShouldContainSubstring, `http_route_latency_seconds_bucket{method="GET",route="/user/:id",le="1"} 1`+"\n")
+		So(body, ShouldContainSubstring, `http_route_latency_seconds_bucket{method="GET",route="/user/:id",le="60"} 1`+"\n")
+		So(body, ShouldContainSubstring, `http_route_latency_seconds_count{method="GET",route="/user/:id"} 1`+"\n")
+	})
+}
@@ -0,0 +1,211 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-macaron/inject"
+)
+
+// TimeoutOptions is a struct for specifying configuration options for the macaron.Timeout middleware.
+type TimeoutOptions struct {
+	// Status is the status code of response when the deadline passes.
+	// Default is 503 Service Unavailable, 504 Gateway Timeout suits better for proxies.
+	Status int
+	// Body is the response body when the deadline passes. Default is the status text.
+	Body string
+	// ContentType is the content type of Body. Default is "text/plain; charset=UTF-8".
+	ContentType string
+}
+
+func prepareTimeoutOptions(options []TimeoutOptions) TimeoutOptions {
+	var opt TimeoutOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Status == 0 {
+		opt.Status = http.StatusServiceUnavailable
+	}
+	if len(opt.Body) == 0 {
+		opt.Body = http.StatusText(opt.Status)
+	}
+	if len(opt.ContentType) == 0 {
+		opt.ContentType = _CONTENT_PLAIN + "; charset=" + _DEFAULT_CHARSET
+	}
+	return opt
+}
+
+// timeoutWriter holds the response of handlers until they finish,
+// and discards anything written after the deadline has passed.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+// handlerPanic carries a panic recovered in another goroutine along with its stack,
+// so that Recovery can report where it actually happened.
+type handlerPanic struct {
+	value  interface{}
+	frames []StackFrame
+}
+
+func (p *handlerPanic) String() string {
+	return fmt.Sprint(p.value)
+}
+
+// recoveredPanic wraps the value recovered in a deferred function with stack of the panic,
+// unless it has been wrapped.
+func recoveredPanic(err interface{}) *handlerPanic {
+	if p, ok := err.(*handlerPanic); ok {
+		return p
+	}
+	return &handlerPanic{err, callers(4)}
+}
+
+// fork returns a copy of the context to run rest of handlers in another goroutine,
+// services mapped by them do not affect the original context. The original context is
+// retained because the copy shares its data and may outlive the request.
+func (c *Context) fork(req *http.Request, rw ResponseWriter) *Context {
+	c.retained = true
+	fc := new(Context)
+	*fc = *c
+	fc.Injector = inject.New()
+	fc.SetParent(c.Injector)
+	fc.mapped = append([]reflect.Type(nil), c.mapped...)
+	fc.named = nil
+	fc.providing = nil
+	fc.Map(fc)
+	fc.Req = Request{req}
+	fc.Map(req)
+	fc.setResponseWriter(rw)
+	return fc
+}
+
+// Timeout returns a middleware handler that cancels context of the request when handlers after it
+// do not finish within given duration, and responds with 503 Service Unavailable or as configured.
+// Handlers keep running in the background until they return, so slow operations should give up
+// when ctx.Req.Context() is done; anything they write after the deadline is discarded.
+//
+// Response is held until handlers finish, which means streaming responses are not supported,
+// and handlers cannot reuse services mapped in the original context after the deadline.
+func Timeout(d time.Duration, options ...TimeoutOptions) Handler {
+	opt := prepareTimeoutOptions(options)
+
+	return func(ctx *Context) {
+		reqCtx, cancel := context.WithTimeout(ctx.Req.Context(), d)
+		defer cancel()
+
+		orig := ctx.Resp
+		tw := &timeoutWriter{header: make(http.Header)}
+		tc := ctx.fork(ctx.Req.WithContext(reqCtx), NewResponseWriter(tw))
+
+		done := make(chan struct{})
+		panicChan := make(chan *handlerPanic, 1)
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					p := recoveredPanic(err)
+					tw.mu.Lock()
+					defer tw.mu.Unlock()
+					if !tw.timedOut {
+						panicChan <- p
+						return
+					}
+
+					opt := RecoveryOptions{}
+					if tc.recovery != nil {
+						opt = *tc.recovery
+					}
+					logPanic(opt, tc, tc.m.ErrorLogger(), p.value, p.frames)
+				}
+			}()
+			tc.Next()
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			ctx.setResponseWriter(orig)
+			panic(p)
+		case <-done:
+			ctx.setResponseWriter(orig)
+			// Handlers after this one have been run.
+			ctx.index = tc.index
+
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			header := orig.Header()
+			for k, v := range tw.header {
+				header[k] = v
+			}
+			if tw.status != 0 {
+				orig.WriteHeader(tw.status)
+				orig.Write(tw.buf.Bytes())
+			}
+		case <-reqCtx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+
+			select {
+			case p := <-panicChan:
+				ctx.setResponseWriter(orig)
+				panic(p)
+			default:
+			}
+
+			orig.Header().Set(_CONTENT_TYPE, opt.ContentType)
+			orig.WriteHeader(opt.Status)
+			orig.Write([]byte(opt.Body))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Timeout(t *testing.T) {
+	Convey("Respond before deadline", t, func() {
+		m := New()
+		m.Use(Timeout(time.Second))
+		m.Get("/", func(ctx *Context) {
+			ctx.Resp.Header().Set("X-Custom", "yes")
+			ctx.Resp.WriteHeader(http.StatusCreated)
+			ctx.Resp.Write([]byte("created"))
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusCreated)
+		So(resp.Header().Get("X-Custom"), ShouldEqual, "yes")
+		So(resp.Body.String(), ShouldEqual, "created")
+	})
+
+	Convey("Respond after deadline", t, func() {
+		canceled := make(chan error, 1)
+		m := New()
+		m.Use(Timeout(10 * time.Millisecond))
+		m.Get("/", func(ctx *Context) string {
+			<-ctx.Req.Context().Done()
+			canceled <- ctx.Req.Context().Err()
+			return "late"
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(resp.Body.String(), ShouldEqual, "Service Unavailable")
+		So(<-canceled, ShouldNotBeNil)
+	})
+
+	Convey("Respond after deadline with custom response", t, func() {
+		m := New()
+		m.Use(Timeout(10*time.Millisecond, TimeoutOptions{
+			Status:      http.StatusGatewayTimeout,
+			Body:        `{"error":"timeout"}`,
+			ContentType: "application/json",
+		}))
+		m.Get("/", func(ctx *Context) {
+			<-ctx.Req.Context().Done()
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusGatewayTimeout)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(resp.Body.String(), ShouldEqual, `{"error":"timeout"}`)
+	})
+
+	Convey("Recover panic of handler", t, func() {
+		buf := new(bytes.Buffer)
+		m := NewWithLogger(buf)
+		m.Use(Recovery())
+		m.Use(Timeout(time.Second))
+		m.Get("/", func() {
+			panic("here is a panic!")
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		So(err, ShouldBeNil)
+		m.ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(buf.String(), ShouldContainSubstring, "PANIC: here is a panic!")
+		So(buf.String(), ShouldContainSubstring, "timeout_test.go")
+	})
+}
@@ -0,0 +1,460 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TLSCertificate is a pair of PEM files of a certificate chain and its private key.
+type TLSCertificate struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertReloader keeps certificates loaded from files, which are selected by server names that clients
+// request by SNI, and reloaded when files change, so certificates can be renewed without restarting.
+type CertReloader struct {
+	files []TLSCertificate
+
+	lock     sync.RWMutex
+	certs    []*tls.Certificate
+	modTimes []time.Time
+}
+
+// NewCertReloader loads certificates from files, the first one is used when none matches
+// the server name requested.
+func NewCertReloader(files ...TLSCertificate) (*CertReloader, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no certificate file given")
+	}
+	r := &CertReloader{files: files}
+	return r, r.Reload()
+}
+
+// modTime returns the latest modification time of files of the certificate.
+func (f TLSCertificate) modTime() time.Time {
+	t := fileModTime(f.CertFile)
+	if keyTime := fileModTime(f.KeyFile); keyTime.After(t) {
+		return keyTime
+	}
+	return t
+}
+
+// Reload loads all certificates from files again. Certificates in use are kept if any of them fails to load.
+func (r *CertReloader) Reload() error {
+	certs := make([]*tls.Certificate, len(r.files))
+	modTimes := make([]time.Time, len(r.files))
+	for i, f := range r.files {
+		modTimes[i] = f.modTime()
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.CertFile, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("%s: %v", f.CertFile, err)
+			}
+		}
+		certs[i] = &cert
+	}
+
+	r.lock.Lock()
+	r.certs, r.modTimes = certs, modTimes
+	r.lock.Unlock()
+	return nil
+}
+
+// changed returns true if any of files has been modified since loaded.
+func (r *CertReloader) changed() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for i, f := range r.files {
+		if !f.modTime().Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchServerName returns true if the name matches the pattern, which can be a wildcard like "*.example.com".
+func matchServerName(pattern, name string) bool {
+	if strings.EqualFold(pattern, name) {
+		return true
+	}
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	i := strings.IndexByte(name, '.')
+	return i > 0 && strings.EqualFold(pattern[1:], name[i:])
+}
+
+// GetCertificate returns the certificate whose names match the server name requested, preferring exact
+// names to wildcards, or the first certificate if none matches. It is used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	name := strings.TrimSuffix(hello.ServerName, ".")
+	if len(name) > 0 {
+		for _, cert := range r.certs {
+			for _, dnsName := range cert.Leaf.DNSNames {
+				if strings.EqualFold(dnsName, name) {
+					return cert, nil
+				}
+			}
+		}
+		for _, cert := range r.certs {
+			for _, dnsName := range cert.Leaf.DNSNames {
+				if matchServerName(dnsName, name) {
+					return cert, nil
+				}
+			}
+		}
+	}
+	return r.certs[0], nil
+}
+
+// Watch starts reloading certificates when the process receives SIGHUP, and when files change if interval
+// is positive, which is how often files are checked. Errors of reloading are given to onError if not nil.
+// It returns a function to stop watching.
+func (r *CertReloader) Watch(interval time.Duration, onError func(error)) (stop func()) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sighup:
+			case <-tick:
+				if !r.changed() {
+					continue
+				}
+			}
+			if err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sighup)
+			if ticker != nil {
+				ticker.Stop()
+			}
+			close(done)
+		})
+	}
+}
+
+// TLSOptions is a struct for specifying configuration options of TLS for macaron.RunTLS.
+type TLSOptions struct {
+	// CertFile and KeyFile are PEM files of the certificate chain and private key of the server.
+	CertFile string
+	KeyFile  string
+	// Certificates are additional certificates, selected by server names that clients request by SNI,
+	// e.g. for multiple domains. The certificate of CertFile is used when none matches.
+	Certificates []TLSCertificate
+	// ReloadInterval is how often certificate files are checked for changes, which are reloaded
+	// without restarting the server. Default is 0, in which case they are only reloaded on SIGHUP.
+	ReloadInterval time.Duration
+	// ClientCAFile is the PEM file of certificate authorities that client certificates are verified by,
+	// which enables mutual TLS.
+	ClientCAFile string
+	// ClientAuth is the policy for client certificates. Default is tls.RequireAndVerifyClientCert
+	// if ClientCAFile is set, or tls.NoClientCert otherwise.
+	ClientAuth tls.ClientAuthType
+	// MinVersion is the minimum TLS version accepted. Default is TLS 1.2.
+	MinVersion uint16
+}
+
+// NewTLSConfig returns the TLS configuration of server by options, loading certificates from files.
+func NewTLSConfig(opt TLSOptions) (*tls.Config, error) {
+	config, _, err := newTLSConfig(opt)
+	return config, err
+}
+
+// newTLSConfig returns the TLS configuration of server, and the reloader of its certificates.
+func newTLSConfig(opt TLSOptions) (*tls.Config, *CertReloader, error) {
+	var files []TLSCertificate
+	if len(opt.CertFile) > 0 || len(opt.KeyFile) > 0 {
+		files = append(files, TLSCertificate{opt.CertFile, opt.KeyFile})
+	}
+	reloader, err := NewCertReloader(append(files, opt.Certificates...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     opt.ClientAuth,
+		MinVersion:     opt.MinVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if len(opt.ClientCAFile) > 0 {
+		data, err := ioutil.ReadFile(opt.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("no certificate found in %s", opt.ClientCAFile)
+		}
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, reloader, nil
+}
+
+// RunTLS starts serving HTTPS with the TLS configuration of options, e.g. for mutual TLS
+// between internal services:
+//
+//	m.RunTLS(macaron.TLSOptions{
+//		CertFile:     "server.crt",
+//		KeyFile:      "server.key",
+//		ClientCAFile: "clients-ca.crt",
+//	}, 8443)
+//
+// Host and port are given as Run does. Certificates are reloaded when the process receives SIGHUP,
+// or when files change if ReloadInterval is set, and the ones in use are kept if reloading fails.
+func (m *Macaron) RunTLS(opt TLSOptions, args ...interface{}) {
+	addr := listenAddr(args)
+	logger := m.GetVal(reflect.TypeOf(m.logger)).Interface().(*log.Logger)
+	logger.Printf("listening on %s with TLS (%s)\n", addr, safeEnv())
+
+	config, reloader, err := newTLSConfig(opt)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	defer reloader.Watch(opt.ReloadInterval, func(err error) {
+		m.ErrorLogger().Printf("fail to reload certificates: %v", err)
+	})()
+	l, err := m.listen(addr)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	server := &http.Server{Handler: m, TLSConfig: config}
+	logger.Fatalln(server.Serve(tls.NewListener(l, config)))
+}
+
+// ClientCertInfo is the information of the certificate that the client presented.
+type ClientCertInfo struct {
+	// Subject and Issuer are distinguished names, e.g. "CN=billing,O=Example".
+	Subject    string
+	Issuer     string
+	CommonName string
+	// Subject alternative names.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []string
+	URIs           []string
+	SerialNumber   string
+	// Fingerprint is the hex-encoded SHA-256 hash of the certificate in DER.
+	Fingerprint string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	// Verified is true if the certificate has been verified by certificate authorities,
+	// either of the server or of the trusted proxy that forwarded it.
+	Verified    bool
+	Certificate *x509.Certificate
+}
+
+// NewClientCertInfo returns the information of the certificate.
+func NewClientCertInfo(cert *x509.Certificate, verified bool) *ClientCertInfo {
+	sum := sha256.Sum256(cert.Raw)
+	info := &ClientCertInfo{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		SerialNumber:   cert.SerialNumber.String(),
+		Fingerprint:    hex.EncodeToString(sum[:]),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		Verified:       verified,
+		Certificate:    cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		info.URIs = append(info.URIs, u.String())
+	}
+	return info
+}
+
+// SANs returns all subject alternative names of the certificate.
+func (info *ClientCertInfo) SANs() []string {
+	sans := make([]string, 0, len(info.DNSNames)+len(info.EmailAddresses)+len(info.IPAddresses)+len(info.URIs))
+	sans = append(sans, info.DNSNames...)
+	sans = append(sans, info.EmailAddresses...)
+	sans = append(sans, info.IPAddresses...)
+	return append(sans, info.URIs...)
+}
+
+// ClientCertOptions is a struct for specifying configuration options for macaron.ClientCert
+// and macaron.RequireClientCert.
+type ClientCertOptions struct {
+	// Header is the request header that a TLS-terminating proxy forwards the client certificate in,
+	// as URL-escaped PEM, e.g. $ssl_client_escaped_cert of nginx. It is only accepted from trusted
+	// proxies set by SetTrustedProxies, and the certificate is deemed verified by the proxy.
+	Header string
+	// AllowedSubjects are common names of certificates allowed by RequireClientCert.
+	AllowedSubjects []string
+	// AllowedSANs are subject alternative names of certificates allowed by RequireClientCert,
+	// i.e. DNS names, email addresses, IP addresses or URIs like "spiffe://example.org/billing".
+	AllowedSANs []string
+	// AllowedFingerprints are hex-encoded SHA-256 fingerprints of certificates allowed by RequireClientCert.
+	AllowedFingerprints []string
+}
+
+func prepareClientCertOptions(options []ClientCertOptions) ClientCertOptions {
+	var opt ClientCertOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Fingerprints may be written in uppercase or separated by colons, e.g. by openssl.
+	fingerprints := make([]string, len(opt.AllowedFingerprints))
+	for i, s := range opt.AllowedFingerprints {
+		fingerprints[i] = strings.ToLower(strings.Replace(s, ":", "", -1))
+	}
+	opt.AllowedFingerprints = fingerprints
+	return opt
+}
+
+// allowed returns true if the certificate matches any of allowed subjects, SANs or fingerprints,
+// or there is no restriction.
+func (opt ClientCertOptions) allowed(info *ClientCertInfo) bool {
+	if len(opt.AllowedSubjects) == 0 && len(opt.AllowedSANs) == 0 && len(opt.AllowedFingerprints) == 0 {
+		return true
+	}
+	for _, s := range opt.AllowedSubjects {
+		if s == info.CommonName {
+			return true
+		}
+	}
+	for _, san := range info.SANs() {
+		for _, s := range opt.AllowedSANs {
+			if s == san {
+				return true
+			}
+		}
+	}
+	for _, s := range opt.AllowedFingerprints {
+		if s == info.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedCert parses the URL-escaped PEM certificate forwarded by a proxy.
+func parseForwardedCert(value string) (*x509.Certificate, error) {
+	data, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// clientCertInfo returns the information of certificate the client presented, or nil if there is none.
+func (ctx *Context) clientCertInfo(opt ClientCertOptions) *ClientCertInfo {
+	if tlsState := ctx.Req.TLS; tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+		return NewClientCertInfo(tlsState.PeerCertificates[0], len(tlsState.VerifiedChains) > 0)
+	}
+
+	if len(opt.Header) == 0 || !ctx.fromTrustedProxy() {
+		return nil
+	}
+	value := ctx.Req.Header.Get(opt.Header)
+	if len(value) == 0 {
+		return nil
+	}
+	cert, err := parseForwardedCert(value)
+	if err != nil {
+		if ctx.Router != nil && ctx.m != nil {
+			ctx.m.ErrorLogger().Printf("%sinvalid client certificate in %s: %v", requestTag(ctx), opt.Header, err)
+		}
+		return nil
+	}
+	return NewClientCertInfo(cert, true)
+}
+
+// ClientCert returns a middleware handler that maps *ClientCertInfo of the certificate
+// the client presented, which is nil if there is none.
+func ClientCert(options ...ClientCertOptions) Handler {
+	opt := prepareClientCertOptions(options)
+	return Provides(func(ctx *Context) {
+		ctx.Map(ctx.clientCertInfo(opt))
+	}, (*ClientCertInfo)(nil))
+}
+
+// RequireClientCert returns a middleware handler that authenticates clients by certificates, e.g. for
+// service-to-service APIs over mutual TLS. It responds with 401 Unauthorized if the client presents
+// no verified certificate, or 403 Forbidden if the certificate is not allowed by options, otherwise it
+// maps *ClientCertInfo of the certificate.
+func RequireClientCert(options ...ClientCertOptions) Handler {
+	opt := prepareClientCertOptions(options)
+	return Provides(func(ctx *Context) {
+		info := ctx.clientCertInfo(opt)
+		if info == nil || !info.Verified {
+			writeErrorPage(ctx, ctx.Resp, http.StatusUnauthorized, "")
+			return
+		}
+		if !opt.allowed(info) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusForbidden, "")
+			return
+		}
+		ctx.Map(info)
+	}, (*ClientCertInfo)(nil))
+}
@@ -0,0 +1,340 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by the parent, or a self-signed CA if parent is nil.
+func newTestCert(t *testing.T, parent *testCert, tpl *x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tpl.NotBefore = time.Now().Add(-time.Hour)
+	tpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// serveTLS serves the handler by TLS configuration, and returns the address of server,
+// unlike httptest.Server which adds its own certificate to configurations without one.
+func serveTLS(h http.Handler, config *tls.Config) (addr string, close func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	go http.Serve(tls.NewListener(l, config), h)
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	cert, _ := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	return cert
+}
+
+func Test_NewTLSConfig(t *testing.T) {
+	Convey("Load TLS configuration", t, func() {
+		dir, err := ioutil.TempDir("", "macaron-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca := newTestCert(t, nil, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}})
+		server := newTestCert(t, ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "server"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		})
+		ioutil.WriteFile(filepath.Join(dir, "ca.crt"), ca.certPEM, 0644)
+		ioutil.WriteFile(filepath.Join(dir, "server.crt"), server.certPEM, 0644)
+		ioutil.WriteFile(filepath.Join(dir, "server.key"), server.keyPEM, 0600)
+
+		opt := TLSOptions{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")}
+		config, err := NewTLSConfig(opt)
+		So(err, ShouldBeNil)
+		So(config.ClientAuth, ShouldEqual, tls.NoClientCert)
+		So(config.MinVersion, ShouldEqual, tls.VersionTLS12)
+
+		opt.ClientCAFile = filepath.Join(dir, "ca.crt")
+		config, err = NewTLSConfig(opt)
+		So(err, ShouldBeNil)
+		So(config.ClientAuth, ShouldEqual, tls.RequireAndVerifyClientCert)
+		So(config.ClientCAs, ShouldNotBeNil)
+
+		opt.ClientAuth = tls.VerifyClientCertIfGiven
+		config, err = NewTLSConfig(opt)
+		So(err, ShouldBeNil)
+		So(config.ClientAuth, ShouldEqual, tls.VerifyClientCertIfGiven)
+
+		opt.ClientCAFile = filepath.Join(dir, "server.key")
+		_, err = NewTLSConfig(opt)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewTLSConfig(TLSOptions{CertFile: "404.crt", KeyFile: "404.key"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_RequireClientCert(t *testing.T) {
+	Convey("Authenticate clients by certificates", t, func() {
+		dir, err := ioutil.TempDir("", "macaron-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca := newTestCert(t, nil, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}})
+		server := newTestCert(t, ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "server"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		})
+		spiffe, _ := url.Parse("spiffe://example.org/billing")
+		billing := newTestCert(t, ca, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+			DNSNames: []string{"billing.internal"},
+			URIs:     []*url.URL{spiffe},
+		})
+		other := newTestCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}})
+		ioutil.WriteFile(filepath.Join(dir, "ca.crt"), ca.certPEM, 0644)
+		ioutil.WriteFile(filepath.Join(dir, "server.crt"), server.certPEM, 0644)
+		ioutil.WriteFile(filepath.Join(dir, "server.key"), server.keyPEM, 0600)
+
+		config, err := NewTLSConfig(TLSOptions{
+			CertFile:     filepath.Join(dir, "server.crt"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.crt"),
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		})
+		So(err, ShouldBeNil)
+
+		m := New()
+		m.Get("/whoami", ClientCert(), func(info *ClientCertInfo) string {
+			if info == nil {
+				return "anonymous"
+			}
+			return info.CommonName
+		})
+		m.Get("/billing", RequireClientCert(ClientCertOptions{AllowedSANs: []string{"spiffe://example.org/billing"}}),
+			func(info *ClientCertInfo) string {
+				return info.Subject + " " + info.DNSNames[0]
+			})
+		m.Get("/fingerprint", RequireClientCert(), func(info *ClientCertInfo) string {
+			return info.Fingerprint
+		})
+
+		addr, stop := serveTLS(m, config)
+		defer stop()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		get := func(cert *testCert, path string) (int, string) {
+			tlsConfig := &tls.Config{RootCAs: roots}
+			if cert != nil {
+				tlsConfig.Certificates = []tls.Certificate{cert.tlsCertificate()}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			resp, err := client.Get("https://" + addr + path)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			data, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, string(data)
+		}
+
+		_, body := get(nil, "/whoami")
+		So(body, ShouldEqual, "anonymous")
+		_, body = get(billing, "/whoami")
+		So(body, ShouldEqual, "billing")
+
+		status, body := get(billing, "/billing")
+		So(status, ShouldEqual, http.StatusOK)
+		So(body, ShouldEqual, "CN=billing,O=Example billing.internal")
+		status, _ = get(other, "/billing")
+		So(status, ShouldEqual, http.StatusForbidden)
+		status, _ = get(nil, "/billing")
+		So(status, ShouldEqual, http.StatusUnauthorized)
+
+		_, body = get(other, "/fingerprint")
+		So(body, ShouldEqual, NewClientCertInfo(other.cert, true).Fingerprint)
+		So(len(body), ShouldEqual, 64)
+	})
+
+	Convey("Accept certificates forwarded by trusted proxies", t, func() {
+		ca := newTestCert(t, nil, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}})
+		billing := newTestCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})
+		fingerprint := NewClientCertInfo(billing.cert, true).Fingerprint
+		other := newTestCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}})
+
+		// Fingerprints are accepted in the format of openssl, e.g. "AB:CD:...".
+		var pairs []string
+		for i := 0; i < len(fingerprint); i += 2 {
+			pairs = append(pairs, strings.ToUpper(fingerprint[i:i+2]))
+		}
+		opensslFingerprint := strings.Join(pairs, ":")
+
+		m := New()
+		So(m.SetTrustedProxies("10.0.0.1"), ShouldBeNil)
+		m.Get("/", RequireClientCert(ClientCertOptions{
+			Header:              "X-Client-Cert",
+			AllowedFingerprints: []string{opensslFingerprint},
+		}), func() string { return "ok" })
+		m.Get("/any", RequireClientCert(ClientCertOptions{Header: "X-Client-Cert"}), func(info *ClientCertInfo) string {
+			return info.CommonName
+		})
+
+		do := func(path, remoteAddr, cert string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", path, nil)
+			So(err, ShouldBeNil)
+			req.RemoteAddr = remoteAddr
+			if len(cert) > 0 {
+				req.Header.Set("X-Client-Cert", cert)
+			}
+			m.ServeHTTP(resp, req)
+			return resp
+		}
+
+		escaped := url.QueryEscape(string(billing.certPEM))
+		resp := do("/any", "10.0.0.1:1234", escaped)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "billing")
+		So(do("/any", "192.0.2.1:1234", escaped).Code, ShouldEqual, http.StatusUnauthorized)
+		So(do("/any", "10.0.0.1:1234", "garbage").Code, ShouldEqual, http.StatusUnauthorized)
+
+		So(do("/", "10.0.0.1:1234", escaped).Code, ShouldEqual, http.StatusOK)
+		So(do("/", "10.0.0.1:1234", url.QueryEscape(string(other.certPEM))).Code, ShouldEqual, http.StatusForbidden)
+	})
+}
+
+func Test_CertReloader(t *testing.T) {
+	Convey("Select certificates by SNI and reload them", t, func() {
+		dir, err := ioutil.TempDir("", "macaron-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca := newTestCert(t, nil, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}})
+		write := func(name string, cert *testCert) TLSCertificate {
+			f := TLSCertificate{filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")}
+			ioutil.WriteFile(f.CertFile, cert.certPEM, 0644)
+			ioutil.WriteFile(f.KeyFile, cert.keyPEM, 0600)
+			return f
+		}
+		defaultCert := newTestCert(t, ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "default"},
+			DNSNames:    []string{"example.com"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		})
+		wildcard := newTestCert(t, ca, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "wildcard"},
+			DNSNames: []string{"*.example.org"},
+		})
+		exact := newTestCert(t, ca, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "exact"},
+			DNSNames: []string{"api.example.org"},
+		})
+
+		_, err = NewTLSConfig(TLSOptions{})
+		So(err, ShouldNotBeNil)
+
+		config, reloader, err := newTLSConfig(TLSOptions{
+			CertFile:     write("default", defaultCert).CertFile,
+			KeyFile:      filepath.Join(dir, "default.key"),
+			Certificates: []TLSCertificate{write("wildcard", wildcard), write("exact", exact)},
+		})
+		So(err, ShouldBeNil)
+
+		addr, stop := serveTLS(New(), config)
+		defer stop()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		commonName := func(serverName string) string {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: serverName})
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		}
+		So(commonName("example.com"), ShouldEqual, "default")
+		So(commonName("API.example.org"), ShouldEqual, "exact")
+		So(commonName("www.example.org"), ShouldEqual, "wildcard")
+		So(commonName("127.0.0.1"), ShouldEqual, "default")
+
+		Convey("Reload certificates when files change", func() {
+			stopWatching := reloader.Watch(10*time.Millisecond, nil)
+			defer stopWatching()
+
+			renewed := newTestCert(t, ca, &x509.Certificate{
+				Subject:  pkix.Name{CommonName: "renewed"},
+				DNSNames: []string{"*.example.org"},
+			})
+			f := write("wildcard", renewed)
+			future := time.Now().Add(time.Minute)
+			os.Chtimes(f.CertFile, future, future)
+			os.Chtimes(f.KeyFile, future, future)
+
+			for i := 0; i < 100 && reloader.changed(); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(commonName("www.example.org"), ShouldEqual, "renewed")
+		})
+
+		Convey("Keep certificates in use if reloading fails", func() {
+			ioutil.WriteFile(filepath.Join(dir, "exact.key"), []byte("garbage"), 0600)
+			So(reloader.Reload(), ShouldNotBeNil)
+			So(commonName("api.example.org"), ShouldEqual, "exact")
+		})
+	})
+}
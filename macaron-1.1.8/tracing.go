@@ -0,0 +1,209 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Span is a span of request created by Tracer.
+type Span interface {
+	// SetStatus records the status code of response, and the error if the request failed.
+	SetStatus(status int, err error)
+	// End finishes the span.
+	End()
+}
+
+// Tracer starts server spans of requests, which joins the trace propagated by headers of the request.
+// It is implemented by W3CTracer, and can be implemented by an adapter of OpenTelemetry, e.g.
+//
+//	func (t otelTracer) Start(req *http.Request, name string) (context.Context, macaron.Span) {
+//		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+//		ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	// Start starts a span with given name, and returns a context of the request that contains the span.
+	Start(req *http.Request, name string) (context.Context, Span)
+}
+
+// TraceSpan is a span of W3CTracer.
+type TraceSpan struct {
+	// TraceID is the hex-encoded ID of the trace.
+	TraceID string
+	// SpanID is the hex-encoded ID of the span.
+	SpanID string
+	// ParentID is the hex-encoded ID of the parent span, empty for root spans.
+	ParentID string
+	// TraceState is the vendor-specific trace state propagated from the parent.
+	TraceState string
+	Sampled    bool
+	Name       string
+	StartTime  time.Time
+	EndTime    time.Time
+	Status     int
+	Err        error
+
+	onEnd func(*TraceSpan)
+}
+
+// SetStatus records the status code of response, and the error if the request failed.
+func (s *TraceSpan) SetStatus(status int, err error) {
+	s.Status = status
+	s.Err = err
+}
+
+// End finishes the span.
+func (s *TraceSpan) End() {
+	s.EndTime = time.Now()
+	if s.Sampled && s.onEnd != nil {
+		s.onEnd(s)
+	}
+}
+
+// TraceParent returns the value of traceparent header that makes the span parent of others.
+func (s *TraceSpan) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+type traceSpanKey struct{}
+
+// SpanFromContext returns the span of W3CTracer in the context, or nil if there is none.
+func SpanFromContext(ctx context.Context) *TraceSpan {
+	s, _ := ctx.Value(traceSpanKey{}).(*TraceSpan)
+	return s
+}
+
+// InjectTraceParent sets traceparent and tracestate headers by the span in the context,
+// so that downstream requests join the trace.
+func InjectTraceParent(ctx context.Context, header http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	header.Set("traceparent", s.TraceParent())
+	if len(s.TraceState) > 0 {
+		header.Set("tracestate", s.TraceState)
+	}
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	// IDs of all zeros are invalid.
+	return err == nil && strings.Trim(s, "0") != ""
+}
+
+// parseTraceParent parses the traceparent header, and returns trace ID, parent ID and
+// whether the parent is sampled, or false if the header is not valid.
+func parseTraceParent(value string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", "", false, false
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), flags[0]&1 == 1, true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("tracing: fail to generate ID: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// W3CTracer is a Tracer that propagates traces by W3C Trace Context headers,
+// and calls OnEnd with every sampled span that ends, e.g. to export it.
+type W3CTracer struct {
+	OnEnd func(*TraceSpan)
+}
+
+// Start starts a span that joins the trace of traceparent header if it is valid,
+// or a new trace otherwise. The span can be retrieved by SpanFromContext.
+func (t *W3CTracer) Start(req *http.Request, name string) (context.Context, Span) {
+	s := &TraceSpan{
+		SpanID:    randomHex(8),
+		Sampled:   true,
+		Name:      name,
+		StartTime: time.Now(),
+		onEnd:     t.OnEnd,
+	}
+	if traceID, parentID, sampled, ok := parseTraceParent(req.Header.Get("traceparent")); ok {
+		s.TraceID, s.ParentID, s.Sampled = traceID, parentID, sampled
+		s.TraceState = req.Header.Get("tracestate")
+	} else {
+		s.TraceID = randomHex(16)
+	}
+	return context.WithValue(req.Context(), traceSpanKey{}, s), s
+}
+
+// Tracing returns a middleware handler that starts a span for every request, which is named
+// by method and route pattern, e.g. "GET /users/:id", and records status of the response,
+// or the panic of handlers. Context of the request is replaced with the one containing the span,
+// so downstream calls made with ctx.Context() join the trace. Span is also mapped as a service.
+func Tracing(tracer Tracer) Handler {
+	return Provides(func(ctx *Context) {
+		name := ctx.Req.Method + " " + ctx.RoutePattern()
+		if len(ctx.RoutePattern()) == 0 {
+			name = ctx.Req.Method
+		}
+		reqCtx, span := tracer.Start(ctx.Req.Request, name)
+		ctx.Req.Request = ctx.Req.WithContext(reqCtx)
+		ctx.Map(ctx.Req.Request)
+		ctx.MapTo(span, (*Span)(nil))
+
+		rw := ctx.Resp
+		defer func() {
+			if err := recover(); err != nil {
+				p := recoveredPanic(err)
+				span.SetStatus(http.StatusInternalServerError, fmt.Errorf("panic: %v", p.value))
+				span.End()
+				panic(p)
+			}
+
+			status := rw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			var err error
+			if status >= 500 {
+				err = fmt.Errorf("%d %s", status, http.StatusText(status))
+			}
+			span.SetStatus(status, err)
+			span.End()
+		}()
+
+		ctx.Next()
+	}, (*Span)(nil))
+}
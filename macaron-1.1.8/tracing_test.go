@@ -0,0 +1,122 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_parseTraceParent(t *testing.T) {
+	Convey("Parse traceparent header", t, func() {
+		traceID, parentID, sampled, ok := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		So(ok, ShouldBeTrue)
+		So(traceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+		So(parentID, ShouldEqual, "00f067aa0ba902b7")
+		So(sampled, ShouldBeTrue)
+
+		_, _, sampled, ok = parseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+		So(ok, ShouldBeTrue)
+		So(sampled, ShouldBeFalse)
+
+		for _, value := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+		} {
+			_, _, _, ok = parseTraceParent(value)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
+
+func Test_Tracing(t *testing.T) {
+	Convey("Trace requests", t, func() {
+		var spans []*TraceSpan
+		m := New()
+		m.Use(Recovery())
+		m.Use(Tracing(&W3CTracer{OnEnd: func(s *TraceSpan) { spans = append(spans, s) }}))
+		m.Get("/users/:id", func(ctx *Context) string {
+			header := make(http.Header)
+			InjectTraceParent(ctx.Context(), header)
+			return header.Get("traceparent")
+		})
+		m.Get("/panic", func() { panic("oops") })
+		So(m.Check(), ShouldBeEmpty)
+
+		Convey("Join propagated trace", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/users/1", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			req.Header.Set("tracestate", "vendor=value")
+			m.ServeHTTP(resp, req)
+
+			So(spans, ShouldHaveLength, 1)
+			s := spans[0]
+			So(s.Name, ShouldEqual, "GET /users/:id")
+			So(s.TraceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(s.ParentID, ShouldEqual, "00f067aa0ba902b7")
+			So(s.TraceState, ShouldEqual, "vendor=value")
+			So(s.Status, ShouldEqual, http.StatusOK)
+			So(s.Err, ShouldBeNil)
+			So(resp.Body.String(), ShouldEqual, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+s.SpanID+"-01")
+		})
+
+		Convey("Start new trace", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/users/1", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].TraceID, ShouldHaveLength, 32)
+			So(spans[0].ParentID, ShouldBeBlank)
+		})
+
+		Convey("Do not export unsampled spans", func() {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/users/1", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+			m.ServeHTTP(resp, req)
+
+			So(spans, ShouldBeEmpty)
+			So(resp.Body.String(), ShouldEndWith, "-00")
+		})
+
+		Convey("Record panic", func() {
+			buf := new(bytes.Buffer)
+			m.SetLogOutputs(buf, buf)
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/panic", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].Status, ShouldEqual, http.StatusInternalServerError)
+			So(spans[0].Err.Error(), ShouldEqual, "panic: oops")
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package macaron
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -33,6 +34,8 @@ type Leaf struct {
 	optional   bool
 
 	handle     Handle
+	// Serves requests with the context that params are matched into, if not nil.
+	serve      func(*Context)
 }
 
 var wildcardPattern = regexp.MustCompile(`:[a-zA-Z0-9]+`)
@@ -95,15 +98,18 @@ func getRawPattern(rawPattern string) string {
 			break
 		}
 
-		closeIdx := strings.Index(rawPattern, ")")
-		if closeIdx > -1 {
-			rawPattern = rawPattern[:startIdx] + rawPattern[closeIdx + 1:]
+		// Unbalanced parentheses are kept, they are rejected when the regexp is compiled.
+		closeIdx := strings.Index(rawPattern[startIdx:], ")")
+		if closeIdx == -1 {
+			break
 		}
+		rawPattern = rawPattern[:startIdx] + rawPattern[startIdx + closeIdx + 1:]
 	}
 	return rawPattern
 }
 
-func checkPattern(pattern string) (typ patternType, rawPattern string, wildcards []string, reg *regexp.Regexp) {
+// compilePattern parses a segment of route pattern, and returns error if its regexp is invalid.
+func compilePattern(pattern string) (typ patternType, rawPattern string, wildcards []string, reg *regexp.Regexp, err error) {
 	pattern = strings.TrimLeft(pattern, "?")
 	rawPattern = getRawPattern(pattern)
 
@@ -116,20 +122,39 @@ func checkPattern(pattern string) (typ patternType, rawPattern string, wildcards
 		pattern, wildcards = getWildcards(pattern)
 		if pattern == "(.+)" {
 			typ = _PATTERN_HOLDER
-		} else {
-			reg = regexp.MustCompile(pattern)
+		} else if reg, err = regexp.Compile(pattern); err != nil {
+			return typ, rawPattern, wildcards, nil, err
 		}
 	}
+	return typ, rawPattern, wildcards, reg, nil
+}
+
+func checkPattern(pattern string) (typ patternType, rawPattern string, wildcards []string, reg *regexp.Regexp) {
+	typ, rawPattern, wildcards, reg, err := compilePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("invalid route pattern segment %q: %v", pattern, err))
+	}
 	return typ, rawPattern, wildcards, reg
 }
 
+// ValidatePattern returns error if the route pattern is malformed, e.g. with invalid regexp,
+// which makes registering the route panic.
+func ValidatePattern(pattern string) error {
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if _, _, _, _, err := compilePattern(segment); err != nil {
+			return fmt.Errorf("invalid route pattern %q: segment %q: %v", pattern, segment, err)
+		}
+	}
+	return nil
+}
+
 func NewLeaf(parent *Tree, pattern string, handle Handle) *Leaf {
 	typ, rawPattern, wildcards, reg := checkPattern(pattern)
 	optional := false
 	if len(pattern) > 0 && pattern[0] == '?' {
 		optional = true
 	}
-	return &Leaf{parent, typ, pattern, rawPattern, wildcards, reg, optional, handle}
+	return &Leaf{parent, typ, pattern, rawPattern, wildcards, reg, optional, handle, nil}
 }
 
 // URLPath build path part of URL by given pair values.
@@ -250,12 +275,12 @@ func (t *Tree) Add(pattern string, handle Handle) *Leaf {
 	return t.addNextSegment(pattern, handle)
 }
 
-func (t *Tree) matchLeaf(globLevel int, url string, params Params) (Handle, bool) {
+func (t *Tree) matchLeaf(globLevel int, url string, params *routeParams) (*Leaf, bool) {
 	for i := 0; i < len(t.leaves); i++ {
 		switch t.leaves[i].typ {
 		case _PATTERN_STATIC:
 			if t.leaves[i].pattern == url {
-				return t.leaves[i].handle, true
+				return t.leaves[i], true
 			}
 		case _PATTERN_REGEXP:
 			results := t.leaves[i].reg.FindStringSubmatch(url)
@@ -265,37 +290,37 @@ func (t *Tree) matchLeaf(globLevel int, url string, params Params) (Handle, bool
 			}
 
 			for j := 0; j < len(t.leaves[i].wildcards); j++ {
-				params[t.leaves[i].wildcards[j]] = results[j + 1]
+				params.set(t.leaves[i].wildcards[j], results[j + 1])
 			}
-			return t.leaves[i].handle, true
+			return t.leaves[i], true
 		case _PATTERN_PATH_EXT:
 			j := strings.LastIndex(url, ".")
 			if j > -1 {
-				params[":path"] = url[:j]
-				params[":ext"] = url[j + 1:]
+				params.set(":path", url[:j])
+				params.set(":ext", url[j + 1:])
 			} else {
-				params[":path"] = url
+				params.set(":path", url)
 			}
-			return t.leaves[i].handle, true
+			return t.leaves[i], true
 		case _PATTERN_HOLDER:
-			params[t.leaves[i].wildcards[0]] = url
-			return t.leaves[i].handle, true
+			params.set(t.leaves[i].wildcards[0], url)
+			return t.leaves[i], true
 		case _PATTERN_MATCH_ALL:
-			params["*"] = url
-			params["*" + com.ToStr(globLevel)] = url
-			return t.leaves[i].handle, true
+			params.set("*", url)
+			params.set("*" + com.ToStr(globLevel), url)
+			return t.leaves[i], true
 		}
 	}
 	return nil, false
 }
 
-func (t *Tree) matchSubtree(globLevel int, segment, url string, params Params) (Handle, bool) {
+func (t *Tree) matchSubtree(globLevel int, segment, url string, params *routeParams) (*Leaf, bool) {
 	for i := 0; i < len(t.subtrees); i++ {
 		switch t.subtrees[i].typ {
 		case _PATTERN_STATIC:
 			if t.subtrees[i].pattern == segment {
-				if handle, ok := t.subtrees[i].matchNextSegment(globLevel, url, params); ok {
-					return handle, true
+				if leaf, ok := t.subtrees[i].matchNextSegment(globLevel, url, params); ok {
+					return leaf, true
 				}
 			}
 		case _PATTERN_REGEXP:
@@ -305,20 +330,20 @@ func (t *Tree) matchSubtree(globLevel int, segment, url string, params Params) (
 			}
 
 			for j := 0; j < len(t.subtrees[i].wildcards); j++ {
-				params[t.subtrees[i].wildcards[j]] = results[j + 1]
+				params.set(t.subtrees[i].wildcards[j], results[j + 1])
 			}
-			if handle, ok := t.subtrees[i].matchNextSegment(globLevel, url, params); ok {
-				return handle, true
+			if leaf, ok := t.subtrees[i].matchNextSegment(globLevel, url, params); ok {
+				return leaf, true
 			}
 		case _PATTERN_HOLDER:
-			if handle, ok := t.subtrees[i].matchNextSegment(globLevel + 1, url, params); ok {
-				params[t.subtrees[i].wildcards[0]] = segment
-				return handle, true
+			if leaf, ok := t.subtrees[i].matchNextSegment(globLevel + 1, url, params); ok {
+				params.set(t.subtrees[i].wildcards[0], segment)
+				return leaf, true
 			}
 		case _PATTERN_MATCH_ALL:
-			if handle, ok := t.subtrees[i].matchNextSegment(globLevel + 1, url, params); ok {
-				params["*" + com.ToStr(globLevel)] = segment
-				return handle, true
+			if leaf, ok := t.subtrees[i].matchNextSegment(globLevel + 1, url, params); ok {
+				params.set("*" + com.ToStr(globLevel), segment)
+				return leaf, true
 			}
 		}
 	}
@@ -329,22 +354,22 @@ func (t *Tree) matchSubtree(globLevel int, segment, url string, params Params) (
 			url = segment + "/" + url
 			j := strings.LastIndex(url, ".")
 			if j > -1 {
-				params[":path"] = url[:j]
-				params[":ext"] = url[j + 1:]
+				params.set(":path", url[:j])
+				params.set(":ext", url[j + 1:])
 			} else {
-				params[":path"] = url
+				params.set(":path", url)
 			}
-			return leaf.handle, true
+			return leaf, true
 		} else if leaf.typ == _PATTERN_MATCH_ALL {
-			params["*"] = segment + "/" + url
-			params["*" + com.ToStr(globLevel)] = segment + "/" + url
-			return leaf.handle, true
+			params.set("*", segment + "/" + url)
+			params.set("*" + com.ToStr(globLevel), segment + "/" + url)
+			return leaf, true
 		}
 	}
 	return nil, false
 }
 
-func (t *Tree) matchNextSegment(globLevel int, url string, params Params) (Handle, bool) {
+func (t *Tree) matchNextSegment(globLevel int, url string, params *routeParams) (*Leaf, bool) {
 	i := strings.Index(url, "/")
 	if i == -1 {
 		return t.matchLeaf(globLevel, url, params)
@@ -353,11 +378,37 @@ func (t *Tree) matchNextSegment(globLevel int, url string, params Params) (Handl
 }
 
 func (t *Tree) Match(url string) (Handle, Params, bool) {
+	leaf, params, ok := t.MatchLeaf(url)
+	if !ok {
+		return nil, params, false
+	}
+	return leaf.handle, params, true
+}
+
+// MatchLeaf returns the leaf that matches given URL and params extracted from the URL.
+func (t *Tree) MatchLeaf(url string) (*Leaf, Params, bool) {
+	var params routeParams
+	leaf, ok := t.matchParams(url, &params)
+	return leaf, params.toMap(), ok
+}
+
+// matchParams returns the leaf that matches given URL, and sets params extracted from the URL.
+func (t *Tree) matchParams(url string, params *routeParams) (*Leaf, bool) {
 	url = strings.TrimPrefix(url, "/")
 	url = strings.TrimSuffix(url, "/")
-	params := make(Params)
-	handle, ok := t.matchNextSegment(0, url, params)
-	return handle, params, ok
+	return t.matchNextSegment(0, url, params)
+}
+
+// MatchPattern matches the URL with the route pattern as the router does, and returns params
+// extracted from the URL. It returns error rather than panicking if the pattern is malformed.
+func MatchPattern(pattern, url string) (Params, bool, error) {
+	if err := ValidatePattern(pattern); err != nil {
+		return nil, false, err
+	}
+	t := NewTree()
+	t.Add(pattern, nil)
+	_, params, ok := t.MatchLeaf(url)
+	return params, ok, nil
 }
 
 // MatchTest returns true if given URL is matched by given pattern.
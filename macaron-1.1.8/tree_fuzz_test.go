@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+
+package macaron
+
+import (
+	"testing"
+)
+
+var fuzzPatterns = []string{
+	"/", "/users/:id", "/:id([0-9]+)/*", "/*.*", "/?:name", "/:id:int/:name:string",
+	"/posts/:year-:month-:day", "/*/files/*.*", "/:id(", "/)(", "/a/:b(c)d)",
+}
+
+// FuzzValidatePattern checks that route patterns accepted by ValidatePattern can be registered.
+func FuzzValidatePattern(f *testing.F) {
+	for _, pattern := range fuzzPatterns {
+		f.Add(pattern)
+	}
+	f.Fuzz(func(t *testing.T, pattern string) {
+		if ValidatePattern(pattern) != nil {
+			return
+		}
+		NewTree().Add(pattern, nil)
+	})
+}
+
+// FuzzMatchPattern checks that matching hostile URLs with route patterns does not panic or hang.
+func FuzzMatchPattern(f *testing.F) {
+	urls := []string{"/", "/users/42", "//a//b/", "/files/a/b.c.d", "/%2e%2e/%00", "/2016-01-02"}
+	for i, pattern := range fuzzPatterns {
+		f.Add(pattern, urls[i%len(urls)])
+	}
+	f.Fuzz(func(t *testing.T, pattern, url string) {
+		params, ok, err := MatchPattern(pattern, url)
+		if err != nil || !ok {
+			return
+		}
+		if params == nil {
+			t.Fatalf("matched %q with %q without params", url, pattern)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+
+package macaron
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ValidatePattern(t *testing.T) {
+	Convey("Validate route patterns", t, func() {
+		for _, pattern := range []string{"/", "/users/:id", "/:id([0-9]+)/*", "/*.*", "/?:name", "/:id:int", "/posts/:slug(.+)"} {
+			So(ValidatePattern(pattern), ShouldBeNil)
+		}
+		for _, pattern := range []string{"/:id(", "/:id([0-9)", "/:id(?<x)", "/a/:id{2,1001}"} {
+			So(ValidatePattern(pattern), ShouldNotBeNil)
+		}
+
+		Convey("Registering malformed patterns panics with the reason", func() {
+			m := New()
+			So(func() { m.Get("/users/:id(", func() {}) }, ShouldPanic)
+		})
+	})
+
+	Convey("Unbalanced parentheses do not hang", t, func() {
+		done := make(chan struct{})
+		go func() {
+			for _, pattern := range []string{"/:id(", "/)(:id", "/a)(b", "/(("} {
+				ValidatePattern(pattern)
+				MatchPattern(pattern, "/a")
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("validating patterns hangs")
+		}
+	})
+}
+
+func Test_MatchPattern(t *testing.T) {
+	Convey("Match URLs with patterns", t, func() {
+		params, ok, err := MatchPattern("/users/:id([0-9]+)/posts/:slug", "/users/42/posts/hello")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(params, ShouldResemble, Params{":id": "42", ":slug": "hello"})
+
+		params, ok, err = MatchPattern("/files/*.*", "/files/a/b.txt")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(params[":path"], ShouldEqual, "a/b")
+		So(params[":ext"], ShouldEqual, "txt")
+
+		_, ok, err = MatchPattern("/users/:id:int", "/users/joe")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		_, ok, err = MatchPattern("/users/:id(", "/users/42")
+		So(err, ShouldNotBeNil)
+		So(ok, ShouldBeFalse)
+	})
+}
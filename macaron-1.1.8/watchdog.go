@@ -0,0 +1,123 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"log"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// WatchdogOptions is a struct for specifying configuration options for the macaron.Watchdog middleware.
+type WatchdogOptions struct {
+	// Repeat is the interval of warnings after the first one while the request is still running.
+	// Default is 0, i.e. warn only once.
+	Repeat time.Duration
+	// AllGoroutines logs stacks of all goroutines instead of only the one serving the request,
+	// which helps to find what it is waiting for, e.g. the holder of a lock.
+	AllGoroutines bool
+	// OnSlow is called with the stack snapshot after the warning is logged, e.g. to report it.
+	OnSlow func(method, uri string, elapsed time.Duration, stack []byte)
+}
+
+func prepareWatchdogOptions(options []WatchdogOptions) WatchdogOptions {
+	var opt WatchdogOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	return opt
+}
+
+// goroutineID returns ID of current goroutine, parsed from header of its stack, e.g. "goroutine 18 [running]:".
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > -1 {
+		buf = buf[:i]
+	}
+	if _, err := strconv.ParseUint(string(buf), 10, 64); err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// goroutineStacks returns stacks of all goroutines, or only the goroutine of given ID if it is not empty.
+func goroutineStacks(id string) []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	if len(id) == 0 {
+		return buf
+	}
+
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, []byte("goroutine "+id+" ")) {
+			return stack
+		}
+	}
+	return nil
+}
+
+// Watchdog returns a middleware handler that logs a warning with stack snapshot of the goroutine
+// serving the request, when it is still running after threshold, so hung handlers, e.g. waiting for
+// a deadlock or slow query, can be diagnosed while they are hanging.
+func Watchdog(threshold time.Duration, options ...WatchdogOptions) Handler {
+	opt := prepareWatchdogOptions(options)
+
+	return func(ctx *Context, log *log.Logger) {
+		id := goroutineID()
+		if opt.AllGoroutines {
+			id = ""
+		}
+		method, uri, tag := ctx.Req.Method, ctx.Req.RequestURI, requestTag(ctx)
+		start := time.Now()
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			timer := time.NewTimer(threshold)
+			defer timer.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-timer.C:
+				}
+
+				elapsed := time.Since(start)
+				stack := goroutineStacks(id)
+				log.Printf("%sSLOW: %s %s has been running for %v\n%s", tag, method, uri, elapsed, stack)
+				if opt.OnSlow != nil {
+					opt.OnSlow(method, uri, elapsed, stack)
+				}
+				if opt.Repeat <= 0 {
+					return
+				}
+				timer.Reset(opt.Repeat)
+			}
+		}()
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowHandlerForWatchdog blocks until release is closed, so it shows up in stack snapshots.
+func slowHandlerForWatchdog(release chan struct{}) {
+	<-release
+}
+
+func Test_Watchdog(t *testing.T) {
+	Convey("Warn about slow requests", t, func() {
+		var lock sync.Mutex
+		buf := new(bytes.Buffer)
+		slow := make(chan []byte, 10)
+		release := make(chan struct{})
+
+		m := NewWithLogger(&syncWriter{lock: &lock, w: buf})
+		m.Use(Watchdog(10*time.Millisecond, WatchdogOptions{
+			Repeat: 10 * time.Millisecond,
+			OnSlow: func(method, uri string, elapsed time.Duration, stack []byte) {
+				if method == "GET" && uri == "/slow" && elapsed >= 10*time.Millisecond {
+					slow <- stack
+				}
+			},
+		}))
+		m.Get("/slow", func() {
+			slowHandlerForWatchdog(release)
+		})
+		m.Get("/fast", func() {})
+
+		done := make(chan bool)
+		go func() {
+			resp := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/slow", nil)
+			req.RequestURI = "/slow"
+			m.ServeHTTP(resp, req)
+			done <- true
+		}()
+
+		stack := <-slow
+		So(string(stack), ShouldContainSubstring, "slowHandlerForWatchdog")
+		So(string(stack), ShouldStartWith, "goroutine ")
+		So(bytes.Count(stack, []byte("\n\ngoroutine ")), ShouldEqual, 0)
+		<-slow
+		close(release)
+		<-done
+
+		lock.Lock()
+		So(buf.String(), ShouldContainSubstring, "SLOW: GET /slow has been running for")
+		lock.Unlock()
+
+		Convey("But not fast ones", func() {
+			lock.Lock()
+			buf.Reset()
+			lock.Unlock()
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/fast", nil)
+			So(err, ShouldBeNil)
+			m.ServeHTTP(resp, req)
+			time.Sleep(20 * time.Millisecond)
+
+			lock.Lock()
+			So(buf.String(), ShouldNotContainSubstring, "SLOW")
+			lock.Unlock()
+		})
+	})
+
+	Convey("Get stacks of goroutines", t, func() {
+		id := goroutineID()
+		So(id, ShouldNotBeEmpty)
+		So(string(goroutineStacks(id)), ShouldStartWith, "goroutine "+id+" [running]")
+		So(bytes.Count(goroutineStacks(""), []byte("\n\ngoroutine ")), ShouldBeGreaterThan, 0)
+	})
+}
+
+// syncWriter serializes writes of loggers in multiple goroutines.
+type syncWriter struct {
+	lock *sync.Mutex
+	w    *bytes.Buffer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,285 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Providers of webhooks that Webhook verifies.
+const (
+	WEBHOOK_GITHUB = "github"
+	WEBHOOK_STRIPE = "stripe"
+	WEBHOOK_SLACK  = "slack"
+)
+
+var (
+	ErrWebhookSignature = errors.New("webhook: signature is missing or invalid")
+	ErrWebhookTimestamp = errors.New("webhook: timestamp is missing or out of tolerance")
+)
+
+// WebhookEvent is a verified webhook event, which is mapped as a service by Webhook middleware.
+type WebhookEvent struct {
+	Provider string
+	// Type is the type of event, e.g. "push" of GitHub, "invoice.paid" of Stripe
+	// or "event_callback" of Slack.
+	Type string
+	// ID is the ID of event, or of the delivery of GitHub, which can be used to drop duplicates.
+	ID string
+	// Timestamp is when the event is signed, zero for GitHub.
+	Timestamp time.Time
+	// Body is the raw body of the request.
+	Body []byte
+	// Payload is the parsed body, which is a JSON object, or values of the form
+	// for Slack commands.
+	Payload map[string]interface{}
+}
+
+// VerifyOptions is a struct for specifying configuration options for the macaron.Webhook middleware.
+type VerifyOptions struct {
+	// Provider is the provider of webhooks, WEBHOOK_GITHUB, WEBHOOK_STRIPE or WEBHOOK_SLACK.
+	Provider string
+	// Secret is the secret that the provider signs requests with.
+	Secret string
+	// Tolerance is how far timestamp of requests can be from now, to prevent replay attacks.
+	// It does not apply to GitHub, which does not sign timestamp. Default is 5 minutes.
+	Tolerance time.Duration
+	// MaxBodySize is the maximum size in bytes of request body. Default is 1 MB.
+	MaxBodySize int64
+	// Unauthorized is called with the reason when verification fails.
+	// Default responds with 401 Unauthorized.
+	Unauthorized func(ctx *Context, err error)
+}
+
+func prepareVerifyOptions(options []VerifyOptions) VerifyOptions {
+	var opt VerifyOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.Tolerance <= 0 {
+		opt.Tolerance = 5 * time.Minute
+	}
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = 1 << 20
+	}
+	if opt.Unauthorized == nil {
+		opt.Unauthorized = func(ctx *Context, err error) {
+			writeErrorPage(ctx, ctx.Resp, http.StatusUnauthorized, "")
+		}
+	}
+	return opt
+}
+
+func hmacSHA256(secret string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+// verifyHexSignature returns true if the hex-encoded signature is the expected MAC.
+func verifyHexSignature(signature string, expected []byte) bool {
+	sig, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(sig, expected)
+}
+
+// parseUnixTime parses a timestamp in seconds and checks it is within tolerance of now.
+func parseUnixTime(value string, tolerance time.Duration) (time.Time, error) {
+	sec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrWebhookTimestamp
+	}
+	t := time.Unix(sec, 0)
+	if d := time.Since(t); d > tolerance || d < -tolerance {
+		return time.Time{}, ErrWebhookTimestamp
+	}
+	return t, nil
+}
+
+// verifyGitHub verifies X-Hub-Signature-256 header, which is "sha256=" followed by HMAC of the body.
+func verifyGitHub(req *http.Request, body []byte, opt VerifyOptions, event *WebhookEvent) error {
+	signature := req.Header.Get("X-Hub-Signature-256")
+	if !strings.HasPrefix(signature, "sha256=") ||
+		!verifyHexSignature(signature[len("sha256="):], hmacSHA256(opt.Secret, body)) {
+		return ErrWebhookSignature
+	}
+	event.Type = req.Header.Get("X-GitHub-Event")
+	event.ID = req.Header.Get("X-GitHub-Delivery")
+	return nil
+}
+
+// verifyStripe verifies Stripe-Signature header, which has the timestamp "t" and signatures "v1"
+// of HMAC of the timestamp and the body joined by ".". There can be multiple signatures
+// while the secret is being rolled.
+func verifyStripe(req *http.Request, body []byte, opt VerifyOptions, event *WebhookEvent) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(req.Header.Get("Stripe-Signature"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if len(timestamp) == 0 || len(signatures) == 0 {
+		return ErrWebhookSignature
+	}
+
+	expected := hmacSHA256(opt.Secret, []byte(timestamp), []byte("."), body)
+	verified := false
+	for _, sig := range signatures {
+		if verifyHexSignature(sig, expected) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return ErrWebhookSignature
+	}
+	t, err := parseUnixTime(timestamp, opt.Tolerance)
+	if err != nil {
+		return err
+	}
+	event.Timestamp = t
+	return nil
+}
+
+// verifySlack verifies X-Slack-Signature header, which is "v0=" followed by HMAC of "v0",
+// timestamp of X-Slack-Request-Timestamp header and the body joined by ":".
+func verifySlack(req *http.Request, body []byte, opt VerifyOptions, event *WebhookEvent) error {
+	timestamp := req.Header.Get("X-Slack-Request-Timestamp")
+	signature := req.Header.Get("X-Slack-Signature")
+	if !strings.HasPrefix(signature, "v0=") || !verifyHexSignature(signature[len("v0="):],
+		hmacSHA256(opt.Secret, []byte("v0:"+timestamp+":"), body)) {
+		return ErrWebhookSignature
+	}
+	t, err := parseUnixTime(timestamp, opt.Tolerance)
+	if err != nil {
+		return err
+	}
+	event.Timestamp = t
+	return nil
+}
+
+var webhookVerifiers = map[string]func(*http.Request, []byte, VerifyOptions, *WebhookEvent) error{
+	WEBHOOK_GITHUB: verifyGitHub,
+	WEBHOOK_STRIPE: verifyStripe,
+	WEBHOOK_SLACK:  verifySlack,
+}
+
+// parseWebhookPayload parses the body as a JSON object, or as a form for Slack, whose interactive
+// components submit the JSON object in form field "payload".
+func parseWebhookPayload(contentType string, body []byte) (map[string]interface{}, error) {
+	payload := make(map[string]interface{})
+	if typ, _, _ := mime.ParseMediaType(contentType); typ == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		if v := form.Get("payload"); len(v) > 0 {
+			return payload, json.Unmarshal([]byte(v), &payload)
+		}
+		for k := range form {
+			payload[k] = form.Get(k)
+		}
+		return payload, nil
+	}
+	return payload, json.Unmarshal(body, &payload)
+}
+
+// Webhook returns a middleware handler that verifies signatures of webhooks of the provider
+// over the raw body, and timestamp of them within tolerance. The event is mapped as *WebhookEvent,
+// and the body is restored so handlers after it can read it again. Requests that fail verification
+// are responded by VerifyOptions.Unauthorized, and those with body that cannot be parsed with
+// 400 Bad Request, e.g.
+//
+//	m.Post("/webhooks/github", macaron.SkipCSRF, macaron.Webhook(macaron.VerifyOptions{
+//		Provider: macaron.WEBHOOK_GITHUB,
+//		Secret:   os.Getenv("GITHUB_WEBHOOK_SECRET"),
+//	}), func(event *macaron.WebhookEvent) {
+//		...
+//	})
+func Webhook(options ...VerifyOptions) Handler {
+	opt := prepareVerifyOptions(options)
+	verify, ok := webhookVerifiers[opt.Provider]
+	if !ok {
+		panic(fmt.Sprintf("webhook: unknown provider %q", opt.Provider))
+	}
+	if len(opt.Secret) == 0 {
+		panic("webhook: secret is required")
+	}
+
+	return Provides(func(ctx *Context) {
+		var body []byte
+		if ctx.Req.Request.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(io.LimitReader(ctx.Req.Request.Body, opt.MaxBodySize+1))
+			if err != nil {
+				writeErrorPage(ctx, ctx.Resp, http.StatusBadRequest, "")
+				return
+			} else if int64(len(body)) > opt.MaxBodySize {
+				writeErrorPage(ctx, ctx.Resp, http.StatusRequestEntityTooLarge, "")
+				return
+			}
+		}
+		ctx.Req.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		event := &WebhookEvent{Provider: opt.Provider, Body: body}
+		if err := verify(ctx.Req.Request, body, opt, event); err != nil {
+			opt.Unauthorized(ctx, err)
+			return
+		}
+
+		payload, err := parseWebhookPayload(ctx.Req.Header.Get(_CONTENT_TYPE), body)
+		if err != nil {
+			writeErrorPage(ctx, ctx.Resp, http.StatusBadRequest, "")
+			return
+		}
+		event.Payload = payload
+		if len(event.Type) == 0 {
+			event.Type, _ = payload["type"].(string)
+		}
+		if len(event.ID) == 0 {
+			event.ID, _ = payload["id"].(string)
+			if len(event.ID) == 0 {
+				// Slack names ID of events of the Events API "event_id".
+				event.ID, _ = payload["event_id"].(string)
+			}
+		}
+		ctx.Map(event)
+	}, (*WebhookEvent)(nil))
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Webhook(t *testing.T) {
+	sign := func(secret string, parts ...string) string {
+		bs := make([][]byte, len(parts))
+		for i := range parts {
+			bs[i] = []byte(parts[i])
+		}
+		return hex.EncodeToString(hmacSHA256(secret, bs...))
+	}
+
+	newServer := func(provider string) *Macaron {
+		m := New()
+		m.Post("/hook", Webhook(VerifyOptions{Provider: provider, Secret: "s3cret"}),
+			func(ctx *Context, event *WebhookEvent) string {
+				body, _ := ioutil.ReadAll(ctx.Req.Request.Body)
+				return event.Type + "|" + event.ID + "|" + string(body)
+			})
+		return m
+	}
+
+	serve := func(m *Macaron, body string, header map[string]string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/hook", strings.NewReader(body))
+		So(err, ShouldBeNil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		m.ServeHTTP(resp, req)
+		return resp
+	}
+
+	Convey("Verify webhooks of GitHub", t, func() {
+		m := newServer(WEBHOOK_GITHUB)
+		body := `{"ref":"refs/heads/master"}`
+		resp := serve(m, body, map[string]string{
+			"X-Hub-Signature-256": "sha256=" + sign("s3cret", body),
+			"X-GitHub-Event":      "push",
+			"X-GitHub-Delivery":   "72d3162e",
+		})
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "push|72d3162e|"+body)
+
+		resp = serve(m, body, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("wrong", body)})
+		So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+		resp = serve(m, body, nil)
+		So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+
+		resp = serve(m, "not json", map[string]string{"X-Hub-Signature-256": "sha256=" + sign("s3cret", "not json")})
+		So(resp.Code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("Verify webhooks of Stripe", t, func() {
+		m := newServer(WEBHOOK_STRIPE)
+		body := `{"id":"evt_1","type":"invoice.paid"}`
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		resp := serve(m, body, map[string]string{
+			"Stripe-Signature": "t=" + now + ",v1=" + sign("old", now, ".", body) + ",v1=" + sign("s3cret", now, ".", body),
+		})
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "invoice.paid|evt_1|"+body)
+
+		old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		resp = serve(m, body, map[string]string{
+			"Stripe-Signature": "t=" + old + ",v1=" + sign("s3cret", old, ".", body),
+		})
+		So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+
+		resp = serve(m, body, map[string]string{
+			"Stripe-Signature": "t=" + now + ",v1=" + sign("s3cret", now, ".", body+" "),
+		})
+		So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+	})
+
+	Convey("Verify webhooks of Slack", t, func() {
+		m := newServer(WEBHOOK_SLACK)
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		body := url.Values{"command": {"/deploy"}, "text": {"prod"}}.Encode()
+		resp := serve(m, body, map[string]string{
+			"Content-Type":              "application/x-www-form-urlencoded",
+			"X-Slack-Request-Timestamp": now,
+			"X-Slack-Signature":         "v0=" + sign("s3cret", "v0:"+now+":", body),
+		})
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, "||"+body)
+
+		body = `{"type":"event_callback","event_id":"Ev01"}`
+		resp = serve(m, body, map[string]string{
+			"X-Slack-Request-Timestamp": now,
+			"X-Slack-Signature":         "v0=" + sign("s3cret", "v0:"+now+":", body),
+		})
+		So(resp.Body.String(), ShouldEqual, "event_callback|Ev01|"+body)
+
+		resp = serve(m, body, map[string]string{
+			"X-Slack-Request-Timestamp": "1",
+			"X-Slack-Signature":         "v0=" + sign("s3cret", "v0:1:", body),
+		})
+		So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+	})
+
+	Convey("Parse payload of Slack interactive components", t, func() {
+		payload, err := parseWebhookPayload("application/x-www-form-urlencoded",
+			[]byte(url.Values{"payload": {`{"type":"block_actions"}`}}.Encode()))
+		So(err, ShouldBeNil)
+		So(payload["type"], ShouldEqual, "block_actions")
+	})
+
+	Convey("Reject unknown providers", t, func() {
+		So(func() { Webhook(VerifyOptions{Provider: "gitlab", Secret: "s3cret"}) }, ShouldPanic)
+		So(func() { Webhook(VerifyOptions{Provider: WEBHOOK_GITHUB}) }, ShouldPanic)
+	})
+}
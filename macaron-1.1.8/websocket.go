@@ -0,0 +1,523 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Types of WebSocket messages.
+const (
+	WS_TEXT   = 1
+	WS_BINARY = 2
+)
+
+// Opcodes of WebSocket frames that are handled by WSConn itself.
+const (
+	wsContinuation = 0
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+)
+
+// Status codes of closing WebSocket connections, see RFC 6455 section 7.4.
+const (
+	WS_CLOSE_NORMAL           = 1000
+	WS_CLOSE_GOING_AWAY       = 1001
+	WS_CLOSE_PROTOCOL_ERROR   = 1002
+	WS_CLOSE_UNSUPPORTED_DATA = 1003
+	WS_CLOSE_NO_STATUS        = 1005
+	WS_CLOSE_INVALID_PAYLOAD  = 1007
+	WS_CLOSE_POLICY_VIOLATION = 1008
+	WS_CLOSE_TOO_LARGE        = 1009
+	WS_CLOSE_INTERNAL_ERROR   = 1011
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWSClosed is returned by operations on WebSocket connections that have been closed.
+var ErrWSClosed = errors.New("websocket: connection closed")
+
+// WSCloseError is returned by WSConn.ReadMessage when the connection is closed by the client,
+// or by the server because the client violates the protocol.
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	if len(e.Reason) == 0 {
+		return fmt.Sprintf("websocket: closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with status %d: %s", e.Code, e.Reason)
+}
+
+// WebSocketOptions is a struct for specifying configuration options for macaron.WebSocketUpgrade.
+type WebSocketOptions struct {
+	// AllowedOrigins is the list of origins allowed to connect, e.g. "https://example.com", or "*" for any.
+	// Default allows requests from the same host, and those without Origin header.
+	AllowedOrigins []string
+	// CheckOrigin reports whether the request is allowed to connect, it overrides AllowedOrigins if set.
+	CheckOrigin func(req *http.Request) bool
+	// Subprotocols are subprotocols supported by the server in order of preference.
+	Subprotocols []string
+	// MaxMessageSize is the maximum size of messages read in bytes. Default is 1MB.
+	MaxMessageSize int64
+	// PingInterval is how often pings are sent to keep the connection alive. Default is 30 seconds,
+	// and negative value disables pings.
+	PingInterval time.Duration
+	// PongTimeout is how long reading waits for any frame, including pongs, before the connection
+	// is considered dead. Default is twice of PingInterval, or no timeout if pings are disabled.
+	PongTimeout time.Duration
+	// WriteTimeout is the timeout of writing a frame. Default is 10 seconds.
+	WriteTimeout time.Duration
+}
+
+func prepareWebSocketOptions(options []WebSocketOptions) WebSocketOptions {
+	var opt WebSocketOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	// Defaults
+	if opt.CheckOrigin == nil {
+		if len(opt.AllowedOrigins) > 0 {
+			origins := opt.AllowedOrigins
+			opt.CheckOrigin = func(req *http.Request) bool {
+				origin := req.Header.Get("Origin")
+				for _, o := range origins {
+					if o == "*" || strings.EqualFold(o, origin) {
+						return true
+					}
+				}
+				return false
+			}
+		} else {
+			opt.CheckOrigin = sameOrigin
+		}
+	}
+	if opt.MaxMessageSize <= 0 {
+		opt.MaxMessageSize = 1 << 20
+	}
+	if opt.PingInterval == 0 {
+		opt.PingInterval = 30 * time.Second
+	}
+	if opt.PongTimeout == 0 && opt.PingInterval > 0 {
+		opt.PongTimeout = 2 * opt.PingInterval
+	}
+	if opt.WriteTimeout <= 0 {
+		opt.WriteTimeout = 10 * time.Second
+	}
+	return opt
+}
+
+// sameOrigin reports whether Origin header of the request is absent or has the same host as the request.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// WSConn is a WebSocket connection upgraded by WebSocketUpgrade. Reading is not safe for concurrent use,
+// while writing is. Pings from the client are answered, and pongs are received, while reading messages.
+type WSConn struct {
+	// Subprotocol is the subprotocol negotiated with the client, empty if none.
+	Subprotocol string
+
+	conn net.Conn
+	br   *bufio.Reader
+	opt  WebSocketOptions
+
+	writeLock sync.Mutex
+	closeSent bool
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, opt WebSocketOptions, subprotocol string) *WSConn {
+	c := &WSConn{
+		Subprotocol: subprotocol,
+		conn:        conn,
+		br:          br,
+		opt:         opt,
+		closed:      make(chan struct{}),
+	}
+	if opt.PingInterval > 0 {
+		go c.keepalive()
+	}
+	return c
+}
+
+// RemoteAddr returns the network address of the client.
+func (c *WSConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Done returns a channel that is closed when the connection is closed.
+func (c *WSConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *WSConn) keepalive() {
+	ticker := time.NewTicker(c.opt.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if err := c.writeFrame(wsPing, nil); err != nil {
+				c.shutdown()
+				return
+			}
+		}
+	}
+}
+
+func (c *WSConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return ErrWSClosed
+	}
+	if opcode == wsClose {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, len(payload)+10)
+	buf = append(buf, 0x80|opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+	buf = append(buf, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.opt.WriteTimeout))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// readFrame reads a frame from the client, whose payload must not be longer than limit.
+func (c *WSConn) readFrame(limit int64) (fin bool, opcode byte, payload []byte, err error) {
+	var head [8]byte
+	if _, err = io.ReadFull(c.br, head[:2]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(c.br, head[:2]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(head[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, head[:8]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(head[:8])
+	}
+
+	switch {
+	case head[0]&0x70 != 0:
+		return false, 0, nil, &WSCloseError{WS_CLOSE_PROTOCOL_ERROR, "reserved bits are set"}
+	case !masked:
+		return false, 0, nil, &WSCloseError{WS_CLOSE_PROTOCOL_ERROR, "frame is not masked"}
+	case opcode >= wsClose && (!fin || n > 125):
+		return false, 0, nil, &WSCloseError{WS_CLOSE_PROTOCOL_ERROR, "invalid control frame"}
+	case opcode < wsClose && n > uint64(limit):
+		return false, 0, nil, &WSCloseError{WS_CLOSE_TOO_LARGE, "message is too large"}
+	}
+
+	var key [4]byte
+	if _, err = io.ReadFull(c.br, key[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// fail closes the connection because of the error, and returns it.
+func (c *WSConn) fail(err error) error {
+	if e, ok := err.(*WSCloseError); ok {
+		c.Close(e.Code, e.Reason)
+	} else {
+		c.shutdown()
+	}
+	return err
+}
+
+// ReadMessage reads a message, and returns its type, WS_TEXT or WS_BINARY, and data.
+// It returns *WSCloseError if the connection is closed by the client, or the client violates
+// the protocol, in which case the connection is closed with corresponding status.
+func (c *WSConn) ReadMessage() (int, []byte, error) {
+	var typ byte
+	var msg []byte
+	for {
+		select {
+		case <-c.closed:
+			return 0, nil, ErrWSClosed
+		default:
+		}
+		if c.opt.PongTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.opt.PongTimeout))
+		}
+
+		fin, opcode, payload, err := c.readFrame(c.opt.MaxMessageSize - int64(len(msg)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch opcode {
+		case wsPing:
+			if err = c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			e := &WSCloseError{Code: WS_CLOSE_NO_STATUS}
+			if len(payload) >= 2 {
+				e.Code = int(binary.BigEndian.Uint16(payload))
+				e.Reason = string(payload[2:])
+			}
+			c.Close(e.Code, "")
+			return 0, nil, e
+		case wsContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(&WSCloseError{WS_CLOSE_PROTOCOL_ERROR, "unexpected continuation frame"})
+			}
+		case WS_TEXT, WS_BINARY:
+			if typ != 0 {
+				return 0, nil, c.fail(&WSCloseError{WS_CLOSE_PROTOCOL_ERROR, "expected continuation frame"})
+			}
+			typ = opcode
+		default:
+			return 0, nil, c.fail(&WSCloseError{WS_CLOSE_PROTOCOL_ERROR, "unknown opcode"})
+		}
+
+		msg = append(msg, payload...)
+		if fin {
+			if typ == WS_TEXT && !utf8.Valid(msg) {
+				return 0, nil, c.fail(&WSCloseError{WS_CLOSE_INVALID_PAYLOAD, "text is not valid UTF-8"})
+			}
+			return int(typ), msg, nil
+		}
+	}
+}
+
+// WriteMessage writes a message of given type, WS_TEXT or WS_BINARY.
+func (c *WSConn) WriteMessage(typ int, data []byte) error {
+	if typ != WS_TEXT && typ != WS_BINARY {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	return c.writeFrame(byte(typ), data)
+}
+
+// ReadJSON reads a message and decodes it as JSON into v.
+func (c *WSConn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteJSON encodes v as JSON and writes it as a text message.
+func (c *WSConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(WS_TEXT, data)
+}
+
+// Ping sends a ping with given data, the pong is received while reading messages.
+func (c *WSConn) Ping(data []byte) error {
+	return c.writeFrame(wsPing, data)
+}
+
+// Close sends a close frame with given status and reason, and closes the connection.
+// It is safe to be called more than once.
+func (c *WSConn) Close(code int, reason string) error {
+	var payload []byte
+	if code != WS_CLOSE_NO_STATUS {
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+	}
+	err := c.writeFrame(wsClose, payload)
+	c.shutdown()
+	if err == ErrWSClosed {
+		return nil
+	}
+	return err
+}
+
+func (c *WSConn) shutdown() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// headerHasToken reports whether the comma-separated header contains the token case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake of the request, or responds with the error.
+func upgradeWebSocket(ctx *Context, opt WebSocketOptions) (*WSConn, bool) {
+	req := ctx.Req.Request
+	if req.Method != "GET" || !headerHasToken(req.Header, "Connection", "upgrade") ||
+		!headerHasToken(req.Header, "Upgrade", "websocket") {
+		ctx.Resp.Header().Set("Upgrade", "websocket")
+		http.Error(ctx.Resp, "WebSocket upgrade is required", http.StatusUpgradeRequired)
+		return nil, false
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.Resp.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(ctx.Resp, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, false
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		http.Error(ctx.Resp, "Invalid Sec-WebSocket-Key header", http.StatusBadRequest)
+		return nil, false
+	}
+	if !opt.CheckOrigin(req) {
+		http.Error(ctx.Resp, "Origin is not allowed", http.StatusForbidden)
+		return nil, false
+	}
+
+	subprotocol := ""
+	offered := make(map[string]bool)
+	for _, v := range req.Header["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			offered[strings.TrimSpace(p)] = true
+		}
+	}
+	for _, p := range opt.Subprotocols {
+		if offered[p] {
+			subprotocol = p
+			break
+		}
+	}
+
+	hijacker, ok := ctx.Resp.(http.Hijacker)
+	if !ok {
+		http.Error(ctx.Resp, "WebSocket is not supported", http.StatusInternalServerError)
+		return nil, false
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(ctx.Resp, "WebSocket is not supported", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if len(subprotocol) > 0 {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	conn.SetWriteDeadline(time.Now().Add(opt.WriteTimeout))
+	if _, err = conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	conn.SetDeadline(time.Time{})
+	return newWSConn(conn, brw.Reader, opt, subprotocol), true
+}
+
+// WebSocketUpgrade returns a middleware handler that upgrades requests to WebSocket, and maps
+// the connection as *WSConn for handlers after it. Requests that are not valid WebSocket handshakes,
+// or from origins not allowed, are rejected. The connection is closed normally when handlers return.
+func WebSocketUpgrade(options ...WebSocketOptions) Handler {
+	opt := prepareWebSocketOptions(options)
+	return Provides(func(ctx *Context) {
+		conn, ok := upgradeWebSocket(ctx, opt)
+		if !ok {
+			return
+		}
+		ctx.Map(conn)
+		defer func() {
+			if err := recover(); err != nil {
+				conn.Close(WS_CLOSE_INTERNAL_ERROR, "")
+				panic(err)
+			}
+			conn.Close(WS_CLOSE_NORMAL, "")
+		}()
+		ctx.Next()
+	}, (*WSConn)(nil))
+}
+
+// WebSocket registers a route of WebSocket by WebSocketUpgrade with default options,
+// whose handlers get the connection injected, e.g.
+//
+//	m.WebSocket("/echo", func(conn *macaron.WSConn) {
+//		for {
+//			typ, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			conn.WriteMessage(typ, msg)
+//		}
+//	})
+//
+// Use m.Get(pattern, macaron.WebSocketUpgrade(options), handlers...) for other options.
+func (r *Router) WebSocket(pattern string, h ...Handler) *Route {
+	return r.Handle("GET", pattern, append([]Handler{WebSocketUpgrade()}, h...))
+}
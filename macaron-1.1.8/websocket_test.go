@@ -0,0 +1,217 @@
+// Copyright 2014 The Macaron Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package macaron
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// wsTestClient is a minimal WebSocket client for tests.
+type wsTestClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dialWS(addr, path string, header map[string]string) (*wsTestClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	for k, v := range header {
+		req += k + ": " + v + "\r\n"
+	}
+	if _, err = conn.Write([]byte(req + "\r\n")); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &wsTestClient{conn, br, resp}, nil
+}
+
+func (c *wsTestClient) writeFrame(fin bool, opcode byte, payload []byte) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	buf := []byte{b0}
+	if n := len(payload); n <= 125 {
+		buf = append(buf, 0x80|byte(n))
+	} else {
+		buf = append(buf, 0x80|126, byte(n>>8), byte(n))
+	}
+	key := []byte{1, 2, 3, 4}
+	buf = append(buf, key...)
+	for i, b := range payload {
+		buf = append(buf, b^key[i%4])
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *wsTestClient) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	_, err := io.ReadFull(c.br, payload)
+	return head[0] & 0x0f, payload, err
+}
+
+func Test_WebSocket(t *testing.T) {
+	Convey("Serve WebSocket connections", t, func() {
+		m := New()
+		m.WebSocket("/echo", func(conn *WSConn) {
+			for {
+				typ, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.WriteMessage(typ, msg)
+			}
+		})
+		m.Get("/json", WebSocketUpgrade(WebSocketOptions{
+			AllowedOrigins: []string{"https://app.example.com"},
+			Subprotocols:   []string{"v2", "v1"},
+			PingInterval:   -1,
+		}), func(conn *WSConn) {
+			var v map[string]int
+			if err := conn.ReadJSON(&v); err != nil {
+				return
+			}
+			v["n"]++
+			conn.WriteJSON(map[string]interface{}{"n": v["n"], "proto": conn.Subprotocol})
+		})
+		server := httptest.NewServer(m)
+		defer server.Close()
+		addr := strings.TrimPrefix(server.URL, "http://")
+
+		Convey("Echo messages", func() {
+			c, err := dialWS(addr, "/echo", nil)
+			So(err, ShouldBeNil)
+			defer c.conn.Close()
+			So(c.resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			So(c.resp.Header.Get("Sec-WebSocket-Accept"), ShouldEqual, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+
+			So(c.writeFrame(true, WS_TEXT, []byte("hello")), ShouldBeNil)
+			op, payload, err := c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, WS_TEXT)
+			So(string(payload), ShouldEqual, "hello")
+
+			// Fragmented message with an interleaved ping.
+			big := strings.Repeat("x", 200)
+			So(c.writeFrame(false, WS_BINARY, []byte(big[:100])), ShouldBeNil)
+			So(c.writeFrame(true, wsPing, []byte("p")), ShouldBeNil)
+			So(c.writeFrame(true, wsContinuation, []byte(big[100:])), ShouldBeNil)
+			op, payload, err = c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, wsPong)
+			So(string(payload), ShouldEqual, "p")
+			op, payload, err = c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, WS_BINARY)
+			So(string(payload), ShouldEqual, big)
+
+			So(c.writeFrame(true, wsClose, []byte{0x03, 0xe8}), ShouldBeNil)
+			op, payload, err = c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, wsClose)
+			So(binary.BigEndian.Uint16(payload), ShouldEqual, WS_CLOSE_NORMAL)
+		})
+
+		Convey("Close on protocol errors", func() {
+			c, err := dialWS(addr, "/echo", nil)
+			So(err, ShouldBeNil)
+			defer c.conn.Close()
+			So(c.writeFrame(true, wsContinuation, []byte("x")), ShouldBeNil)
+			op, payload, err := c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, wsClose)
+			So(binary.BigEndian.Uint16(payload), ShouldEqual, WS_CLOSE_PROTOCOL_ERROR)
+
+			c, err = dialWS(addr, "/echo", nil)
+			So(err, ShouldBeNil)
+			defer c.conn.Close()
+			So(c.writeFrame(true, WS_TEXT, []byte{0xff, 0xfe}), ShouldBeNil)
+			op, payload, err = c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, wsClose)
+			So(binary.BigEndian.Uint16(payload), ShouldEqual, WS_CLOSE_INVALID_PAYLOAD)
+		})
+
+		Convey("Exchange JSON with subprotocol", func() {
+			c, err := dialWS(addr, "/json", map[string]string{
+				"Origin":                 "https://app.example.com",
+				"Sec-WebSocket-Protocol": "v1, v2",
+			})
+			So(err, ShouldBeNil)
+			defer c.conn.Close()
+			So(c.resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			So(c.resp.Header.Get("Sec-WebSocket-Protocol"), ShouldEqual, "v2")
+
+			So(c.writeFrame(true, WS_TEXT, []byte(`{"n":41}`)), ShouldBeNil)
+			_, payload, err := c.readFrame()
+			So(err, ShouldBeNil)
+			So(string(payload), ShouldEqual, `{"n":42,"proto":"v2"}`)
+			// Connection is closed when the handler returns.
+			op, _, err := c.readFrame()
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, wsClose)
+		})
+
+		Convey("Reject invalid handshakes", func() {
+			c, err := dialWS(addr, "/json", map[string]string{"Origin": "https://evil.example.com"})
+			So(err, ShouldBeNil)
+			c.conn.Close()
+			So(c.resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+			c, err = dialWS(addr, "/echo", map[string]string{"Origin": "http://elsewhere.com"})
+			So(err, ShouldBeNil)
+			c.conn.Close()
+			So(c.resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+			resp, err := http.Get(server.URL + "/echo")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUpgradeRequired)
+		})
+	})
+}